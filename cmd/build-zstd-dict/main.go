@@ -4,6 +4,7 @@ import (
 	"flag"
 	"os"
 
+	"github.com/MixinNetwork/mixin/common"
 	"github.com/MixinNetwork/mixin/crypto"
 	"github.com/dgraph-io/badger/v2"
 )
@@ -39,7 +40,7 @@ func loopSnapshots(db *badger.DB, dir string) {
 		if err != nil {
 			panic(err)
 		}
-		err = os.WriteFile(dir+"/SNAPSHOT-"+crypto.NewHash(key).String(), val, 0644)
+		err = os.WriteFile(dir+"/SNAPSHOT-"+crypto.NewHash(key).String(), decompress(val), 0644)
 		if err != nil {
 			panic(err)
 		}
@@ -61,7 +62,7 @@ func loopTransactions(db *badger.DB, dir string) {
 		if err != nil {
 			panic(err)
 		}
-		err = os.WriteFile(dir+"/TRANSACTION-"+crypto.NewHash(key).String(), val, 0644)
+		err = os.WriteFile(dir+"/TRANSACTION-"+crypto.NewHash(key).String(), decompress(val), 0644)
 		if err != nil {
 			panic(err)
 		}
@@ -83,13 +84,22 @@ func loopUTXOs(db *badger.DB, dir string) {
 		if err != nil {
 			panic(err)
 		}
-		err = os.WriteFile(dir+"/UTXO-"+crypto.NewHash(key).String(), val, 0644)
+		err = os.WriteFile(dir+"/UTXO-"+crypto.NewHash(key).String(), decompress(val), 0644)
 		if err != nil {
 			panic(err)
 		}
 	}
 }
 
+// decompress returns the msgpack value to train the dictionary, because the
+// values are stored compressed with the previous dictionary.
+func decompress(val []byte) []byte {
+	if b := common.Decompress(val); b != nil {
+		return b
+	}
+	return val
+}
+
 func openDB(dir string) (*badger.DB, error) {
 	opts := badger.DefaultOptions(dir)
 	return badger.Open(opts)
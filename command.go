@@ -1,12 +1,13 @@
 package main
 
 import (
-	"bytes"
+	"context"
 	"crypto/rand"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
 	"strconv"
@@ -17,6 +18,7 @@ import (
 	"github.com/MixinNetwork/mixin/config"
 	"github.com/MixinNetwork/mixin/crypto"
 	"github.com/MixinNetwork/mixin/kernel"
+	"github.com/MixinNetwork/mixin/rpc/client"
 	"github.com/MixinNetwork/mixin/storage"
 	"github.com/urfave/cli/v2"
 )
@@ -390,13 +392,19 @@ func signTransactionCmd(c *cli.Context) error {
 }
 
 func sendTransactionCmd(c *cli.Context) error {
-	data, err := callRPC(c.String("node"), "sendrawtransaction", []interface{}{
-		c.String("raw"),
-	}, c.Bool("time"))
-	if err == nil {
-		fmt.Println(string(data))
+	hash, err := newCommandClient(c).SendRawTransaction(context.Background(), c.String("raw"))
+	if err != nil {
+		return err
 	}
-	return err
+	return printJSON(map[string]interface{}{"hash": hash})
+}
+
+func validateTransactionCmd(c *cli.Context) error {
+	validation, err := newCommandClient(c).ValidateRawTransaction(context.Background(), c.String("raw"))
+	if err != nil {
+		return err
+	}
+	return printJSON(validation)
 }
 
 func pledgeNodeCmd(c *cli.Context) error {
@@ -578,138 +586,499 @@ func decodePledgeNodeCmd(c *cli.Context) error {
 }
 
 func getRoundLinkCmd(c *cli.Context) error {
-	data, err := callRPC(c.String("node"), "getroundlink", []interface{}{
-		c.String("from"),
-		c.String("to"),
-	}, c.Bool("time"))
-	if err == nil {
-		fmt.Println(string(data))
+	from, err := crypto.HashFromString(c.String("from"))
+	if err != nil {
+		return err
 	}
-	return err
+	to, err := crypto.HashFromString(c.String("to"))
+	if err != nil {
+		return err
+	}
+	link, err := newCommandClient(c).GetRoundLink(context.Background(), from, to)
+	if err != nil {
+		return err
+	}
+	return printJSON(map[string]interface{}{"link": link})
 }
 
 func getRoundByNumberCmd(c *cli.Context) error {
-	data, err := callRPC(c.String("node"), "getroundbynumber", []interface{}{
-		c.String("id"),
-		c.Uint64("number"),
-	}, c.Bool("time"))
-	if err == nil {
-		fmt.Println(string(data))
+	id, err := crypto.HashFromString(c.String("id"))
+	if err != nil {
+		return err
 	}
-	return err
+	round, err := newCommandClient(c).GetRoundByNumber(context.Background(), id, c.Uint64("number"))
+	if err != nil {
+		return err
+	}
+	return printJSON(roundToMap(round))
 }
 
 func getRoundByHashCmd(c *cli.Context) error {
-	data, err := callRPC(c.String("node"), "getroundbyhash", []interface{}{
-		c.String("hash"),
-	}, c.Bool("time"))
-	if err == nil {
-		fmt.Println(string(data))
+	hash, err := crypto.HashFromString(c.String("hash"))
+	if err != nil {
+		return err
 	}
-	return err
+	round, err := newCommandClient(c).GetRoundByHash(context.Background(), hash)
+	if err != nil {
+		return err
+	}
+	return printJSON(roundToMap(round))
 }
 
 func listSnapshotsCmd(c *cli.Context) error {
-	data, err := callRPC(c.String("node"), "listsnapshots", []interface{}{
-		c.Uint64("since"),
-		c.Uint64("count"),
-		c.Bool("sig"),
-		c.Bool("tx"),
-	}, c.Bool("time"))
-	if err == nil {
-		fmt.Println(string(data))
+	rc := newCommandClient(c)
+	snapshots, err := rc.ListSnapshots(context.Background(), c.Uint64("since"), c.Uint64("count"), c.Bool("sig"))
+	if err != nil {
+		return err
 	}
-	return err
+	var transactions []*common.VersionedTransaction
+	if c.Bool("tx") {
+		hashes := make([]crypto.Hash, len(snapshots))
+		for i, s := range snapshots {
+			hashes[i] = s.Transaction
+		}
+		transactions, err = readTransactions(rc, hashes)
+		if err != nil {
+			return err
+		}
+	}
+	result := make([]map[string]interface{}, len(snapshots))
+	for i, s := range snapshots {
+		if len(transactions) > 0 {
+			result[i] = snapshotToMap(s, transactions[i], c.Bool("sig"))
+		} else {
+			result[i] = snapshotToMap(s, nil, c.Bool("sig"))
+		}
+	}
+	return printJSON(result)
 }
 
 func getSnapshotCmd(c *cli.Context) error {
-	data, err := callRPC(c.String("node"), "getsnapshot", []interface{}{
-		c.String("hash"),
-	}, c.Bool("time"))
-	if err == nil {
-		fmt.Println(string(data))
+	hash, err := crypto.HashFromString(c.String("hash"))
+	if err != nil {
+		return err
 	}
-	return err
+	rc := newCommandClient(c)
+	s, err := rc.GetSnapshot(context.Background(), hash)
+	if err != nil || s == nil {
+		return printResult(nil, err)
+	}
+	tx, _, err := rc.GetTransaction(context.Background(), s.Transaction)
+	if err != nil {
+		return err
+	}
+	return printJSON(snapshotToMap(s, tx, true))
+}
+
+func getSnapshotProofCmd(c *cli.Context) error {
+	hash, err := crypto.HashFromString(c.String("hash"))
+	if err != nil {
+		return err
+	}
+	proof, err := newCommandClient(c).GetSnapshotProof(context.Background(), hash, c.Uint64("checkpoint"))
+	return printResult(proof, err)
 }
 
 func getTransactionCmd(c *cli.Context) error {
-	data, err := callRPC(c.String("node"), "gettransaction", []interface{}{
-		c.String("hash"),
-	}, c.Bool("time"))
-	if err == nil {
-		fmt.Println(string(data))
+	hash, err := crypto.HashFromString(c.String("hash"))
+	if err != nil {
+		return err
 	}
-	return err
+	tx, snap, err := newCommandClient(c).GetTransaction(context.Background(), hash)
+	if err != nil || tx == nil {
+		return printResult(nil, err)
+	}
+	data := transactionToMap(tx)
+	data["hex"] = hex.EncodeToString(tx.Marshal())
+	if snap.HasValue() {
+		data["snapshot"] = snap
+	}
+	return printJSON(data)
 }
 
 func getCacheTransactionCmd(c *cli.Context) error {
-	data, err := callRPC(c.String("node"), "getcachetransaction", []interface{}{
-		c.String("hash"),
-	}, c.Bool("time"))
-	if err == nil {
-		fmt.Println(string(data))
+	hash, err := crypto.HashFromString(c.String("hash"))
+	if err != nil {
+		return err
 	}
-	return err
+	tx, err := newCommandClient(c).GetCacheTransaction(context.Background(), hash)
+	if err != nil || tx == nil {
+		return printResult(nil, err)
+	}
+	data := transactionToMap(tx)
+	data["hex"] = hex.EncodeToString(tx.Marshal())
+	return printJSON(data)
+}
+
+func getTransactionStatusCmd(c *cli.Context) error {
+	hash, err := crypto.HashFromString(c.String("hash"))
+	if err != nil {
+		return err
+	}
+	status, err := newCommandClient(c).GetTransactionStatus(context.Background(), hash)
+	return printResult(status, err)
+}
+
+func listPendingTransactionsCmd(c *cli.Context) error {
+	var offset, asset crypto.Hash
+	var err error
+	if s := c.String("offset"); s != "" {
+		offset, err = crypto.HashFromString(s)
+		if err != nil {
+			return err
+		}
+	}
+	if s := c.String("asset"); s != "" {
+		asset, err = crypto.HashFromString(s)
+		if err != nil {
+			return err
+		}
+	}
+	txs, next, err := newCommandClient(c).ListPendingTransactions(context.Background(), offset, int(c.Uint64("limit")), asset)
+	if err != nil {
+		return err
+	}
+	data := make([]map[string]interface{}, len(txs))
+	for i, tx := range txs {
+		data[i] = transactionToMap(tx)
+		data[i]["hex"] = hex.EncodeToString(tx.Marshal())
+	}
+	result := map[string]interface{}{"transactions": data}
+	if next.HasValue() {
+		result["next"] = next
+	}
+	return printJSON(result)
+}
+
+func getPendingTransactionsStatsCmd(c *cli.Context) error {
+	stats, err := newCommandClient(c).GetPendingTransactionsStats(context.Background())
+	return printResult(stats, err)
+}
+
+func removePendingTransactionCmd(c *cli.Context) error {
+	hash, err := crypto.HashFromString(c.String("hash"))
+	if err != nil {
+		return err
+	}
+	err = newCommandClient(c).RemovePendingTransaction(context.Background(), hash)
+	if err != nil {
+		return err
+	}
+	return printJSON(map[string]interface{}{"hash": hash})
 }
 
 func getUTXOCmd(c *cli.Context) error {
-	data, err := callRPC(c.String("node"), "getutxo", []interface{}{
-		c.String("hash"),
-		c.Uint64("index"),
-	}, c.Bool("time"))
-	if err == nil {
-		fmt.Println(string(data))
+	hash, err := crypto.HashFromString(c.String("hash"))
+	if err != nil {
+		return err
 	}
-	return err
+	utxo, err := newCommandClient(c).GetUTXO(context.Background(), hash, int(c.Uint64("index")))
+	if err != nil || utxo == nil {
+		return printResult(nil, err)
+	}
+	output := map[string]interface{}{
+		"type":   utxo.Type,
+		"hash":   utxo.Hash,
+		"index":  utxo.Index,
+		"amount": utxo.Amount,
+	}
+	if len(utxo.Keys) > 0 {
+		output["keys"] = utxo.Keys
+	}
+	if len(utxo.Script) > 0 {
+		output["script"] = utxo.Script
+	}
+	if utxo.Mask.HasValue() {
+		output["mask"] = utxo.Mask
+	}
+	if utxo.LockHash.HasValue() {
+		output["lock"] = utxo.LockHash
+	}
+	return printJSON(output)
 }
 
 func listMintWorksCmd(c *cli.Context) error {
-	data, err := callRPC(c.String("node"), "listmintworks", []interface{}{
-		c.Uint64("since"),
-	}, c.Bool("time"))
-	if err == nil {
-		fmt.Println(string(data))
+	works, err := newCommandClient(c).ListMintWorks(context.Background(), c.Uint64("since"))
+	if err != nil {
+		return err
 	}
-	return err
+	wm := make(map[string][2]uint64)
+	for id, w := range works {
+		wm[id.String()] = w
+	}
+	return printJSON(wm)
 }
 
 func listMintDistributionsCmd(c *cli.Context) error {
-	data, err := callRPC(c.String("node"), "listmintdistributions", []interface{}{
-		c.Uint64("since"),
-		c.Uint64("count"),
-		c.Bool("tx"),
-	}, c.Bool("time"))
-	if err == nil {
-		fmt.Println(string(data))
+	rc := newCommandClient(c)
+	mints, err := rc.ListMintDistributions(context.Background(), c.Uint64("since"), c.Uint64("count"))
+	if err != nil {
+		return err
 	}
-	return err
+	var transactions []*common.VersionedTransaction
+	if c.Bool("tx") {
+		hashes := make([]crypto.Hash, len(mints))
+		for i, m := range mints {
+			hashes[i] = m.Transaction
+		}
+		transactions, err = readTransactions(rc, hashes)
+		if err != nil {
+			return err
+		}
+	}
+	result := make([]map[string]interface{}, len(mints))
+	for i, m := range mints {
+		item := map[string]interface{}{
+			"group":  m.Group,
+			"batch":  m.Batch,
+			"amount": m.Amount,
+		}
+		if len(transactions) > 0 {
+			item["transaction"] = transactionToMap(transactions[i])
+		} else {
+			item["transaction"] = m.Transaction
+		}
+		result[i] = item
+	}
+	return printJSON(result)
 }
 
 func listAllNodesCmd(c *cli.Context) error {
-	data, err := callRPC(c.String("node"), "listallnodes", []interface{}{
-		c.Uint64("threshold"),
-		c.Bool("state"),
-	}, c.Bool("time"))
-	if err == nil {
-		fmt.Println(string(data))
-	}
-	return err
+	nodes, err := newCommandClient(c).ListAllNodes(context.Background(), c.Uint64("threshold"), c.Bool("state"))
+	return printResult(nodes, err)
 }
 
 func getInfoCmd(c *cli.Context) error {
-	data, err := callRPC(c.String("node"), "getinfo", []interface{}{}, c.Bool("time"))
-	if err == nil {
-		fmt.Println(string(data))
+	info, err := newCommandClient(c).GetInfo(context.Background())
+	if err != nil {
+		return err
 	}
-	return err
+	// the cache rounds are decoded into the kernel snapshots, which have
+	// no JSON names, so they are printed in the shape of the node response
+	cache := make(map[string]interface{})
+	for id, r := range info.Graph.Cache {
+		snapshots := make([]map[string]interface{}, len(r.Snapshots))
+		for i, s := range r.Snapshots {
+			snapshots[i] = snapshotToMap(&common.SnapshotWithTopologicalOrder{Snapshot: *s}, nil, true)
+			delete(snapshots[i], "topology")
+		}
+		cache[id] = map[string]interface{}{
+			"node":       r.Node,
+			"round":      r.Round,
+			"timestamp":  r.Timestamp,
+			"snapshots":  snapshots,
+			"references": roundLinkToMap(r.References),
+		}
+	}
+	data, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	var result map[string]interface{}
+	err = json.Unmarshal(data, &result)
+	if err != nil {
+		return err
+	}
+	result["graph"].(map[string]interface{})["cache"] = cache
+	return printJSON(result)
 }
 
 func dumpGraphHeadCmd(c *cli.Context) error {
-	data, err := callRPC(c.String("node"), "dumpgraphhead", []interface{}{}, c.Bool("time"))
-	if err == nil {
-		fmt.Println(string(data))
+	heads, err := newCommandClient(c).DumpGraphHead(context.Background())
+	return printResult(heads, err)
+}
+
+func listPeersCmd(c *cli.Context) error {
+	peers, err := newCommandClient(c).ListPeers(context.Background())
+	return printResult(peers, err)
+}
+
+func addNeighborCmd(c *cli.Context) error {
+	id, err := crypto.HashFromString(c.String("id"))
+	if err != nil {
+		return err
+	}
+	err = newCommandClient(c).AddNeighbor(context.Background(), id, c.String("address"))
+	if err != nil {
+		return err
+	}
+	return printJSON(map[string]interface{}{"id": id, "address": c.String("address")})
+}
+
+func removeNeighborCmd(c *cli.Context) error {
+	id, err := crypto.HashFromString(c.String("id"))
+	if err != nil {
+		return err
+	}
+	removed, err := newCommandClient(c).RemoveNeighbor(context.Background(), id)
+	if err != nil {
+		return err
+	}
+	return printJSON(map[string]interface{}{"removed": removed})
+}
+
+func setLogLevelCmd(c *cli.Context) error {
+	err := newCommandClient(c).SetLogLevel(context.Background(), c.Int("level"))
+	if err != nil {
+		return err
+	}
+	return printJSON(map[string]interface{}{"level": c.Int("level")})
+}
+
+func setLogLimiterCmd(c *cli.Context) error {
+	err := newCommandClient(c).SetLogLimiter(context.Background(), c.Int("limiter"))
+	if err != nil {
+		return err
+	}
+	return printJSON(map[string]interface{}{"limiter": c.Int("limiter")})
+}
+
+func setLogFilterCmd(c *cli.Context) error {
+	err := newCommandClient(c).SetLogFilter(context.Background(), c.String("filter"))
+	if err != nil {
+		return err
+	}
+	return printJSON(map[string]interface{}{"filter": c.String("filter")})
+}
+
+func runValueLogGCCmd(c *cli.Context) error {
+	rewritten, err := newCommandClient(c).RunValueLogGC(context.Background(), c.Float64("ratio"))
+	if err != nil {
+		return err
+	}
+	return printJSON(map[string]interface{}{"rewritten": rewritten})
+}
+
+func dumpGoroutinesCmd(c *cli.Context) error {
+	count, stacks, err := newCommandClient(c).DumpGoroutines(context.Background())
+	if err != nil {
+		return err
+	}
+	fmt.Printf("GOROUTINES: %d\n\n%s", count, stacks)
+	return nil
+}
+
+func dumpQueueCmd(c *cli.Context) error {
+	queue, err := newCommandClient(c).DumpQueue(context.Background())
+	return printResult(queue, err)
+}
+
+func exportCheckpointCmd(c *cli.Context) error {
+	rc := newCommandClient(c)
+	state, err := rc.ExportCheckpoint(context.Background())
+	if err != nil {
+		return err
+	}
+	done, err := pollCheckpointState(rc, state.Header)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("checkpoint:\t%s\n", done.Hash)
+	fmt.Printf("file:\t\tcheckpoint.dat in the node directory\n")
+	return nil
+}
+
+func signCheckpointCmd(c *cli.Context) error {
+	f, err := os.Open(c.String("file"))
+	if err != nil {
+		return err
+	}
+	kind, header, err := common.NewCheckpointReader(f).Next()
+	f.Close()
+	if err != nil {
+		return err
+	}
+	if kind != common.CheckpointRecordHeader {
+		return fmt.Errorf("invalid checkpoint header %d", kind)
+	}
+
+	rc := newCommandClient(c)
+	state, err := pollCheckpointState(rc, header)
+	if err != nil {
+		return err
+	}
+	hash, err := kernel.AppendCheckpointSignature(c.String("file"), state.Node, state.Signature)
+	if err != nil {
+		return err
+	}
+	if hash != state.Hash {
+		return fmt.Errorf("checkpoint hash unmatch %s %s", hash, state.Hash)
+	}
+	fmt.Printf("checkpoint:\t%s\n", hash)
+	fmt.Printf("signer:\t\t%s\n", state.Node)
+	return nil
+}
+
+// pollCheckpointState waits the node to compute the checkpoint hash with
+// the header, which reads all the state and takes a long time.
+func pollCheckpointState(rc *client.Client, header []byte) (*client.CheckpointState, error) {
+	for {
+		state, err := rc.SignCheckpoint(context.Background(), header)
+		if err != nil {
+			return nil, err
+		}
+		if state.Error != "" {
+			return nil, errors.New(state.Error)
+		}
+		if state.Done {
+			return state, nil
+		}
+		time.Sleep(5 * time.Second)
+	}
+}
+
+// downloadCheckpoint resumes the download with the range requests, because
+// the node RPC server writes a response in limited time.
+func downloadCheckpoint(url, path string) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	for {
+		offset, err := f.Seek(0, io.SeekEnd)
+		if err != nil {
+			return err
+		}
+		req, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		var total int64
+		switch resp.StatusCode {
+		case http.StatusRequestedRangeNotSatisfiable:
+			resp.Body.Close()
+			return nil
+		case http.StatusOK:
+			total = resp.ContentLength
+			err = f.Truncate(0)
+			if err == nil {
+				offset, err = f.Seek(0, io.SeekStart)
+			}
+		case http.StatusPartialContent:
+			_, err = fmt.Sscanf(resp.Header.Get("Content-Range"), "bytes %d-%d/%d", new(int64), new(int64), &total)
+		default:
+			err = fmt.Errorf("checkpoint download %s %s", url, resp.Status)
+		}
+		if err != nil {
+			resp.Body.Close()
+			return err
+		}
+		n, err := io.Copy(f, resp.Body)
+		resp.Body.Close()
+		if offset+n == total {
+			return nil
+		}
+		if n == 0 {
+			return fmt.Errorf("checkpoint download %s stalled at %d %v", url, offset, err)
+		}
 	}
-	return err
 }
 
 func setupTestNetCmd(c *cli.Context) error {
@@ -794,57 +1163,104 @@ peers = [%s]
 	return nil
 }
 
-var httpClient *http.Client
+func newRPCClient(node string) *client.Client {
+	return client.NewClient(strings.Split(node, ",")...)
+}
 
-func callRPC(node, method string, params []interface{}, pt bool) ([]byte, error) {
-	if httpClient == nil {
-		httpClient = &http.Client{Timeout: 60 * time.Second}
+// newCommandClient is the RPC client of the node flag, with the admin token
+// and the runtime printed if the time flag set.
+func newCommandClient(c *cli.Context) *client.Client {
+	rc := newRPCClient(c.String("node"))
+	rc.AdminToken = c.String("admin-token")
+	if c.Bool("time") {
+		rc.OnRuntime = func(method, runtime string) {
+			fmt.Printf("RUNTIME: %s\n\n", runtime)
+		}
 	}
+	return rc
+}
 
-	body, err := json.Marshal(map[string]interface{}{
-		"method": method,
-		"params": params,
-	})
+func printResult(v interface{}, err error) error {
 	if err != nil {
-		panic(err)
+		return err
 	}
+	return printJSON(v)
+}
 
-	endpoint := "http://" + node
-	if strings.HasPrefix(node, "http") {
-		endpoint = node
-	}
-	req, err := http.NewRequest("POST", endpoint, bytes.NewReader(body))
+func printJSON(v interface{}) error {
+	data, err := json.Marshal(v)
 	if err != nil {
-		return nil, err
+		return err
 	}
+	fmt.Println(string(data))
+	return nil
+}
 
-	req.Close = true
-	req.Header.Set("Content-Type", "application/json")
-	resp, err := httpClient.Do(req)
-	if err != nil {
-		return nil, err
+// readTransactions reads the transactions one by one, because the client
+// listings return the transaction hashes only.
+func readTransactions(rc *client.Client, hashes []crypto.Hash) ([]*common.VersionedTransaction, error) {
+	transactions := make([]*common.VersionedTransaction, len(hashes))
+	for i, h := range hashes {
+		tx, _, err := rc.GetTransaction(context.Background(), h)
+		if err != nil {
+			return nil, err
+		}
+		if tx == nil {
+			return nil, fmt.Errorf("transaction %s not found", h)
+		}
+		transactions[i] = tx
 	}
-	defer resp.Body.Close()
+	return transactions, nil
+}
 
-	var result struct {
-		Runtime string      `json:"runtime"`
-		Data    interface{} `json:"data"`
-		Error   interface{} `json:"error"`
+func roundToMap(r *client.Round) map[string]interface{} {
+	snapshots := make([]map[string]interface{}, len(r.Snapshots))
+	for i, s := range r.Snapshots {
+		snapshots[i] = snapshotToMap(s, nil, false)
 	}
-	dec := json.NewDecoder(resp.Body)
-	dec.UseNumber()
-	err = dec.Decode(&result)
-	if err != nil {
-		return nil, err
+	return map[string]interface{}{
+		"node":       r.Node,
+		"hash":       r.Hash,
+		"start":      r.Start,
+		"end":        r.End,
+		"number":     r.Number,
+		"references": roundLinkToMap(r.References),
+		"snapshots":  snapshots,
+	}
+}
+
+func roundLinkToMap(r *common.RoundLink) map[string]interface{} {
+	if r == nil {
+		return nil
 	}
-	if result.Error != nil {
-		return nil, fmt.Errorf("ERROR %s", result.Error)
+	return map[string]interface{}{
+		"self":     r.Self.String(),
+		"external": r.External.String(),
 	}
+}
 
-	if len(result.Runtime) > 0 && pt {
-		fmt.Printf("RUNTIME: %s\n\n", result.Runtime)
+func snapshotToMap(s *common.SnapshotWithTopologicalOrder, tx *common.VersionedTransaction, sig bool) map[string]interface{} {
+	item := map[string]interface{}{
+		"version":    s.Version,
+		"node":       s.NodeId,
+		"references": roundLinkToMap(s.References),
+		"round":      s.RoundNumber,
+		"timestamp":  s.Timestamp,
+		"hash":       s.Hash,
+		"topology":   s.TopologicalOrder,
+	}
+	if tx != nil {
+		item["transaction"] = transactionToMap(tx)
+	} else {
+		item["transaction"] = s.Transaction
+	}
+	if sig && s.Version == 0 {
+		item["signatures"] = s.Signatures
 	}
-	return json.Marshal(result.Data)
+	if sig && s.Version == common.SnapshotVersion {
+		item["signature"] = s.Signature
+	}
+	return item
 }
 
 type signerInput struct {
@@ -885,16 +1301,11 @@ func (raw signerInput) ReadUTXOKeys(hash crypto.Hash, index int) (*common.UTXOKe
 		}
 	}
 
-	data, err := callRPC(raw.Node, "getutxo", []interface{}{hash.String(), index}, false)
-	if err != nil {
-		return nil, err
-	}
-	var out common.UTXOWithLock
-	err = json.Unmarshal(data, &out)
+	out, err := newRPCClient(raw.Node).GetUTXO(context.Background(), hash, index)
 	if err != nil {
 		return nil, err
 	}
-	if out.Amount.Sign() == 0 {
+	if out == nil || out.Amount.Sign() == 0 {
 		return nil, fmt.Errorf("invalid input %s#%d", hash.String(), index)
 	}
 	utxo.Keys = out.Keys
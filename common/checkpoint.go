@@ -0,0 +1,176 @@
+package common
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/MixinNetwork/mixin/crypto"
+)
+
+// A checkpoint is a stream of records, the header first, then the state
+// finalized in the rounds up to the heads, and the signatures last. The
+// hash of the checkpoint covers all records except the signatures, so the
+// signatures could be appended to the file later. The snapshot and
+// transaction records are hashed with their signatures, so the signatures
+// imported are covered by the threshold signed hash, except the mint
+// transactions, which are signed by each node and never verified, so they
+// are hashed by the payload only. If the signatures of a record differ among
+// the nodes, the nodes won't sign the same checkpoint hash.
+const (
+	CheckpointVersion       = 1
+	CheckpointRecordMaxSize = 16 * 1024 * 1024
+
+	CheckpointRecordHeader      = 1
+	CheckpointRecordNode        = 2
+	CheckpointRecordDomain      = 3
+	CheckpointRecordMint        = 4
+	CheckpointRecordDeposit     = 5
+	CheckpointRecordUTXO        = 6
+	CheckpointRecordTransaction = 7
+	CheckpointRecordSnapshot    = 8
+	CheckpointRecordSignature   = 9
+)
+
+// CheckpointHead is the last round of the chain included, the topological
+// orders are local to each node, so the checkpoint is cut by rounds. The
+// hash of the round is final, even if the node hasn't started a new round
+// after it, e.g. the chain of a crashed node.
+type CheckpointHead struct {
+	NodeId crypto.Hash
+	Number uint64
+	Hash   crypto.Hash
+}
+
+type CheckpointHeader struct {
+	Version   uint8
+	NetworkId crypto.Hash
+	Timestamp uint64
+	Heads     []*CheckpointHead
+}
+
+type CheckpointNode struct {
+	Signer      crypto.Key
+	Payee       crypto.Key
+	State       string
+	Transaction crypto.Hash
+	Timestamp   uint64
+}
+
+type CheckpointDomain struct {
+	Signer      crypto.Key
+	Transaction crypto.Hash
+	Timestamp   uint64
+}
+
+type CheckpointDeposit struct {
+	Key         crypto.Hash
+	Transaction crypto.Hash
+}
+
+type CheckpointTransaction struct {
+	Data []byte
+}
+
+type CheckpointSignature struct {
+	NodeId    crypto.Hash
+	Signature crypto.Signature
+}
+
+type CheckpointWriter struct {
+	w    *bufio.Writer
+	hash crypto.Hash
+}
+
+func NewCheckpointWriter(w io.Writer) *CheckpointWriter {
+	return &CheckpointWriter{w: bufio.NewWriter(w)}
+}
+
+func (cw *CheckpointWriter) Write(kind uint8, v interface{}) error {
+	data := MsgpackMarshalPanic(v)
+	if len(data) > CheckpointRecordMaxSize {
+		return fmt.Errorf("checkpoint record too large %d %d", kind, len(data))
+	}
+	header := make([]byte, 5)
+	header[0] = kind
+	binary.BigEndian.PutUint32(header[1:], uint32(len(data)))
+	_, err := cw.w.Write(header)
+	if err != nil {
+		return err
+	}
+	_, err = cw.w.Write(data)
+	if err != nil {
+		return err
+	}
+	cw.hash, err = checkpointHash(cw.hash, kind, data)
+	return err
+}
+
+func (cw *CheckpointWriter) Flush() error {
+	return cw.w.Flush()
+}
+
+// Hash returns the hash of all records written, except the signatures.
+func (cw *CheckpointWriter) Hash() crypto.Hash {
+	return cw.hash
+}
+
+type CheckpointReader struct {
+	r    *bufio.Reader
+	hash crypto.Hash
+}
+
+func NewCheckpointReader(r io.Reader) *CheckpointReader {
+	return &CheckpointReader{r: bufio.NewReader(r)}
+}
+
+// Next returns the kind and data of the next record, or io.EOF at the end.
+func (cr *CheckpointReader) Next() (uint8, []byte, error) {
+	header := make([]byte, 5)
+	_, err := io.ReadFull(cr.r, header)
+	if err != nil {
+		return 0, nil, err
+	}
+	size := binary.BigEndian.Uint32(header[1:])
+	if size > CheckpointRecordMaxSize {
+		return 0, nil, fmt.Errorf("checkpoint record too large %d %d", header[0], size)
+	}
+	data := make([]byte, size)
+	_, err = io.ReadFull(cr.r, data)
+	if err == io.EOF {
+		return 0, nil, io.ErrUnexpectedEOF
+	} else if err != nil {
+		return 0, nil, err
+	}
+	cr.hash, err = checkpointHash(cr.hash, header[0], data)
+	return header[0], data, err
+}
+
+// Hash returns the hash of all records read, except the signatures.
+func (cr *CheckpointReader) Hash() crypto.Hash {
+	return cr.hash
+}
+
+func checkpointHash(prev crypto.Hash, kind uint8, data []byte) (crypto.Hash, error) {
+	switch kind {
+	case CheckpointRecordSignature:
+		return prev, nil
+	case CheckpointRecordTransaction:
+		var t CheckpointTransaction
+		err := MsgpackUnmarshal(data, &t)
+		if err != nil {
+			return prev, err
+		}
+		ver, err := UnmarshalVersionedTransaction(t.Data)
+		if err != nil {
+			return prev, err
+		}
+		if ver.TransactionType() == TransactionTypeMint {
+			payload := ver.PayloadHash()
+			data = payload[:]
+		}
+	}
+	buf := append(prev[:], kind)
+	return crypto.NewHash(append(buf, data...)), nil
+}
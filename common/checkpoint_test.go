@@ -0,0 +1,79 @@
+package common
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/MixinNetwork/mixin/crypto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckpoint(t *testing.T) {
+	assert := assert.New(t)
+
+	header := &CheckpointHeader{
+		Version:   CheckpointVersion,
+		NetworkId: crypto.NewHash([]byte("network")),
+		Timestamp: 1551312000000000000,
+		Heads:     []*CheckpointHead{{NodeId: crypto.NewHash([]byte("node")), Number: 7}},
+	}
+	tx := NewTransaction(XINAssetId)
+	tx.AddInput(crypto.NewHash([]byte("input")), 0)
+	ver := tx.AsLatestVersion()
+	snap := &Snapshot{Version: SnapshotVersion, NodeId: header.Heads[0].NodeId, RoundNumber: 7, Transaction: ver.PayloadHash()}
+
+	write := func(sig byte) ([]byte, crypto.Hash) {
+		ver.SignaturesMap = []map[uint16]*crypto.Signature{{0: &crypto.Signature{sig}}}
+		snap.Signature = &crypto.CosiSignature{Signature: crypto.Signature{sig}, Mask: uint64(sig)}
+		var buf bytes.Buffer
+		w := NewCheckpointWriter(&buf)
+		assert.Nil(w.Write(CheckpointRecordHeader, header))
+		assert.Nil(w.Write(CheckpointRecordTransaction, &CheckpointTransaction{Data: ver.Marshal()}))
+		assert.Nil(w.Write(CheckpointRecordSnapshot, snap))
+		hash := w.Hash()
+		assert.Nil(w.Write(CheckpointRecordSignature, &CheckpointSignature{NodeId: snap.NodeId}))
+		assert.Equal(hash, w.Hash())
+		assert.Nil(w.Flush())
+		return buf.Bytes(), hash
+	}
+	data, hash := write(1)
+	other, otherHash := write(2)
+	assert.NotEqual(data, other)
+	assert.NotEqual(hash, otherHash)
+
+	r := NewCheckpointReader(bytes.NewReader(data))
+	kinds := []uint8{CheckpointRecordHeader, CheckpointRecordTransaction, CheckpointRecordSnapshot, CheckpointRecordSignature}
+	for _, k := range kinds {
+		kind, _, err := r.Next()
+		assert.Nil(err)
+		assert.Equal(k, kind)
+	}
+	_, _, err := r.Next()
+	assert.Equal(io.EOF, err)
+	assert.Equal(hash, r.Hash())
+
+	r = NewCheckpointReader(bytes.NewReader(data[:len(data)-1]))
+	for i := 0; i < 3; i++ {
+		_, _, err = r.Next()
+		assert.Nil(err)
+	}
+	_, _, err = r.Next()
+	assert.Equal(io.ErrUnexpectedEOF, err)
+
+	header.Heads[0].Number = 8
+	_, changed := write(1)
+	assert.NotEqual(hash, changed)
+
+	mint := NewTransaction(XINAssetId)
+	mint.AddKernelNodeMintInput(1, NewInteger(100))
+	mver := mint.AsLatestVersion()
+	hashes := make([]crypto.Hash, 2)
+	for i := range hashes {
+		mver.SignaturesMap = []map[uint16]*crypto.Signature{{0: &crypto.Signature{byte(i)}}}
+		w := NewCheckpointWriter(io.Discard)
+		assert.Nil(w.Write(CheckpointRecordTransaction, &CheckpointTransaction{Data: mver.Marshal()}))
+		hashes[i] = w.Hash()
+	}
+	assert.Equal(hashes[0], hashes[1])
+}
@@ -3,6 +3,7 @@ package common
 import (
 	"bytes"
 	_ "embed"
+	"encoding/binary"
 	"encoding/hex"
 	"fmt"
 
@@ -21,29 +22,36 @@ func init() {
 }
 
 var (
-	// zstd --train /tmp/zstd/* -o config/data/zstd.dic
+	// go run ./cmd/build-zstd-dict -db /tmp/mixin/snapshots -dic /tmp/zstd
+	// zstd --train /tmp/zstd/* --maxdict=112640 -o common/data/zstd-1.dic
 	zstdEncoder *zstd.Encoder
 	zstdDecoder *zstd.Decoder
 
-	CompressionVersionZero   = []byte{0, 0, 0, 0}
-	CompressionVersionLatest = CompressionVersionZero
+	CompressionVersionZero   = zstdCompressionVersion(ZstdDictionaryVersionZero)
+	CompressionVersionLatest = zstdCompressionVersion(ZstdDictionaryVersionStorage)
 )
 
+// compressionVersion checks the dictionary version tagged to the compressed
+// data, all embedded versions are readable.
+func compressionVersion(b []byte) bool {
+	header := len(CompressionVersionLatest)
+	if len(b) < header*2 {
+		return false
+	}
+	_, found := zstdDictionaries[binary.BigEndian.Uint32(b[:header])]
+	return found
+}
+
 func Compress(b []byte) []byte {
 	b = zstdEncoder.EncodeAll(b, nil)
 	return append(CompressionVersionLatest, b...)
 }
 
 func Decompress(b []byte) []byte {
-	header := len(CompressionVersionLatest)
-	if len(b) < header*2 {
+	if !compressionVersion(b) {
 		return nil
 	}
-
-	if !bytes.Equal(b[:header], CompressionVersionZero) {
-		return nil
-	}
-	b, err := zstdDecoder.DecodeAll(b[header:], nil)
+	b, err := zstdDecoder.DecodeAll(b[len(CompressionVersionLatest):], nil)
 	if err != nil {
 		return nil
 	}
@@ -57,14 +65,8 @@ func CompressMsgpackMarshalPanic(val interface{}) []byte {
 }
 
 func DecompressMsgpackUnmarshal(data []byte, val interface{}) error {
-	header := len(CompressionVersionLatest)
-	if len(data) < header*2 {
-		return MsgpackUnmarshal(data, val)
-	}
-
-	version := data[:header]
-	if bytes.Equal(version, CompressionVersionZero) {
-		payload, err := zstdDecoder.DecodeAll(data[header:], nil)
+	if compressionVersion(data) {
+		payload, err := zstdDecoder.DecodeAll(data[len(CompressionVersionLatest):], nil)
 		if err != nil {
 			return err
 		}
@@ -139,8 +139,13 @@ func validateInputs(store UTXOLockReader, tx *SignedTransaction, msg []byte, has
 		allKeys = append(allKeys, utxo.Keys...)
 	}
 
-	if len(keySigs) == 0 && (txType == TransactionTypeNodeAccept || txType == TransactionTypeNodeRemove) {
-		return inputsFilter, inputAmount, nil
+	// the pledge input of the cancel transaction has no keys, and the only
+	// signature is verified by the pledge source key in validateNodeCancel
+	switch txType {
+	case TransactionTypeNodeAccept, TransactionTypeNodeRemove, TransactionTypeNodeCancel:
+		if len(keySigs) == 0 {
+			return inputsFilter, inputAmount, nil
+		}
 	}
 	if len(keySigs) < len(tx.Inputs) {
 		return inputsFilter, inputAmount, fmt.Errorf("batch verification not ready %d %d", len(tx.Inputs), len(keySigs))
@@ -1,17 +1,66 @@
 package common
 
 import (
+	_ "embed"
+	"encoding/binary"
+	"fmt"
 	"runtime"
+	"sort"
 
 	"github.com/klauspost/compress/zstd"
 )
 
+// The zstd dictionaries are trained from the samples dumped by the
+// cmd/build-zstd-dict, and embedded by the version. A released dictionary
+// must never be changed or removed, because the stored values are tagged
+// with the dictionary version, and the zstd frames with the dictionary id,
+// so the decoder with all dictionaries could read both old and new data.
+// A new dictionary is added with a new version, e.g. data/zstd-2.dic, and
+// the latest version is preferred by the transport, if the neighbor has it
+// too. The storage keeps writing with the version zero, so the data is still
+// readable after a downgrade to the nodes without the newer dictionaries.
+const (
+	ZstdDictionaryVersionZero    = 0
+	ZstdDictionaryVersionOne     = 1
+	ZstdDictionaryVersionLatest  = ZstdDictionaryVersionOne
+	ZstdDictionaryVersionStorage = ZstdDictionaryVersionZero
+)
+
+//go:embed data/zstd-1.dic
+var zstdDictionaryOne []byte
+
+var zstdDictionaries = map[uint32][]byte{
+	ZstdDictionaryVersionZero: ZstdEmbed,
+	ZstdDictionaryVersionOne:  zstdDictionaryOne,
+}
+
+// ZstdDictionaryVersions returns all the dictionary versions embedded, the
+// newer first.
+func ZstdDictionaryVersions() []uint32 {
+	versions := make([]uint32, 0, len(zstdDictionaries))
+	for v := range zstdDictionaries {
+		versions = append(versions, v)
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i] > versions[j] })
+	return versions
+}
+
+func zstdCompressionVersion(dict uint32) []byte {
+	version := make([]byte, 4)
+	binary.BigEndian.PutUint32(version, dict)
+	return version
+}
+
 func NewZstdDecoder(ccr int) *zstd.Decoder {
 	if ccr > runtime.GOMAXPROCS(0) {
 		ccr = runtime.GOMAXPROCS(0)
 	}
+	var dicts [][]byte
+	for _, v := range ZstdDictionaryVersions() {
+		dicts = append(dicts, zstdDictionaries[v])
+	}
 	opts := []zstd.DOption{
-		zstd.WithDecoderDicts(ZstdEmbed),
+		zstd.WithDecoderDicts(dicts...),
 		zstd.WithDecoderConcurrency(ccr),
 		zstd.WithDecoderLowmem(true),
 		zstd.WithDecoderMaxMemory(1024 * 1024 * 16),
@@ -24,16 +73,32 @@ func NewZstdDecoder(ccr int) *zstd.Decoder {
 }
 
 func NewZstdEncoder(ccr int) *zstd.Encoder {
+	return NewZstdDictionaryEncoder(ccr, ZstdDictionaryVersionStorage)
+}
+
+// NewZstdDictionaryEncoder creates the encoder with the dictionary version,
+// which must be embedded.
+func NewZstdDictionaryEncoder(ccr int, version uint32) *zstd.Encoder {
+	dict, found := zstdDictionaries[version]
+	if !found {
+		panic(fmt.Errorf("zstd dictionary %d not found", version))
+	}
+	return newZstdEncoder(ccr, dict)
+}
+
+func newZstdEncoder(ccr int, dict []byte) *zstd.Encoder {
 	if ccr > runtime.GOMAXPROCS(0) {
 		ccr = runtime.GOMAXPROCS(0)
 	}
 	opts := []zstd.EOption{
 		zstd.WithEncoderConcurrency(ccr),
-		zstd.WithEncoderDict(ZstdEmbed),
 		zstd.WithEncoderLevel(3),
 		zstd.WithWindowSize(1024 * 32),
 		zstd.WithEncoderCRC(false),
 	}
+	if dict != nil {
+		opts = append(opts, zstd.WithEncoderDict(dict))
+	}
 	enc, err := zstd.NewWriter(nil, opts...)
 	if err != nil {
 		panic(err)
@@ -0,0 +1,181 @@
+package common
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/MixinNetwork/mixin/crypto"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestZstd(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal([]uint32{ZstdDictionaryVersionOne, ZstdDictionaryVersionZero}, ZstdDictionaryVersions())
+	assert.Equal(uint32(ZstdDictionaryVersionOne), uint32(ZstdDictionaryVersionLatest))
+	assert.Equal([]byte{0, 0, 0, 0}, CompressionVersionZero)
+	assert.Equal(CompressionVersionZero, CompressionVersionLatest)
+	assert.Panics(func() { NewZstdDictionaryEncoder(1, 100) })
+
+	snap := zstdSampleSnapshot()
+	data := CompressMsgpackMarshalPanic(snap)
+	assert.Equal(CompressionVersionLatest, data[:4])
+	var frame zstd.Header
+	assert.Nil(frame.Decode(data[4:]))
+	assert.NotZero(frame.DictionaryID)
+	var out Snapshot
+	assert.Nil(DecompressMsgpackUnmarshal(data, &out))
+	assert.Equal(snap.PayloadHash(), out.PayloadHash())
+	assert.Equal(MsgpackMarshalPanic(snap), Decompress(Compress(MsgpackMarshalPanic(snap))))
+
+	enc := newZstdEncoder(1, nil)
+	defer enc.Close()
+	plain := append(CompressionVersionZero, enc.EncodeAll(MsgpackMarshalPanic(snap), nil)...)
+	assert.Nil(DecompressMsgpackUnmarshal(plain, &out))
+	assert.Equal(snap.PayloadHash(), out.PayloadHash())
+
+	dec := NewZstdDecoder(1)
+	defer dec.Close()
+	ids := make(map[uint32]bool)
+	for _, v := range ZstdDictionaryVersions() {
+		enc := NewZstdDictionaryEncoder(1, v)
+		defer enc.Close()
+		frame := enc.EncodeAll(MsgpackMarshalPanic(snap), nil)
+		var header zstd.Header
+		assert.Nil(header.Decode(frame))
+		assert.NotZero(header.DictionaryID)
+		ids[header.DictionaryID] = true
+		payload, err := dec.DecodeAll(frame, nil)
+		assert.Nil(err)
+		assert.Equal(MsgpackMarshalPanic(snap), payload)
+		tagged := append(zstdCompressionVersion(v), frame...)
+		assert.Nil(DecompressMsgpackUnmarshal(tagged, &out))
+		assert.Equal(snap.PayloadHash(), out.PayloadHash())
+	}
+	assert.Len(ids, len(ZstdDictionaryVersions()))
+
+	unknown := append([]byte{}, data...)
+	binary.BigEndian.PutUint32(unknown, 100)
+	assert.Nil(Decompress(unknown))
+	assert.NotNil(DecompressMsgpackUnmarshal(unknown, &out))
+	assert.Nil(DecompressMsgpackUnmarshal(MsgpackMarshalPanic(snap), &out))
+	assert.Equal(snap.PayloadHash(), out.PayloadHash())
+}
+
+// BenchmarkZstd compares the compression without and with the dictionary,
+// and reports the ratio of compressed size. The samples dumped by the
+// cmd/build-zstd-dict are used if MIXIN_ZSTD_SAMPLES is the directory,
+// otherwise the random snapshots and transactions.
+func BenchmarkZstd(b *testing.B) {
+	samples := zstdBenchmarkSamples(b)
+	names, dicts := []string{"none"}, [][]byte{nil}
+	for _, v := range ZstdDictionaryVersions() {
+		names = append(names, fmt.Sprintf("dict%d", v))
+		dicts = append(dicts, zstdDictionaries[v])
+	}
+	for i, name := range names {
+		enc := newZstdEncoder(1, dicts[i])
+		defer enc.Close()
+		compressed := make([][]byte, len(samples))
+		var raw, size int
+		for i, s := range samples {
+			compressed[i] = enc.EncodeAll(s, nil)
+			raw, size = raw+len(s), size+len(compressed[i])
+		}
+
+		b.Run("encode-"+name, func(b *testing.B) {
+			b.SetBytes(int64(raw / len(samples)))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				enc.EncodeAll(samples[i%len(samples)], nil)
+			}
+			b.ReportMetric(float64(size)/float64(raw), "ratio")
+		})
+		b.Run("decode-"+name, func(b *testing.B) {
+			dec := NewZstdDecoder(1)
+			defer dec.Close()
+			b.SetBytes(int64(raw / len(samples)))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				_, err := dec.DecodeAll(compressed[i%len(compressed)], nil)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func zstdBenchmarkSamples(b *testing.B) [][]byte {
+	var samples [][]byte
+	if dir := os.Getenv("MIXIN_ZSTD_SAMPLES"); dir != "" {
+		files, err := filepath.Glob(filepath.Join(dir, "*"))
+		if err != nil {
+			b.Fatal(err)
+		}
+		for _, f := range files {
+			data, err := os.ReadFile(f)
+			if err != nil {
+				b.Fatal(err)
+			}
+			samples = append(samples, data)
+		}
+		if len(samples) == 0 {
+			b.Fatalf("no zstd samples in %s", dir)
+		}
+		return samples
+	}
+	for i := 0; i < 1000; i++ {
+		snap := zstdSampleSnapshot()
+		samples = append(samples, MsgpackMarshalPanic(&SnapshotWithTopologicalOrder{
+			Snapshot:         *snap,
+			TopologicalOrder: uint64(i),
+		}))
+		samples = append(samples, zstdSampleTransaction().Marshal())
+	}
+	return samples
+}
+
+func zstdSampleSnapshot() *Snapshot {
+	snap := &Snapshot{
+		Version:     SnapshotVersion,
+		NodeId:      zstdRandomHash(),
+		Transaction: zstdRandomHash(),
+		References:  &RoundLink{Self: zstdRandomHash(), External: zstdRandomHash()},
+		RoundNumber: 1024,
+		Timestamp:   1551312000000000000,
+		Signature:   &crypto.CosiSignature{Mask: 0x7ff},
+	}
+	rand.Read(snap.Signature.Signature[:])
+	return snap
+}
+
+func zstdSampleTransaction() *VersionedTransaction {
+	tx := NewTransaction(XINAssetId)
+	tx.AddInput(zstdRandomHash(), 0)
+	accounts := []*Address{}
+	for i := 0; i < 2; i++ {
+		a := randomAccount()
+		accounts = append(accounts, &a)
+	}
+	tx.AddRandomScriptOutput(accounts[:1], NewThresholdScript(1), NewIntegerFromString("20"))
+	tx.AddRandomScriptOutput(accounts[1:], NewThresholdScript(1), NewIntegerFromString("8273"))
+	extra := zstdRandomHash()
+	tx.Extra = extra[:16]
+	ver := tx.AsLatestVersion()
+	var sig crypto.Signature
+	rand.Read(sig[:])
+	ver.SignaturesMap = []map[uint16]*crypto.Signature{{0: &sig}}
+	return ver
+}
+
+func zstdRandomHash() crypto.Hash {
+	seed := make([]byte, 64)
+	rand.Read(seed)
+	return crypto.NewHash(seed)
+}
@@ -41,6 +41,7 @@ type Custom struct {
 		KernelOprationPeriod int        `toml:"kernel-operation-period"`
 		MemoryCacheSize      int        `toml:"memory-cache-size"`
 		CacheTTL             int        `toml:"cache-ttl"`
+		ClockSkewLimit       int        `toml:"clock-skew-limit"`
 	} `toml:"node"`
 	Storage struct {
 		Truncate      bool `toml:"truncate"`
@@ -48,12 +49,22 @@ type Custom struct {
 		LowMemoryMode bool `toml:"low-memory-mode"`
 	} `toml:"storage"`
 	Network struct {
-		Listener        string   `toml:"listener"`
-		GossipNeighbors bool     `toml:"gossip-neighbors"`
-		Peers           []string `toml:"peers"`
+		Listener             string   `toml:"listener"`
+		Advertise            []string `toml:"advertise"`
+		Listen               []string `toml:"listen"`
+		TCP                  bool     `toml:"tcp"`
+		GossipNeighbors      bool     `toml:"gossip-neighbors"`
+		Peers                []string `toml:"peers"`
+		SyncBandwidth        int      `toml:"sync-bandwidth"`
+		SyncMessages         int      `toml:"sync-messages"`
+		MessageRecorder      string   `toml:"message-recorder"`
+		LegacyAuthentication bool     `toml:"legacy-authentication"`
 	} `toml:"network"`
 	RPC struct {
-		Runtime bool `toml:"runtime"`
+		Runtime            bool   `toml:"runtime"`
+		AdminToken         string `toml:"admin-token"`
+		ReadyNeighbors     int    `toml:"ready-neighbors"`
+		ReadyTopologyStall int    `toml:"ready-topology-stall"`
 	} `toml:"rpc"`
 	Dev struct {
 		Profile bool `toml:"profile"`
@@ -66,6 +77,9 @@ func Initialize(file string) (*Custom, error) {
 		return nil, err
 	}
 	var config Custom
+	// the neighbors check of /readyz is disabled by 0, so a negative
+	// value means the option is not set, and defaults to 1
+	config.RPC.ReadyNeighbors = -1
 	err = toml.Unmarshal(f, &config)
 	if err != nil {
 		return nil, err
@@ -84,5 +98,11 @@ func Initialize(file string) (*Custom, error) {
 	if config.Node.CacheTTL == 0 {
 		config.Node.CacheTTL = 3600 * 2
 	}
+	if config.RPC.ReadyNeighbors < 0 {
+		config.RPC.ReadyNeighbors = 1
+	}
+	if config.RPC.ReadyTopologyStall == 0 {
+		config.RPC.ReadyTopologyStall = 600
+	}
 	return &config, nil
 }
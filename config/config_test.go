@@ -1,6 +1,8 @@
 package config
 
 import (
+	"os"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -19,8 +21,29 @@ func TestConfig(t *testing.T) {
 	assert.Equal(7200, custom.Node.CacheTTL)
 
 	assert.Equal("mixin-node.example.com:7239", custom.Network.Listener)
+	assert.Len(custom.Network.Advertise, 0)
+	assert.Len(custom.Network.Listen, 0)
+	assert.Equal(false, custom.Network.TCP)
+	assert.Equal(false, custom.Network.LegacyAuthentication)
 	assert.Len(custom.Network.Peers, 37)
 	assert.Equal("lehigh-2.hotot.org:7239", custom.Network.Peers[36])
 
 	assert.Equal(false, custom.RPC.Runtime)
+	assert.Equal("", custom.RPC.AdminToken)
+	assert.Equal(1, custom.RPC.ReadyNeighbors)
+	assert.Equal(600, custom.RPC.ReadyTopologyStall)
+
+	example, err := os.ReadFile("./config.example.toml")
+	assert.Nil(err)
+	path := t.TempDir() + "/config.toml"
+	for _, c := range []struct {
+		line      string
+		neighbors int
+	}{{"ready-neighbors = 0", 0}, {"ready-neighbors = 3", 3}, {"", 1}} {
+		data := strings.Replace(string(example), "ready-neighbors = 1", c.line, 1)
+		assert.Nil(os.WriteFile(path, []byte(data), 0600))
+		custom, err = Initialize(path)
+		assert.Nil(err)
+		assert.Equal(c.neighbors, custom.RPC.ReadyNeighbors)
+	}
 }
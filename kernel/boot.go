@@ -5,6 +5,7 @@ import (
 	"math/rand"
 	"time"
 
+	"github.com/MixinNetwork/mixin/crypto"
 	"github.com/MixinNetwork/mixin/kernel/internal/clock"
 )
 
@@ -22,21 +23,45 @@ func (node *Node) Loop() error {
 	}()
 	go node.LoopCacheQueue()
 	go node.MintLoop()
+	go node.ClockLoop()
 	node.ElectionLoop()
 	return nil
 }
 
+// Teardown cancels the node context, which is the root of all the loops of
+// the node, chains and peer, then waits for them to stop.
 func (node *Node) Teardown() {
-	close(node.done)
+	node.cancel()
 	<-node.cqc
 	<-node.mlc
 	<-node.elc
-	node.chains.RLock()
-	for _, c := range node.chains.m {
-		c.Teardown()
-	}
-	node.chains.RUnlock()
+	<-node.ckc
 	node.Peer.Teardown()
+	if node.recorder != nil {
+		node.recorder.Close()
+	}
+
+	// the chains must be torn down without the lock, because the chain loops
+	// may create new chains before they stop, and no more chains created once
+	// all the loops stopped
+	torn := make(map[crypto.Hash]bool)
+	for {
+		var chains []*Chain
+		node.chains.RLock()
+		for id, c := range node.chains.m {
+			if !torn[id] {
+				chains = append(chains, c)
+				torn[id] = true
+			}
+		}
+		node.chains.RUnlock()
+		if len(chains) == 0 {
+			break
+		}
+		for _, c := range chains {
+			c.Teardown()
+		}
+	}
 	node.persistStore.Close()
 	node.cacheStore.Reset()
 }
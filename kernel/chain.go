@@ -1,6 +1,7 @@
 package kernel
 
 import (
+	"context"
 	"fmt"
 	"sync"
 	"time"
@@ -11,6 +12,7 @@ import (
 	"github.com/MixinNetwork/mixin/kernel/internal/clock"
 	"github.com/MixinNetwork/mixin/logger"
 	"github.com/MixinNetwork/mixin/storage"
+	"github.com/MixinNetwork/mixin/util"
 )
 
 const (
@@ -62,7 +64,8 @@ type Chain struct {
 	plc              chan struct{}
 	clc              chan struct{}
 	wlc              chan struct{}
-	running          bool
+	ctx              context.Context
+	cancel           context.CancelFunc
 }
 
 func (node *Node) buildChain(chainId crypto.Hash) *Chain {
@@ -77,8 +80,8 @@ func (node *Node) buildChain(chainId crypto.Hash) *Chain {
 		plc:              make(chan struct{}),
 		clc:              make(chan struct{}),
 		wlc:              make(chan struct{}),
-		running:          true,
 	}
+	chain.ctx, chain.cancel = context.WithCancel(node.ctx)
 
 	err := chain.loadState()
 	if err != nil {
@@ -126,7 +129,7 @@ func (chain *Chain) loadIdentity() *CNode {
 }
 
 func (chain *Chain) Teardown() {
-	chain.running = false
+	chain.cancel()
 	<-chain.clc
 	<-chain.plc
 	<-chain.wlc
@@ -185,7 +188,7 @@ func (chain *Chain) QueuePollSnapshots() {
 	logger.Printf("QueuePollSnapshots(%s)\n", chain.ChainId)
 	defer close(chain.plc)
 
-	for chain.running {
+	for chain.ctx.Err() == nil {
 		final, cache, stale := 0, 0, false
 		for i := 0; i < 2; i++ {
 			index := (chain.FinalIndex + i) % FinalPoolSlotsLimit
@@ -253,9 +256,9 @@ func (chain *Chain) QueuePollSnapshots() {
 		logger.Debugf("QueuePollSnapshots cache pool end %s when final %d %d\n", chain.ChainId, chain.FinalIndex, chain.FinalCount)
 
 		if stale || final == 0 && cache == 0 {
-			time.Sleep(300 * time.Millisecond)
+			util.Sleep(chain.ctx, 300*time.Millisecond)
 		} else {
-			time.Sleep(100 * time.Millisecond)
+			util.Sleep(chain.ctx, 100*time.Millisecond)
 		}
 	}
 }
@@ -270,19 +273,19 @@ func (chain *Chain) ConsumeFinalActions() {
 	logger.Printf("ConsumeFinalActions(%s)\n", chain.ChainId)
 	defer close(chain.clc)
 
-	for chain.running {
+	for chain.ctx.Err() == nil {
 		ps := chain.finalActionsRing.Poll()
 		if ps == nil {
-			time.Sleep(100 * time.Millisecond)
+			util.Sleep(chain.ctx, 100*time.Millisecond)
 			continue
 		}
 		logger.Debugf("ConsumeFinalActions(%s) %s\n", chain.ChainId, ps.Snapshot.Hash)
-		for chain.running {
+		for chain.ctx.Err() == nil {
 			retry, err := chain.appendFinalSnapshot(ps.PeerId, ps.Snapshot)
 			if err != nil {
 				panic(err)
 			} else if retry {
-				time.Sleep(1 * time.Second)
+				util.Sleep(chain.ctx, time.Second)
 			} else {
 				break
 			}
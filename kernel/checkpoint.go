@@ -0,0 +1,348 @@
+package kernel
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/MixinNetwork/mixin/common"
+	"github.com/MixinNetwork/mixin/crypto"
+	"github.com/MixinNetwork/mixin/kernel/internal/clock"
+	"github.com/MixinNetwork/mixin/logger"
+	"github.com/MixinNetwork/mixin/storage"
+)
+
+// A checkpoint is cut by the final rounds of all chains, and the hash of the
+// state in the cut is signed by the nodes finalized the rounds. A new node
+// imports the checkpoint signed by the threshold of the accepted nodes in it,
+// then syncs the rounds after the heads from the neighbors.
+type CheckpointState struct {
+	Header    *common.CheckpointHeader
+	NodeId    crypto.Hash
+	Hash      crypto.Hash
+	Signature crypto.Signature
+	Done      bool
+	Err       error
+}
+
+func (node *Node) CheckpointFile() string {
+	return node.configDir + "/checkpoint.dat"
+}
+
+// ExportCheckpoint writes the checkpoint of the final rounds to the file in
+// the background, and the state of the export is polled by SignCheckpoint
+// with the header.
+func (node *Node) ExportCheckpoint() (*CheckpointState, error) {
+	node.checkpointMutex.Lock()
+	defer node.checkpointMutex.Unlock()
+
+	if cs := node.checkpoint; cs != nil && !cs.Done {
+		return nil, fmt.Errorf("checkpoint %d running", cs.Header.Timestamp)
+	}
+	header, err := node.buildCheckpointHeader()
+	if err != nil {
+		return nil, err
+	}
+	cs := &CheckpointState{Header: header, NodeId: node.IdForNetwork}
+	node.checkpoint = cs
+	go node.runCheckpoint(cs, node.CheckpointFile())
+	return cs.copy(), nil
+}
+
+// SignCheckpoint computes the hash of the checkpoint with the header in the
+// background, and returns the state with the signature when done. Only one
+// checkpoint runs at the same time, because it reads all the state. A failed
+// checkpoint is returned once, then retried by the next call, e.g. the heads
+// not finalized by the node yet.
+func (node *Node) SignCheckpoint(header *common.CheckpointHeader) (*CheckpointState, error) {
+	if header.Version != common.CheckpointVersion {
+		return nil, fmt.Errorf("invalid checkpoint version %d", header.Version)
+	}
+	if header.NetworkId != node.networkId {
+		return nil, fmt.Errorf("invalid checkpoint network %s", header.NetworkId)
+	}
+
+	node.checkpointMutex.Lock()
+	defer node.checkpointMutex.Unlock()
+
+	cs := node.checkpoint
+	if cs != nil && bytes.Equal(common.MsgpackMarshalPanic(cs.Header), common.MsgpackMarshalPanic(header)) {
+		if cs.Done && cs.Err != nil {
+			node.checkpoint = nil
+		}
+		return cs.copy(), nil
+	}
+	if cs != nil && !cs.Done {
+		return nil, fmt.Errorf("checkpoint %d running", cs.Header.Timestamp)
+	}
+	cs = &CheckpointState{Header: header, NodeId: node.IdForNetwork}
+	node.checkpoint = cs
+	go node.runCheckpoint(cs, "")
+	return cs.copy(), nil
+}
+
+func (node *Node) buildCheckpointHeader() (*common.CheckpointHeader, error) {
+	now := uint64(clock.Now().UnixNano())
+	header := &common.CheckpointHeader{
+		Version:   common.CheckpointVersion,
+		NetworkId: node.networkId,
+		Timestamp: now,
+	}
+	for _, cn := range node.persistStore.ReadAllNodes(now, false) {
+		id := cn.IdForNetwork(node.networkId)
+		cache, err := node.persistStore.ReadRound(id)
+		if err != nil {
+			return nil, err
+		}
+		if cache == nil || cache.Number == 0 {
+			continue
+		}
+		if cache.Number == 1 && !node.genesisNodesMap[id] {
+			return nil, fmt.Errorf("checkpoint chain %s without final round after acceptance", id)
+		}
+		header.Heads = append(header.Heads, &common.CheckpointHead{
+			NodeId: id,
+			Number: cache.Number - 1,
+			Hash:   cache.References.Self,
+		})
+	}
+	sort.Slice(header.Heads, func(i, j int) bool {
+		a, b := header.Heads[i].NodeId, header.Heads[j].NodeId
+		return bytes.Compare(a[:], b[:]) < 0
+	})
+	return header, nil
+}
+
+func (node *Node) runCheckpoint(cs *CheckpointState, path string) {
+	hash, sig, err := node.writeCheckpoint(cs.Header, path)
+	logger.Printf("Checkpoint %d %s %v\n", cs.Header.Timestamp, hash, err)
+
+	node.checkpointMutex.Lock()
+	defer node.checkpointMutex.Unlock()
+	cs.Hash, cs.Signature, cs.Err, cs.Done = hash, sig, err, true
+}
+
+// writeCheckpoint writes the checkpoint with the signature to the file, or
+// only computes the hash and signature if the path is empty.
+func (node *Node) writeCheckpoint(header *common.CheckpointHeader, path string) (crypto.Hash, crypto.Signature, error) {
+	var hash crypto.Hash
+	var sig crypto.Signature
+
+	var w io.Writer = io.Discard
+	var f *os.File
+	if path != "" {
+		tmp, err := os.Create(path + ".tmp")
+		if err != nil {
+			return hash, sig, err
+		}
+		defer tmp.Close()
+		w, f = tmp, tmp
+	}
+
+	cw := common.NewCheckpointWriter(w)
+	err := cw.Write(common.CheckpointRecordHeader, header)
+	if err != nil {
+		return hash, sig, err
+	}
+	err = node.persistStore.ExportCheckpoint(header.Heads, cw)
+	if err != nil {
+		return hash, sig, err
+	}
+	hash = cw.Hash()
+	sig = node.Signer.PrivateSpendKey.Sign(hash[:])
+	if f == nil {
+		return hash, sig, nil
+	}
+
+	err = cw.Write(common.CheckpointRecordSignature, &common.CheckpointSignature{
+		NodeId:    node.IdForNetwork,
+		Signature: sig,
+	})
+	if err != nil {
+		return hash, sig, err
+	}
+	err = cw.Flush()
+	if err != nil {
+		return hash, sig, err
+	}
+	err = f.Close()
+	if err != nil {
+		return hash, sig, err
+	}
+	return hash, sig, os.Rename(path+".tmp", path)
+}
+
+func (cs *CheckpointState) copy() *CheckpointState {
+	c := *cs
+	return &c
+}
+
+// AppendCheckpointSignature verifies the signature of the node for the
+// checkpoint file, and appends it to the file.
+func AppendCheckpointSignature(path string, nodeId crypto.Hash, sig crypto.Signature) (crypto.Hash, error) {
+	cp, err := readCheckpoint(path)
+	if err != nil {
+		return crypto.Hash{}, err
+	}
+	signer, found := cp.signers[nodeId]
+	if !found {
+		return cp.hash, fmt.Errorf("checkpoint signer %s not found", nodeId)
+	}
+	if !signer.Verify(cp.hash[:], sig) {
+		return cp.hash, fmt.Errorf("invalid checkpoint signature from %s", nodeId)
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return cp.hash, err
+	}
+	defer f.Close()
+	cw := common.NewCheckpointWriter(f)
+	err = cw.Write(common.CheckpointRecordSignature, &common.CheckpointSignature{
+		NodeId:    nodeId,
+		Signature: sig,
+	})
+	if err != nil {
+		return cp.hash, err
+	}
+	err = cw.Flush()
+	if err != nil {
+		return cp.hash, err
+	}
+	return cp.hash, f.Close()
+}
+
+// ImportCheckpoint verifies the checkpoint file and writes it to the store
+// with only the genesis loaded. The checkpoint must be signed by the threshold
+// of the accepted nodes in it, and match the expected hash, which is required
+// because anyone could forge a checkpoint with the nodes signed by themselves.
+// The node should be setup with the store after the import.
+func ImportCheckpoint(store storage.Store, configDir, path string, expect crypto.Hash) (crypto.Hash, error) {
+	if !expect.HasValue() {
+		return crypto.Hash{}, fmt.Errorf("checkpoint hash required")
+	}
+	node := &Node{persistStore: store, genesisNodesMap: make(map[crypto.Hash]bool)}
+	err := node.LoadGenesis(configDir)
+	if err != nil {
+		return crypto.Hash{}, err
+	}
+	err = node.checkGenesisOnly(configDir)
+	if err != nil {
+		return crypto.Hash{}, err
+	}
+
+	cp, err := readCheckpoint(path)
+	if err != nil {
+		return crypto.Hash{}, err
+	}
+	if cp.header.NetworkId != node.networkId {
+		return cp.hash, fmt.Errorf("invalid checkpoint network %s", cp.header.NetworkId)
+	}
+	if cp.hash != expect {
+		return cp.hash, fmt.Errorf("checkpoint hash %s unmatch %s", cp.hash, expect)
+	}
+	var signed int
+	for id, sig := range cp.signatures {
+		signer, found := cp.signers[id]
+		if found && signer.Verify(cp.hash[:], sig) {
+			signed += 1
+		}
+	}
+	if threshold := len(cp.signers)*2/3 + 1; signed < threshold {
+		return cp.hash, fmt.Errorf("checkpoint signatures %d less than %d", signed, threshold)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return cp.hash, err
+	}
+	defer f.Close()
+	r := common.NewCheckpointReader(f)
+	_, _, err = r.Next()
+	if err != nil {
+		return cp.hash, err
+	}
+	err = store.ImportCheckpoint(cp.header, r)
+	if err != nil {
+		return cp.hash, err
+	}
+	if r.Hash() != cp.hash {
+		return cp.hash, fmt.Errorf("checkpoint changed during import %s %s", cp.hash, r.Hash())
+	}
+	return cp.hash, nil
+}
+
+type checkpoint struct {
+	header     *common.CheckpointHeader
+	hash       crypto.Hash
+	signers    map[crypto.Hash]*crypto.Key
+	signatures map[crypto.Hash]crypto.Signature
+}
+
+// readCheckpoint reads the header, hash and signatures of the checkpoint
+// file, and the signers are the accepted nodes in the checkpoint.
+func readCheckpoint(path string) (*checkpoint, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := common.NewCheckpointReader(f)
+	kind, data, err := r.Next()
+	if err != nil {
+		return nil, err
+	}
+	if kind != common.CheckpointRecordHeader {
+		return nil, fmt.Errorf("invalid checkpoint header %d", kind)
+	}
+	cp := &checkpoint{
+		header:     &common.CheckpointHeader{},
+		signers:    make(map[crypto.Hash]*crypto.Key),
+		signatures: make(map[crypto.Hash]crypto.Signature),
+	}
+	err = common.MsgpackUnmarshal(data, cp.header)
+	if err != nil {
+		return nil, err
+	}
+	if cp.header.Version != common.CheckpointVersion {
+		return nil, fmt.Errorf("invalid checkpoint version %d", cp.header.Version)
+	}
+
+	nodes := make(map[crypto.Key]*common.CheckpointNode)
+	for {
+		kind, data, err := r.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		switch kind {
+		case common.CheckpointRecordNode:
+			var n common.CheckpointNode
+			err = common.MsgpackUnmarshal(data, &n)
+			nodes[n.Signer] = &n
+		case common.CheckpointRecordSignature:
+			var s common.CheckpointSignature
+			err = common.MsgpackUnmarshal(data, &s)
+			cp.signatures[s.NodeId] = s.Signature
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	cp.hash = r.Hash()
+
+	for _, n := range nodes {
+		if n.State != common.NodeStateAccepted {
+			continue
+		}
+		signer := n.Signer
+		privateView := signer.DeterministicHashDerive()
+		addr := common.Address{PublicSpendKey: signer, PublicViewKey: privateView.Public()}
+		cp.signers[addr.Hash().ForNetwork(cp.header.NetworkId)] = &signer
+	}
+	return cp, nil
+}
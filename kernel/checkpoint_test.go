@@ -0,0 +1,119 @@
+package kernel
+
+import (
+	"crypto/rand"
+	"os"
+	"testing"
+
+	"github.com/MixinNetwork/mixin/common"
+	"github.com/MixinNetwork/mixin/crypto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestImportForgedCheckpoint(t *testing.T) {
+	assert := assert.New(t)
+
+	root, err := os.MkdirTemp("", "mixin-checkpoint-test")
+	assert.Nil(err)
+	defer os.RemoveAll(root)
+
+	node := setupTestNode(assert, root)
+	assert.NotNil(node)
+
+	seed := make([]byte, 64)
+	rand.Read(seed)
+	forger := common.NewAddressFromSeed(seed)
+	forger.PrivateViewKey = forger.PublicSpendKey.DeterministicHashDerive()
+	forger.PublicViewKey = forger.PrivateViewKey.Public()
+
+	path := root + "/forged.dat"
+	f, err := os.Create(path)
+	assert.Nil(err)
+	cw := common.NewCheckpointWriter(f)
+	assert.Nil(cw.Write(common.CheckpointRecordHeader, &common.CheckpointHeader{
+		Version:   common.CheckpointVersion,
+		NetworkId: node.networkId,
+		Timestamp: node.GraphTimestamp,
+	}))
+	assert.Nil(cw.Write(common.CheckpointRecordNode, &common.CheckpointNode{
+		Signer:    forger.PublicSpendKey,
+		Payee:     forger.PublicSpendKey,
+		State:     common.NodeStateAccepted,
+		Timestamp: node.GraphTimestamp,
+	}))
+	forged := cw.Hash()
+	assert.Nil(cw.Write(common.CheckpointRecordSignature, &common.CheckpointSignature{
+		NodeId:    forger.Hash().ForNetwork(node.networkId),
+		Signature: forger.PrivateSpendKey.Sign(forged[:]),
+	}))
+	assert.Nil(cw.Flush())
+	assert.Nil(f.Close())
+
+	cp, err := readCheckpoint(path)
+	assert.Nil(err)
+	assert.Equal(forged, cp.hash)
+	assert.Len(cp.signers, 1)
+	assert.Len(cp.signatures, 1)
+
+	hash, err := ImportCheckpoint(node.persistStore, root, path, crypto.Hash{})
+	assert.NotNil(err)
+	assert.Equal("checkpoint hash required", err.Error())
+	assert.Equal(crypto.Hash{}, hash)
+	hash, err = ImportCheckpoint(node.persistStore, root, path, crypto.NewHash([]byte("trusted")))
+	assert.NotNil(err)
+	assert.Contains(err.Error(), "unmatch")
+	assert.Equal(forged, hash)
+
+	tx := common.NewTransaction(common.XINAssetId)
+	tx.AddInput(crypto.NewHash([]byte("input")), 0)
+	tx.AddScriptOutput([]*common.Address{&forger}, common.NewThresholdScript(1), common.NewInteger(1), seed)
+	ver := tx.AsLatestVersion()
+	ver.SignaturesMap = []map[uint16]*crypto.Signature{{0: &crypto.Signature{}}}
+	snap := &common.Snapshot{
+		Version:     common.SnapshotVersion,
+		NodeId:      forger.Hash().ForNetwork(node.networkId),
+		Transaction: ver.PayloadHash(),
+		Timestamp:   node.GraphTimestamp,
+	}
+	snap.Signature = &crypto.CosiSignature{}
+	write := func(path string) crypto.Hash {
+		f, err := os.Create(path)
+		assert.Nil(err)
+		cw := common.NewCheckpointWriter(f)
+		assert.Nil(cw.Write(common.CheckpointRecordHeader, &common.CheckpointHeader{
+			Version:   common.CheckpointVersion,
+			NetworkId: node.networkId,
+			Timestamp: node.GraphTimestamp,
+		}))
+		assert.Nil(cw.Write(common.CheckpointRecordTransaction, &common.CheckpointTransaction{Data: ver.Marshal()}))
+		assert.Nil(cw.Write(common.CheckpointRecordSnapshot, snap))
+		hash := cw.Hash()
+		assert.Nil(cw.Write(common.CheckpointRecordSignature, &common.CheckpointSignature{
+			NodeId:    forger.Hash().ForNetwork(node.networkId),
+			Signature: forger.PrivateSpendKey.Sign(hash[:]),
+		}))
+		assert.Nil(cw.Flush())
+		assert.Nil(f.Close())
+		return hash
+	}
+	trusted := write(root + "/trusted.dat")
+
+	// the signatures of the transactions and snapshots are not verified
+	// during import, so tampering them must change the checkpoint hash
+	ver.SignaturesMap[0][0] = &crypto.Signature{1}
+	tampered := write(root + "/tampered.dat")
+	assert.NotEqual(trusted, tampered)
+	hash, err = ImportCheckpoint(node.persistStore, root, root+"/tampered.dat", trusted)
+	assert.NotNil(err)
+	assert.Contains(err.Error(), "unmatch")
+	assert.Equal(tampered, hash)
+
+	ver.SignaturesMap[0][0] = &crypto.Signature{}
+	snap.Signature.Signature[0] = 1
+	tampered = write(root + "/tampered.dat")
+	assert.NotEqual(trusted, tampered)
+	hash, err = ImportCheckpoint(node.persistStore, root, root+"/tampered.dat", trusted)
+	assert.NotNil(err)
+	assert.Contains(err.Error(), "unmatch")
+	assert.Equal(tampered, hash)
+}
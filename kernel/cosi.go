@@ -10,6 +10,7 @@ import (
 	"github.com/MixinNetwork/mixin/crypto"
 	"github.com/MixinNetwork/mixin/kernel/internal/clock"
 	"github.com/MixinNetwork/mixin/logger"
+	"github.com/MixinNetwork/mixin/network"
 )
 
 const (
@@ -58,7 +59,7 @@ type CosiVerifier struct {
 
 func (chain *Chain) cosiHook(m *CosiAction) (bool, error) {
 	logger.Debugf("cosiHook(%s) %v\n", chain.ChainId, m)
-	if !chain.running {
+	if chain.ctx.Err() != nil {
 		return false, nil
 	}
 	err := chain.cosiHandleAction(m)
@@ -233,6 +234,10 @@ func (chain *Chain) cosiSendAnnouncement(m *CosiAction) error {
 	logger.Verbosef("CosiLoop cosiHandleAction cosiSendAnnouncement %v\n", m.Snapshot)
 	s, cd := m.Snapshot, m.data
 	s.Timestamp = uint64(clock.Now().UnixNano())
+	if chain.node.clockSkewed() {
+		logger.Verbosef("CosiLoop cosiHandleAction cosiSendAnnouncement clock skewed\n")
+		return nil
+	}
 	if chain.IsPledging() && s.RoundNumber == 0 && cd.TX.TransactionType() == common.TransactionTypeNodeAccept {
 	} else if chain.State == nil {
 		return nil
@@ -316,6 +321,8 @@ func (chain *Chain) cosiSendAnnouncement(m *CosiAction) error {
 	chain.CosiVerifiers[s.Transaction] = v
 	agg.Commitments[cd.CN.ConsensusIndex] = &R
 	chain.CosiAggregators[s.Hash] = agg
+	chain.node.cacheTransactionAnnouncement(s.Transaction, s.Hash)
+	chain.node.cacheSnapshotSigners(agg)
 	nodes := chain.node.NodesListWithoutState(s.Timestamp, true)
 	for _, cn := range nodes {
 		peerId := cn.IdForNetwork
@@ -379,6 +386,7 @@ func (chain *Chain) cosiHandleAnnouncement(m *CosiAction) error {
 	v := &CosiVerifier{Snapshot: s, Commitment: m.Commitment, random: r}
 	chain.CosiVerifiers[s.Hash] = v
 	chain.CosiVerifiers[s.Transaction] = v
+	chain.node.cacheTransactionAnnouncement(s.Transaction, s.Hash)
 	err := chain.node.Peer.SendSnapshotCommitmentMessage(s.NodeId, s.Hash, r.Public(), cd.TX == nil)
 	if err != nil {
 		logger.Verbosef("CosiLoop cosiHandleAction cosiHandleAnnouncement SendSnapshotCommitmentMessage(%s, %s) ERROR %s\n", s.NodeId, s.Hash, err.Error())
@@ -402,6 +410,7 @@ func (chain *Chain) cosiHandleCommitment(m *CosiAction) error {
 	}
 	ann.Commitments[cd.PN.ConsensusIndex] = m.Commitment
 	ann.WantTxs[m.PeerId] = m.WantTx
+	chain.node.cacheSnapshotSigners(ann)
 	logger.Verbosef("CosiLoop cosiHandleAction cosiHandleCommitment %v NOW %d %d\nn", m, len(ann.Commitments), base)
 	if len(ann.Commitments) < base {
 		return nil
@@ -416,12 +425,21 @@ func (chain *Chain) cosiHandleCommitment(m *CosiAction) error {
 	v := chain.CosiVerifiers[m.SnapshotHash]
 	priv := chain.node.Signer.PrivateSpendKey
 	_, publics := chain.ConsensusKeys(s.RoundNumber, s.Timestamp)
+	if !cosiSignersConsensus(cosi, publics) {
+		logger.Printf("CosiLoop cosiHandleAction cosiHandleCommitment %v CONSENSUS CHANGED %v %d\n", m, cosi.Keys(), len(publics))
+		// the verifier of the transaction is kept, so the transaction queued
+		// again is only announced in another round as required by the peers
+		delete(chain.CosiAggregators, s.Hash)
+		delete(chain.CosiVerifiers, s.Hash)
+		return chain.clearAndQueueSnapshotOrPanic(s)
+	}
 	response, err := cosi.Response(&priv, v.random, publics, m.SnapshotHash[:])
 	if err != nil {
 		return err
 	}
 	ann.Responses[cd.CN.ConsensusIndex] = response
 	copy(cosi.Signature[32:], response[:])
+	chain.node.cacheSnapshotSigners(ann)
 
 	nodes := chain.node.NodesListWithoutState(s.Timestamp, true)
 	for _, cn := range nodes {
@@ -440,6 +458,19 @@ func (chain *Chain) cosiHandleCommitment(m *CosiAction) error {
 	return nil
 }
 
+// cosiSignersConsensus checks the signer indexes of the commitments against
+// the consensus keys, which may change during the round, e.g. the pledging
+// node is accepted, then the snapshot is dropped and queued again instead of
+// failing the chain.
+func cosiSignersConsensus(cosi *crypto.CosiSignature, publics []*crypto.Key) bool {
+	for _, i := range cosi.Keys() {
+		if i >= len(publics) {
+			return false
+		}
+	}
+	return true
+}
+
 func (chain *Chain) cosiHandleChallenge(m *CosiAction) error {
 	logger.Verbosef("CosiLoop cosiHandleAction cosiHandleChallenge %v\n", m)
 	v := chain.CosiVerifiers[m.SnapshotHash]
@@ -487,6 +518,7 @@ func (chain *Chain) cosiHandleResponse(m *CosiAction) error {
 	}
 	base := chain.node.ConsensusThreshold(s.Timestamp)
 	agg.Responses[cd.PN.ConsensusIndex] = m.Response
+	chain.node.cacheSnapshotSigners(agg)
 	logger.Verbosef("CosiLoop cosiHandleAction cosiHandleResponse %v NOW %d %d %d\n", m, len(agg.Responses), len(agg.Commitments), base)
 	if len(agg.Responses) != len(agg.Commitments) {
 		return nil
@@ -726,6 +758,7 @@ func (node *Node) VerifyAndQueueAppendSnapshotFinalization(peerId crypto.Hash, s
 	chain := node.GetOrCreateChain(s.NodeId)
 	if _, finalized := chain.verifyFinalization(s); !finalized {
 		logger.Verbosef("ERROR VerifyAndQueueAppendSnapshotFinalization %s %v %d %t %v %v\n", peerId, s, node.ConsensusThreshold(s.Timestamp), chain.IsPledging(), chain.State, chain.ConsensusInfo)
+		node.penalizeInvalidSnapshot(peerId, chain, s)
 		return nil
 	}
 
@@ -743,3 +776,17 @@ func (node *Node) VerifyAndQueueAppendSnapshotFinalization(peerId crypto.Hash, s
 	}
 	return err
 }
+
+// penalizeInvalidSnapshot only if the consensus of the round is known, and
+// the snapshot is not finalized here, e.g. the genesis snapshots have no
+// signatures, otherwise this node may be just behind the peer.
+func (node *Node) penalizeInvalidSnapshot(peerId crypto.Hash, chain *Chain, s *common.Snapshot) {
+	if chain.State == nil || s.RoundNumber > chain.State.FinalRound.Number {
+		return
+	}
+	old, err := node.persistStore.ReadSnapshot(s.Hash)
+	if err != nil || old != nil {
+		return
+	}
+	node.Peer.PenalizeNeighbor(peerId, network.PeerPenaltyInvalidSnapshot, "invalid snapshot finalization")
+}
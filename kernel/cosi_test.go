@@ -0,0 +1,83 @@
+package kernel
+
+import (
+	"crypto/rand"
+	"os"
+	"testing"
+
+	"github.com/MixinNetwork/mixin/common"
+	"github.com/MixinNetwork/mixin/crypto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCosiCommitmentConsensusChanged(t *testing.T) {
+	assert := assert.New(t)
+
+	root, err := os.MkdirTemp("", "mixin-cosi-test")
+	assert.Nil(err)
+	defer os.RemoveAll(root)
+
+	node := setupTestNode(assert, root)
+	assert.NotNil(node)
+	chain := node.GetOrCreateChain(node.IdForNetwork)
+	// stop the chain loops to read the snapshot queued again
+	chain.Teardown()
+
+	s := &common.Snapshot{
+		Version:     common.SnapshotVersion,
+		NodeId:      node.IdForNetwork,
+		Transaction: crypto.NewHash([]byte("transaction")),
+		RoundNumber: 1,
+		Timestamp:   node.GraphTimestamp,
+	}
+	s.Hash = s.PayloadHash()
+	_, publics := chain.ConsensusKeys(s.RoundNumber, s.Timestamp)
+	base := node.ConsensusThreshold(s.Timestamp)
+	assert.True(base > 1)
+	assert.True(base <= len(publics))
+
+	// the last commitment is from the pledging node, which is appended to
+	// the consensus keys in its round 0, and the index is out of the keys
+	// once the node accepted during the round
+	agg := &CosiAggregator{
+		Snapshot:    s,
+		WantTxs:     make(map[crypto.Hash]bool),
+		Commitments: make(map[int]*crypto.Key),
+		Responses:   make(map[int]*[32]byte),
+	}
+	for i := len(publics) - base + 1; i < len(publics); i++ {
+		R := crypto.CosiCommit(rand.Reader).Public()
+		agg.Commitments[i] = &R
+	}
+	random := crypto.CosiCommit(rand.Reader)
+	R, commitment := random.Public(), crypto.CosiCommit(rand.Reader).Public()
+	chain.CosiAggregators[s.Hash] = agg
+	v := &CosiVerifier{Snapshot: s, Commitment: &R, random: random}
+	chain.CosiVerifiers[s.Hash] = v
+	chain.CosiVerifiers[s.Transaction] = v
+
+	err = chain.cosiHandleCommitment(&CosiAction{
+		PeerId:       crypto.NewHash([]byte("pledging")),
+		SnapshotHash: s.Hash,
+		Commitment:   &commitment,
+		data: &CosiChainData{
+			PN: &CNode{ConsensusIndex: len(publics)},
+			CN: &CNode{ConsensusIndex: 0},
+		},
+	})
+	assert.Nil(err)
+	assert.Len(agg.Commitments, base)
+	assert.Len(agg.Responses, 0)
+	assert.NotNil(s.Signature)
+	assert.False(cosiSignersConsensus(s.Signature, publics))
+
+	assert.Nil(chain.CosiAggregators[s.Hash])
+	assert.Nil(chain.CosiVerifiers[s.Hash])
+	assert.Equal(v, chain.CosiVerifiers[s.Transaction])
+	m := chain.CachePool.Poll()
+	assert.NotNil(m)
+	assert.Equal(CosiActionSelfEmpty, m.Action)
+	assert.Equal(s.Transaction, m.Snapshot.Transaction)
+	assert.Equal(uint64(0), m.Snapshot.Timestamp)
+	assert.Nil(chain.CachePool.Poll())
+}
@@ -64,7 +64,7 @@ func (node *Node) ElectionLoop() {
 	chain := node.GetOrCreateChain(node.IdForNetwork)
 	for chain.State == nil {
 		select {
-		case <-node.done:
+		case <-node.ctx.Done():
 			return
 		case <-ticker.C:
 			err := chain.tryToSendAcceptTransaction()
@@ -77,7 +77,7 @@ func (node *Node) ElectionLoop() {
 
 	for {
 		select {
-		case <-node.done:
+		case <-node.ctx.Done():
 			return
 		case <-ticker.C:
 			err := node.tryToSendRemoveTransaction()
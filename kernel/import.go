@@ -11,28 +11,10 @@ import (
 )
 
 func (node *Node) Import(configDir string, source storage.Store) error {
-	gns, err := readGenesis(configDir + "/genesis.json")
-	if err != nil {
-		return err
-	}
-	_, gss, _, err := buildGenesisSnapshots(node.networkId, node.Epoch, gns)
+	err := node.checkGenesisOnly(configDir)
 	if err != nil {
 		return err
 	}
-	kss, err := node.persistStore.ReadSnapshotsSinceTopology(0, 100)
-	if err != nil {
-		return err
-	}
-	if len(gss) != len(kss) {
-		return fmt.Errorf("kernel already initilaized %d %d", len(gss), len(kss))
-	}
-
-	for i, gs := range gss {
-		ks := kss[i]
-		if ks.PayloadHash() != gs.PayloadHash() {
-			return fmt.Errorf("kernel genesis unmatch %d %s %s", i, gs.PayloadHash(), ks.PayloadHash())
-		}
-	}
 
 	nodes := source.ReadAllNodes(uint64(clock.Now().UnixNano()), false)
 	for _, cn := range nodes {
@@ -95,3 +77,29 @@ func (chain *Chain) importSnapshot(s *common.SnapshotWithTopologicalOrder, tx *c
 		}
 	}
 }
+
+func (node *Node) checkGenesisOnly(configDir string) error {
+	gns, err := readGenesis(configDir + "/genesis.json")
+	if err != nil {
+		return err
+	}
+	_, gss, _, err := buildGenesisSnapshots(node.networkId, node.Epoch, gns)
+	if err != nil {
+		return err
+	}
+	kss, err := node.persistStore.ReadSnapshotsSinceTopology(0, 100)
+	if err != nil {
+		return err
+	}
+	if len(gss) != len(kss) {
+		return fmt.Errorf("kernel already initilaized %d %d", len(gss), len(kss))
+	}
+
+	for i, gs := range gss {
+		ks := kss[i]
+		if ks.PayloadHash() != gs.PayloadHash() {
+			return fmt.Errorf("kernel genesis unmatch %d %s %s", i, gs.PayloadHash(), ks.PayloadHash())
+		}
+	}
+	return nil
+}
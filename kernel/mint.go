@@ -11,6 +11,7 @@ import (
 	"github.com/MixinNetwork/mixin/crypto"
 	"github.com/MixinNetwork/mixin/kernel/internal/clock"
 	"github.com/MixinNetwork/mixin/logger"
+	"github.com/MixinNetwork/mixin/util"
 	"github.com/dgraph-io/badger/v2"
 )
 
@@ -50,10 +51,10 @@ func (chain *Chain) AggregateMintWork() {
 
 	period := time.Duration(chain.node.custom.Node.KernelOprationPeriod) * time.Second
 	fork := uint64(SnapshotRoundDayLeapForkHack.UnixNano())
-	for chain.running {
+	for chain.ctx.Err() == nil {
 		if cs := chain.State; cs == nil {
 			logger.Printf("AggregateMintWork(%s) no state yet\n", chain.ChainId)
-			time.Sleep(period)
+			util.Sleep(chain.ctx, period)
 			continue
 		}
 		crn := chain.State.CacheRound.Number
@@ -66,10 +67,10 @@ func (chain *Chain) AggregateMintWork() {
 			continue
 		}
 		if len(snapshots) == 0 {
-			time.Sleep(period)
+			util.Sleep(chain.ctx, period)
 			continue
 		}
-		for chain.running {
+		for chain.ctx.Err() == nil {
 			if chain.node.networkId.String() == config.MainnetId && snapshots[0].Timestamp < fork {
 				snapshots = nil
 			}
@@ -79,7 +80,7 @@ func (chain *Chain) AggregateMintWork() {
 			}
 			if errors.Is(err, badger.ErrConflict) {
 				logger.Verbosef("AggregateMintWork(%s) ERROR WriteRoundWork %s\n", chain.ChainId, err.Error())
-				time.Sleep(100 * time.Millisecond)
+				util.Sleep(chain.ctx, 100*time.Millisecond)
 				continue
 			}
 			panic(err)
@@ -87,7 +88,7 @@ func (chain *Chain) AggregateMintWork() {
 		if round < crn {
 			round = round + 1
 		} else {
-			time.Sleep(period)
+			util.Sleep(chain.ctx, period)
 		}
 	}
 
@@ -102,7 +103,7 @@ func (node *Node) MintLoop() {
 
 	for {
 		select {
-		case <-node.done:
+		case <-node.ctx.Done():
 			return
 		case <-ticker.C:
 			err := node.tryToMintKernelNode()
@@ -230,11 +231,14 @@ func (node *Node) tryToMintKernelNode() error {
 	})
 }
 
-func (node *Node) validateMintSnapshot(snap *common.Snapshot, tx *common.VersionedTransaction) error {
+func (node *Node) validateMintSnapshot(snap *common.Snapshot, tx *common.VersionedTransaction, finalized bool) error {
 	timestamp := snap.Timestamp
 	if snap.Timestamp == 0 && snap.NodeId == node.IdForNetwork {
 		timestamp = uint64(clock.Now().UnixNano())
 	}
+	if finalized && node.checkpointMintWorksMissing(timestamp) {
+		return nil
+	}
 	signed := node.buildMintTransaction(timestamp, true)
 	if signed == nil {
 		return fmt.Errorf("no mint available at %d", timestamp)
@@ -248,6 +252,36 @@ func (node *Node) validateMintSnapshot(snap *common.Snapshot, tx *common.Version
 	return nil
 }
 
+// checkpointMintWorksMissing returns true if the node is initialized by a
+// checkpoint, and the mint at the timestamp requires the works of the days
+// with snapshots before the checkpoint heads, which are not aggregated. The
+// mint requires the works of its day and the day before, so only the mints
+// until the day after the last snapshot of the heads are affected, and they
+// are trusted when finalized by the consensus nodes.
+func (node *Node) checkpointMintWorksMissing(timestamp uint64) bool {
+	header, err := node.persistStore.ReadCheckpoint()
+	if err != nil {
+		panic(err)
+	}
+	if header == nil {
+		return false
+	}
+	var last uint64
+	for _, h := range header.Heads {
+		snapshots, err := node.persistStore.ReadSnapshotsForNodeRound(h.NodeId, h.Number)
+		if err != nil {
+			panic(err)
+		}
+		for _, s := range snapshots {
+			if s.Timestamp > last {
+				last = s.Timestamp
+			}
+		}
+	}
+	day := uint64(time.Hour) * 24
+	return timestamp/day <= last/day+1
+}
+
 func (node *Node) checkMintPossibility(timestamp uint64, validateOnly bool) (int, common.Integer) {
 	if timestamp <= node.Epoch {
 		return 0, common.Zero
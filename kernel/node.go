@@ -1,9 +1,11 @@
 package kernel
 
 import (
+	"context"
 	"encoding/binary"
 	"fmt"
 	"sort"
+	"strings"
 	"sync"
 	"time"
 
@@ -21,6 +23,7 @@ type Node struct {
 	IdForNetwork crypto.Hash
 	Signer       common.Address
 	Listener     string
+	Advertised   []string
 
 	Peer          *network.Peer
 	TopoCounter   *TopologicalSequence
@@ -45,11 +48,18 @@ type Node struct {
 	custom          *config.Custom
 	configDir       string
 	addr            string
+	transport       network.TransportFactory
+	recorder        *network.MessageRecorder
+	checkpoint      *CheckpointState
+	checkpointMutex sync.Mutex
+	statusMutex     sync.Mutex
 
-	done chan struct{}
-	elc  chan struct{}
-	mlc  chan struct{}
-	cqc  chan struct{}
+	ctx    context.Context
+	cancel context.CancelFunc
+	elc    chan struct{}
+	mlc    chan struct{}
+	cqc    chan struct{}
+	ckc    chan struct{}
 }
 
 type NodeStateSequence struct {
@@ -78,13 +88,18 @@ func SetupNode(custom *config.Custom, persistStore storage.Store, cacheStore *fa
 		configDir:       dir,
 		addr:            addr,
 		startAt:         clock.Now(),
-		done:            make(chan struct{}),
 		elc:             make(chan struct{}),
 		mlc:             make(chan struct{}),
 		cqc:             make(chan struct{}),
+		ckc:             make(chan struct{}),
 	}
 
+	node.ctx, node.cancel = context.WithCancel(context.Background())
+
 	node.LoadNodeConfig()
+	if len(node.Advertised) > network.PeerRecordMaxAddresses {
+		return nil, fmt.Errorf("too many advertised addresses %d", len(node.Advertised))
+	}
 
 	err := node.LoadGenesis(dir)
 	if err != nil {
@@ -112,7 +127,12 @@ func SetupNode(custom *config.Custom, persistStore storage.Store, cacheStore *fa
 		return nil, err
 	}
 
-	logger.Printf("Listen:\t%s\n", addr)
+	listen := []string{addr}
+	if len(custom.Network.Listen) > 0 {
+		listen = custom.Network.Listen
+	}
+	logger.Printf("Listen:\t%s\n", strings.Join(listen, " "))
+	logger.Printf("Advertise:\t%s\n", strings.Join(node.Advertised, " "))
 	logger.Printf("Signer:\t%s\n", node.Signer.String())
 	logger.Printf("Network:\t%s\n", node.networkId.String())
 	logger.Printf("Node Id:\t%s\n", node.IdForNetwork.String())
@@ -128,6 +148,26 @@ func (node *Node) LoadNodeConfig() {
 	addr.PublicViewKey = addr.PrivateViewKey.Public()
 	node.Signer = addr
 	node.Listener = node.custom.Network.Listener
+	node.Advertised = nil
+	for _, a := range append([]string{node.Listener}, node.custom.Network.Advertise...) {
+		if a != "" && !node.isAdvertised(a) {
+			node.Advertised = append(node.Advertised, a)
+		}
+	}
+	if node.Listener == "" && len(node.Advertised) > 0 {
+		node.Listener = node.Advertised[0]
+	}
+}
+
+// isAdvertised checks the address is one of the public addresses of this
+// node, so that the node never pings itself.
+func (node *Node) isAdvertised(addr string) bool {
+	for _, a := range node.Advertised {
+		if a == addr {
+			return true
+		}
+	}
+	return false
 }
 
 func (node *Node) buildNodeStateSequences(allNodesSortedWithState []*CNode, acceptedOnly bool) []*NodeStateSequence {
@@ -304,20 +344,45 @@ func (node *Node) LoadConsensusNodes() error {
 }
 
 func (node *Node) PingNeighborsFromConfig() error {
-	node.Peer = network.NewPeer(node, node.IdForNetwork, node.addr, node.custom.Network.GossipNeighbors)
+	node.Peer = network.NewPeer(node.ctx, node, node.IdForNetwork, node.addr, node.custom.Network.GossipNeighbors)
+	if node.transport == nil {
+		factory, err := network.NewSignerTransportFactory(node.Signer.PrivateSpendKey, node.networkId, node.verifyTransportPeer)
+		if err != nil {
+			return err
+		}
+		node.transport = factory
+	}
+	node.Peer.SetTransportFactory(node.transport)
+	node.Peer.SetSyncRateLimit(node.custom.Network.SyncBandwidth*1024, node.custom.Network.SyncMessages)
+	node.Peer.SetLegacyAuthentication(node.custom.Network.LegacyAuthentication)
+	if dir := node.custom.Network.MessageRecorder; dir != "" {
+		recorder, err := network.NewMessageRecorder(dir)
+		if err != nil {
+			return err
+		}
+		node.recorder = recorder
+		node.Peer.SetMessageRecorder(recorder)
+	}
 
 	for _, s := range node.custom.Network.Peers {
-		if s == node.Listener {
+		if node.isAdvertised(s) {
 			continue
 		}
 		node.Peer.PingNeighbor(s)
 	}
+	node.Peer.PingRecordedNeighbors()
 	return nil
 }
 
+// SetTransportFactory replaces the QUIC and TCP transports of the peer,
+// and must be called before PingNeighborsFromConfig.
+func (node *Node) SetTransportFactory(factory network.TransportFactory) {
+	node.transport = factory
+}
+
 func (node *Node) UpdateNeighbors(neighbors []string) error {
 	for _, in := range neighbors {
-		if in == node.Listener {
+		if node.isAdvertised(in) {
 			continue
 		}
 		node.Peer.PingNeighbor(in)
@@ -325,8 +390,19 @@ func (node *Node) UpdateNeighbors(neighbors []string) error {
 	return nil
 }
 
+// ListenNeighbors listens on the listen addresses in config, which may be
+// different from the advertised addresses behind NAT or load balancers, or
+// the port of the node with the TCP transport if enabled.
 func (node *Node) ListenNeighbors() error {
-	return node.Peer.ListenNeighbors()
+	if len(node.custom.Network.Listen) > 0 {
+		return node.Peer.ListenNeighbors(node.custom.Network.Listen)
+	}
+	listeners := []string{node.addr}
+	tcp := network.AddressSchemeTCP + "://"
+	if node.custom.Network.TCP || strings.HasPrefix(node.Listener, tcp) {
+		listeners = append(listeners, tcp+node.addr)
+	}
+	return node.Peer.ListenNeighbors(listeners)
 }
 
 func (node *Node) NetworkId() crypto.Hash {
@@ -364,7 +440,31 @@ func (node *Node) BuildGraph() []*network.SyncPoint {
 	return points
 }
 
-func (node *Node) BuildAuthenticationMessage() []byte {
+// The authentication message signs the listener and the binding of the
+// connection, which is exported from its TLS session, so a captured message
+// can't be replayed on another connection, and the listener can't be altered.
+// The version is the first byte, which is always zero in the legacy message
+// started with the timestamp. The legacy message is still built for the
+// nodes not upgraded yet, and only accepted if the legacy-authentication
+// option is enabled during the transition period.
+const authenticationVersion = network.AuthenticationVersionBound
+
+// BuildAuthenticationMessage builds the legacy message without the binding
+// if the binding is nil, for the peers not advertised the bound version.
+func (node *Node) BuildAuthenticationMessage(binding []byte) []byte {
+	if binding == nil {
+		return node.buildLegacyAuthenticationMessage()
+	}
+	data := make([]byte, 9)
+	data[0] = authenticationVersion
+	binary.BigEndian.PutUint64(data[1:], uint64(clock.Now().Unix()))
+	data = append(data, node.Signer.PublicSpendKey[:]...)
+	sig := node.Signer.PrivateSpendKey.Sign(authenticationPayload(data, node.Listener, binding))
+	data = append(data, sig[:]...)
+	return append(data, []byte(node.Listener)...)
+}
+
+func (node *Node) buildLegacyAuthenticationMessage() []byte {
 	data := make([]byte, 8)
 	binary.BigEndian.PutUint64(data, uint64(clock.Now().Unix()))
 	data = append(data, node.Signer.PublicSpendKey[:]...)
@@ -373,28 +473,69 @@ func (node *Node) BuildAuthenticationMessage() []byte {
 	return append(data, []byte(node.Listener)...)
 }
 
-func (node *Node) Authenticate(msg []byte) (crypto.Hash, string, error) {
-	if len(msg) < 8+len(crypto.Hash{})+len(crypto.Signature{}) {
+// Authenticate verifies the authentication message with the binding and the
+// peer id proven by the TLS signer certificate of the connection, which is an
+// empty hash if the certificate is not signed.
+func (node *Node) Authenticate(msg, binding []byte, certified crypto.Hash) (crypto.Hash, string, error) {
+	if len(msg) > 0 && msg[0] == 0 {
+		return node.authenticateLegacy(msg, certified)
+	}
+	if len(msg) < 41+len(crypto.Signature{}) {
 		return crypto.Hash{}, "", fmt.Errorf("peer authentication message malformated %d", len(msg))
 	}
-	ts := binary.BigEndian.Uint64(msg[:8])
-	if clock.Now().Unix()-int64(ts) > 3 {
-		return crypto.Hash{}, "", fmt.Errorf("peer authentication message timeout %d %d", ts, clock.Now().Unix())
+	if msg[0] != authenticationVersion {
+		return crypto.Hash{}, "", fmt.Errorf("peer authentication message version invalid %d", msg[0])
+	}
+	if len(binding) == 0 {
+		return crypto.Hash{}, "", fmt.Errorf("peer authentication without binding")
 	}
 
 	var signer common.Address
-	copy(signer.PublicSpendKey[:], msg[8:40])
+	copy(signer.PublicSpendKey[:], msg[9:41])
 	signer.PublicViewKey = signer.PublicSpendKey.DeterministicHashDerive().Public()
 	peerId := signer.Hash().ForNetwork(node.networkId)
 	if peerId == node.IdForNetwork {
 		return crypto.Hash{}, "", fmt.Errorf("peer authentication invalid consensus peer %s", peerId)
 	}
-	peer := node.GetAcceptedOrPledgingNode(peerId)
 
-	if node.custom.Node.ConsensusOnly && peer == nil {
-		return crypto.Hash{}, "", fmt.Errorf("peer authentication invalid consensus peer %s", peerId)
+	var sig crypto.Signature
+	copy(sig[:], msg[41:41+len(sig)])
+	listener := string(msg[41+len(sig):])
+	if !signer.PublicSpendKey.Verify(authenticationPayload(msg[:41], listener, binding), sig) {
+		return crypto.Hash{}, "", fmt.Errorf("peer authentication message signature invalid %s", peerId)
 	}
-	if peer != nil && peer.Signer.Hash() != signer.Hash() {
+
+	// the peer id is returned with the errors after the signature verified,
+	// so that the peer could be penalized without being impersonated
+	ts := binary.BigEndian.Uint64(msg[1:9])
+	err := node.authenticatePeer(signer, peerId, ts, listener)
+	if err != nil {
+		return peerId, "", err
+	}
+	return peerId, listener, nil
+}
+
+// authenticateLegacy verifies the legacy message of the nodes not upgraded
+// yet, and it should be removed after the transition period. The legacy
+// message is not bound to the connection, so the peer id is never returned
+// with errors. If the certificate is signed, it must be the same signer.
+// The nodes not upgraded present unsigned certificates, so their messages
+// are accepted without any proof of the connection, and a message captured
+// could be replayed by anyone within the timestamp window to impersonate the
+// node. This risk is only taken with the legacy-authentication option.
+func (node *Node) authenticateLegacy(msg []byte, certified crypto.Hash) (crypto.Hash, string, error) {
+	if !node.custom.Network.LegacyAuthentication {
+		return crypto.Hash{}, "", fmt.Errorf("peer authentication legacy message disabled")
+	}
+	if len(msg) < 8+len(crypto.Hash{})+len(crypto.Signature{}) {
+		return crypto.Hash{}, "", fmt.Errorf("peer authentication message malformated %d", len(msg))
+	}
+
+	var signer common.Address
+	copy(signer.PublicSpendKey[:], msg[8:40])
+	signer.PublicViewKey = signer.PublicSpendKey.DeterministicHashDerive().Public()
+	peerId := signer.Hash().ForNetwork(node.networkId)
+	if peerId == node.IdForNetwork {
 		return crypto.Hash{}, "", fmt.Errorf("peer authentication invalid consensus peer %s", peerId)
 	}
 
@@ -403,11 +544,62 @@ func (node *Node) Authenticate(msg []byte) (crypto.Hash, string, error) {
 	if !signer.PublicSpendKey.Verify(msg[:40], sig) {
 		return crypto.Hash{}, "", fmt.Errorf("peer authentication message signature invalid %s", peerId)
 	}
+	if certified.HasValue() && peerId != certified {
+		return crypto.Hash{}, "", fmt.Errorf("peer authentication legacy certificate invalid %s %s", peerId, certified)
+	}
 
+	ts := binary.BigEndian.Uint64(msg[:8])
 	listener := string(msg[40+len(sig):])
+	err := node.authenticatePeer(signer, peerId, ts, listener)
+	if err != nil {
+		return crypto.Hash{}, "", err
+	}
 	return peerId, listener, nil
 }
 
+func (node *Node) authenticatePeer(signer common.Address, peerId crypto.Hash, ts uint64, listener string) error {
+	if clock.Now().Unix()-int64(ts) > 3 {
+		return fmt.Errorf("peer authentication message timeout %d %d", ts, clock.Now().Unix())
+	}
+	if _, _, err := network.ParseAddress(listener); err != nil {
+		return fmt.Errorf("peer authentication invalid listener %s", listener)
+	}
+	peer := node.GetAcceptedOrPledgingNode(peerId)
+	if node.custom.Node.ConsensusOnly && peer == nil {
+		return fmt.Errorf("peer authentication invalid consensus peer %s", peerId)
+	}
+	if peer != nil && peer.Signer.Hash() != signer.Hash() {
+		return fmt.Errorf("peer authentication invalid consensus peer %s", peerId)
+	}
+	return nil
+}
+
+func authenticationPayload(header []byte, listener string, binding []byte) []byte {
+	payload := append([]byte{}, header...)
+	payload = append(payload, listener...)
+	return append(payload, binding...)
+}
+
+// verifyTransportPeer is called in the TLS handshake, and the consensus
+// only node rejects the peers not signed by a consensus node. The unsigned
+// certificates of the nodes not upgraded are allowed with the legacy
+// authentication, and their legacy messages are checked for consensus later.
+func (node *Node) verifyTransportPeer(peerId crypto.Hash) error {
+	if !node.custom.Node.ConsensusOnly {
+		return nil
+	}
+	if !peerId.HasValue() {
+		if node.custom.Network.LegacyAuthentication {
+			return nil
+		}
+		return fmt.Errorf("transport peer certificate not signed")
+	}
+	if node.GetAcceptedOrPledgingNode(peerId) == nil {
+		return fmt.Errorf("transport peer invalid consensus peer %s", peerId)
+	}
+	return nil
+}
+
 func (node *Node) SendTransactionToPeer(peerId, hash crypto.Hash) error {
 	tx, err := node.checkTxInStorage(hash)
 	if err != nil || tx == nil {
@@ -420,6 +612,63 @@ func (node *Node) CachePutTransaction(peerId crypto.Hash, tx *common.VersionedTr
 	return node.persistStore.CachePutTransaction(tx)
 }
 
+func (node *Node) ReadTransaction(hash crypto.Hash) (*common.VersionedTransaction, error) {
+	tx, _, err := node.persistStore.ReadTransaction(hash)
+	return tx, err
+}
+
+func (node *Node) ReadPeerBans() (map[crypto.Hash]time.Time, error) {
+	return node.persistStore.CacheListPeerBans()
+}
+
+func (node *Node) WritePeerBan(peerId crypto.Hash, until time.Time) error {
+	return node.persistStore.CacheWritePeerBan(peerId, until)
+}
+
+func (node *Node) ReadPeerRecords() (map[crypto.Hash][]byte, error) {
+	return node.persistStore.CacheListPeerRecords()
+}
+
+func (node *Node) WritePeerRecord(peerId crypto.Hash, data []byte, until time.Time) error {
+	return node.persistStore.CacheWritePeerRecord(peerId, data, until)
+}
+
+// BuildPeerRecord signs the advertised addresses of this node, which are
+// gossiped to the neighbors, and nil if the node has no listener.
+func (node *Node) BuildPeerRecord() *network.PeerRecord {
+	if len(node.Advertised) == 0 {
+		return nil
+	}
+	r := &network.PeerRecord{
+		NodeId:    node.IdForNetwork,
+		Signer:    node.Signer.PublicSpendKey,
+		Addresses: node.Advertised,
+		Timestamp: uint64(clock.Now().UnixNano()),
+	}
+	r.Signature = node.Signer.PrivateSpendKey.Sign(r.Payload())
+	return r
+}
+
+// VerifyPeerRecord checks the record is signed by an accepted or pledging
+// node, the records of other nodes are not kept.
+func (node *Node) VerifyPeerRecord(r *network.PeerRecord) error {
+	var signer common.Address
+	signer.PublicSpendKey = r.Signer
+	signer.PublicViewKey = signer.PublicSpendKey.DeterministicHashDerive().Public()
+	peerId := signer.Hash().ForNetwork(node.networkId)
+	if peerId != r.NodeId {
+		return fmt.Errorf("peer record invalid signer %s %s", r.NodeId, peerId)
+	}
+	cn := node.GetAcceptedOrPledgingNode(peerId)
+	if cn == nil || cn.Signer.Hash() != signer.Hash() {
+		return fmt.Errorf("peer record invalid consensus peer %s", peerId)
+	}
+	if !r.Signer.Verify(r.Payload(), r.Signature) {
+		return fmt.Errorf("peer record signature invalid %s", peerId)
+	}
+	return nil
+}
+
 func (node *Node) ReadAllNodesWithoutState() []crypto.Hash {
 	var all []crypto.Hash
 	nodes := node.NodesListWithoutState(uint64(clock.Now().UnixNano()), false)
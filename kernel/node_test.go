@@ -0,0 +1,198 @@
+package kernel
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/MixinNetwork/mixin/common"
+	"github.com/MixinNetwork/mixin/crypto"
+	"github.com/MixinNetwork/mixin/kernel/internal/clock"
+	"github.com/MixinNetwork/mixin/network"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthenticate(t *testing.T) {
+	assert := assert.New(t)
+
+	root, err := os.MkdirTemp("", "mixin-node-test")
+	assert.Nil(err)
+	defer os.RemoveAll(root)
+
+	node := setupTestNode(assert, root)
+	assert.NotNil(node)
+
+	seed := make([]byte, 64)
+	rand.Read(seed)
+	peer := &Node{
+		Signer:   common.NewAddressFromSeed(seed),
+		Listener: "127.0.0.1:7240",
+	}
+	peer.Signer.PublicViewKey = peer.Signer.PublicSpendKey.DeterministicHashDerive().Public()
+	peerId := peer.Signer.Hash().ForNetwork(node.networkId)
+	binding, other := make([]byte, 32), make([]byte, 32)
+	rand.Read(binding)
+	rand.Read(other)
+
+	msg := peer.BuildAuthenticationMessage(binding)
+	id, _, err := node.Authenticate(msg, binding, peerId)
+	assert.NotNil(err)
+	assert.Equal(peerId, id)
+	node.custom.Node.ConsensusOnly = false
+	id, listener, err := node.Authenticate(msg, binding, peerId)
+	assert.Nil(err)
+	assert.Equal(peerId, id)
+	assert.Equal(peer.Listener, listener)
+
+	id, _, err = node.Authenticate(msg, other, peerId)
+	assert.NotNil(err)
+	assert.Equal(crypto.Hash{}, id)
+	id, _, err = node.Authenticate(msg, nil, peerId)
+	assert.NotNil(err)
+	assert.Equal(crypto.Hash{}, id)
+	altered := append(append([]byte{}, msg[:len(msg)-len(peer.Listener)]...), "127.0.0.1:7241"...)
+	id, _, err = node.Authenticate(altered, binding, peerId)
+	assert.NotNil(err)
+	assert.Equal(crypto.Hash{}, id)
+	legacy := append([]byte{}, msg...)
+	legacy[0] = 0
+	id, _, err = node.Authenticate(legacy, binding, peerId)
+	assert.NotNil(err)
+	assert.Equal(crypto.Hash{}, id)
+	id, _, err = node.Authenticate(msg[:100], binding, peerId)
+	assert.NotNil(err)
+	assert.Equal(crypto.Hash{}, id)
+
+	legacy = peer.BuildAuthenticationMessage(nil)
+	assert.Equal(byte(0), legacy[0])
+	assert.Len(legacy, 8+32+64+len(peer.Listener))
+	id, _, err = node.Authenticate(legacy, binding, peerId)
+	assert.NotNil(err)
+	assert.Equal(crypto.Hash{}, id)
+	node.custom.Network.LegacyAuthentication = true
+	id, listener, err = node.Authenticate(legacy, binding, crypto.Hash{})
+	assert.Nil(err)
+	assert.Equal(peerId, id)
+	assert.Equal(peer.Listener, listener)
+	id, _, err = node.Authenticate(legacy, binding, node.IdForNetwork)
+	assert.NotNil(err)
+	assert.Equal(crypto.Hash{}, id)
+	id, listener, err = node.Authenticate(legacy, binding, peerId)
+	assert.Nil(err)
+	assert.Equal(peerId, id)
+	assert.Equal(peer.Listener, listener)
+	id, listener, err = node.Authenticate(legacy, nil, peerId)
+	assert.Nil(err)
+	assert.Equal(peerId, id)
+	assert.Equal(peer.Listener, listener)
+	forged := append([]byte{}, legacy...)
+	forged[20] ^= 1
+	id, _, err = node.Authenticate(forged, binding, peerId)
+	assert.NotNil(err)
+	assert.Equal(crypto.Hash{}, id)
+	id, _, err = node.Authenticate(legacy[:100], binding, peerId)
+	assert.NotNil(err)
+	assert.Equal(crypto.Hash{}, id)
+	node.custom.Node.ConsensusOnly = true
+	id, _, err = node.Authenticate(legacy, binding, peerId)
+	assert.NotNil(err)
+	assert.Equal(crypto.Hash{}, id)
+	node.custom.Node.ConsensusOnly = false
+
+	clock.MockDiff(-time.Minute)
+	msg = peer.BuildAuthenticationMessage(binding)
+	clock.MockDiff(time.Minute)
+	id, _, err = node.Authenticate(msg, binding, peerId)
+	assert.NotNil(err)
+	assert.Equal(peerId, id)
+	clock.MockDiff(-time.Minute)
+	legacy = peer.BuildAuthenticationMessage(nil)
+	clock.MockDiff(time.Minute)
+	id, _, err = node.Authenticate(legacy, binding, peerId)
+	assert.NotNil(err)
+	assert.Equal(crypto.Hash{}, id)
+
+	peer.Listener = "mixin://127.0.0.1:7240"
+	id, _, err = node.Authenticate(peer.BuildAuthenticationMessage(binding), binding, peerId)
+	assert.NotNil(err)
+	assert.Equal(peerId, id)
+}
+
+func TestAuthenticateBaselinePeer(t *testing.T) {
+	assert := assert.New(t)
+
+	root, err := os.MkdirTemp("", "mixin-node-test")
+	assert.Nil(err)
+	defer os.RemoveAll(root)
+
+	node := setupTestNode(assert, root)
+	assert.NotNil(node)
+	node.custom.Node.ConsensusOnly = false
+	node.custom.Network.LegacyAuthentication = true
+	node.Peer = network.NewPeer(node.ctx, node, node.IdForNetwork, node.addr, false)
+	factory, err := network.NewSignerTransportFactory(node.Signer.PrivateSpendKey, node.networkId, node.verifyTransportPeer)
+	assert.Nil(err)
+	node.Peer.SetTransportFactory(factory)
+	node.Peer.SetLegacyAuthentication(true)
+	go node.Peer.ListenNeighbors([]string{"tcp://127.0.0.1:7031"})
+	defer node.Peer.Teardown()
+
+	// the baseline peer has the unsigned certificate, and never sends the
+	// capabilities, so it only knows the legacy authentication message
+	seed := make([]byte, 64)
+	rand.Read(seed)
+	baseline := &Node{
+		Signer:   common.NewAddressFromSeed(seed),
+		Listener: "tcp://127.0.0.1:7032",
+	}
+	baseline.Signer.PublicViewKey = baseline.Signer.PublicSpendKey.DeterministicHashDerive().Public()
+	baselineId := baseline.Signer.Hash().ForNetwork(node.networkId)
+	server, err := network.NewServerTransport(baseline.Listener)
+	assert.Nil(err)
+	assert.Nil(server.Listen())
+	defer server.Close()
+
+	var client network.Client
+	for i := 0; i < 50; i++ {
+		transport, err := network.NewClientTransport("tcp://127.0.0.1:7031")
+		assert.Nil(err)
+		client, err = transport.Dial(context.Background())
+		if err == nil {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	assert.NotNil(client)
+	defer client.Close()
+	msg := append([]byte{network.PeerMessageTypeAuthentication}, baseline.BuildAuthenticationMessage(nil)...)
+	assert.Nil(client.Send(msg))
+
+	// the upgraded node adds the baseline peer as neighbor, and sends the
+	// legacy message back, which is verified as the baseline node does
+	remote, err := server.Accept(context.Background())
+	assert.Nil(err)
+	defer remote.Close()
+	data, err := remote.Receive()
+	assert.Nil(err)
+	assert.Equal(uint8(network.PeerMessageTypeAuthentication), data[0])
+	auth := data[1:]
+	assert.True(len(auth) > 40+len(crypto.Signature{}))
+	ts := binary.BigEndian.Uint64(auth[:8])
+	assert.True(clock.Now().Unix()-int64(ts) <= 3)
+	var signer crypto.Key
+	copy(signer[:], auth[8:40])
+	assert.Equal(node.Signer.PublicSpendKey, signer)
+	var sig crypto.Signature
+	copy(sig[:], auth[40:40+len(sig)])
+	assert.True(signer.Verify(auth[:40], sig))
+	assert.Equal(node.Listener, string(auth[40+len(sig):]))
+
+	var found bool
+	for _, p := range node.Peer.Neighbors() {
+		found = found || p.IdForNetwork == baselineId
+	}
+	assert.True(found)
+}
@@ -0,0 +1,89 @@
+package kernel
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sort"
+
+	"github.com/MixinNetwork/mixin/common"
+	"github.com/MixinNetwork/mixin/crypto"
+	"github.com/MixinNetwork/mixin/proof"
+)
+
+func (node *Node) BuildSnapshotProof(hash crypto.Hash, checkpoint uint64) (*proof.SnapshotProof, error) {
+	s, err := node.persistStore.ReadSnapshot(hash)
+	if err != nil || s == nil {
+		return nil, err
+	}
+	if s.Version != common.SnapshotVersion || s.Signature == nil {
+		return nil, fmt.Errorf("snapshot %s version %d without cosi signature", hash, s.Version)
+	}
+	if checkpoint < node.Epoch {
+		checkpoint = node.Epoch
+	}
+	if checkpoint >= s.Timestamp {
+		return nil, fmt.Errorf("checkpoint %d after snapshot %d", checkpoint, s.Timestamp)
+	}
+
+	cp := &proof.Checkpoint{NetworkId: node.networkId, Timestamp: checkpoint}
+	latest := make(map[crypto.Key]*proof.Node)
+	var changes []*proof.Change
+	for _, n := range node.persistStore.ReadAllNodes(s.Timestamp, true) {
+		if n.Timestamp <= checkpoint {
+			if node.genesisNodesMap[n.IdForNetwork(node.networkId)] && n.Timestamp == node.Epoch {
+				cp.Genesis = append(cp.Genesis, n.Signer.PublicSpendKey)
+			}
+			latest[n.Signer.PublicSpendKey] = &proof.Node{
+				Signer:    n.Signer.PublicSpendKey,
+				State:     n.State,
+				Timestamp: n.Timestamp,
+			}
+			continue
+		}
+		if n.Timestamp >= s.Timestamp {
+			break
+		}
+		tx, snap, err := node.persistStore.ReadTransaction(n.Transaction)
+		if err != nil {
+			return nil, err
+		}
+		if tx == nil || len(snap) == 0 {
+			return nil, fmt.Errorf("node operation %s not finalized", n.Transaction)
+		}
+		sh, err := crypto.HashFromString(snap)
+		if err != nil {
+			return nil, err
+		}
+		ns, err := node.persistStore.ReadSnapshot(sh)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, &proof.Change{
+			Snapshot:    proof.EncodeSnapshot(&ns.Snapshot),
+			Transaction: hex.EncodeToString(tx.Marshal()),
+		})
+	}
+	for _, n := range latest {
+		cp.Nodes = append(cp.Nodes, n)
+	}
+	sort.Slice(cp.Nodes, func(i, j int) bool {
+		if cp.Nodes[i].Timestamp != cp.Nodes[j].Timestamp {
+			return cp.Nodes[i].Timestamp < cp.Nodes[j].Timestamp
+		}
+		return cp.Nodes[i].Signer.String() < cp.Nodes[j].Signer.String()
+	})
+
+	chain := node.GetOrCreateChain(s.NodeId)
+	_, publics := chain.ConsensusKeys(s.RoundNumber, s.Timestamp)
+	keys := make([]crypto.Key, len(publics))
+	for i, k := range publics {
+		keys[i] = *k
+	}
+	return &proof.SnapshotProof{
+		Snapshot:   proof.EncodeSnapshot(&s.Snapshot),
+		Keys:       keys,
+		Threshold:  node.ConsensusThreshold(s.Timestamp),
+		Checkpoint: cp,
+		Changes:    changes,
+	}, nil
+}
@@ -56,7 +56,7 @@ func (node *Node) LoopCacheQueue() error {
 		}
 		timer := time.NewTimer(period)
 		select {
-		case <-node.done:
+		case <-node.ctx.Done():
 			return nil
 		case <-timer.C:
 		}
@@ -84,6 +84,7 @@ func (node *Node) LoopCacheQueue() error {
 				continue
 			}
 			err = tx.Validate(node.persistStore)
+			node.cacheTransactionValidationError(offset, err)
 			if err != nil {
 				logger.Debugf("LoopCacheQueue Validate ERROR %s %s\n", offset, err)
 				// not mark invalid tx as stale is to ensure final graph sync
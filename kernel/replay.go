@@ -0,0 +1,40 @@
+package kernel
+
+import (
+	"os"
+	"time"
+
+	"github.com/MixinNetwork/mixin/config"
+	"github.com/MixinNetwork/mixin/logger"
+	"github.com/MixinNetwork/mixin/network"
+)
+
+// Replay feeds the messages recorded by the message recorder into the node
+// without any network, the files are replayed in order. The node should be
+// setup with a copy of the config and state of the recorded node before the
+// recording, so the messages are handled as the incident. The node is torn
+// down after all messages handled, and the snapshots and transactions are
+// kept in the store for inspection.
+func (node *Node) Replay(files []string, realtime bool) error {
+	node.Peer = network.NewPeer(node.ctx, node, node.IdForNetwork, node.addr, false)
+	go node.LoopCacheQueue()
+	go node.MintLoop()
+	go node.ElectionLoop()
+	go node.ClockLoop()
+	defer node.Teardown()
+
+	for _, path := range files {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		count, err := node.Peer.Replay(network.NewMessageReader(f), realtime)
+		f.Close()
+		logger.Printf("Replay(%s) %d %v\n", path, count, err)
+		if err != nil {
+			return err
+		}
+	}
+	time.Sleep(time.Duration(config.SnapshotRoundGap))
+	return nil
+}
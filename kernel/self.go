@@ -66,7 +66,7 @@ func (node *Node) lockAndPersistTransaction(tx *common.VersionedTransaction, fin
 func (node *Node) validateKernelSnapshot(s *common.Snapshot, tx *common.VersionedTransaction, finalized bool) error {
 	switch tx.TransactionType() {
 	case common.TransactionTypeMint:
-		err := node.validateMintSnapshot(s, tx)
+		err := node.validateMintSnapshot(s, tx, finalized)
 		if err != nil {
 			logger.Verbosef("validateMintSnapshot ERROR %v %s %s\n", s, hex.EncodeToString(tx.PayloadMarshal()), err.Error())
 			return err
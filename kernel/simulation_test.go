@@ -0,0 +1,918 @@
+package kernel
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/MixinNetwork/mixin/common"
+	"github.com/MixinNetwork/mixin/config"
+	"github.com/MixinNetwork/mixin/crypto"
+	"github.com/MixinNetwork/mixin/domains/ethereum"
+	"github.com/MixinNetwork/mixin/kernel/internal/clock"
+	"github.com/MixinNetwork/mixin/logger"
+	"github.com/MixinNetwork/mixin/network"
+	"github.com/MixinNetwork/mixin/storage"
+	"github.com/VictoriaMetrics/fastcache"
+	"github.com/stretchr/testify/assert"
+)
+
+// The simulation boots all nodes in this process on in-memory stores and
+// the in-memory network, every decision of the harness and the drops of the
+// network are made by a rand seeded from SIMULATION_SEED. The same seed only
+// repeats these decisions, the message delays are scheduled by the wall clock
+// and the nodes run on real goroutines, so a failed scenario may not fail
+// again with the same seed.
+
+const (
+	simulationNodes   = 8
+	simulationEpoch   = 1551312000
+	simulationTimeout = 60 * time.Second
+	simulationPage    = 500
+)
+
+const simulationConfigTmpl = `[node]
+signer-key = "%s"
+consensus-only = false
+memory-cache-size = 16
+kernel-operation-period = 1
+cache-ttl = 3600
+[network]
+listener = "%s"
+peers = [%s]
+`
+
+type simulationNode struct {
+	signer  common.Address
+	payee   common.Address
+	address string
+	dir     string
+	node    *Node
+	crashed bool
+	removed bool
+}
+
+type simulationUTXO struct {
+	hash   crypto.Hash
+	index  int
+	amount common.Integer
+}
+
+// simulationGroup of a single transaction must be finalized, and a group
+// of more than one transaction is a double spend, which could be stuck
+// forever but never finalized more than once.
+type simulationGroup struct {
+	txs  []*common.VersionedTransaction
+	pool bool
+}
+
+type simulation struct {
+	t         *testing.T
+	assert    *assert.Assertions
+	seed      int64
+	rand      *rand.Rand
+	root      string
+	network   *network.MemoryNetwork
+	genesis   []byte
+	peers     string
+	domain    common.Address
+	nodes     []*simulationNode
+	observers []*simulationNode
+	utxos     []*simulationUTXO
+	pending   []*simulationGroup
+	rejected  []*common.VersionedTransaction
+	final     []*common.VersionedTransaction
+	deposits  int
+}
+
+func TestSimulation(t *testing.T) {
+	if testing.Short() {
+		t.Skip("simulation skipped in short mode")
+	}
+
+	sim := newSimulation(t, simulationSeed(t))
+	sim.settle()
+	sim.check()
+
+	for i := 0; i < 16; i++ {
+		sim.deposit(common.NewIntegerFromString(strconv.Itoa(sim.rand.Intn(1000) + 1)))
+	}
+	sim.settle()
+	sim.check()
+
+	sim.transfers(12)
+	sim.doubleSpends(4, false)
+	sim.settle()
+	sim.check()
+
+	sim.crash(sim.rand.Intn(simulationNodes))
+	sim.transfers(8)
+	sim.doubleSpends(2, false)
+	sim.settle()
+	sim.check()
+
+	sim.advance((config.KernelMintTimeBegin + 24) * time.Hour)
+	sim.waitFor("mint distribution", func() bool {
+		// the mint requires the works of the day from enough nodes
+		sim.transfers(len(sim.voters()))
+		sim.settle()
+		return sim.mintDistributions() > 0
+	})
+	sim.check()
+
+	imported := sim.checkpoint()
+	sim.transfers(4)
+	sim.settle()
+	sim.checkImported(imported)
+
+	source := sim.pledgeSource()
+	pledged := sim.pledge(source, true)
+	sim.waitNodeState(pledged, common.NodeStatePledging)
+	sim.advance(config.KernelNodeAcceptPeriodMinimum)
+	sim.waitNodeState(pledged, common.NodeStateAccepted)
+	sim.check()
+	sim.checkImported(imported)
+
+	sim.advance(24 * time.Hour)
+	removed := sim.waitRemoval()
+	sim.assert.NotEqual(pledged.signer.String(), removed.signer.String())
+	sim.check()
+
+	sim.advance(config.KernelNodePledgePeriodMinimum)
+	source = sim.pledgeSource()
+	cancelled := sim.pledge(source, false)
+	sim.waitNodeState(cancelled, common.NodeStatePledging)
+	// the cancel is locked by the pledge operation for two pledge periods,
+	// and only valid in the hours of the day for the node acceptance
+	sim.advanceToHour(config.KernelNodePledgePeriodMinimum*2, config.KernelNodeAcceptTimeBegin)
+	sim.transfers(1)
+	sim.settle()
+	sim.assert.Nil(sim.cancel(cancelled, source))
+	sim.waitNodeState(cancelled, common.NodeStateCancelled)
+	sim.check()
+}
+
+func simulationSeed(t *testing.T) int64 {
+	seed := time.Now().UnixNano()
+	if s := os.Getenv("SIMULATION_SEED"); s != "" {
+		v, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			t.Fatalf("invalid SIMULATION_SEED %s", s)
+		}
+		seed = v
+	}
+	// printed when the test starts, because a kernel panic in another
+	// goroutine kills the test binary before any cleanup or failure log
+	fmt.Printf("simulation with SIMULATION_SEED=%d\n", seed)
+	return seed
+}
+
+func simulationAccount(i int, role string) common.Address {
+	seed := make([]byte, 64)
+	copy(seed, []byte("SIMULATION#"+role+"#"))
+	seed[63] = byte(i)
+	account := common.NewAddressFromSeed(seed)
+	account.PrivateViewKey = account.PublicSpendKey.DeterministicHashDerive()
+	account.PublicViewKey = account.PrivateViewKey.Public()
+	return account
+}
+
+func newSimulation(t *testing.T, seed int64) *simulation {
+	level, _ := strconv.ParseInt(os.Getenv("LOG"), 10, 64)
+	logger.SetLevel(int(level))
+
+	sim := &simulation{
+		t:       t,
+		assert:  assert.New(t),
+		seed:    seed,
+		rand:    rand.New(rand.NewSource(seed)),
+		root:    t.TempDir(),
+		network: network.NewMemoryNetwork(seed),
+	}
+	sim.network.SetDefaultLink(network.MemoryLink{
+		Latency: time.Duration(sim.rand.Intn(5)+1) * time.Millisecond,
+		Jitter:  time.Duration(sim.rand.Intn(3)+1) * time.Millisecond,
+	})
+
+	inputs := make([]map[string]string, 0)
+	peers := make([]string, 0)
+	for i := 0; i < simulationNodes; i++ {
+		sn := sim.newNode(i)
+		sim.nodes = append(sim.nodes, sn)
+		peers = append(peers, sn.address)
+		inputs = append(inputs, map[string]string{
+			"signer":  sn.signer.String(),
+			"payee":   sn.payee.String(),
+			"balance": "10000",
+		})
+	}
+	sim.domain = sim.nodes[0].signer
+	sim.peers = `"` + strings.Join(peers, `","`) + `"`
+
+	genesis, err := json.MarshalIndent(map[string]interface{}{
+		"epoch": simulationEpoch,
+		"nodes": inputs,
+		"domains": []map[string]string{{
+			"signer":  sim.domain.String(),
+			"balance": "50000",
+		}},
+	}, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sim.genesis = genesis
+
+	clock.Reset()
+	clock.MockDiff(time.Unix(simulationEpoch, 0).Sub(time.Now()))
+	t.Cleanup(sim.teardown)
+	for _, sn := range sim.nodes {
+		sim.boot(sn)
+	}
+	return sim
+}
+
+func (sim *simulation) newNode(i int) *simulationNode {
+	return &simulationNode{
+		signer:  simulationAccount(i, "SIGNER"),
+		payee:   simulationAccount(i, "PAYEE"),
+		address: fmt.Sprintf("127.0.0.1:%d", 17100+i),
+		dir:     fmt.Sprintf("%s/node-%02d", sim.root, i),
+	}
+}
+
+func (sim *simulation) boot(sn *simulationNode) {
+	custom := sim.configure(sn)
+	store, err := storage.NewBadgerMemoryStore(custom)
+	if err != nil {
+		sim.t.Fatal(err)
+	}
+	sim.start(sn, custom, store)
+}
+
+func (sim *simulation) configure(sn *simulationNode) *config.Custom {
+	err := os.MkdirAll(sn.dir, 0755)
+	if err != nil {
+		sim.t.Fatal(err)
+	}
+	data := fmt.Sprintf(simulationConfigTmpl, sn.signer.PrivateSpendKey.String(), sn.address, sim.peers)
+	err = os.WriteFile(sn.dir+"/config.toml", []byte(data), 0644)
+	if err != nil {
+		sim.t.Fatal(err)
+	}
+	err = os.WriteFile(sn.dir+"/genesis.json", sim.genesis, 0644)
+	if err != nil {
+		sim.t.Fatal(err)
+	}
+
+	custom, err := config.Initialize(sn.dir + "/config.toml")
+	if err != nil {
+		sim.t.Fatal(err)
+	}
+	return custom
+}
+
+func (sim *simulation) start(sn *simulationNode, custom *config.Custom, store storage.Store) {
+	cache := fastcache.New(custom.Node.MemoryCacheSize * 1024 * 1024)
+	node, err := SetupNode(custom, store, cache, sn.address, sn.dir)
+	if err != nil {
+		sim.t.Fatal(err)
+	}
+	node.SetTransportFactory(sim.network.Endpoint(sn.address))
+	sn.node = node
+	go node.Loop()
+}
+
+// crash stops the node and drops its memory store, the node never comes
+// back, so the scenario must keep enough nodes for the threshold.
+func (sim *simulation) crash(i int) {
+	sn := sim.nodes[i]
+	sim.t.Logf("simulation crash %s", sn.signer)
+	sn.crashed = true
+	sn.node.Teardown()
+}
+
+func (sim *simulation) teardown() {
+	var wg sync.WaitGroup
+	for _, sn := range append(sim.nodes, sim.observers...) {
+		if sn.node == nil || sn.crashed {
+			continue
+		}
+		wg.Add(1)
+		go func(node *Node) {
+			defer wg.Done()
+			node.Teardown()
+		}(sn.node)
+	}
+	wg.Wait()
+	clock.Reset()
+}
+
+func (sim *simulation) advance(d time.Duration) {
+	clock.MockDiff(d)
+}
+
+// advanceToHour advances the clock at least the duration, then to the start
+// of the next hour of the day since the epoch.
+func (sim *simulation) advanceToHour(d time.Duration, hour int) {
+	sim.advance(d)
+	since := time.Duration(uint64(clock.Now().UnixNano()) - sim.voters()[0].node.Epoch)
+	next := since.Truncate(24*time.Hour) + time.Duration(hour)*time.Hour
+	if next < since {
+		next += 24 * time.Hour
+	}
+	sim.advance(next - since)
+}
+
+// live nodes are running, and the voters are the live nodes not removed.
+func (sim *simulation) live() []*simulationNode {
+	var nodes []*simulationNode
+	for _, sn := range sim.nodes {
+		if sn.node != nil && !sn.crashed {
+			nodes = append(nodes, sn)
+		}
+	}
+	return nodes
+}
+
+func (sim *simulation) voters() []*simulationNode {
+	var nodes []*simulationNode
+	for _, sn := range sim.live() {
+		if !sn.removed {
+			nodes = append(nodes, sn)
+		}
+	}
+	return nodes
+}
+
+func (sim *simulation) pick() *simulationNode {
+	voters := sim.voters()
+	return voters[sim.rand.Intn(len(voters))]
+}
+
+// waitFor polls the condition until the timeout, and the optional detail
+// is updated by the condition to explain the failure.
+func (sim *simulation) waitFor(name string, cond func() bool, detail ...*string) {
+	deadline := time.Now().Add(simulationTimeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			for _, d := range detail {
+				name = name + " " + *d
+			}
+			sim.t.Fatalf("simulation timeout waiting for %s", name)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func (sim *simulation) outputSeed() []byte {
+	seed := make([]byte, 64)
+	sim.rand.Read(seed)
+	return seed
+}
+
+func (sim *simulation) send(sn *simulationNode, tx *common.VersionedTransaction) error {
+	_, err := sn.node.QueueTransaction(tx)
+	return err
+}
+
+func (sim *simulation) expect(pool bool, txs ...*common.VersionedTransaction) {
+	sim.pending = append(sim.pending, &simulationGroup{txs: txs, pool: pool})
+}
+
+func (sim *simulation) sign(tx *common.Transaction) *common.VersionedTransaction {
+	signed := tx.AsLatestVersion()
+	reader := sim.voters()[0].node.persistStore
+	for i := range signed.Inputs {
+		err := signed.SignInput(reader, i, []*common.Address{&sim.domain})
+		if err != nil {
+			sim.t.Fatal(err)
+		}
+	}
+	return signed
+}
+
+func (sim *simulation) buildDeposit(amount common.Integer) *common.VersionedTransaction {
+	sim.deposits += 1
+	tx := common.NewTransaction(common.XINAssetId)
+	tx.AddDepositInput(&common.DepositData{
+		Chain:           ethereum.EthereumChainId,
+		AssetKey:        "0xa974c709cfb4566686553a20790685a47aceaa33",
+		TransactionHash: fmt.Sprintf("0x%064x", sim.seed+int64(sim.deposits)),
+		OutputIndex:     0,
+		Amount:          amount,
+	})
+	tx.AddScriptOutput([]*common.Address{&sim.domain}, common.NewThresholdScript(1), amount, sim.outputSeed())
+	return sim.sign(tx)
+}
+
+func (sim *simulation) deposit(amount common.Integer) *common.VersionedTransaction {
+	ver := sim.buildDeposit(amount)
+	sim.assert.Nil(sim.send(sim.pick(), ver))
+	sim.expect(true, ver)
+	return ver
+}
+
+func (sim *simulation) buildTransfer(in *simulationUTXO, outputs int) *common.VersionedTransaction {
+	tx := common.NewTransaction(common.XINAssetId)
+	tx.AddInput(in.hash, in.index)
+	amount, script := in.amount, common.NewThresholdScript(1)
+	if outputs > 1 && amount.Cmp(common.NewIntegerFromString("1")) > 0 {
+		split := amount.Div(2)
+		tx.AddScriptOutput([]*common.Address{&sim.domain}, script, split, sim.outputSeed())
+		amount = amount.Sub(split)
+	}
+	tx.AddScriptOutput([]*common.Address{&sim.domain}, script, amount, sim.outputSeed())
+	return sim.sign(tx)
+}
+
+func (sim *simulation) take() *simulationUTXO {
+	j := sim.rand.Intn(len(sim.utxos))
+	in := sim.utxos[j]
+	sim.utxos = append(sim.utxos[:j], sim.utxos[j+1:]...)
+	return in
+}
+
+// transfers spends random outputs of the pool to random nodes.
+func (sim *simulation) transfers(count int) {
+	for i := 0; i < count && len(sim.utxos) > 0; i++ {
+		tx := sim.buildTransfer(sim.take(), sim.rand.Intn(2)+1)
+		sim.assert.Nil(sim.send(sim.pick(), tx))
+		sim.expect(true, tx)
+	}
+}
+
+// doubleSpends spends random outputs of the pool twice by two transactions
+// sent to two different nodes. The second transaction is sent after the
+// first finalized unless race, and a race may leave the loser snapshot
+// finalized by some nodes, which blocks the others from agreement.
+func (sim *simulation) doubleSpends(count int, race bool) {
+	for i := 0; i < count && len(sim.utxos) > 0; i++ {
+		in := sim.take()
+		a := sim.buildTransfer(in, sim.rand.Intn(2)+1)
+		b := sim.buildTransfer(in, sim.rand.Intn(2)+1)
+		na, nb := sim.pick(), sim.pick()
+		if !race {
+			sim.assert.Nil(sim.send(na, a))
+			sim.expect(true, a)
+			sim.settle()
+			sim.assert.NotNil(sim.send(nb, b))
+			sim.rejected = append(sim.rejected, b)
+			continue
+		}
+
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			sim.send(na, a)
+		}()
+		go func() {
+			defer wg.Done()
+			sim.send(nb, b)
+		}()
+		wg.Wait()
+		sim.expect(true, a, b)
+	}
+}
+
+func (sim *simulation) finalized(sn *simulationNode, hash crypto.Hash) bool {
+	_, snap, err := sn.node.persistStore.ReadTransaction(hash)
+	return err == nil && len(snap) > 0
+}
+
+// settle waits until all pending transactions except the double spends
+// are finalized by all voters, and puts the script outputs of the finalized
+// transactions into the pool.
+func (sim *simulation) settle() {
+	start, count := time.Now(), len(sim.pending)
+	defer func() {
+		sim.t.Logf("simulation settle %d groups in %s", count, time.Since(start))
+	}()
+
+	var missing string
+	sim.waitFor("pending transactions", func() bool {
+		for _, sn := range sim.voters() {
+			for _, g := range sim.pending {
+				if len(g.txs) > 1 {
+					continue
+				}
+				var found bool
+				for _, tx := range g.txs {
+					found = found || sim.finalized(sn, tx.PayloadHash())
+				}
+				if !found {
+					missing = fmt.Sprintf("%s on %s", g.txs[0].PayloadHash(), sn.signer)
+					return false
+				}
+			}
+		}
+		return true
+	}, &missing)
+	sim.agree()
+
+	for _, g := range sim.pending {
+		var final []*common.VersionedTransaction
+		for _, tx := range g.txs {
+			if sim.finalized(sim.voters()[0], tx.PayloadHash()) {
+				final = append(final, tx)
+			}
+		}
+		if len(g.txs) == 1 {
+			sim.assert.Len(final, 1)
+		} else {
+			sim.assert.LessOrEqual(len(final), 1)
+		}
+		sim.final = append(sim.final, final...)
+		if !g.pool || len(final) != 1 {
+			continue
+		}
+		tx := final[0]
+		for i, out := range tx.Outputs {
+			if out.Type != common.OutputTypeScript {
+				continue
+			}
+			sim.utxos = append(sim.utxos, &simulationUTXO{
+				hash:   tx.PayloadHash(),
+				index:  i,
+				amount: out.Amount,
+			})
+		}
+	}
+	sim.pending = nil
+}
+
+// agree waits until all voters finalized the same snapshots.
+func (sim *simulation) agree() {
+	var detail string
+	sim.waitFor("snapshots agreement", func() bool {
+		voters := sim.voters()
+		first := sim.snapshots(voters[0])
+		for _, sn := range voters[1:] {
+			other := sim.snapshots(sn)
+			if !sim.sameKeys(first, other) {
+				detail = fmt.Sprintf("%d on %s and %d on %s", len(first), voters[0].signer, len(other), sn.signer)
+				for k := range first {
+					if !other[k] {
+						detail = detail + " missing " + k.String()
+					}
+				}
+				return false
+			}
+		}
+		return true
+	}, &detail)
+}
+
+func (sim *simulation) snapshots(sn *simulationNode) map[crypto.Hash]bool {
+	filter := make(map[crypto.Hash]bool)
+	for offset := uint64(0); ; {
+		snapshots, err := sn.node.persistStore.ReadSnapshotsSinceTopology(offset, simulationPage)
+		if err != nil {
+			sim.t.Fatal(err)
+		}
+		for _, s := range snapshots {
+			filter[s.Hash] = true
+			offset = s.TopologicalOrder + 1
+		}
+		if len(snapshots) < simulationPage {
+			return filter
+		}
+	}
+}
+
+func (sim *simulation) sameKeys(a, b map[crypto.Hash]bool) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k := range a {
+		if !b[k] {
+			return false
+		}
+	}
+	return true
+}
+
+func (sim *simulation) mintDistributions() int {
+	var count int
+	for i, sn := range sim.voters() {
+		mints, _, err := sn.node.persistStore.ReadMintDistributions(common.MintGroupKernelNode, 0, simulationPage)
+		if err != nil {
+			sim.t.Fatal(err)
+		}
+		if i > 0 && len(mints) != count {
+			return 0
+		}
+		count = len(mints)
+	}
+	return count
+}
+
+// check asserts the invariants on all voters after they agree on the
+// snapshots, they must never spend an input twice, and agree on the mint
+// distributions and the nodes list.
+func (sim *simulation) check() {
+	sim.agree()
+	voters := sim.voters()
+	first := sim.snapshots(voters[0])
+	for _, sn := range voters[1:] {
+		sim.assert.True(sim.sameKeys(first, sim.snapshots(sn)), sn.signer.String())
+	}
+
+	for _, sn := range voters {
+		sim.checkDoubleSpends(sn)
+		for _, tx := range sim.rejected {
+			sim.assert.False(sim.finalized(sn, tx.PayloadHash()), tx.PayloadHash().String())
+		}
+	}
+
+	mints := sim.readMints(voters[0])
+	for _, sn := range voters[1:] {
+		sim.assert.Equal(mints, sim.readMints(sn), sn.signer.String())
+	}
+
+	nodes := sim.readNodes(voters[0])
+	for _, sn := range voters[1:] {
+		sim.assert.Equal(nodes, sim.readNodes(sn), sn.signer.String())
+	}
+}
+
+func (sim *simulation) checkDoubleSpends(sn *simulationNode) {
+	spent := make(map[string]crypto.Hash)
+	for offset := uint64(0); ; {
+		snapshots, txs, err := sn.node.persistStore.ReadSnapshotWithTransactionsSinceTopology(offset, simulationPage)
+		if err != nil {
+			sim.t.Fatal(err)
+		}
+		for _, tx := range txs {
+			hash := tx.PayloadHash()
+			for _, in := range tx.Inputs {
+				var key string
+				switch {
+				case in.Deposit != nil:
+					key = fmt.Sprintf("DEPOSIT:%s:%s:%d", in.Deposit.Chain, in.Deposit.TransactionHash, in.Deposit.OutputIndex)
+				case in.Mint != nil:
+					key = fmt.Sprintf("MINT:%s:%d", in.Mint.Group, in.Mint.Batch)
+				case in.Genesis != nil:
+					continue
+				default:
+					key = fmt.Sprintf("UTXO:%s:%d", in.Hash, in.Index)
+				}
+				if old, found := spent[key]; found && old != hash {
+					sim.t.Errorf("double spend %s by %s and %s on %s", key, old, hash, sn.signer)
+				}
+				spent[key] = hash
+			}
+		}
+		for _, s := range snapshots {
+			offset = s.TopologicalOrder + 1
+		}
+		if len(snapshots) < simulationPage {
+			return
+		}
+	}
+}
+
+func (sim *simulation) readMints(sn *simulationNode) []string {
+	mints, _, err := sn.node.persistStore.ReadMintDistributions(common.MintGroupKernelNode, 0, simulationPage)
+	if err != nil {
+		sim.t.Fatal(err)
+	}
+	var list []string
+	for _, m := range mints {
+		list = append(list, fmt.Sprintf("%d:%s:%s", m.Batch, m.Amount, m.Transaction))
+	}
+	return list
+}
+
+// readNodes doesn't compare the timestamp, because the remove transaction
+// is snapshotted by many nodes, and each node uses the timestamp of the
+// snapshot it finalized first.
+func (sim *simulation) readNodes(sn *simulationNode) []string {
+	var list []string
+	for _, n := range sn.node.persistStore.ReadAllNodes(uint64(clock.Now().UnixNano()), false) {
+		list = append(list, fmt.Sprintf("%s:%s:%s", n.Signer, n.State, n.Transaction))
+	}
+	sort.Strings(list)
+	return list
+}
+
+func (sim *simulation) nodeState(sn *simulationNode, signer common.Address) string {
+	for _, n := range sn.node.persistStore.ReadAllNodes(uint64(clock.Now().UnixNano()), false) {
+		if n.Signer.String() == signer.String() {
+			return n.State
+		}
+	}
+	return ""
+}
+
+func (sim *simulation) waitNodeState(target *simulationNode, state string) {
+	sim.waitFor(target.signer.String()+" "+state, func() bool {
+		for _, sn := range sim.voters() {
+			if sim.nodeState(sn, target.signer) != state {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// waitRemoval waits until a genesis node removed by the election, and
+// the removed node doesn't vote anymore if still running. The election
+// checks the graph timestamp, which only moves with finalized rounds.
+func (sim *simulation) waitRemoval() *simulationNode {
+	var removed *simulationNode
+	sim.waitFor("node removal", func() bool {
+		sim.transfers(len(sim.voters()))
+		sim.settle()
+		for _, target := range sim.nodes[:simulationNodes] {
+			all := true
+			for _, sn := range sim.voters() {
+				all = all && sim.nodeState(sn, target.signer) == common.NodeStateRemoved
+			}
+			if all {
+				removed = target
+				return true
+			}
+		}
+		return false
+	})
+	sim.t.Logf("simulation removed %s", removed.signer)
+	removed.removed = true
+	return removed
+}
+
+// pledgeSource deposits the pledge amount and moves it to a transaction of
+// a single output, which is required by the cancel transaction.
+func (sim *simulation) pledgeSource() *common.VersionedTransaction {
+	amount := pledgeAmount(0)
+	deposit := sim.buildDeposit(amount)
+	sim.assert.Nil(sim.send(sim.pick(), deposit))
+	sim.expect(false, deposit)
+	sim.settle()
+
+	source := sim.buildTransfer(&simulationUTXO{hash: deposit.PayloadHash(), amount: amount}, 1)
+	sim.assert.Nil(sim.send(sim.pick(), source))
+	sim.expect(false, source)
+	sim.settle()
+	return source
+}
+
+// pledge a new node with the source, and boot the node to accept it.
+func (sim *simulation) pledge(source *common.VersionedTransaction, boot bool) *simulationNode {
+	sn := sim.newNode(len(sim.nodes))
+	sim.nodes = append(sim.nodes, sn)
+
+	tx := common.NewTransaction(common.XINAssetId)
+	tx.AddInput(source.PayloadHash(), 0)
+	tx.AddOutputWithType(common.OutputTypeNodePledge, nil, common.Script{}, source.Outputs[0].Amount, sim.outputSeed())
+	tx.Extra = append(sn.signer.PublicSpendKey[:], sn.payee.PublicSpendKey[:]...)
+	pledge := sim.sign(tx)
+	sim.assert.Nil(sim.send(sim.pick(), pledge))
+	sim.expect(false, pledge)
+	sim.settle()
+	sim.t.Logf("simulation pledge %s %s", sn.signer, pledge.PayloadHash())
+
+	if boot {
+		sim.boot(sn)
+	}
+	return sn
+}
+
+// cancel sends the cancel transaction of the pledged node, and returns the
+// error if the transaction rejected.
+func (sim *simulation) cancel(sn *simulationNode, source *common.VersionedTransaction) error {
+	var pledge *common.VersionedTransaction
+	for _, n := range sim.voters()[0].node.persistStore.ReadAllNodes(uint64(clock.Now().UnixNano()), false) {
+		if n.Signer.String() == sn.signer.String() {
+			tx, _, err := sim.voters()[0].node.persistStore.ReadTransaction(n.Transaction)
+			if err != nil {
+				sim.t.Fatal(err)
+			}
+			pledge = tx
+		}
+	}
+	if pledge == nil {
+		sim.t.Fatalf("simulation pledge not found %s", sn.signer)
+	}
+
+	seed := sim.outputSeed()
+	tx := common.NewTransaction(common.XINAssetId)
+	tx.AddInput(pledge.PayloadHash(), 0)
+	tx.AddOutputWithType(common.OutputTypeNodeCancel, nil, common.Script{}, pledge.Outputs[0].Amount.Div(100), seed)
+	tx.AddScriptOutput([]*common.Address{&sim.domain}, common.NewThresholdScript(1), pledge.Outputs[0].Amount.Sub(tx.Outputs[0].Amount), seed)
+	tx.Extra = append(pledge.Extra, sim.domain.PrivateViewKey[:]...)
+	utxo := &common.UTXO{
+		Input: common.Input{
+			Hash:  pledge.PayloadHash(),
+			Index: 0,
+		},
+		Output: common.Output{
+			Type: common.OutputTypeNodePledge,
+			Keys: source.Outputs[0].Keys,
+			Mask: source.Outputs[0].Mask,
+		},
+	}
+	cancel := tx.AsLatestVersion()
+	err := cancel.SignUTXO(utxo, []*common.Address{&sim.domain})
+	if err != nil {
+		sim.t.Fatal(err)
+	}
+	err = sim.send(sim.pick(), cancel)
+	if err != nil {
+		return err
+	}
+	sim.expect(false, cancel)
+	sim.settle()
+	sim.t.Logf("simulation cancel %s %s", sn.signer, cancel.PayloadHash())
+	return nil
+}
+
+// checkpoint exports the checkpoint from a voter, signs it by all voters,
+// and boots an observer node from the checkpoint imported to a new store.
+func (sim *simulation) checkpoint() *simulationNode {
+	sim.final = nil
+	voters := sim.voters()
+	exported, err := voters[0].node.ExportCheckpoint()
+	if err != nil {
+		sim.t.Fatal(err)
+	}
+	path := voters[0].node.CheckpointFile()
+	var hash crypto.Hash
+	for _, sn := range voters {
+		var state *CheckpointState
+		var detail string
+		sim.waitFor("checkpoint "+sn.signer.String(), func() bool {
+			state, err = sn.node.SignCheckpoint(exported.Header)
+			if err != nil {
+				sim.t.Fatal(err)
+			}
+			if state.Err != nil {
+				detail = state.Err.Error()
+			}
+			return state.Done && state.Err == nil
+		}, &detail)
+		if sn == voters[0] {
+			hash = state.Hash
+			continue
+		}
+		sim.assert.Equal(hash, state.Hash, sn.signer.String())
+		signed, err := AppendCheckpointSignature(path, state.NodeId, state.Signature)
+		sim.assert.Nil(err)
+		sim.assert.Equal(hash, signed)
+	}
+
+	sn := &simulationNode{
+		signer:  simulationAccount(0, "OBSERVER"),
+		payee:   simulationAccount(0, "OBSERVER"),
+		address: fmt.Sprintf("127.0.0.1:%d", 17000),
+		dir:     fmt.Sprintf("%s/observer-%02d", sim.root, len(sim.observers)),
+	}
+	custom := sim.configure(sn)
+	store, err := storage.NewBadgerMemoryStore(custom)
+	if err != nil {
+		sim.t.Fatal(err)
+	}
+	_, err = ImportCheckpoint(store, sn.dir, path, crypto.NewHash([]byte("unmatch")))
+	sim.assert.NotNil(err)
+	imported, err := ImportCheckpoint(store, sn.dir, path, hash)
+	if err != nil {
+		sim.t.Fatal(err)
+	}
+	sim.assert.Equal(hash, imported)
+	sim.t.Logf("simulation checkpoint %s with %d heads", hash, len(exported.Header.Heads))
+
+	sim.observers = append(sim.observers, sn)
+	sim.start(sn, custom, store)
+	return sn
+}
+
+// checkImported waits the observer to finalize all transactions settled
+// after the checkpoint, and agree on the mints and nodes with the voters.
+func (sim *simulation) checkImported(sn *simulationNode) {
+	var missing string
+	sim.waitFor("imported transactions", func() bool {
+		for _, tx := range sim.final {
+			if !sim.finalized(sn, tx.PayloadHash()) {
+				missing = tx.PayloadHash().String()
+				return false
+			}
+		}
+		return true
+	}, &missing)
+
+	// the kernel transactions, e.g. the node accept, are made by the nodes
+	sim.waitFor("imported nodes and mints", func() bool {
+		voters := sim.voters()
+		nodes, mints := sim.readNodes(voters[0]), sim.readMints(voters[0])
+		return strings.Join(nodes, ",") == strings.Join(sim.readNodes(sn), ",") &&
+			strings.Join(mints, ",") == strings.Join(sim.readMints(sn), ",")
+	})
+	sim.checkDoubleSpends(sn)
+}
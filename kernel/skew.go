@@ -0,0 +1,72 @@
+package kernel
+
+import (
+	"time"
+
+	"github.com/MixinNetwork/mixin/config"
+	"github.com/MixinNetwork/mixin/kernel/internal/clock"
+	"github.com/MixinNetwork/mixin/logger"
+	"github.com/MixinNetwork/mixin/network"
+)
+
+// The consensus depends on the timestamps of the snapshots, so a skewed
+// clock makes the node fail the round gap and accept windows. The skew is
+// the local clock minus the median clock of the accepted consensus nodes,
+// which are estimated by the pings exchanged with the neighbors.
+const (
+	clockSkewCheckPeriod = time.Minute
+	clockSkewWarning     = time.Duration(config.SnapshotRoundGap / 3)
+)
+
+// ClockSkew returns the local clock skew and the count of the consensus
+// nodes with the clock offset estimated.
+func (node *Node) ClockSkew() (time.Duration, int) {
+	if node.Peer == nil {
+		return 0, 0
+	}
+	offsets := node.Peer.ClockOffsets()
+	var consensus []time.Duration
+	for _, cn := range node.NodesListWithoutState(uint64(clock.Now().UnixNano()), true) {
+		if o, found := offsets[cn.IdForNetwork]; found {
+			consensus = append(consensus, o)
+		}
+	}
+	return -network.MedianClockOffset(consensus), len(consensus)
+}
+
+// clockSkewed is true if the skew exceeds the clock skew limit, and only
+// if the offsets of enough consensus nodes are estimated.
+func (node *Node) clockSkewed() bool {
+	limit := time.Duration(node.custom.Node.ClockSkewLimit) * time.Millisecond
+	if limit <= 0 {
+		return false
+	}
+	skew, peers := node.ClockSkew()
+	if peers+1 < node.ConsensusThreshold(uint64(clock.Now().UnixNano())) {
+		return false
+	}
+	return skew > limit || skew < -limit
+}
+
+func (node *Node) ClockLoop() {
+	defer close(node.ckc)
+
+	ticker := time.NewTicker(clockSkewCheckPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-node.ctx.Done():
+			return
+		case <-ticker.C:
+			skew, peers := node.ClockSkew()
+			if peers == 0 || (skew <= clockSkewWarning && skew >= -clockSkewWarning) {
+				continue
+			}
+			logger.Printf("ClockLoop local clock skew %s to %d consensus nodes\n", skew, peers)
+			if node.clockSkewed() {
+				logger.Printf("ClockLoop refuse to propose snapshots with clock skew %s\n", skew)
+			}
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package kernel
+
+import (
+	"encoding/binary"
+
+	"github.com/MixinNetwork/mixin/crypto"
+)
+
+const (
+	TransactionStageUnknown   = "unknown"
+	TransactionStageCache     = "cache"
+	TransactionStageAnnounced = "announced"
+	TransactionStageFinalized = "finalized"
+
+	transactionStatusSnapshotsLimit = 16
+
+	statusPrefixTransactionSnapshots = "STATUS:TRANSACTION:SNAPSHOTS"
+	statusPrefixTransactionError     = "STATUS:TRANSACTION:ERROR"
+	statusPrefixSnapshotSigners      = "STATUS:SNAPSHOT:SIGNERS"
+)
+
+type SnapshotSigners struct {
+	Hash        crypto.Hash
+	Commitments int
+	Responses   int
+}
+
+type TransactionStatus struct {
+	Hash      crypto.Hash
+	Stage     string
+	Snapshots []*SnapshotSigners
+	Error     string
+}
+
+// The status records are kept in the node memory cache only, they are best effort
+// hints for clients to track the transaction progress, and may be evicted anytime.
+func (node *Node) TransactionStatus(hash crypto.Hash) (*TransactionStatus, error) {
+	status := &TransactionStatus{Hash: hash, Stage: TransactionStageUnknown}
+	for _, s := range node.readTransactionAnnouncements(hash) {
+		status.Snapshots = append(status.Snapshots, node.readSnapshotSigners(s))
+	}
+	if msg := node.cacheStore.Get(nil, statusKey(statusPrefixTransactionError, hash)); len(msg) > 0 {
+		status.Error = string(msg)
+	}
+
+	_, finalized, err := node.persistStore.ReadTransaction(hash)
+	if err != nil {
+		return nil, err
+	}
+	if len(finalized) > 0 {
+		snap, err := crypto.HashFromString(finalized)
+		if err != nil {
+			return nil, err
+		}
+		status.Stage = TransactionStageFinalized
+		status.Snapshots = []*SnapshotSigners{node.readSnapshotSigners(snap)}
+		status.Error = ""
+		return status, nil
+	}
+	if len(status.Snapshots) > 0 {
+		status.Stage = TransactionStageAnnounced
+		return status, nil
+	}
+
+	tx, err := node.persistStore.CacheGetTransaction(hash)
+	if err != nil {
+		return nil, err
+	}
+	if tx != nil {
+		status.Stage = TransactionStageCache
+	}
+	return status, nil
+}
+
+func (node *Node) cacheTransactionValidationError(hash crypto.Hash, err error) {
+	key := statusKey(statusPrefixTransactionError, hash)
+	if err == nil {
+		node.cacheStore.Del(key)
+	} else {
+		node.cacheStore.Set(key, []byte(err.Error()))
+	}
+}
+
+// cacheTransactionAnnouncement appends the snapshot to the cached list, the
+// mutex guards the read and write of the list from the concurrent chains.
+func (node *Node) cacheTransactionAnnouncement(tx, snap crypto.Hash) {
+	node.statusMutex.Lock()
+	defer node.statusMutex.Unlock()
+
+	snapshots := node.readTransactionAnnouncements(tx)
+	for _, s := range snapshots {
+		if s == snap {
+			return
+		}
+	}
+	snapshots = append(snapshots, snap)
+	if len(snapshots) > transactionStatusSnapshotsLimit {
+		snapshots = snapshots[len(snapshots)-transactionStatusSnapshotsLimit:]
+	}
+	val := make([]byte, 0, len(snapshots)*len(crypto.Hash{}))
+	for _, s := range snapshots {
+		val = append(val, s[:]...)
+	}
+	node.cacheStore.Set(statusKey(statusPrefixTransactionSnapshots, tx), val)
+}
+
+func (node *Node) cacheSnapshotSigners(agg *CosiAggregator) {
+	val := make([]byte, 4)
+	binary.BigEndian.PutUint16(val[:2], uint16(len(agg.Commitments)))
+	binary.BigEndian.PutUint16(val[2:], uint16(len(agg.Responses)))
+	node.cacheStore.Set(statusKey(statusPrefixSnapshotSigners, agg.Snapshot.Hash), val)
+}
+
+func (node *Node) readTransactionAnnouncements(tx crypto.Hash) []crypto.Hash {
+	val := node.cacheStore.Get(nil, statusKey(statusPrefixTransactionSnapshots, tx))
+	size := len(crypto.Hash{})
+	if len(val)%size != 0 {
+		return nil
+	}
+	snapshots := make([]crypto.Hash, len(val)/size)
+	for i := range snapshots {
+		copy(snapshots[i][:], val[i*size:(i+1)*size])
+	}
+	return snapshots
+}
+
+func (node *Node) readSnapshotSigners(snap crypto.Hash) *SnapshotSigners {
+	ss := &SnapshotSigners{Hash: snap}
+	val := node.cacheStore.Get(nil, statusKey(statusPrefixSnapshotSigners, snap))
+	if len(val) == 4 {
+		ss.Commitments = int(binary.BigEndian.Uint16(val[:2]))
+		ss.Responses = int(binary.BigEndian.Uint16(val[2:]))
+	}
+	return ss
+}
+
+func statusKey(prefix string, hash crypto.Hash) []byte {
+	return append([]byte(prefix), hash[:]...)
+}
@@ -0,0 +1,43 @@
+package kernel
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/MixinNetwork/mixin/crypto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTransactionAnnouncementConcurrent(t *testing.T) {
+	assert := assert.New(t)
+
+	root, err := os.MkdirTemp("", "mixin-status-test")
+	assert.Nil(err)
+	defer os.RemoveAll(root)
+
+	node := setupTestNode(assert, root)
+	assert.NotNil(node)
+
+	tx := crypto.NewHash([]byte("transaction"))
+	var wg sync.WaitGroup
+	for i := 0; i < transactionStatusSnapshotsLimit; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			snap := crypto.NewHash([]byte(fmt.Sprintf("snapshot-%d", i)))
+			node.cacheTransactionAnnouncement(tx, snap)
+		}(i)
+	}
+	wg.Wait()
+	assert.Len(node.readTransactionAnnouncements(tx), transactionStatusSnapshotsLimit)
+
+	snap := crypto.NewHash([]byte("snapshot-0"))
+	node.cacheTransactionAnnouncement(tx, snap)
+	assert.Len(node.readTransactionAnnouncements(tx), transactionStatusSnapshotsLimit)
+	node.cacheTransactionAnnouncement(tx, crypto.NewHash([]byte("snapshot-latest")))
+	snapshots := node.readTransactionAnnouncements(tx)
+	assert.Len(snapshots, transactionStatusSnapshotsLimit)
+	assert.Equal(crypto.NewHash([]byte("snapshot-latest")), snapshots[len(snapshots)-1])
+}
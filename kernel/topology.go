@@ -16,6 +16,7 @@ type TopologicalSequence struct {
 	seq   uint64
 	point uint64
 	sps   float64
+	last  time.Time
 }
 
 func (node *Node) TopologicalOrder() uint64 {
@@ -26,6 +27,14 @@ func (node *Node) SPS() float64 {
 	return node.TopoCounter.sps
 }
 
+// TopologyAge is the duration since the last snapshot written, or since the
+// node start if no snapshot written, by the node clock.
+func (node *Node) TopologyAge() time.Duration {
+	node.TopoCounter.Lock()
+	defer node.TopoCounter.Unlock()
+	return clock.Now().Sub(node.TopoCounter.last)
+}
+
 type SnapshotWitness struct {
 	Signature *crypto.Signature
 	Timestamp uint64
@@ -49,6 +58,7 @@ func (node *Node) TopoWrite(s *common.Snapshot, signers []crypto.Hash) *common.S
 	}
 
 	node.TopoCounter.seq += 1
+	node.TopoCounter.last = clock.Now()
 	topo := &common.SnapshotWithTopologicalOrder{
 		Snapshot:         *s,
 		TopologicalOrder: node.TopoCounter.seq,
@@ -71,7 +81,8 @@ func (topo *TopologicalSequence) TopoStats() {
 
 func getTopologyCounter(store storage.Store) *TopologicalSequence {
 	topo := &TopologicalSequence{
-		seq: store.TopologySequence(),
+		seq:  store.TopologySequence(),
+		last: clock.Now(),
 	}
 	topo.point = topo.seq
 	go topo.TopoStats()
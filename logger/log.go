@@ -18,27 +18,31 @@ const (
 
 // FIXME GLOBAL VARAIBLES
 
+// level, limiter and filter are changed by the admin RPC while the node is
+// logging, so they are always accessed atomically.
 var (
-	level   int
-	limiter int
-	filter  *regexp.Regexp
+	level   int32
+	limiter int32
+	filter  atomic.Value
 	counter *hashmap.HashMap
 )
 
 func init() {
+	filter.Store((*regexp.Regexp)(nil))
 	counter = &hashmap.HashMap{}
 }
 
 func SetLevel(l int) {
-	level = l
+	atomic.StoreInt32(&level, int32(l))
 }
 
 func SetLimiter(l int) {
-	limiter = l
+	atomic.StoreInt32(&limiter, int32(l))
 }
 
 func SetFilter(pattern string) error {
 	if pattern == "" {
+		filter.Store((*regexp.Regexp)(nil))
 		return nil
 	}
 	// https://github.com/google/re2/wiki/Syntax
@@ -46,18 +50,18 @@ func SetFilter(pattern string) error {
 	if err != nil {
 		return err
 	}
-	filter = reg
+	filter.Store(reg)
 	return nil
 }
 
 func Println(v ...interface{}) {
-	if level >= INFO {
+	if atomic.LoadInt32(&level) >= INFO {
 		log.Println(v...)
 	}
 }
 
 func Printf(format string, v ...interface{}) {
-	if level >= INFO {
+	if atomic.LoadInt32(&level) >= INFO {
 		log.Printf(format, v...)
 	}
 }
@@ -70,8 +74,8 @@ func Debugf(format string, v ...interface{}) {
 	printfAtLevel(DEBUG, format, v...)
 }
 
-func printfAtLevel(l int, format string, v ...interface{}) {
-	if level < l {
+func printfAtLevel(l int32, format string, v ...interface{}) {
+	if atomic.LoadInt32(&level) < l {
 		return
 	}
 	out := filterOutput(format, v...)
@@ -85,7 +89,8 @@ func printfAtLevel(l int, format string, v ...interface{}) {
 }
 
 func limiterAvailable(out string) bool {
-	if limiter == 0 {
+	limit := atomic.LoadInt32(&limiter)
+	if limit == 0 {
 		return true
 	}
 	var i int64
@@ -93,12 +98,13 @@ func limiterAvailable(out string) bool {
 	actual := (val).(*int64)
 	count := atomic.LoadInt64(actual)
 	atomic.AddInt64(actual, 1)
-	return count < int64(limiter)
+	return count < int64(limit)
 }
 
 func filterOutput(format string, v ...interface{}) string {
 	out := fmt.Sprintf(format, v...)
-	if filter == nil || filter.MatchString(out) {
+	reg := filter.Load().(*regexp.Regexp)
+	if reg == nil || reg.MatchString(out) {
 		return out
 	}
 	return ""
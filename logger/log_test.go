@@ -47,6 +47,11 @@ func TestLogger(t *testing.T) {
 	out = filterOutput("ethereum or bitcoin %d", time.Now().UnixNano())
 	assert.NotContains(out, "mixin")
 
+	err = SetFilter("")
+	assert.Nil(err)
+	out = filterOutput("ethereum or bitcoin %d", time.Now().UnixNano())
+	assert.Contains(out, "ethereum")
+
 	la := limiterAvailable("hello from mixin")
 	assert.True(la)
 	SetLimiter(10)
@@ -59,8 +64,33 @@ func TestLogger(t *testing.T) {
 	la = limiterAvailable("hello from mixin again")
 	assert.True(la)
 
-	level = 0
-	limiter = 0
-	filter = nil
+	SetLevel(0)
+	SetLimiter(0)
+	SetFilter("")
+	counter = &hashmap.HashMap{}
+}
+
+func TestLoggerConcurrent(t *testing.T) {
+	assert := assert.New(t)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			SetLevel(i % 2)
+			SetLimiter(i)
+			assert.Nil(SetFilter("^mixin"))
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		Debugf("mixin %d\n", i)
+		filterOutput("mixin %d", i)
+		limiterAvailable("mixin concurrent")
+	}
+	<-done
+
+	SetLevel(0)
+	SetLimiter(0)
+	SetFilter("")
 	counter = &hashmap.HashMap{}
 }
@@ -6,11 +6,14 @@ import (
 	_ "net/http/pprof"
 	"os"
 	"runtime"
+	"strings"
 	"time"
 
 	"github.com/MixinNetwork/mixin/config"
+	"github.com/MixinNetwork/mixin/crypto"
 	"github.com/MixinNetwork/mixin/kernel"
 	"github.com/MixinNetwork/mixin/logger"
+	"github.com/MixinNetwork/mixin/network"
 	"github.com/MixinNetwork/mixin/rpc"
 	"github.com/MixinNetwork/mixin/storage"
 	"github.com/VictoriaMetrics/fastcache"
@@ -27,7 +30,7 @@ func main() {
 			Name:    "node",
 			Aliases: []string{"n"},
 			Value:   "127.0.0.1:8239",
-			Usage:   "the node RPC endpoint",
+			Usage:   "the node RPC endpoint, or comma separated endpoints to try in order",
 		},
 		&cli.StringFlag{
 			Name:    "dir",
@@ -39,6 +42,10 @@ func main() {
 			Value: false,
 			Usage: "print the runtime",
 		},
+		&cli.StringFlag{
+			Name:  "admin-token",
+			Usage: "the node RPC admin token",
+		},
 	}
 	app.EnableBashCompletion = true
 	app.Commands = []*cli.Command{
@@ -108,6 +115,55 @@ func main() {
 				},
 			},
 		},
+		{
+			Name:   "importcheckpoint",
+			Usage:  "Import a checkpoint signed by the consensus nodes to initialize the kernel",
+			Action: importCheckpointCmd,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:    "dir",
+					Aliases: []string{"d"},
+					Usage:   "the kernel data directory",
+				},
+				&cli.StringFlag{
+					Name:    "src",
+					Aliases: []string{"s"},
+					Usage:   "the checkpoint file or the checkpoint URL of a node RPC",
+				},
+				&cli.StringFlag{
+					Name:     "hash",
+					Usage:    "the expected checkpoint hash from a trusted source",
+					Required: true,
+				},
+			},
+		},
+		{
+			Name:   "replay",
+			Usage:  "Replay the recorded peer messages into a kernel without network",
+			Action: replayCmd,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:    "dir",
+					Aliases: []string{"d"},
+					Usage:   "the kernel data directory, a copy of the recorded node",
+				},
+				&cli.StringFlag{
+					Name:    "src",
+					Aliases: []string{"s"},
+					Usage:   "the recorded file or the message recorder directory",
+				},
+				&cli.BoolFlag{
+					Name:  "realtime",
+					Usage: "replay the messages with the recorded intervals",
+				},
+				&cli.IntFlag{
+					Name:    "log",
+					Aliases: []string{"l"},
+					Value:   logger.INFO,
+					Usage:   "the log level",
+				},
+			},
+		},
 		{
 			Name:   "setuptestnet",
 			Usage:  "Setup the test nodes and genesis",
@@ -286,6 +342,17 @@ func main() {
 				},
 			},
 		},
+		{
+			Name:   "validaterawtransaction",
+			Usage:  "Validate a hex encoded signed raw transaction without broadcasting it",
+			Action: validateTransactionCmd,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:  "raw",
+					Usage: "the hex encoded signed raw transaction",
+				},
+			},
+		},
 		{
 			Name:   "decoderawtransaction",
 			Usage:  "Decode a raw transaction as JSON",
@@ -448,6 +515,23 @@ func main() {
 				},
 			},
 		},
+		{
+			Name:   "getsnapshotproof",
+			Usage:  "Get the finalization proof of a snapshot since a checkpoint",
+			Action: getSnapshotProofCmd,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:    "hash",
+					Aliases: []string{"x"},
+					Usage:   "the snapshot hash",
+				},
+				&cli.Uint64Flag{
+					Name:  "checkpoint",
+					Value: 0,
+					Usage: "the trusted checkpoint timestamp, defaults to the genesis",
+				},
+			},
+		},
 		{
 			Name:   "gettransaction",
 			Usage:  "Get the finalized transaction by hash",
@@ -472,6 +556,55 @@ func main() {
 				},
 			},
 		},
+		{
+			Name:   "gettransactionstatus",
+			Usage:  "Get the transaction lifecycle status by hash",
+			Action: getTransactionStatusCmd,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:    "hash",
+					Aliases: []string{"x"},
+					Usage:   "the transaction hash",
+				},
+			},
+		},
+		{
+			Name:   "listpendingtransactions",
+			Usage:  "List the unconfirmed transactions in cache",
+			Action: listPendingTransactionsCmd,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:  "offset",
+					Usage: "the transaction hash to start from",
+				},
+				&cli.Uint64Flag{
+					Name:  "limit",
+					Value: 10,
+					Usage: "the up limit of the returned transactions",
+				},
+				&cli.StringFlag{
+					Name:  "asset",
+					Usage: "only list transactions of this asset",
+				},
+			},
+		},
+		{
+			Name:   "getpendingtransactionsstats",
+			Usage:  "Get the statistics of the unconfirmed transactions in cache",
+			Action: getPendingTransactionsStatsCmd,
+		},
+		{
+			Name:   "removependingtransaction",
+			Usage:  "Remove an unconfirmed transaction from cache, requires the admin token",
+			Action: removePendingTransactionCmd,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:    "hash",
+					Aliases: []string{"x"},
+					Usage:   "the transaction hash",
+				},
+			},
+		},
 		{
 			Name:   "getutxo",
 			Usage:  "Get the UTXO by hash and index",
@@ -553,6 +686,111 @@ func main() {
 			Usage:  "Dump the graph head",
 			Action: dumpGraphHeadCmd,
 		},
+		{
+			Name:   "listpeers",
+			Usage:  "List the neighbors with connection and sync state",
+			Action: listPeersCmd,
+		},
+		{
+			Name:   "addneighbor",
+			Usage:  "Connect to a neighbor without restart, requires the admin token",
+			Action: addNeighborCmd,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:  "id",
+					Usage: "the neighbor node id",
+				},
+				&cli.StringFlag{
+					Name:    "address",
+					Aliases: []string{"a"},
+					Usage:   "the neighbor listener address",
+				},
+			},
+		},
+		{
+			Name:   "removeneighbor",
+			Usage:  "Disconnect a neighbor without restart, requires the admin token",
+			Action: removeNeighborCmd,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:  "id",
+					Usage: "the neighbor node id",
+				},
+			},
+		},
+		{
+			Name:   "setloglevel",
+			Usage:  "Change the log verbosity of the node, requires the admin token",
+			Action: setLogLevelCmd,
+			Flags: []cli.Flag{
+				&cli.IntFlag{
+					Name:    "level",
+					Aliases: []string{"l"},
+					Usage:   "the verbosity level",
+				},
+			},
+		},
+		{
+			Name:   "setloglimiter",
+			Usage:  "Change the log limiter of the node, requires the admin token",
+			Action: setLogLimiterCmd,
+			Flags: []cli.Flag{
+				&cli.IntFlag{
+					Name:  "limiter",
+					Usage: "the limit of the same log, 0 for no limit",
+				},
+			},
+		},
+		{
+			Name:   "setlogfilter",
+			Usage:  "Change the log filter of the node, requires the admin token",
+			Action: setLogFilterCmd,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:  "filter",
+					Usage: "the RE2 regex pattern to filter log, empty to disable",
+				},
+			},
+		},
+		{
+			Name:   "runvalueloggc",
+			Usage:  "Run the badger value log GC of the node, requires the admin token",
+			Action: runValueLogGCCmd,
+			Flags: []cli.Flag{
+				&cli.Float64Flag{
+					Name:  "ratio",
+					Value: 0.5,
+					Usage: "the discard ratio of the value log files to rewrite",
+				},
+			},
+		},
+		{
+			Name:   "dumpgoroutines",
+			Usage:  "Dump the goroutine stacks of the node, requires the admin token",
+			Action: dumpGoroutinesCmd,
+		},
+		{
+			Name:   "dumpqueue",
+			Usage:  "Dump the queue state of the node, requires the admin token",
+			Action: dumpQueueCmd,
+		},
+		{
+			Name:   "exportcheckpoint",
+			Usage:  "Export the checkpoint of the node to its directory, requires the admin token",
+			Action: exportCheckpointCmd,
+		},
+		{
+			Name:   "signcheckpoint",
+			Usage:  "Sign the checkpoint file by the node and append the signature, requires the admin token",
+			Action: signCheckpointCmd,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:    "file",
+					Aliases: []string{"f"},
+					Usage:   "the checkpoint file",
+				},
+			},
+		},
 	}
 	err := app.Run(os.Args)
 	if err != nil {
@@ -607,6 +845,71 @@ func cloneCmd(c *cli.Context) error {
 	return node.Import(c.String("dir"), source)
 }
 
+func importCheckpointCmd(c *cli.Context) error {
+	custom, err := config.Initialize(c.String("dir") + "/config.toml")
+	if err != nil {
+		return err
+	}
+
+	expect, err := crypto.HashFromString(c.String("hash"))
+	if err != nil {
+		return err
+	}
+	path := c.String("src")
+	if strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://") {
+		path = c.String("dir") + "/checkpoint.dat"
+		err = downloadCheckpoint(c.String("src"), path)
+		if err != nil {
+			return err
+		}
+	}
+
+	store, err := storage.NewBadgerStore(custom, c.String("dir"))
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	hash, err := kernel.ImportCheckpoint(store, c.String("dir"), path, expect)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("checkpoint:\t%s\n", hash)
+	return nil
+}
+
+func replayCmd(c *cli.Context) error {
+	logger.SetLevel(c.Int("log"))
+	custom, err := config.Initialize(c.String("dir") + "/config.toml")
+	if err != nil {
+		return err
+	}
+
+	files := []string{c.String("src")}
+	info, err := os.Stat(c.String("src"))
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		files, err = network.RecordedFiles(c.String("src"))
+		if err != nil {
+			return err
+		}
+	}
+
+	cache := fastcache.New(custom.Node.MemoryCacheSize * 1024 * 1024)
+	store, err := storage.NewBadgerStore(custom, c.String("dir"))
+	if err != nil {
+		return err
+	}
+	node, err := kernel.SetupNode(custom, store, cache, "", c.String("dir"))
+	if err != nil {
+		store.Close()
+		return err
+	}
+	return node.Replay(files, c.Bool("realtime"))
+}
+
 func kernelCmd(c *cli.Context) error {
 	runtime.GOMAXPROCS(runtime.NumCPU())
 
@@ -0,0 +1,87 @@
+package network
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+const (
+	AddressSchemeQuic = "quic"
+	AddressSchemeTCP  = "tcp"
+)
+
+// ParseAddress splits the peer address into the transport scheme and the
+// host port, an address without scheme uses QUIC.
+func ParseAddress(addr string) (string, string, error) {
+	scheme, host := splitAddress(addr)
+	switch scheme {
+	case AddressSchemeQuic:
+		a, err := net.ResolveUDPAddr("udp", host)
+		if err != nil {
+			return "", "", fmt.Errorf("invalid address %s %s", addr, err)
+		} else if a.Port < 80 || a.IP == nil {
+			return "", "", fmt.Errorf("invalid address %s %d %s", addr, a.Port, a.IP)
+		}
+	case AddressSchemeTCP:
+		a, err := net.ResolveTCPAddr("tcp", host)
+		if err != nil {
+			return "", "", fmt.Errorf("invalid address %s %s", addr, err)
+		} else if a.Port < 80 || a.IP == nil {
+			return "", "", fmt.Errorf("invalid address %s %d %s", addr, a.Port, a.IP)
+		}
+	default:
+		return "", "", fmt.Errorf("invalid address scheme %s", addr)
+	}
+	return scheme, host, nil
+}
+
+// routableAddress returns the first address with a route from this node,
+// e.g. the IPv4 address of a dual stack node for the nodes without IPv6.
+// The UDP socket is only connected to find the route, nothing is sent.
+func routableAddress(addresses []string) string {
+	for _, addr := range addresses {
+		_, host, err := ParseAddress(addr)
+		if err != nil {
+			continue
+		}
+		conn, err := net.Dial("udp", host)
+		if err != nil {
+			continue
+		}
+		conn.Close()
+		return addr
+	}
+	return addresses[0]
+}
+
+func NewClientTransport(addr string) (Transport, error) {
+	scheme, host, err := ParseAddress(addr)
+	if err != nil {
+		return nil, err
+	}
+	if scheme == AddressSchemeTCP {
+		return NewTCPClient(host)
+	}
+	return NewQuicClient(host)
+}
+
+// NewServerTransport doesn't resolve the address, because it could be
+// a bind address without host.
+func NewServerTransport(addr string) (Transport, error) {
+	scheme, host := splitAddress(addr)
+	switch scheme {
+	case AddressSchemeQuic:
+		return NewQuicServer(host)
+	case AddressSchemeTCP:
+		return NewTCPServer(host)
+	}
+	return nil, fmt.Errorf("invalid address scheme %s", addr)
+}
+
+func splitAddress(addr string) (string, string) {
+	if i := strings.Index(addr, "://"); i >= 0 {
+		return addr[:i], addr[i+3:]
+	}
+	return AddressSchemeQuic, addr
+}
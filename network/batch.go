@@ -0,0 +1,149 @@
+package network
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/MixinNetwork/mixin/common"
+	"github.com/MixinNetwork/mixin/crypto"
+	"github.com/MixinNetwork/mixin/logger"
+)
+
+// The finalized snapshots of the sync are batched in a single message and
+// compression frame, with the transactions the neighbor may lack, i.e. of
+// the rounds after its final round. The snapshots are bounded by count and
+// the transactions by bytes, so a batch never reaches the message limit.
+const (
+	syncBatchSnapshots = 200
+	syncBatchBytes     = 1024 * 1024
+)
+
+type SnapshotBatch struct {
+	Snapshots    []*common.Snapshot
+	Transactions [][]byte
+}
+
+type syncBatch struct {
+	me    *Peer
+	peer  *Peer
+	batch *SnapshotBatch
+	keys  [][]byte
+	txs   map[crypto.Hash]bool
+	size  int
+	limit int
+}
+
+func (me *Peer) newSyncBatch(p *Peer) *syncBatch {
+	limit := int(p.Capabilities().MaxMessageSize) / 2
+	if limit > syncBatchBytes {
+		limit = syncBatchBytes
+	}
+	b := &syncBatch{me: me, peer: p, limit: limit}
+	b.reset()
+	return b
+}
+
+func (b *syncBatch) reset() {
+	b.batch = &SnapshotBatch{}
+	b.keys = nil
+	b.txs = make(map[crypto.Hash]bool)
+	b.size = 0
+}
+
+// add queues the snapshot to the batch, with its transaction if withTx and
+// not sent to the neighbor recently. The neighbor without the batch support
+// gets the snapshot in a single finalization message.
+func (b *syncBatch) add(s *common.Snapshot, withTx bool) error {
+	me, p := b.me, b.peer
+	if !p.Capabilities().Supports(PeerMessageTypeSnapshotFinalizationBatch) {
+		return me.sendSyncSnapshotToPeer(p, s)
+	}
+	key := me.syncSnapshotKey(p, s)
+	if key == nil {
+		return nil
+	}
+
+	if withTx && !b.txs[s.Transaction] && !me.transactionSentToPeer(p, s.Transaction) {
+		ver, err := me.handle.ReadTransaction(s.Transaction)
+		if err != nil {
+			return err
+		}
+		if ver != nil {
+			tx := ver.Marshal()
+			b.batch.Transactions = append(b.batch.Transactions, tx)
+			b.size += len(tx)
+			b.txs[s.Transaction] = true
+		}
+	}
+	b.batch.Snapshots = append(b.batch.Snapshots, s)
+	b.keys = append(b.keys, key)
+
+	if len(b.batch.Snapshots) >= syncBatchSnapshots || b.size >= b.limit {
+		return b.flush()
+	}
+	return nil
+}
+
+// flush queues the batch to the low ring of the neighbor with the sync rate
+// limits, then marks all the snapshots in it as sent.
+func (b *syncBatch) flush() error {
+	me, p := b.me, b.peer
+	if len(b.batch.Snapshots) == 0 {
+		return nil
+	}
+	defer b.reset()
+
+	data := buildSnapshotFinalizationBatchMessage(b.batch)
+	if !me.waitSyncLimit(p, len(data)) {
+		return fmt.Errorf("peer send low closing")
+	}
+	hash := crypto.NewHash(data)
+	key := append(p.IdForNetwork[:], hash[:]...)
+	key = append(key, 'S', 'N', 'A', 'P', PeerMessageTypeSnapshotFinalizationBatch)
+	success, _ := p.lowRing.Offer(&ChanMsg{key, data})
+	if !success {
+		return fmt.Errorf("peer send low timeout")
+	}
+	now := time.Now()
+	for _, k := range b.keys {
+		me.snapshotsCaches.store(k, now)
+	}
+	return nil
+}
+
+func (me *Peer) transactionSentToPeer(p *Peer, tx crypto.Hash) bool {
+	key := append(p.IdForNetwork[:], tx[:]...)
+	key = append(key, 'T', 'X', PeerMessageTypeTransaction)
+	return me.snapshotsCaches.contains(key, time.Minute)
+}
+
+func (me *Peer) handleSnapshotFinalizationBatch(peer *Peer, b *SnapshotBatch) {
+	txs := make(map[crypto.Hash]*common.VersionedTransaction)
+	for _, tx := range b.Transactions {
+		ver, err := common.UnmarshalVersionedTransaction(tx)
+		if err != nil {
+			me.PenalizeNeighbor(peer.IdForNetwork, PeerPenaltyMalformedMessage, "invalid batch transaction")
+			return
+		}
+		txs[ver.PayloadHash()] = ver
+	}
+	for _, s := range b.Snapshots {
+		if ver := txs[s.Transaction]; ver != nil {
+			err := me.handle.CachePutTransaction(peer.IdForNetwork, ver)
+			if err != nil {
+				logger.Printf("network.batch handleSnapshotFinalizationBatch CachePutTransaction %s error %s\n", s.Transaction, err)
+				return
+			}
+			delete(txs, s.Transaction)
+		}
+		me.handle.VerifyAndQueueAppendSnapshotFinalization(peer.IdForNetwork, s)
+	}
+	if len(txs) > 0 {
+		me.PenalizeNeighbor(peer.IdForNetwork, PeerPenaltyMalformedMessage, "unreferenced batch transaction")
+	}
+}
+
+func buildSnapshotFinalizationBatchMessage(b *SnapshotBatch) []byte {
+	data := common.MsgpackMarshalPanic(b)
+	return append([]byte{PeerMessageTypeSnapshotFinalizationBatch}, data...)
+}
@@ -0,0 +1,117 @@
+package network
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/MixinNetwork/mixin/common"
+	"github.com/MixinNetwork/mixin/crypto"
+	"github.com/VictoriaMetrics/fastcache"
+	"github.com/stretchr/testify/assert"
+)
+
+type testSyncHandle struct {
+	SyncHandle
+	cache        *fastcache.Cache
+	transactions map[crypto.Hash]*common.VersionedTransaction
+	cached       []crypto.Hash
+	finalized    []crypto.Hash
+	graph        []*SyncPoint
+}
+
+func (h *testSyncHandle) BuildGraph() []*SyncPoint {
+	return h.graph
+}
+
+func (h *testSyncHandle) GetCacheStore() *fastcache.Cache {
+	return h.cache
+}
+
+func (h *testSyncHandle) ReadPeerBans() (map[crypto.Hash]time.Time, error) {
+	return nil, nil
+}
+
+func (h *testSyncHandle) ReadPeerRecords() (map[crypto.Hash][]byte, error) {
+	return nil, nil
+}
+
+func (h *testSyncHandle) ReadTransaction(hash crypto.Hash) (*common.VersionedTransaction, error) {
+	return h.transactions[hash], nil
+}
+
+func (h *testSyncHandle) CachePutTransaction(peerId crypto.Hash, ver *common.VersionedTransaction) error {
+	h.cached = append(h.cached, ver.PayloadHash())
+	return nil
+}
+
+func (h *testSyncHandle) VerifyAndQueueAppendSnapshotFinalization(peerId crypto.Hash, s *common.Snapshot) error {
+	h.finalized = append(h.finalized, s.Hash)
+	return nil
+}
+
+func TestSnapshotFinalizationBatch(t *testing.T) {
+	assert := assert.New(t)
+
+	handle := &testSyncHandle{
+		cache:        fastcache.New(16 * 1024 * 1024),
+		transactions: make(map[crypto.Hash]*common.VersionedTransaction),
+	}
+	me := NewPeer(context.Background(), handle, crypto.NewHash([]byte("local")), "127.0.0.1:7011", false)
+	p := NewPeer(context.Background(), nil, crypto.NewHash([]byte("remote")), "127.0.0.1:7012", false)
+	me.negotiateCapabilities(p, LocalCapabilities())
+
+	var txs []crypto.Hash
+	for i := 0; i < 2; i++ {
+		ver := common.NewTransaction(crypto.NewHash([]byte{byte(i)})).AsLatestVersion()
+		handle.transactions[ver.PayloadHash()] = ver
+		txs = append(txs, ver.PayloadHash())
+	}
+	var snapshots []*common.Snapshot
+	for i := 0; i < 4; i++ {
+		s := &common.Snapshot{Version: common.SnapshotVersion, RoundNumber: uint64(i), Transaction: txs[i%2]}
+		s.Hash = s.PayloadHash()
+		snapshots = append(snapshots, s)
+	}
+
+	batch := me.newSyncBatch(p)
+	for i, s := range snapshots {
+		assert.Nil(batch.add(s, i < 3))
+	}
+	assert.Nil(batch.flush())
+	assert.Equal(uint64(1), p.lowRing.Len())
+	item, err := p.lowRing.Poll(false)
+	assert.Nil(err)
+	msg, err := parseNetworkMessage(item.(*ChanMsg).data)
+	assert.Nil(err)
+	assert.Equal(uint8(PeerMessageTypeSnapshotFinalizationBatch), msg.Type)
+	assert.Len(msg.Batch.Snapshots, 4)
+	assert.Len(msg.Batch.Transactions, 2)
+	assert.Equal(snapshots[3].Hash, msg.Batch.Snapshots[3].PayloadHash())
+
+	for _, s := range snapshots {
+		assert.Nil(batch.add(s, true))
+	}
+	assert.Nil(batch.flush())
+	assert.Equal(uint64(0), p.lowRing.Len())
+
+	me.handleSnapshotFinalizationBatch(p, msg.Batch)
+	assert.Equal(txs, handle.cached)
+	assert.Len(handle.finalized, 4)
+
+	legacy := NewPeer(context.Background(), nil, crypto.NewHash([]byte("legacy")), "127.0.0.1:7013", false)
+	batch = me.newSyncBatch(legacy)
+	assert.Nil(batch.add(snapshots[0], true))
+	assert.Nil(batch.flush())
+	assert.Equal(uint64(1), legacy.lowRing.Len())
+	item, _ = legacy.lowRing.Poll(false)
+	assert.Equal(uint8(PeerMessageTypeSnapshotFinalization), item.(*ChanMsg).data[0])
+
+	_, err = parseNetworkMessage(buildSnapshotFinalizationBatchMessage(&SnapshotBatch{}))
+	assert.NotNil(err)
+	_, err = parseNetworkMessage(buildSnapshotFinalizationBatchMessage(&SnapshotBatch{
+		Snapshots:    snapshots[:1],
+		Transactions: [][]byte{{1}, {2}},
+	}))
+	assert.NotNil(err)
+}
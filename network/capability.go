@@ -0,0 +1,220 @@
+package network
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/MixinNetwork/mixin/common"
+	"github.com/MixinNetwork/mixin/config"
+	"github.com/MixinNetwork/mixin/logger"
+)
+
+// The capabilities message is sent right after the authentication message
+// in the same handshake, older nodes ignore it as an unknown message type,
+// and the peers without it are assumed to have the legacy capabilities.
+// The version is increased when new fields added, and the fields unknown
+// to the other peer are ignored, so both peers use the intersection.
+const CapabilitiesVersion = 3
+
+// AuthenticationVersionBound is advertised by the nodes which send and verify
+// the authentication message bound to the TLS session. The legacy message is
+// sent to the peers without it, e.g. the peers before version 3, and to the
+// peers with unknown capabilities if the legacy authentication enabled.
+const AuthenticationVersionBound = 1
+
+type Capabilities struct {
+	Version        uint8
+	Software       string
+	MessageTypes   []uint8
+	Compressions   []uint8
+	MaxMessageSize uint32
+	Dictionaries   []uint32
+	Authentication uint8
+}
+
+// legacyMessageTypes are supported by all nodes before the capabilities,
+// the new message types must be added to the local capabilities only.
+var legacyMessageTypes = []uint8{
+	PeerMessageTypePing,
+	PeerMessageTypeAuthentication,
+	PeerMessageTypeGraph,
+	PeerMessageTypeSnapshotConfirm,
+	PeerMessageTypeTransactionRequest,
+	PeerMessageTypeTransaction,
+	PeerMessageTypeSnapshotAnnoucement,
+	PeerMessageTypeSnapshotCommitment,
+	PeerMessageTypeTransactionChallenge,
+	PeerMessageTypeSnapshotResponse,
+	PeerMessageTypeSnapshotFinalization,
+	PeerMessageTypeGossipNeighbors,
+}
+
+func LocalCapabilities() *Capabilities {
+	types := append([]uint8{}, legacyMessageTypes...)
+	types = append(types, PeerMessageTypeCapabilities)
+	types = append(types, PeerMessageTypeSnapshotRangeRequest)
+	types = append(types, PeerMessageTypeSnapshotRangeResponse)
+	types = append(types, PeerMessageTypeSnapshotFinalizationBatch)
+	types = append(types, PeerMessageTypePeerRecords)
+	return &Capabilities{
+		Version:        CapabilitiesVersion,
+		Software:       config.BuildVersion,
+		MessageTypes:   types,
+		Compressions:   []uint8{TransportCompressionZstd, TransportCompressionGzip},
+		MaxMessageSize: TransportMessageMaxSize,
+		Dictionaries:   common.ZstdDictionaryVersions(),
+		Authentication: AuthenticationVersionBound,
+	}
+}
+
+func legacyCapabilities() *Capabilities {
+	return &Capabilities{
+		MessageTypes:   legacyMessageTypes,
+		Compressions:   []uint8{TransportCompressionZstd, TransportCompressionGzip},
+		MaxMessageSize: TransportMessageMaxSize,
+		Dictionaries:   []uint32{common.ZstdDictionaryVersionZero},
+	}
+}
+
+func (c *Capabilities) validate() error {
+	if c.Version < 1 {
+		return fmt.Errorf("invalid capabilities version %d", c.Version)
+	}
+	if len(c.Compressions) == 0 {
+		return fmt.Errorf("invalid capabilities compressions %v", c.Compressions)
+	}
+	if c.MaxMessageSize == 0 {
+		return fmt.Errorf("invalid capabilities max message size %d", c.MaxMessageSize)
+	}
+	return nil
+}
+
+// Intersect keeps the local order of the message types, compressions and
+// dictionaries, so the first ones are the most preferred by this node. The
+// version and software are from the remote peer.
+func (c *Capabilities) Intersect(remote *Capabilities) *Capabilities {
+	ic := &Capabilities{
+		Version:        remote.Version,
+		Software:       remote.Software,
+		MessageTypes:   intersectBytes(c.MessageTypes, remote.MessageTypes),
+		Compressions:   intersectBytes(c.Compressions, remote.Compressions),
+		MaxMessageSize: c.MaxMessageSize,
+		Dictionaries:   intersectVersions(c.Dictionaries, remote.Dictionaries),
+	}
+	if remote.MaxMessageSize < ic.MaxMessageSize {
+		ic.MaxMessageSize = remote.MaxMessageSize
+	}
+	ic.Authentication = c.Authentication
+	if remote.Authentication < ic.Authentication {
+		ic.Authentication = remote.Authentication
+	}
+	return ic
+}
+
+func (c *Capabilities) Supports(typ uint8) bool {
+	for _, t := range c.MessageTypes {
+		if t == typ {
+			return true
+		}
+	}
+	return false
+}
+
+// Compression returns the preferred compression, or the transport default
+// if there is no common compression, which all nodes could receive.
+func (c *Capabilities) Compression() uint8 {
+	if len(c.Compressions) == 0 {
+		return TransportCompressionMethod
+	}
+	return c.Compressions[0]
+}
+
+// Dictionary returns the newest zstd dictionary both peers have, or the
+// version zero embedded in all nodes, e.g. the peers before version 2.
+func (c *Capabilities) Dictionary() uint32 {
+	if len(c.Dictionaries) == 0 {
+		return common.ZstdDictionaryVersionZero
+	}
+	return c.Dictionaries[0]
+}
+
+// intersectBytes converts the message types and compressions to versions,
+// so all the lists are intersected by the same intersectVersions.
+func intersectBytes(a, b []uint8) []uint8 {
+	va, vb := make([]uint32, len(a)), make([]uint32, len(b))
+	for i, v := range a {
+		va[i] = uint32(v)
+	}
+	for i, v := range b {
+		vb[i] = uint32(v)
+	}
+	var ib []uint8
+	for _, v := range intersectVersions(va, vb) {
+		ib = append(ib, uint8(v))
+	}
+	return ib
+}
+
+func intersectVersions(a, b []uint32) []uint32 {
+	filter := make(map[uint32]bool)
+	for _, v := range b {
+		filter[v] = true
+	}
+	var iv []uint32
+	for _, v := range a {
+		if filter[v] {
+			iv = append(iv, v)
+			delete(filter, v)
+		}
+	}
+	return iv
+}
+
+type capabilitySet struct {
+	sync.RWMutex
+	negotiated *Capabilities
+}
+
+func (s *capabilitySet) get() *Capabilities {
+	s.RLock()
+	defer s.RUnlock()
+
+	return s.negotiated
+}
+
+func (s *capabilitySet) set(c *Capabilities) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.negotiated = c
+}
+
+// Capabilities returns the capabilities negotiated with the neighbor, or
+// the legacy capabilities if the neighbor has not sent its capabilities.
+func (p *Peer) Capabilities() *Capabilities {
+	if c := p.capabilities.get(); c != nil {
+		return c
+	}
+	return legacyCapabilities()
+}
+
+func (me *Peer) negotiateCapabilities(peer *Peer, remote *Capabilities) {
+	ic := me.local.Intersect(remote)
+	logger.Verbosef("negotiateCapabilities(%s) %s %v %v %d %v %d\n", peer.IdForNetwork, ic.Software, ic.MessageTypes, ic.Compressions, ic.MaxMessageSize, ic.Dictionaries, ic.Authentication)
+	peer.capabilities.set(ic)
+}
+
+// accepts checks the message type and size against the capabilities of the
+// neighbor, the unsupported messages are dropped instead of sent.
+func (p *Peer) accepts(data []byte) bool {
+	c := p.Capabilities()
+	if !c.Supports(data[0]) {
+		logger.Verbosef("peer %s doesn't support message type %d\n", p.IdForNetwork, data[0])
+		return false
+	}
+	if uint32(len(data)) > c.MaxMessageSize {
+		logger.Verbosef("peer %s doesn't support message size %d\n", p.IdForNetwork, len(data))
+		return false
+	}
+	return true
+}
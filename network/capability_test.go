@@ -0,0 +1,230 @@
+package network
+
+import (
+	"context"
+	"testing"
+
+	"github.com/MixinNetwork/mixin/common"
+	"github.com/MixinNetwork/mixin/crypto"
+	"github.com/VictoriaMetrics/fastcache"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCapabilities(t *testing.T) {
+	assert := assert.New(t)
+
+	local := LocalCapabilities()
+	assert.Nil(local.validate())
+	assert.True(local.Supports(PeerMessageTypeCapabilities))
+	assert.Equal(uint8(TransportCompressionZstd), local.Compression())
+	assert.Equal(uint32(common.ZstdDictionaryVersionLatest), local.Dictionary())
+	assert.Equal(uint8(AuthenticationVersionBound), local.Authentication)
+
+	remote := &Capabilities{
+		Version:        CapabilitiesVersion + 1,
+		Software:       "v0.13.0",
+		MessageTypes:   []uint8{PeerMessageTypeGraph, 200, PeerMessageTypePing, PeerMessageTypeCapabilities},
+		Compressions:   []uint8{3, TransportCompressionGzip},
+		MaxMessageSize: 1024,
+		Dictionaries:   []uint32{100, common.ZstdDictionaryVersionZero},
+	}
+	msg, err := parseNetworkMessage(buildCapabilitiesMessage(remote))
+	assert.Nil(err)
+	assert.Equal(remote, msg.Capabilities)
+
+	ic := local.Intersect(msg.Capabilities)
+	assert.Equal(remote.Version, ic.Version)
+	assert.Equal("v0.13.0", ic.Software)
+	assert.Equal([]uint8{PeerMessageTypePing, PeerMessageTypeGraph, PeerMessageTypeCapabilities}, ic.MessageTypes)
+	assert.Equal([]uint8{TransportCompressionGzip}, ic.Compressions)
+	assert.Equal(uint32(1024), ic.MaxMessageSize)
+	assert.False(ic.Supports(200))
+	assert.Equal(uint8(TransportCompressionGzip), ic.Compression())
+	assert.Equal([]uint32{common.ZstdDictionaryVersionZero}, ic.Dictionaries)
+	assert.Equal(uint8(0), ic.Authentication)
+	assert.Equal(uint8(AuthenticationVersionBound), local.Intersect(local).Authentication)
+	ic = ic.Intersect(&Capabilities{Version: 1, Compressions: []uint8{3}, MaxMessageSize: 1})
+	assert.Len(ic.MessageTypes, 0)
+	assert.Len(ic.Dictionaries, 0)
+	assert.Equal(uint8(TransportCompressionMethod), ic.Compression())
+	assert.Equal(uint32(common.ZstdDictionaryVersionZero), ic.Dictionary())
+
+	_, err = parseNetworkMessage(buildCapabilitiesMessage(&Capabilities{Compressions: []uint8{1}, MaxMessageSize: 1}))
+	assert.NotNil(err)
+	_, err = parseNetworkMessage(buildCapabilitiesMessage(&Capabilities{Version: 1, MaxMessageSize: 1}))
+	assert.NotNil(err)
+	_, err = parseNetworkMessage(append([]byte{PeerMessageTypeCapabilities}, 0xc0))
+	assert.NotNil(err)
+
+	me := NewPeer(context.Background(), nil, crypto.NewHash([]byte("local")), "127.0.0.1:7006", false)
+	peer := NewPeer(context.Background(), nil, crypto.NewHash([]byte("remote")), "127.0.0.1:7007", false)
+	assert.Nil(peer.Info().Capabilities)
+	assert.True(peer.accepts(buildGraphMessage(nil)))
+	assert.False(peer.accepts(buildCapabilitiesMessage(local)))
+
+	me.negotiateCapabilities(peer, remote)
+	assert.Equal("v0.13.0", peer.Info().Capabilities.Software)
+	assert.True(peer.accepts(buildGraphMessage(nil)))
+	assert.False(peer.accepts(buildTransactionRequestMessage(crypto.Hash{})))
+	assert.False(peer.accepts(append(buildGraphMessage(nil), make([]byte, 1024)...)))
+}
+
+func TestTCPCompression(t *testing.T) {
+	assert := assert.New(t)
+
+	addr := "tcp://127.0.0.1:7008"
+	st, err := NewServerTransport(addr)
+	assert.Nil(err)
+	assert.Nil(st.Listen())
+	defer st.Close()
+	received := make(chan []byte, 2)
+	go func() {
+		c, err := st.Accept(context.Background())
+		assert.Nil(err)
+		defer c.Close()
+		for i := 0; i < 2; i++ {
+			msg, err := c.Receive()
+			assert.Nil(err)
+			received <- msg
+		}
+	}()
+
+	ct, err := NewClientTransport(addr)
+	assert.Nil(err)
+	c, err := ct.Dial(context.Background())
+	assert.Nil(err)
+	defer c.Close()
+	for _, method := range []uint8{TransportCompressionGzip, TransportCompressionZstd} {
+		setClientCompression(c, method, common.ZstdDictionaryVersionZero)
+		assert.Nil(c.Send([]byte("hello compression")))
+		assert.Equal("hello compression", string(<-received))
+	}
+	setClientCompression(c, 3, common.ZstdDictionaryVersionZero)
+	assert.NotNil(c.Send([]byte("hello compression")))
+}
+
+func TestDictionaryNegotiation(t *testing.T) {
+	assert := assert.New(t)
+
+	addr := "tcp://127.0.0.1:7009"
+	st, err := NewServerTransport(addr)
+	assert.Nil(err)
+	assert.Nil(st.Listen())
+	defer st.Close()
+	received := make(chan []byte, 3)
+	go func() {
+		for i := 0; i < 3; i++ {
+			c, err := st.Accept(context.Background())
+			assert.Nil(err)
+			msg, err := c.Receive()
+			assert.Nil(err)
+			received <- msg
+			c.Close()
+		}
+	}()
+
+	older := LocalCapabilities()
+	older.Dictionaries = []uint32{common.ZstdDictionaryVersionZero}
+	me := NewPeer(context.Background(), nil, crypto.NewHash([]byte("local")), "127.0.0.1:7010", false)
+	peers := []*Peer{
+		NewPeer(context.Background(), nil, crypto.NewHash([]byte("newer")), "127.0.0.1:7011", false),
+		NewPeer(context.Background(), nil, crypto.NewHash([]byte("older")), "127.0.0.1:7012", false),
+		NewPeer(context.Background(), nil, crypto.NewHash([]byte("legacy")), "127.0.0.1:7013", false),
+	}
+	me.negotiateCapabilities(peers[0], LocalCapabilities())
+	me.negotiateCapabilities(peers[1], older)
+	expects := []uint32{
+		common.ZstdDictionaryVersionOne,
+		common.ZstdDictionaryVersionZero,
+		common.ZstdDictionaryVersionZero,
+	}
+
+	for i, p := range peers {
+		caps := p.Capabilities()
+		assert.Equal(expects[i], caps.Dictionary())
+		ct, err := NewClientTransport(addr)
+		assert.Nil(err)
+		c, err := ct.Dial(context.Background())
+		assert.Nil(err)
+		assert.Equal(uint32(common.ZstdDictionaryVersionZero), c.(*TCPClient).dictionary)
+		setClientCompression(c, caps.Compression(), caps.Dictionary())
+		assert.Equal(uint8(TransportCompressionZstd), c.(*TCPClient).compression)
+		assert.Equal(expects[i], c.(*TCPClient).dictionary)
+		assert.Nil(c.Send([]byte("hello dictionary")))
+		assert.Equal("hello dictionary", string(<-received))
+		c.Close()
+	}
+}
+
+type authenticationSyncHandle struct {
+	*recordSyncHandle
+}
+
+func (h *authenticationSyncHandle) BuildAuthenticationMessage(binding []byte) []byte {
+	if binding == nil {
+		return []byte{0}
+	}
+	return append([]byte{AuthenticationVersionBound}, binding...)
+}
+
+func TestAuthenticationNegotiation(t *testing.T) {
+	assert := assert.New(t)
+
+	a, b := "127.0.0.1:7014", "127.0.0.1:7015"
+	mn := NewMemoryNetwork(7)
+	server, _ := mn.Endpoint(b).NewServer(":7239")
+	assert.Nil(server.Listen())
+	defer server.Close()
+
+	older := LocalCapabilities()
+	older.Version = 2
+	older.Authentication = 0
+	me := NewPeer(context.Background(), &authenticationSyncHandle{&recordSyncHandle{
+		cache:   fastcache.New(16 * 1024 * 1024),
+		records: make(map[crypto.Hash][]byte),
+	}}, crypto.NewHash([]byte("local")), a, false)
+	peers := []*Peer{
+		NewPeer(context.Background(), nil, crypto.NewHash([]byte("newer")), b, false),
+		NewPeer(context.Background(), nil, crypto.NewHash([]byte("older")), b, false),
+		NewPeer(context.Background(), nil, crypto.NewHash([]byte("unknown")), b, false),
+	}
+	me.negotiateCapabilities(peers[0], LocalCapabilities())
+	me.negotiateCapabilities(peers[1], older)
+	expects := []uint8{AuthenticationVersionBound, 0, AuthenticationVersionBound}
+	testAuthenticationNegotiation(assert, mn, server, me, peers, expects)
+
+	// the nodes not upgraded never send the capabilities, so the legacy
+	// message is sent to the unknown peers with the legacy authentication
+	me.SetLegacyAuthentication(true)
+	expects = []uint8{AuthenticationVersionBound, 0, 0}
+	testAuthenticationNegotiation(assert, mn, server, me, peers, expects)
+}
+
+func testAuthenticationNegotiation(assert *assert.Assertions, mn *MemoryNetwork, server Transport, me *Peer, peers []*Peer, expects []uint8) {
+	a, b := me.Address, peers[0].Address
+	for i, p := range peers {
+		caps := p.capabilities.get()
+		trans, err := mn.Endpoint(a).NewClient(b)
+		assert.Nil(err)
+		client, err := trans.Dial(context.Background())
+		assert.Nil(err)
+		remote, err := server.Accept(context.Background())
+		assert.Nil(err)
+		assert.Nil(me.sendAuthentication(client, caps))
+		data, err := remote.Receive()
+		assert.Nil(err)
+		msg, err := parseNetworkMessage(data)
+		assert.Nil(err)
+		assert.Equal(uint8(PeerMessageTypeAuthentication), msg.Type)
+		assert.Equal(expects[i], msg.Auth[0])
+		if expects[i] == AuthenticationVersionBound {
+			binding, err := remote.Binding()
+			assert.Nil(err)
+			assert.Equal(binding, msg.Auth[1:])
+		} else {
+			assert.Len(msg.Auth, 1)
+		}
+		client.Close()
+		remote.Close()
+	}
+}
@@ -0,0 +1,177 @@
+package network
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"net/url"
+	"time"
+
+	"github.com/MixinNetwork/mixin/common"
+	"github.com/MixinNetwork/mixin/crypto"
+)
+
+// The signer certificate is self-signed by an ephemeral ed25519 key, and
+// the ed25519 public key is signed by the node signer key for the network.
+// The signer public key and the signature are in the certificate URI
+// mixin:SIGNER?signature=SIGNATURE, so both sides of the TLS handshake
+// prove their node identity before any peer message.
+const signerCertificateScheme = "mixin"
+
+type signerTransportFactory struct {
+	networkId   crypto.Hash
+	certificate tls.Certificate
+	verify      func(peerId crypto.Hash) error
+}
+
+// NewSignerTransportFactory creates the QUIC and TCP transports with the
+// signer certificate. The verify function is called in the handshake with
+// the peer id, or an empty hash if the peer certificate is not signed.
+func NewSignerTransportFactory(signer crypto.Key, networkId crypto.Hash, verify func(peerId crypto.Hash) error) (TransportFactory, error) {
+	cert, err := generateSignerCertificate(signer, networkId)
+	if err != nil {
+		return nil, err
+	}
+	return &signerTransportFactory{
+		networkId:   networkId,
+		certificate: cert,
+		verify:      verify,
+	}, nil
+}
+
+func (f *signerTransportFactory) NewServer(addr string) (Transport, error) {
+	scheme, host := splitAddress(addr)
+	conf := f.tlsConfig(scheme)
+	conf.ClientAuth = tls.RequestClientCert
+	switch scheme {
+	case AddressSchemeQuic:
+		return &QuicTransport{addr: host, tls: conf, networkId: f.networkId}, nil
+	case AddressSchemeTCP:
+		return &TCPTransport{addr: host, tls: conf, networkId: f.networkId}, nil
+	}
+	return nil, fmt.Errorf("invalid address scheme %s", addr)
+}
+
+func (f *signerTransportFactory) NewClient(addr string) (Transport, error) {
+	scheme, host, err := ParseAddress(addr)
+	if err != nil {
+		return nil, err
+	}
+	conf := f.tlsConfig(scheme)
+	conf.InsecureSkipVerify = true
+	if scheme == AddressSchemeTCP {
+		return &TCPTransport{addr: host, tls: conf, networkId: f.networkId}, nil
+	}
+	return &QuicTransport{addr: host, tls: conf, networkId: f.networkId}, nil
+}
+
+func (f *signerTransportFactory) tlsConfig(scheme string) *tls.Config {
+	conf := &tls.Config{
+		Certificates:          []tls.Certificate{f.certificate},
+		NextProtos:            []string{"mixin-quic-peer"},
+		VerifyPeerCertificate: f.verifyPeerCertificate,
+	}
+	if scheme == AddressSchemeTCP {
+		conf.NextProtos = []string{"mixin-tcp-peer"}
+		conf.MinVersion = tls.VersionTLS13
+	}
+	return conf
+}
+
+func (f *signerTransportFactory) verifyPeerCertificate(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+	peerId, err := verifySignerCertificate(rawCerts, f.networkId)
+	if err != nil {
+		return err
+	}
+	return f.verify(peerId)
+}
+
+func generateSignerCertificate(signer crypto.Key, networkId crypto.Hash) (tls.Certificate, error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	msg := append(networkId[:], pub...)
+	sig := signer.Sign(msg)
+	uri := &url.URL{
+		Scheme:   signerCertificateScheme,
+		Opaque:   signer.Public().String(),
+		RawQuery: "signature=" + hex.EncodeToString(sig[:]),
+	}
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour * 24 * 365 * 10),
+		URIs:         []*url.URL{uri},
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, &template, &template, pub, priv)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{
+		Certificate: [][]byte{certDER},
+		PrivateKey:  priv,
+	}, nil
+}
+
+// peerCertificateSigner returns the peer id of the signer certificate in the
+// TLS connection state, or an empty hash if the certificate has no signer.
+func peerCertificateSigner(cs tls.ConnectionState, networkId crypto.Hash) (crypto.Hash, error) {
+	rawCerts := make([][]byte, len(cs.PeerCertificates))
+	for i, cert := range cs.PeerCertificates {
+		rawCerts[i] = cert.Raw
+	}
+	return verifySignerCertificate(rawCerts, networkId)
+}
+
+// verifySignerCertificate returns the peer id of the signer certificate,
+// or an empty hash if the certificate has no signer. The certificate self
+// signature is not checked, because the handshake proves the ed25519 key.
+func verifySignerCertificate(rawCerts [][]byte, networkId crypto.Hash) (crypto.Hash, error) {
+	if len(rawCerts) == 0 {
+		return crypto.Hash{}, nil
+	}
+	cert, err := x509.ParseCertificate(rawCerts[0])
+	if err != nil {
+		return crypto.Hash{}, err
+	}
+	var uri *url.URL
+	for _, u := range cert.URIs {
+		if u.Scheme == signerCertificateScheme {
+			uri = u
+		}
+	}
+	if uri == nil {
+		return crypto.Hash{}, nil
+	}
+
+	pub, ok := cert.PublicKey.(ed25519.PublicKey)
+	if !ok {
+		return crypto.Hash{}, fmt.Errorf("invalid signer certificate key %T", cert.PublicKey)
+	}
+	key, err := crypto.KeyFromString(uri.Opaque)
+	if err != nil || !key.CheckKey() {
+		return crypto.Hash{}, fmt.Errorf("invalid signer certificate public key %s", uri.Opaque)
+	}
+	b, err := hex.DecodeString(uri.Query().Get("signature"))
+	if err != nil {
+		return crypto.Hash{}, err
+	}
+	var sig crypto.Signature
+	if len(b) != len(sig) {
+		return crypto.Hash{}, fmt.Errorf("invalid signer certificate signature %d", len(b))
+	}
+	copy(sig[:], b)
+	if !key.Verify(append(networkId[:], pub...), sig) {
+		return crypto.Hash{}, fmt.Errorf("invalid signer certificate signature %s", key)
+	}
+
+	var signer common.Address
+	signer.PublicSpendKey = key
+	signer.PublicViewKey = key.DeterministicHashDerive().Public()
+	return signer.Hash().ForNetwork(networkId), nil
+}
@@ -0,0 +1,166 @@
+package network
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"testing"
+
+	"github.com/MixinNetwork/mixin/common"
+	"github.com/MixinNetwork/mixin/crypto"
+	"github.com/VictoriaMetrics/fastcache"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSignerCertificate(t *testing.T) {
+	assert := assert.New(t)
+
+	networkId := crypto.NewHash([]byte("mixin-certificate-test"))
+	signer, peerId := testSignerKey(networkId)
+	cert, err := generateSignerCertificate(signer, networkId)
+	assert.Nil(err)
+	id, err := verifySignerCertificate(cert.Certificate, networkId)
+	assert.Nil(err)
+	assert.Equal(peerId, id)
+	_, err = verifySignerCertificate(cert.Certificate, crypto.NewHash([]byte("other")))
+	assert.NotNil(err)
+
+	legacy := generateTLSConfig()
+	id, err = verifySignerCertificate(legacy.Certificates[0].Certificate, networkId)
+	assert.Nil(err)
+	assert.False(id.HasValue())
+	id, err = verifySignerCertificate(nil, networkId)
+	assert.Nil(err)
+	assert.False(id.HasValue())
+
+	for i, scheme := range []string{AddressSchemeQuic, AddressSchemeTCP} {
+		addr := fmt.Sprintf("%s://127.0.0.1:%d", scheme, 7004+i)
+		client, clientId := testSignerKey(networkId)
+		server, serverId := testSignerKey(networkId)
+
+		verified := make(chan crypto.Hash, 2)
+		sf, err := NewSignerTransportFactory(server, networkId, func(id crypto.Hash) error {
+			verified <- id
+			if id != clientId {
+				return fmt.Errorf("unknown peer %s", id)
+			}
+			return nil
+		})
+		assert.Nil(err)
+		st, err := sf.NewServer(addr)
+		assert.Nil(err)
+		assert.Nil(st.Listen())
+		received := make(chan error, 1)
+		go func() {
+			c, err := st.Accept(context.Background())
+			if err == nil {
+				_, err = c.Receive()
+			}
+			received <- err
+		}()
+
+		cf, err := NewSignerTransportFactory(client, networkId, func(id crypto.Hash) error {
+			if id != serverId {
+				return fmt.Errorf("unknown peer %s", id)
+			}
+			return nil
+		})
+		assert.Nil(err)
+		ct, err := cf.NewClient(addr)
+		assert.Nil(err)
+		c, err := ct.Dial(context.Background())
+		assert.Nil(err)
+		assert.Nil(c.Send([]byte("hello signer")))
+		assert.Nil(<-received)
+		assert.Equal(clientId, <-verified)
+		c.Close()
+
+		legacy, err := NewClientTransport(addr)
+		assert.Nil(err)
+		go func() {
+			c, err := st.Accept(context.Background())
+			if err == nil {
+				c.Receive()
+			}
+		}()
+		c, err = legacy.Dial(context.Background())
+		if err == nil {
+			c.Send([]byte("hello legacy"))
+			defer c.Close()
+		}
+		assert.False((<-verified).HasValue())
+		st.Close()
+	}
+}
+
+type certificateSyncHandle struct {
+	*recordSyncHandle
+}
+
+// Authenticate trusts the peer id in the message, so the peer only rejects
+// the message by the certificate of the connection.
+func (h *certificateSyncHandle) Authenticate(msg, binding []byte, certified crypto.Hash) (crypto.Hash, string, error) {
+	var id crypto.Hash
+	copy(id[:], msg)
+	return id, "127.0.0.1:7019", nil
+}
+
+func TestAuthenticationCertificate(t *testing.T) {
+	assert := assert.New(t)
+
+	networkId := crypto.NewHash([]byte("mixin-certificate-test"))
+	signer, signerId := testSignerKey(networkId)
+	server, _ := testSignerKey(networkId)
+	_, otherId := testSignerKey(networkId)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	me := NewPeer(ctx, &certificateSyncHandle{&recordSyncHandle{
+		cache:   fastcache.New(16 * 1024 * 1024),
+		records: make(map[crypto.Hash][]byte),
+	}}, crypto.NewHash([]byte("local")), "127.0.0.1:7018", false)
+	sf, err := NewSignerTransportFactory(server, networkId, func(crypto.Hash) error { return nil })
+	assert.Nil(err)
+	st, err := sf.NewServer("tcp://127.0.0.1:7017")
+	assert.Nil(err)
+	assert.Nil(st.Listen())
+	defer st.Close()
+	cf, err := NewSignerTransportFactory(signer, networkId, func(crypto.Hash) error { return nil })
+	assert.Nil(err)
+
+	for _, id := range []crypto.Hash{otherId, signerId} {
+		ct, err := cf.NewClient("tcp://127.0.0.1:7017")
+		assert.Nil(err)
+		dialed := make(chan Client, 1)
+		go func(id crypto.Hash) {
+			c, err := ct.Dial(context.Background())
+			assert.Nil(err)
+			assert.Nil(c.Send(buildAuthenticationMessage(id[:])))
+			dialed <- c
+		}(id)
+		remote, err := st.Accept(context.Background())
+		assert.Nil(err)
+		peer, err := me.authenticateNeighbor(ctx, remote)
+		c := <-dialed
+		certified, _ := remote.SignerId()
+		assert.Equal(signerId, certified)
+		if id == otherId {
+			assert.NotNil(err)
+			assert.Contains(err.Error(), "certificate mismatch")
+			assert.Nil(peer)
+		} else {
+			assert.Nil(err)
+			assert.Equal(signerId, peer.IdForNetwork)
+		}
+		c.Close()
+		remote.Close()
+	}
+}
+
+func testSignerKey(networkId crypto.Hash) (crypto.Key, crypto.Hash) {
+	seed := make([]byte, 64)
+	rand.Read(seed)
+	addr := common.NewAddressFromSeed(seed)
+	addr.PublicViewKey = addr.PublicSpendKey.DeterministicHashDerive().Public()
+	return addr.PrivateSpendKey, addr.Hash().ForNetwork(networkId)
+}
@@ -0,0 +1,145 @@
+package network
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/MixinNetwork/mixin/crypto"
+)
+
+// The ping message carries the wall clock timestamps of both peers, so each
+// node could estimate the clock offsets of its neighbors like NTP. A ping
+// echoes the sent timestamp of the last ping received from the neighbor, and
+// the local time it was received, then the neighbor has the four timestamps
+// of a round trip when the ping arrives. The pings of older nodes are empty.
+const (
+	clockPingPeriod     = 10 * time.Second
+	clockSampleCount    = 8
+	clockSampleLifetime = 10 * time.Minute
+)
+
+type ClockPing struct {
+	Sent     uint64
+	Origin   uint64
+	Received uint64
+}
+
+type clockSample struct {
+	offset time.Duration
+	delay  time.Duration
+	at     time.Time
+}
+
+type peerClock struct {
+	sync.Mutex
+	origin   uint64
+	received uint64
+	samples  []*clockSample
+}
+
+func (c *peerClock) ping(now time.Time) *ClockPing {
+	c.Lock()
+	defer c.Unlock()
+
+	p := &ClockPing{
+		Sent:     uint64(now.UnixNano()),
+		Origin:   c.origin,
+		Received: c.received,
+	}
+	c.origin, c.received = 0, 0
+	return p
+}
+
+// pinged keeps the ping to echo, and adds a sample if the ping echoes a ping
+// sent by this node. The offset is the neighbor clock minus the local clock,
+// and the time the neighbor held the echo is excluded from the delay.
+func (c *peerClock) pinged(p *ClockPing, now time.Time) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.origin, c.received = p.Sent, uint64(now.UnixNano())
+	if p.Origin == 0 || p.Received == 0 {
+		return
+	}
+	t1, t2, t3, t4 := int64(p.Origin), int64(p.Received), int64(p.Sent), now.UnixNano()
+	delay := (t4 - t1) - (t3 - t2)
+	if delay < 0 || t4 < t1 {
+		return
+	}
+	c.samples = append(c.samples, &clockSample{
+		offset: time.Duration((t2 - t1 + t3 - t4) / 2),
+		delay:  time.Duration(delay),
+		at:     now,
+	})
+	if len(c.samples) > clockSampleCount {
+		c.samples = c.samples[len(c.samples)-clockSampleCount:]
+	}
+}
+
+// estimate returns the recent sample with the least delay, which has the
+// least error introduced by the asymmetric network paths.
+func (c *peerClock) estimate(now time.Time) *clockSample {
+	c.Lock()
+	defer c.Unlock()
+
+	var best *clockSample
+	for _, s := range c.samples {
+		if s.at.Add(clockSampleLifetime).Before(now) {
+			continue
+		}
+		if best == nil || s.delay < best.delay {
+			best = s
+		}
+	}
+	return best
+}
+
+// ClockOffsets returns the estimated clock offsets of the neighbors, which
+// are positive if the neighbor clock is ahead of the local one.
+func (me *Peer) ClockOffsets() map[crypto.Hash]time.Duration {
+	now := time.Now()
+	offsets := make(map[crypto.Hash]time.Duration)
+	for _, p := range me.neighbors.Slice() {
+		if s := p.clock.estimate(now); s != nil {
+			offsets[p.IdForNetwork] = s.offset
+		}
+	}
+	return offsets
+}
+
+// MedianClockOffset returns the median of the offsets, and the local clock
+// is counted as a zero offset, so a single skewed neighbor has no effect.
+func MedianClockOffset(offsets []time.Duration) time.Duration {
+	all := append([]time.Duration{0}, offsets...)
+	sort.Slice(all, func(i, j int) bool { return all[i] < all[j] })
+	if len(all)%2 == 1 {
+		return all[len(all)/2]
+	}
+	return (all[len(all)/2-1] + all[len(all)/2]) / 2
+}
+
+func buildPingMessage(p *ClockPing) []byte {
+	data := make([]byte, 25)
+	data[0] = PeerMessageTypePing
+	binary.BigEndian.PutUint64(data[1:], p.Sent)
+	binary.BigEndian.PutUint64(data[9:], p.Origin)
+	binary.BigEndian.PutUint64(data[17:], p.Received)
+	return data
+}
+
+func parsePingMessage(data []byte) (*ClockPing, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+	if len(data) != 24 {
+		return nil, fmt.Errorf("invalid ping message size %d", len(data))
+	}
+	return &ClockPing{
+		Sent:     binary.BigEndian.Uint64(data[:8]),
+		Origin:   binary.BigEndian.Uint64(data[8:16]),
+		Received: binary.BigEndian.Uint64(data[16:]),
+	}, nil
+}
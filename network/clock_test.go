@@ -0,0 +1,54 @@
+package network
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPeerClock(t *testing.T) {
+	assert := assert.New(t)
+
+	local, remote := &peerClock{}, &peerClock{}
+	now, skew, delay := time.Now(), 5*time.Second, 20*time.Millisecond
+	assert.Nil(local.estimate(now))
+
+	remote.pinged(local.ping(now), now.Add(skew+delay))
+	assert.Nil(remote.estimate(now.Add(skew + delay)))
+	now = now.Add(time.Second)
+	p := remote.ping(now.Add(skew))
+	assert.Equal(uint64(now.Add(skew-time.Second+delay).UnixNano()), p.Received)
+	local.pinged(p, now.Add(delay))
+	s := local.estimate(now)
+	assert.NotNil(s)
+	assert.Equal(skew, s.offset)
+	assert.Equal(delay*2, s.delay)
+
+	p = remote.ping(now.Add(skew))
+	assert.Equal(uint64(0), p.Origin)
+	local.pinged(p, now.Add(delay))
+	assert.Equal(skew, local.estimate(now).offset)
+
+	remote.pinged(local.ping(now), now.Add(skew+delay*10))
+	local.pinged(remote.ping(now.Add(skew+delay*10)), now.Add(delay*11))
+	s = local.estimate(now)
+	assert.Equal(skew, s.offset)
+	assert.Equal(delay*2, s.delay)
+	assert.Len(local.samples, 2)
+	assert.Nil(local.estimate(now.Add(clockSampleLifetime * 2)))
+
+	msg, err := parseNetworkMessage(buildPingMessage(p))
+	assert.Nil(err)
+	assert.Equal(p, msg.Ping)
+	msg, err = parseNetworkMessage([]byte{PeerMessageTypePing})
+	assert.Nil(err)
+	assert.Nil(msg.Ping)
+	_, err = parseNetworkMessage([]byte{PeerMessageTypePing, 1})
+	assert.NotNil(err)
+
+	assert.Equal(time.Duration(0), MedianClockOffset(nil))
+	assert.Equal(time.Second, MedianClockOffset([]time.Duration{time.Second, time.Second}))
+	assert.Equal(time.Duration(0), MedianClockOffset([]time.Duration{time.Hour, -time.Second}))
+	assert.Equal(time.Second/2, MedianClockOffset([]time.Duration{time.Hour, time.Second, -time.Second}))
+}
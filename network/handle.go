@@ -1,6 +1,7 @@
 package network
 
 import (
+	"context"
 	"encoding/binary"
 	"errors"
 	"fmt"
@@ -19,6 +20,7 @@ const (
 	PeerMessageTypeSnapshotConfirm    = 5
 	PeerMessageTypeTransactionRequest = 6
 	PeerMessageTypeTransaction        = 7
+	PeerMessageTypeCapabilities       = 8
 
 	PeerMessageTypeSnapshotAnnoucement  = 10 // leader send snapshot to peer
 	PeerMessageTypeSnapshotCommitment   = 11 // peer generate ri based, send Ri to leader
@@ -26,6 +28,13 @@ const (
 	PeerMessageTypeSnapshotResponse     = 13 // peer generate A from nodes and Z, send response si = ri + H(R || A || M)ai to leader
 	PeerMessageTypeSnapshotFinalization = 14 // leader generate A, verify si B = ri B + H(R || A || M)ai B = Ri + H(R || A || M)Ai, then finalize based on threshold
 
+	PeerMessageTypeSnapshotRangeRequest  = 15 // lagging node request the finalized rounds of a chain
+	PeerMessageTypeSnapshotRangeResponse = 16 // peer send the finalized rounds with transactions
+
+	PeerMessageTypeSnapshotFinalizationBatch = 17 // peer send the finalized snapshots in sync with transactions
+
+	PeerMessageTypePeerRecords = 18 // peer gossip the signed addresses of nodes
+
 	PeerMessageTypeGossipNeighbors = 101
 )
 
@@ -42,12 +51,17 @@ type PeerMessage struct {
 	Graph           []*SyncPoint
 	Auth            []byte
 	Neighbors       []string
+	Capabilities    *Capabilities
+	Range           *SnapshotRange
+	Batch           *SnapshotBatch
+	Records         []*PeerRecord
+	Ping            *ClockPing
 }
 
 type SyncHandle interface {
 	GetCacheStore() *fastcache.Cache
-	BuildAuthenticationMessage() []byte
-	Authenticate(msg []byte) (crypto.Hash, string, error)
+	BuildAuthenticationMessage(binding []byte) []byte
+	Authenticate(msg, binding []byte, certified crypto.Hash) (crypto.Hash, string, error)
 	UpdateNeighbors(neighbors []string) error
 	BuildGraph() []*SyncPoint
 	UpdateSyncPoint(peerId crypto.Hash, points []*SyncPoint)
@@ -61,6 +75,13 @@ type SyncHandle interface {
 	CosiQueueExternalChallenge(peerId crypto.Hash, snap crypto.Hash, cosi *crypto.CosiSignature, ver *common.VersionedTransaction) error
 	CosiAggregateSelfResponses(peerId crypto.Hash, snap crypto.Hash, response *[32]byte) error
 	VerifyAndQueueAppendSnapshotFinalization(peerId crypto.Hash, s *common.Snapshot) error
+	ReadTransaction(hash crypto.Hash) (*common.VersionedTransaction, error)
+	ReadPeerBans() (map[crypto.Hash]time.Time, error)
+	WritePeerBan(peerId crypto.Hash, until time.Time) error
+	BuildPeerRecord() *PeerRecord
+	VerifyPeerRecord(r *PeerRecord) error
+	ReadPeerRecords() (map[crypto.Hash][]byte, error)
+	WritePeerRecord(peerId crypto.Hash, data []byte, until time.Time) error
 }
 
 func (me *Peer) SendSnapshotAnnouncementMessage(idForNetwork crypto.Hash, s *common.Snapshot, R crypto.Key) error {
@@ -128,6 +149,11 @@ func buildAuthenticationMessage(data []byte) []byte {
 	return append(header, data...)
 }
 
+func buildCapabilitiesMessage(c *Capabilities) []byte {
+	data := common.MsgpackMarshalPanic(c)
+	return append([]byte{PeerMessageTypeCapabilities}, data...)
+}
+
 func buildGossipNeighborsMessage(neighbors []*Peer) []byte {
 	rns := make([]string, len(neighbors))
 	for i, p := range neighbors {
@@ -208,13 +234,43 @@ func parseNetworkMessage(data []byte) (*PeerMessage, error) {
 			return nil, err
 		}
 	case PeerMessageTypePing:
+		ping, err := parsePingMessage(data[1:])
+		if err != nil {
+			return nil, err
+		}
+		msg.Ping = ping
 	case PeerMessageTypeGossipNeighbors:
 		err := common.MsgpackUnmarshal(data[1:], &msg.Neighbors)
 		if err != nil {
 			return nil, err
 		}
+	case PeerMessageTypePeerRecords:
+		err := common.MsgpackUnmarshal(data[1:], &msg.Records)
+		if err != nil {
+			return nil, err
+		}
+		if len(msg.Records) > peerRecordsMaxCount {
+			return nil, fmt.Errorf("invalid peer records count %d", len(msg.Records))
+		}
+		for _, r := range msg.Records {
+			if r == nil {
+				return nil, fmt.Errorf("invalid peer records message data")
+			}
+		}
 	case PeerMessageTypeAuthentication:
 		msg.Auth = data[1:]
+	case PeerMessageTypeCapabilities:
+		err := common.MsgpackUnmarshal(data[1:], &msg.Capabilities)
+		if err != nil {
+			return nil, err
+		}
+		if msg.Capabilities == nil {
+			return nil, fmt.Errorf("invalid capabilities message data")
+		}
+		err = msg.Capabilities.validate()
+		if err != nil {
+			return nil, err
+		}
 	case PeerMessageTypeSnapshotConfirm:
 		copy(msg.SnapshotHash[:], data[1:])
 	case PeerMessageTypeTransaction:
@@ -272,51 +328,116 @@ func parseNetworkMessage(data []byte) (*PeerMessage, error) {
 		if msg.Snapshot == nil {
 			return nil, fmt.Errorf("invalid snapshot finalization message data")
 		}
+	case PeerMessageTypeSnapshotFinalizationBatch:
+		err := common.MsgpackUnmarshal(data[1:], &msg.Batch)
+		if err != nil {
+			return nil, err
+		}
+		if msg.Batch == nil || len(msg.Batch.Snapshots) == 0 || len(msg.Batch.Transactions) > len(msg.Batch.Snapshots) {
+			return nil, fmt.Errorf("invalid snapshot finalization batch message data")
+		}
+		for _, s := range msg.Batch.Snapshots {
+			if s == nil {
+				return nil, fmt.Errorf("invalid snapshot finalization batch message data")
+			}
+		}
+	case PeerMessageTypeSnapshotRangeRequest:
+		if len(data[1:]) != 48 {
+			return nil, fmt.Errorf("invalid range request message size %d", len(data[1:]))
+		}
+		msg.Range = &SnapshotRange{}
+		copy(msg.Range.NodeId[:], data[1:])
+		msg.Range.Start = binary.BigEndian.Uint64(data[33:41])
+		msg.Range.Count = binary.BigEndian.Uint64(data[41:49])
+		if msg.Range.Count == 0 || msg.Range.Count > pullRangeRounds {
+			return nil, fmt.Errorf("invalid range request count %d", msg.Range.Count)
+		}
+	case PeerMessageTypeSnapshotRangeResponse:
+		err := common.MsgpackUnmarshal(data[1:], &msg.Range)
+		if err != nil {
+			return nil, err
+		}
+		if msg.Range == nil || len(msg.Range.Snapshots) != len(msg.Range.Transactions) {
+			return nil, fmt.Errorf("invalid range response message data")
+		}
+		for _, s := range msg.Range.Snapshots {
+			if s == nil {
+				return nil, fmt.Errorf("invalid range response message data")
+			}
+		}
 	}
 	return msg, nil
 }
 
-func (me *Peer) handlePeerMessage(peer *Peer, receive chan *PeerMessage, done chan bool) {
+func (me *Peer) handlePeerMessage(ctx context.Context, peer *Peer, receive chan *PeerMessage) {
 	for {
 		select {
-		case <-done:
+		case <-ctx.Done():
 			return
 		case msg := <-receive:
-			switch msg.Type {
-			case PeerMessageTypePing:
-			case PeerMessageTypeGossipNeighbors:
-				if me.gossipNeighbors {
-					me.handle.UpdateNeighbors(msg.Neighbors)
-				}
-			case PeerMessageTypeGraph:
-				logger.Verbosef("network.handle handlePeerMessage PeerMessageTypeGraph %s\n", peer.IdForNetwork)
-				me.handle.UpdateSyncPoint(peer.IdForNetwork, msg.Graph)
-				peer.syncRing.Offer(msg.Graph)
-			case PeerMessageTypeTransactionRequest:
-				logger.Verbosef("network.handle handlePeerMessage PeerMessageTypeTransactionRequest %s %s\n", peer.IdForNetwork, msg.TransactionHash)
-				me.handle.SendTransactionToPeer(peer.IdForNetwork, msg.TransactionHash)
-			case PeerMessageTypeTransaction:
-				logger.Verbosef("network.handle handlePeerMessage PeerMessageTypeTransaction %s\n", peer.IdForNetwork)
-				me.handle.CachePutTransaction(peer.IdForNetwork, msg.Transaction)
-			case PeerMessageTypeSnapshotConfirm:
-				logger.Verbosef("network.handle handlePeerMessage PeerMessageTypeSnapshotConfirm %s %s\n", peer.IdForNetwork, msg.SnapshotHash)
-				me.ConfirmSnapshotForPeer(peer.IdForNetwork, msg.SnapshotHash)
-			case PeerMessageTypeSnapshotAnnoucement:
-				logger.Verbosef("network.handle handlePeerMessage PeerMessageTypeSnapshotAnnoucement %s %s\n", peer.IdForNetwork, msg.Snapshot.Transaction)
-				me.handle.CosiQueueExternalAnnouncement(peer.IdForNetwork, msg.Snapshot, &msg.Commitment)
-			case PeerMessageTypeSnapshotCommitment:
-				logger.Verbosef("network.handle handlePeerMessage PeerMessageTypeSnapshotCommitment %s %s\n", peer.IdForNetwork, msg.SnapshotHash)
-				me.handle.CosiAggregateSelfCommitments(peer.IdForNetwork, msg.SnapshotHash, &msg.Commitment, msg.WantTx)
-			case PeerMessageTypeTransactionChallenge:
-				logger.Verbosef("network.handle handlePeerMessage PeerMessageTypeTransactionChallenge %s %s %t\n", peer.IdForNetwork, msg.SnapshotHash, msg.Transaction != nil)
-				me.handle.CosiQueueExternalChallenge(peer.IdForNetwork, msg.SnapshotHash, &msg.Cosi, msg.Transaction)
-			case PeerMessageTypeSnapshotResponse:
-				logger.Verbosef("network.handle handlePeerMessage PeerMessageTypeSnapshotResponse %s %s\n", peer.IdForNetwork, msg.SnapshotHash)
-				me.handle.CosiAggregateSelfResponses(peer.IdForNetwork, msg.SnapshotHash, &msg.Response)
-			case PeerMessageTypeSnapshotFinalization:
-				logger.Verbosef("network.handle handlePeerMessage PeerMessageTypeSnapshotFinalization %s %s\n", peer.IdForNetwork, msg.Snapshot.Transaction)
-				me.handle.VerifyAndQueueAppendSnapshotFinalization(peer.IdForNetwork, msg.Snapshot)
-			}
+			me.handleMessage(peer, msg)
+		}
+	}
+}
+
+func (me *Peer) handleMessage(peer *Peer, msg *PeerMessage) {
+	switch msg.Type {
+	case PeerMessageTypePing:
+		if msg.Ping != nil {
+			peer.clock.pinged(msg.Ping, time.Now())
 		}
+	case PeerMessageTypeCapabilities:
+		me.negotiateCapabilities(peer, msg.Capabilities)
+	case PeerMessageTypeGossipNeighbors:
+		if me.gossipNeighbors {
+			me.handle.UpdateNeighbors(msg.Neighbors)
+		}
+	case PeerMessageTypePeerRecords:
+		if me.gossipNeighbors {
+			records := msg.Records
+			me.loop(func() { me.handlePeerRecords(peer, records) })
+		}
+	case PeerMessageTypeGraph:
+		logger.Verbosef("network.handle handlePeerMessage PeerMessageTypeGraph %s\n", peer.IdForNetwork)
+		me.handle.UpdateSyncPoint(peer.IdForNetwork, msg.Graph)
+		peer.stats.updateGraph(msg.Graph)
+		peer.syncRing.Offer(msg.Graph)
+	case PeerMessageTypeTransactionRequest:
+		logger.Verbosef("network.handle handlePeerMessage PeerMessageTypeTransactionRequest %s %s\n", peer.IdForNetwork, msg.TransactionHash)
+		if me.scores.request(peer.IdForNetwork, time.Now()) {
+			me.handle.SendTransactionToPeer(peer.IdForNetwork, msg.TransactionHash)
+		} else {
+			me.PenalizeNeighbor(peer.IdForNetwork, PeerPenaltyTransactionRequest, "transaction request flood")
+		}
+	case PeerMessageTypeTransaction:
+		logger.Verbosef("network.handle handlePeerMessage PeerMessageTypeTransaction %s\n", peer.IdForNetwork)
+		me.handle.CachePutTransaction(peer.IdForNetwork, msg.Transaction)
+	case PeerMessageTypeSnapshotConfirm:
+		logger.Verbosef("network.handle handlePeerMessage PeerMessageTypeSnapshotConfirm %s %s\n", peer.IdForNetwork, msg.SnapshotHash)
+		me.ConfirmSnapshotForPeer(peer.IdForNetwork, msg.SnapshotHash)
+	case PeerMessageTypeSnapshotAnnoucement:
+		logger.Verbosef("network.handle handlePeerMessage PeerMessageTypeSnapshotAnnoucement %s %s\n", peer.IdForNetwork, msg.Snapshot.Transaction)
+		me.handle.CosiQueueExternalAnnouncement(peer.IdForNetwork, msg.Snapshot, &msg.Commitment)
+	case PeerMessageTypeSnapshotCommitment:
+		logger.Verbosef("network.handle handlePeerMessage PeerMessageTypeSnapshotCommitment %s %s\n", peer.IdForNetwork, msg.SnapshotHash)
+		me.handle.CosiAggregateSelfCommitments(peer.IdForNetwork, msg.SnapshotHash, &msg.Commitment, msg.WantTx)
+	case PeerMessageTypeTransactionChallenge:
+		logger.Verbosef("network.handle handlePeerMessage PeerMessageTypeTransactionChallenge %s %s %t\n", peer.IdForNetwork, msg.SnapshotHash, msg.Transaction != nil)
+		me.handle.CosiQueueExternalChallenge(peer.IdForNetwork, msg.SnapshotHash, &msg.Cosi, msg.Transaction)
+	case PeerMessageTypeSnapshotResponse:
+		logger.Verbosef("network.handle handlePeerMessage PeerMessageTypeSnapshotResponse %s %s\n", peer.IdForNetwork, msg.SnapshotHash)
+		me.handle.CosiAggregateSelfResponses(peer.IdForNetwork, msg.SnapshotHash, &msg.Response)
+	case PeerMessageTypeSnapshotFinalization:
+		logger.Verbosef("network.handle handlePeerMessage PeerMessageTypeSnapshotFinalization %s %s\n", peer.IdForNetwork, msg.Snapshot.Transaction)
+		me.handle.VerifyAndQueueAppendSnapshotFinalization(peer.IdForNetwork, msg.Snapshot)
+	case PeerMessageTypeSnapshotFinalizationBatch:
+		logger.Verbosef("network.handle handlePeerMessage PeerMessageTypeSnapshotFinalizationBatch %s %d %d\n", peer.IdForNetwork, len(msg.Batch.Snapshots), len(msg.Batch.Transactions))
+		me.handleSnapshotFinalizationBatch(peer, msg.Batch)
+	case PeerMessageTypeSnapshotRangeRequest:
+		logger.Verbosef("network.handle handlePeerMessage PeerMessageTypeSnapshotRangeRequest %s %s:%d:%d\n", peer.IdForNetwork, msg.Range.NodeId, msg.Range.Start, msg.Range.Count)
+		me.loop(func() { me.serveSnapshotRange(peer, msg.Range) })
+	case PeerMessageTypeSnapshotRangeResponse:
+		logger.Verbosef("network.handle handlePeerMessage PeerMessageTypeSnapshotRangeResponse %s %s:%d:%d\n", peer.IdForNetwork, msg.Range.NodeId, msg.Range.Start, msg.Range.Count)
+		me.handleSnapshotRange(peer, msg.Range)
 	}
 }
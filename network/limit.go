@@ -0,0 +1,84 @@
+package network
+
+import (
+	"sync"
+	"time"
+
+	"github.com/MixinNetwork/mixin/util"
+)
+
+// rateLimiter is a token bucket which allows the burst of one second, and
+// a single request larger than the burst is allowed by borrowing from the
+// future, so the average rate is still the limit.
+type rateLimiter struct {
+	sync.Mutex
+	rate   float64
+	tokens float64
+	at     time.Time
+}
+
+func newRateLimiter(rate int) *rateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	return &rateLimiter{
+		rate:   float64(rate),
+		tokens: float64(rate),
+		at:     time.Now(),
+	}
+}
+
+// reserve takes n tokens and returns the duration to wait before using
+// them, a nil limiter never waits.
+func (l *rateLimiter) reserve(n int, now time.Time) time.Duration {
+	if l == nil {
+		return 0
+	}
+	l.Lock()
+	defer l.Unlock()
+
+	if now.After(l.at) {
+		l.tokens += now.Sub(l.at).Seconds() * l.rate
+		l.at = now
+	}
+	if l.tokens > l.rate {
+		l.tokens = l.rate
+	}
+	l.tokens -= float64(n)
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+type syncLimiter struct {
+	bytes    *rateLimiter
+	messages *rateLimiter
+}
+
+// SetSyncRateLimit limits the outbound sync messages to all neighbors in
+// bytes and messages per second, 0 for unlimited. The consensus messages
+// are never limited, and must be called before any neighbor added.
+func (me *Peer) SetSyncRateLimit(bytes, messages int) {
+	me.syncLimiter = &syncLimiter{
+		bytes:    newRateLimiter(bytes),
+		messages: newRateLimiter(messages),
+	}
+}
+
+// waitSyncLimit blocks the sync of the neighbor until the message is allowed
+// by the limits, and returns false if the neighbor context is done.
+func (me *Peer) waitSyncLimit(p *Peer, size int) bool {
+	if me.syncLimiter == nil {
+		return true
+	}
+	now := time.Now()
+	wait := me.syncLimiter.bytes.reserve(size, now)
+	if mw := me.syncLimiter.messages.reserve(1, now); mw > wait {
+		wait = mw
+	}
+	if wait > 0 {
+		return util.Sleep(p.ctx, wait)
+	}
+	return p.ctx.Err() == nil
+}
@@ -0,0 +1,59 @@
+package network
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/MixinNetwork/mixin/common"
+	"github.com/MixinNetwork/mixin/crypto"
+	"github.com/VictoriaMetrics/fastcache"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiter(t *testing.T) {
+	assert := assert.New(t)
+
+	var nl *rateLimiter
+	assert.Nil(newRateLimiter(0))
+	assert.Equal(time.Duration(0), nl.reserve(1000000, time.Now()))
+
+	now := time.Now()
+	l := newRateLimiter(1000)
+	l.at = now
+	assert.Equal(time.Duration(0), l.reserve(600, now))
+	assert.Equal(time.Duration(0), l.reserve(400, now))
+	assert.Equal(500*time.Millisecond, l.reserve(500, now))
+	now = now.Add(time.Second)
+	assert.Equal(time.Duration(0), l.reserve(500, now))
+	now = now.Add(time.Hour)
+	assert.Equal(time.Second, l.reserve(2000, now))
+	assert.Equal(2*time.Second, l.reserve(1000, now))
+}
+
+func TestSyncLimit(t *testing.T) {
+	assert := assert.New(t)
+
+	me := NewPeer(context.Background(), nil, crypto.NewHash([]byte("local")), "127.0.0.1:7009", false)
+	p := NewPeer(context.Background(), nil, crypto.NewHash([]byte("remote")), "127.0.0.1:7010", false)
+	me.snapshotsCaches = &confirmMap{cache: fastcache.New(16 * 1024 * 1024)}
+	assert.True(me.waitSyncLimit(p, 1000000))
+
+	me.SetSyncRateLimit(0, 10)
+	start := time.Now()
+	for i := 0; i < 15; i++ {
+		s := &common.Snapshot{Version: common.SnapshotVersion, Timestamp: uint64(i)}
+		s.Hash = s.PayloadHash()
+		assert.Nil(me.sendSyncSnapshotToPeer(p, s))
+	}
+	assert.True(time.Since(start) >= 400*time.Millisecond)
+	assert.Equal(uint64(15), p.lowRing.Len())
+	assert.Equal(uint64(0), p.normalRing.Len())
+
+	p.cancel()
+	me.SetSyncRateLimit(0, 1)
+	me.syncLimiter.messages.reserve(10, time.Now())
+	start = time.Now()
+	assert.False(me.waitSyncLimit(p, 1))
+	assert.True(time.Since(start) < time.Second)
+}
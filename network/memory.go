@@ -0,0 +1,380 @@
+package network
+
+import (
+	"context"
+	crand "crypto/rand"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/MixinNetwork/mixin/crypto"
+)
+
+// MemoryLink describes the quality of the link from one endpoint to
+// another, the zero value is a perfect link.
+type MemoryLink struct {
+	Latency   time.Duration
+	Jitter    time.Duration
+	Loss      float64
+	Bandwidth int // bytes per second, 0 for unlimited
+}
+
+// MemoryEvent changes the partitions at the time since Play, a nil
+// Partitions heals the network.
+type MemoryEvent struct {
+	At         time.Duration
+	Partitions [][]string
+}
+
+// MemoryNetwork connects endpoints in the same process over channels, all
+// random decisions are made by the seeded source so a test with the same
+// seed and the same messages order drops and delays the same messages.
+type MemoryNetwork struct {
+	sync.Mutex
+	rand       *rand.Rand
+	listeners  map[string]*MemoryTransport
+	links      map[[2]string]MemoryLink
+	busy       map[[2]string]time.Time
+	partitions map[string]int
+	defaults   MemoryLink
+}
+
+// MemoryEndpoint is the TransportFactory of the node with the address.
+type MemoryEndpoint struct {
+	network *MemoryNetwork
+	addr    string
+}
+
+type MemoryTransport struct {
+	network *MemoryNetwork
+	local   string
+	remote  string
+	accept  chan *MemoryClient
+	closed  chan struct{}
+	once    sync.Once
+}
+
+type MemoryClient struct {
+	network *MemoryNetwork
+	local   string
+	remote  string
+	queue   chan *memoryPacket
+	inbox   chan []byte
+	peer    *MemoryClient
+	binding []byte
+	last    time.Time
+	closed  chan struct{}
+	once    *sync.Once
+}
+
+type memoryPacket struct {
+	at   time.Time
+	data []byte
+}
+
+type memoryAddr string
+
+func (a memoryAddr) Network() string { return "memory" }
+func (a memoryAddr) String() string  { return string(a) }
+
+func NewMemoryNetwork(seed int64) *MemoryNetwork {
+	return &MemoryNetwork{
+		rand:       rand.New(rand.NewSource(seed)),
+		listeners:  make(map[string]*MemoryTransport),
+		links:      make(map[[2]string]MemoryLink),
+		busy:       make(map[[2]string]time.Time),
+		partitions: make(map[string]int),
+	}
+}
+
+func (n *MemoryNetwork) Endpoint(addr string) *MemoryEndpoint {
+	return &MemoryEndpoint{network: n, addr: addr}
+}
+
+func (n *MemoryNetwork) SetDefaultLink(link MemoryLink) {
+	n.Lock()
+	defer n.Unlock()
+	n.defaults = link
+}
+
+// SetLink overrides the default link from one address to another, the
+// opposite direction is not changed.
+func (n *MemoryNetwork) SetLink(from, to string, link MemoryLink) {
+	n.Lock()
+	defer n.Unlock()
+	n.links[[2]string{from, to}] = link
+}
+
+// Partition splits the addresses into groups which can't reach each other,
+// the addresses not in any group can still reach all.
+func (n *MemoryNetwork) Partition(groups ...[]string) {
+	n.Lock()
+	defer n.Unlock()
+	n.partitions = make(map[string]int)
+	for i, g := range groups {
+		for _, addr := range g {
+			n.partitions[addr] = i + 1
+		}
+	}
+}
+
+func (n *MemoryNetwork) Heal() {
+	n.Partition()
+}
+
+// Play applies the events in the background, and the returned function
+// stops the events not applied yet.
+func (n *MemoryNetwork) Play(events []MemoryEvent) func() {
+	var timers []*time.Timer
+	for _, e := range events {
+		e := e
+		timers = append(timers, time.AfterFunc(e.At, func() {
+			n.Partition(e.Partitions...)
+		}))
+	}
+	return func() {
+		for _, t := range timers {
+			t.Stop()
+		}
+	}
+}
+
+func (n *MemoryNetwork) Reachable(from, to string) bool {
+	n.Lock()
+	defer n.Unlock()
+	return n.reachable(from, to)
+}
+
+func (n *MemoryNetwork) reachable(from, to string) bool {
+	a, b := n.partitions[from], n.partitions[to]
+	return a == 0 || b == 0 || a == b
+}
+
+// schedule returns the delivery time of the data, or false if dropped.
+func (n *MemoryNetwork) schedule(from, to string, size int, last time.Time) (time.Time, bool) {
+	n.Lock()
+	defer n.Unlock()
+
+	if !n.reachable(from, to) {
+		return time.Time{}, false
+	}
+	key := [2]string{from, to}
+	link, found := n.links[key]
+	if !found {
+		link = n.defaults
+	}
+	if link.Loss > 0 && n.rand.Float64() < link.Loss {
+		return time.Time{}, false
+	}
+
+	now := time.Now()
+	sent := now
+	if link.Bandwidth > 0 {
+		if busy := n.busy[key]; busy.After(sent) {
+			sent = busy
+		}
+		sent = sent.Add(time.Duration(size) * time.Second / time.Duration(link.Bandwidth))
+		n.busy[key] = sent
+	}
+	at := sent.Add(link.Latency)
+	if link.Jitter > 0 {
+		at = at.Add(time.Duration(n.rand.Int63n(int64(link.Jitter))))
+	}
+	if at.Before(last) {
+		at = last
+	}
+	return at, true
+}
+
+func (e *MemoryEndpoint) NewServer(addr string) (Transport, error) {
+	return &MemoryTransport{network: e.network, local: e.addr}, nil
+}
+
+func (e *MemoryEndpoint) NewClient(addr string) (Transport, error) {
+	_, host, err := ParseAddress(addr)
+	if err != nil {
+		return nil, err
+	}
+	return &MemoryTransport{network: e.network, local: e.addr, remote: host}, nil
+}
+
+func (t *MemoryTransport) Listen() error {
+	t.network.Lock()
+	defer t.network.Unlock()
+
+	if t.network.listeners[t.local] != nil {
+		return fmt.Errorf("memory listen address in use %s", t.local)
+	}
+	t.accept = make(chan *MemoryClient, MaxIncomingStreams)
+	t.closed = make(chan struct{})
+	t.network.listeners[t.local] = t
+	return nil
+}
+
+func (t *MemoryTransport) Dial(ctx context.Context) (Client, error) {
+	t.network.Lock()
+	l := t.network.listeners[t.remote]
+	reachable := t.network.reachable(t.local, t.remote)
+	t.network.Unlock()
+	if l == nil || !reachable {
+		return nil, fmt.Errorf("memory dial %s unreachable from %s", t.remote, t.local)
+	}
+
+	// the binding is random instead of the seeded source, so that it won't
+	// change the drops and delays of the same seed
+	binding := make([]byte, clientBindingLength)
+	_, err := crand.Read(binding)
+	if err != nil {
+		return nil, err
+	}
+	once, closed := &sync.Once{}, make(chan struct{})
+	client := &MemoryClient{
+		network: t.network,
+		local:   t.local,
+		remote:  t.remote,
+		queue:   make(chan *memoryPacket, 1024),
+		binding: binding,
+		closed:  closed,
+		once:    once,
+	}
+	server := &MemoryClient{
+		network: t.network,
+		local:   t.remote,
+		remote:  t.local,
+		inbox:   make(chan []byte, 1024),
+		binding: binding,
+		closed:  closed,
+		once:    once,
+	}
+	client.peer = server
+
+	select {
+	case l.accept <- server:
+		go client.deliver()
+		return client, nil
+	case <-l.closed:
+		err = fmt.Errorf("memory dial %s closed", t.remote)
+	case <-ctx.Done():
+		err = ctx.Err()
+	case <-time.After(HandshakeTimeout):
+		err = fmt.Errorf("memory dial %s timeout", t.remote)
+	}
+	client.Close()
+	return nil, err
+}
+
+func (t *MemoryTransport) Accept(ctx context.Context) (Client, error) {
+	select {
+	case c := <-t.accept:
+		return c, nil
+	case <-t.closed:
+		return nil, io.EOF
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (t *MemoryTransport) Close() error {
+	if t.closed == nil {
+		return nil
+	}
+	t.once.Do(func() {
+		t.network.Lock()
+		defer t.network.Unlock()
+		if t.network.listeners[t.local] == t {
+			delete(t.network.listeners, t.local)
+		}
+		close(t.closed)
+	})
+	return nil
+}
+
+func (c *MemoryClient) RemoteAddr() net.Addr {
+	return memoryAddr(c.remote)
+}
+
+func (c *MemoryClient) Binding() ([]byte, error) {
+	return c.binding, nil
+}
+
+// SignerId is always empty, because there is no certificate in memory.
+func (c *MemoryClient) SignerId() (crypto.Hash, error) {
+	return crypto.Hash{}, nil
+}
+
+func (c *MemoryClient) Receive() ([]byte, error) {
+	if c.inbox == nil {
+		return nil, fmt.Errorf("memory receive on send only client")
+	}
+	timer := time.NewTimer(ReadDeadline)
+	defer timer.Stop()
+	select {
+	case data := <-c.inbox:
+		return data, nil
+	case <-c.closed:
+		return nil, io.EOF
+	case <-timer.C:
+		return nil, fmt.Errorf("memory receive timeout %s", c.remote)
+	}
+}
+
+func (c *MemoryClient) Send(data []byte) error {
+	if l := len(data); l < 1 || l > TransportMessageMaxSize {
+		return fmt.Errorf("memory send invalid message size %d", l)
+	}
+	if c.queue == nil {
+		return fmt.Errorf("memory send on receive only client")
+	}
+	select {
+	case <-c.closed:
+		return io.EOF
+	default:
+	}
+	at, ok := c.network.schedule(c.local, c.remote, len(data), c.last)
+	if !ok {
+		return nil
+	}
+	c.last = at
+	p := &memoryPacket{at: at, data: append([]byte{}, data...)}
+
+	timer := time.NewTimer(WriteDeadline)
+	defer timer.Stop()
+	select {
+	case c.queue <- p:
+		return nil
+	case <-c.closed:
+		return io.EOF
+	case <-timer.C:
+		return fmt.Errorf("memory send timeout %s", c.remote)
+	}
+}
+
+func (c *MemoryClient) deliver() {
+	for {
+		select {
+		case p := <-c.queue:
+			if d := time.Until(p.at); d > 0 {
+				select {
+				case <-time.After(d):
+				case <-c.closed:
+					return
+				}
+			}
+			select {
+			case c.peer.inbox <- p.data:
+			case <-c.closed:
+				return
+			}
+		case <-c.closed:
+			return
+		}
+	}
+}
+
+func (c *MemoryClient) Close() error {
+	c.once.Do(func() { close(c.closed) })
+	return nil
+}
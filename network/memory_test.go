@@ -0,0 +1,129 @@
+package network
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryTransport(t *testing.T) {
+	assert := assert.New(t)
+
+	a, b, c := "127.0.0.1:7101", "127.0.0.1:7102", "127.0.0.1:7103"
+	mn := NewMemoryNetwork(7)
+	mn.SetDefaultLink(MemoryLink{Latency: 50 * time.Millisecond, Jitter: 20 * time.Millisecond})
+
+	server, _ := mn.Endpoint(b).NewServer(":7239")
+	assert.Nil(server.Listen())
+	defer server.Close()
+	trans, err := mn.Endpoint(a).NewClient(b)
+	assert.Nil(err)
+	client, err := trans.Dial(context.Background())
+	assert.Nil(err)
+	defer client.Close()
+	remote, err := server.Accept(context.Background())
+	assert.Nil(err)
+	assert.Equal(a, remote.RemoteAddr().String())
+	binding, err := client.Binding()
+	assert.Nil(err)
+	assert.Len(binding, clientBindingLength)
+	bound, err := remote.Binding()
+	assert.Nil(err)
+	assert.Equal(binding, bound)
+
+	start := time.Now()
+	for i := 0; i < 10; i++ {
+		err = client.Send([]byte(fmt.Sprintf("hello %d", i)))
+		assert.Nil(err)
+	}
+	for i := 0; i < 10; i++ {
+		msg, err := remote.Receive()
+		assert.Nil(err)
+		assert.Equal(fmt.Sprintf("hello %d", i), string(msg))
+	}
+	assert.True(time.Since(start) >= 50*time.Millisecond)
+
+	mn.SetLink(a, b, MemoryLink{Bandwidth: 100000})
+	start = time.Now()
+	for i := 0; i < 10; i++ {
+		err = client.Send(make([]byte, 1000))
+		assert.Nil(err)
+	}
+	for i := 0; i < 10; i++ {
+		_, err := remote.Receive()
+		assert.Nil(err)
+	}
+	assert.True(time.Since(start) >= 90*time.Millisecond)
+
+	mn.Partition([]string{a}, []string{b, c})
+	err = client.Send([]byte("dropped"))
+	assert.Nil(err)
+	trans, _ = mn.Endpoint(a).NewClient(b)
+	_, err = trans.Dial(context.Background())
+	assert.NotNil(err)
+	trans, _ = mn.Endpoint(c).NewClient(b)
+	_, err = trans.Dial(context.Background())
+	assert.Nil(err)
+	mn.Heal()
+	err = client.Send([]byte("healed"))
+	assert.Nil(err)
+	msg, err := remote.Receive()
+	assert.Nil(err)
+	assert.Equal("healed", string(msg))
+
+	stop := mn.Play([]MemoryEvent{
+		{At: 0, Partitions: [][]string{{a}, {b}}},
+		{At: 100 * time.Millisecond},
+	})
+	defer stop()
+	time.Sleep(20 * time.Millisecond)
+	assert.False(mn.Reachable(a, b))
+	time.Sleep(100 * time.Millisecond)
+	assert.True(mn.Reachable(a, b))
+
+	client.Close()
+	_, err = remote.Receive()
+	assert.NotNil(err)
+	err = client.Send([]byte("closed"))
+	assert.NotNil(err)
+}
+
+func TestMemoryTransportLoss(t *testing.T) {
+	assert := assert.New(t)
+
+	run := func(seed int64) []string {
+		mn := NewMemoryNetwork(seed)
+		mn.SetDefaultLink(MemoryLink{Loss: 0.3})
+		server, _ := mn.Endpoint("127.0.0.1:7201").NewServer("")
+		assert.Nil(server.Listen())
+		defer server.Close()
+		trans, _ := mn.Endpoint("127.0.0.1:7202").NewClient("127.0.0.1:7201")
+		client, err := trans.Dial(context.Background())
+		assert.Nil(err)
+		defer client.Close()
+		remote, _ := server.Accept(context.Background())
+		for i := 0; i < 100; i++ {
+			client.Send([]byte(fmt.Sprint(i)))
+		}
+		mn.SetDefaultLink(MemoryLink{})
+		client.Send([]byte("end"))
+		var received []string
+		for {
+			msg, err := remote.Receive()
+			assert.Nil(err)
+			if string(msg) == "end" {
+				return received
+			}
+			received = append(received, string(msg))
+		}
+	}
+
+	first := run(11)
+	assert.Less(len(first), 90)
+	assert.Greater(len(first), 50)
+	assert.Equal(first, run(11))
+	assert.NotEqual(first, run(12))
+}
@@ -6,7 +6,6 @@ import (
 	"encoding/hex"
 	"fmt"
 	"math/rand"
-	"net"
 	"sync"
 	"time"
 
@@ -22,17 +21,32 @@ type Peer struct {
 	Address      string
 
 	ctx             context.Context
+	cancel          context.CancelFunc
+	loops           sync.WaitGroup
+	loopsMutex      sync.Mutex
 	snapshotsCaches *confirmMap
 	neighbors       *neighborMap
 	gossipRound     *neighborMap
 	pingFilter      *neighborMap
 	handle          SyncHandle
-	transport       Transport
+	factory         TransportFactory
+	transports      []Transport
 	gossipNeighbors bool
 	highRing        *util.RingBuffer
 	normalRing      *util.RingBuffer
+	lowRing         *util.RingBuffer
 	syncRing        *util.RingBuffer
-	closing         bool
+	syncLimiter     *syncLimiter
+	pulls           *rangeSync
+	serving         int32
+	stats           *peerStats
+	clock           *peerClock
+	scores          *scoreBoard
+	book            *peerBook
+	recorder        *MessageRecorder
+	legacyAuth      bool
+	local           *Capabilities
+	capabilities    capabilitySet
 	ops             chan struct{}
 	stn             chan struct{}
 }
@@ -49,11 +63,22 @@ type ChanMsg struct {
 	data []byte
 }
 
+// SetTransportFactory replaces the QUIC and TCP transports, and must be
+// called before any neighbor added or listened.
+func (me *Peer) SetTransportFactory(factory TransportFactory) {
+	me.factory = factory
+}
+
+// SetLegacyAuthentication sends the legacy authentication message to the
+// peers with unknown capabilities, e.g. the nodes not upgraded yet, and must
+// be called before any neighbor added or pinged.
+func (me *Peer) SetLegacyAuthentication(enabled bool) {
+	me.legacyAuth = enabled
+}
+
 func (me *Peer) PingNeighbor(addr string) error {
-	if a, err := net.ResolveUDPAddr("udp", addr); err != nil {
-		return fmt.Errorf("invalid address %s %s", addr, err)
-	} else if a.Port < 80 || a.IP == nil {
-		return fmt.Errorf("invalid address %s %d %s", addr, a.Port, a.IP)
+	if _, _, err := ParseAddress(addr); err != nil {
+		return err
 	}
 	key := crypto.NewHash([]byte(addr))
 	if me.pingFilter.Get(key) != nil {
@@ -61,44 +86,96 @@ func (me *Peer) PingNeighbor(addr string) error {
 	}
 	me.pingFilter.Set(key, &Peer{})
 
-	go func() {
-		for !me.closing {
+	me.loop(func() {
+		for me.ctx.Err() == nil {
 			err := me.pingPeerStream(addr)
 			if err != nil {
 				logger.Verbosef("PingNeighbor error %s\n", err.Error())
+				util.Sleep(me.ctx, time.Second)
 			}
 		}
-	}()
+	})
 	return nil
 }
 
 func (me *Peer) pingPeerStream(addr string) error {
 	logger.Verbosef("PING OPEN PEER STREAM %s\n", addr)
-	transport, err := NewQuicClient(addr)
+	transport, err := me.factory.NewClient(addr)
 	if err != nil {
 		return err
 	}
-	client, err := transport.Dial(me.ctx)
+	client, err := me.dial(me.ctx, transport)
+	me.reachPeer(crypto.Hash{}, addr, err)
 	if err != nil {
 		return err
 	}
 	defer client.Close()
 	logger.Verbosef("PING DIAL PEER STREAM %s\n", addr)
 
-	err = client.Send(buildAuthenticationMessage(me.handle.BuildAuthenticationMessage()))
+	err = me.sendAuthentication(client, nil)
 	if err != nil {
 		return err
 	}
 	logger.Verbosef("PING AUTH PEER STREAM %s\n", addr)
-	time.Sleep(time.Duration(config.SnapshotRoundGap))
+	util.Sleep(me.ctx, time.Duration(config.SnapshotRoundGap))
 	return nil
 }
 
+// sendAuthentication sends the authentication message bound to the client,
+// which is the first message of the connection. The legacy message is sent
+// if the capabilities negotiated with the peer have no bound version, or if
+// they are unknown and the legacy authentication enabled, because the nodes
+// not upgraded never send the capabilities and reject the bound message.
+// The negotiated is nil for the pinged addresses and the neighbors not
+// negotiated yet, and an upgraded neighbor gets the bound message once its
+// capabilities received. It should be removed after the transition period.
+func (me *Peer) sendAuthentication(client Client, negotiated *Capabilities) error {
+	legacy := negotiated == nil && me.legacyAuth
+	if negotiated != nil && negotiated.Authentication < AuthenticationVersionBound {
+		legacy = true
+	}
+	if legacy {
+		return client.Send(buildAuthenticationMessage(me.handle.BuildAuthenticationMessage(nil)))
+	}
+	binding, err := client.Binding()
+	if err != nil {
+		return err
+	}
+	return client.Send(buildAuthenticationMessage(me.handle.BuildAuthenticationMessage(binding)))
+}
+
+// dial limits the dial by the handshake timeout, and cancels it once the
+// peer or neighbor is closed.
+func (me *Peer) dial(ctx context.Context, transport Transport) (Client, error) {
+	ctx, cancel := context.WithTimeout(ctx, HandshakeTimeout)
+	defer cancel()
+	return transport.Dial(ctx)
+}
+
+// loop runs the function in a goroutine which must return once the peer
+// context is done, and Teardown waits for all of them. The function is not
+// run if the peer is already torn down.
+func (me *Peer) loop(f func()) bool {
+	me.loopsMutex.Lock()
+	defer me.loopsMutex.Unlock()
+
+	if me.ctx.Err() != nil {
+		return false
+	}
+	me.loops.Add(1)
+	go func() {
+		defer me.loops.Done()
+		f()
+	}()
+	return true
+}
+
 func (me *Peer) AddNeighbor(idForNetwork crypto.Hash, addr string) (*Peer, error) {
-	if a, err := net.ResolveUDPAddr("udp", addr); err != nil {
-		return nil, fmt.Errorf("invalid address %s %s", addr, err)
-	} else if a.Port < 80 || a.IP == nil {
-		return nil, fmt.Errorf("invalid address %s %d %s", addr, a.Port, a.IP)
+	if _, _, err := ParseAddress(addr); err != nil {
+		return nil, err
+	}
+	if me.scores.banned(idForNetwork, time.Now()) {
+		return nil, fmt.Errorf("peer banned %s", idForNetwork)
 	}
 	old := me.neighbors.Get(idForNetwork)
 	if old != nil && old.Address == addr {
@@ -107,27 +184,43 @@ func (me *Peer) AddNeighbor(idForNetwork crypto.Hash, addr string) (*Peer, error
 		old.disconnect()
 	}
 
-	peer := NewPeer(nil, idForNetwork, addr, false)
+	peer := NewPeer(me.ctx, nil, idForNetwork, addr, false)
+	peer.scores = me.scores
 	me.neighbors.Set(idForNetwork, peer)
 	go me.openPeerStreamLoop(peer)
 	go me.syncToNeighborLoop(peer)
 	return peer, nil
 }
 
+// RemoveNeighbor disconnects the neighbor, which may connect back again
+// if it still has this node in its neighbors.
+func (me *Peer) RemoveNeighbor(idForNetwork crypto.Hash) bool {
+	p := me.neighbors.Delete(idForNetwork)
+	if p == nil {
+		return false
+	}
+	p.disconnect()
+	return true
+}
+
 func (me *Peer) Neighbors() []*Peer {
 	return me.neighbors.Slice()
 }
 
 func (p *Peer) disconnect() {
-	p.closing = true
+	p.cancel()
 	p.highRing.Dispose()
 	p.normalRing.Dispose()
+	p.lowRing.Dispose()
 	p.syncRing.Dispose()
 	<-p.ops
 	<-p.stn
 }
 
-func NewPeer(handle SyncHandle, idForNetwork crypto.Hash, addr string, gossipNeighbors bool) *Peer {
+// NewPeer creates the peer with the context of the node, and the neighbors
+// added have the contexts derived from it, so all the goroutines of the peer
+// and neighbors stop once the node context done or the peer torn down.
+func NewPeer(ctx context.Context, handle SyncHandle, idForNetwork crypto.Hash, addr string, gossipNeighbors bool) *Peer {
 	peer := &Peer{
 		IdForNetwork:    idForNetwork,
 		Address:         addr,
@@ -137,24 +230,44 @@ func NewPeer(handle SyncHandle, idForNetwork crypto.Hash, addr string, gossipNei
 		gossipNeighbors: gossipNeighbors,
 		highRing:        util.NewRingBuffer(1024),
 		normalRing:      util.NewRingBuffer(1024),
+		lowRing:         util.NewRingBuffer(1024),
 		syncRing:        util.NewRingBuffer(1024),
 		handle:          handle,
+		factory:         socketTransportFactory{},
+		stats:           &peerStats{},
+		clock:           &peerClock{},
+		scores:          newScoreBoard(),
+		book:            newPeerBook(),
+		pulls:           newRangeSync(),
+		local:           LocalCapabilities(),
 		ops:             make(chan struct{}),
 		stn:             make(chan struct{}),
 	}
-	peer.ctx = context.Background() // FIXME use real context
+	peer.ctx, peer.cancel = context.WithCancel(ctx)
 	if handle != nil {
 		peer.snapshotsCaches = &confirmMap{cache: handle.GetCacheStore()}
+		peer.loadBans()
+		peer.loadPeerRecords()
 	}
 	return peer
 }
 
+// Teardown cancels the context of the peer, then waits for all the loops
+// and neighbors to stop, so no goroutine of the peer is left running.
 func (me *Peer) Teardown() {
-	me.closing = true
-	me.transport.Close()
+	me.loopsMutex.Lock()
+	me.cancel()
+	me.loopsMutex.Unlock()
+
+	for _, t := range me.transports {
+		t.Close()
+	}
 	me.highRing.Dispose()
 	me.normalRing.Dispose()
+	me.lowRing.Dispose()
 	me.syncRing.Dispose()
+	me.loops.Wait()
+
 	neighbors := me.neighbors.Slice()
 	var wg sync.WaitGroup
 	for _, p := range neighbors {
@@ -165,26 +278,39 @@ func (me *Peer) Teardown() {
 		}(p)
 	}
 	wg.Wait()
+	if me.handle != nil {
+		me.flushPeerRecords()
+	}
 	logger.Printf("Teardown(%s, %s)\n", me.IdForNetwork, me.Address)
 }
 
-func (me *Peer) ListenNeighbors() error {
-	transport, err := NewQuicServer(me.Address)
-	if err != nil {
-		return err
+// ListenNeighbors listens on all the listeners, which are bind addresses
+// with optional transport scheme, or the peer address if no listeners. It
+// blocks until the peer torn down.
+func (me *Peer) ListenNeighbors(listeners []string) error {
+	if me.ctx.Err() != nil {
+		return nil
 	}
-	me.transport = transport
-
-	err = me.transport.Listen()
-	if err != nil {
-		return err
+	if len(listeners) == 0 {
+		listeners = []string{me.Address}
+	}
+	for _, l := range listeners {
+		transport, err := me.factory.NewServer(l)
+		if err != nil {
+			return err
+		}
+		err = transport.Listen()
+		if err != nil {
+			return err
+		}
+		me.transports = append(me.transports, transport)
 	}
 
-	go func() {
+	me.loop(func() {
 		ticker := time.NewTicker(time.Duration(config.SnapshotRoundGap))
 		defer ticker.Stop()
 
-		for !me.closing {
+		for {
 			me.gossipRound.Clear()
 			rand.Seed(time.Now().UnixNano())
 			neighbors := me.neighbors.Slice()
@@ -199,56 +325,87 @@ func (me *Peer) ListenNeighbors() error {
 				me.gossipRound.Set(p.IdForNetwork, p)
 			}
 
-			<-ticker.C
+			select {
+			case <-me.ctx.Done():
+				return
+			case <-ticker.C:
+			}
 		}
-	}()
+	})
+	me.loop(me.pullSyncLoop)
 
-	for !me.closing {
-		c, err := me.transport.Accept(me.ctx)
+	for _, t := range me.transports {
+		t := t
+		me.loop(func() { me.acceptNeighborsLoop(t) })
+	}
+	<-me.ctx.Done()
+
+	logger.Printf("ListenNeighbors(%s, %s) DONE\n", me.IdForNetwork, me.Address)
+	return nil
+}
+
+func (me *Peer) acceptNeighborsLoop(transport Transport) {
+	for me.ctx.Err() == nil {
+		c, err := transport.Accept(me.ctx)
 		if err != nil {
 			logger.Verbosef("accept error %s\n", err.Error())
 			continue
 		}
-		go func(c Client) {
+		accepted := me.loop(func() {
 			err := me.acceptNeighborConnection(c)
 			if err != nil {
 				logger.Debugf("accept neighbor %s error %s\n", c.RemoteAddr().String(), err.Error())
 			}
-		}(c)
+		})
+		if !accepted {
+			c.Close()
+		}
 	}
-
-	logger.Printf("ListenNeighbors(%s, %s) DONE\n", me.IdForNetwork, me.Address)
-	return nil
 }
 
 func (me *Peer) openPeerStreamLoop(p *Peer) {
 	defer close(p.ops)
 
 	var resend *ChanMsg
-	for !me.closing && !p.closing {
+	for p.ctx.Err() == nil {
 		msg, err := me.openPeerStream(p, resend)
 		if err != nil {
 			logger.Verbosef("neighbor open stream %s error %s\n", p.Address, err.Error())
+			p.stats.updateError(err)
 		}
 		resend = msg
-		time.Sleep(1 * time.Second)
+		util.Sleep(p.ctx, time.Second)
 	}
 }
 
 func (me *Peer) openPeerStream(p *Peer, resend *ChanMsg) (*ChanMsg, error) {
 	logger.Verbosef("OPEN PEER STREAM %s\n", p.Address)
-	transport, err := NewQuicClient(p.Address)
+	transport, err := me.factory.NewClient(p.Address)
 	if err != nil {
 		return nil, err
 	}
-	client, err := transport.Dial(me.ctx)
+	client, err := me.dial(p.ctx, transport)
+	me.reachPeer(p.IdForNetwork, p.Address, err)
 	if err != nil {
 		return nil, err
 	}
 	defer client.Close()
+	defer p.stats.connect(true)()
+	caps := p.Capabilities()
+	setClientCompression(client, caps.Compression(), caps.Dictionary())
+	client = &meteredClient{Client: client, stats: p.stats}
+	client = me.recordClient(p.IdForNetwork, client)
 	logger.Verbosef("DIAL PEER STREAM %s\n", p.Address)
 
-	err = client.Send(buildAuthenticationMessage(me.handle.BuildAuthenticationMessage()))
+	err = me.sendAuthentication(client, p.capabilities.get())
+	if err != nil {
+		return nil, err
+	}
+	err = client.Send(buildCapabilitiesMessage(me.local))
+	if err != nil {
+		return nil, err
+	}
+	err = client.Send(buildPingMessage(p.clock.ping(time.Now())))
 	if err != nil {
 		return nil, err
 	}
@@ -270,8 +427,11 @@ func (me *Peer) openPeerStream(p *Peer, resend *ChanMsg) (*ChanMsg, error) {
 	gossipNeighborsTicker := time.NewTicker(time.Duration(config.SnapshotRoundGap * 100))
 	defer gossipNeighborsTicker.Stop()
 
-	for !me.closing && !p.closing {
-		gd, hd, nd := false, false, false
+	pingTicker := time.NewTicker(clockPingPeriod)
+	defer pingTicker.Stop()
+
+	for p.ctx.Err() == nil {
+		gd, hd, nd, ld := false, false, false, false
 
 		select {
 		case <-graphTicker.C:
@@ -280,9 +440,18 @@ func (me *Peer) openPeerStream(p *Peer, resend *ChanMsg) (*ChanMsg, error) {
 			if err != nil {
 				return nil, err
 			}
+		case <-pingTicker.C:
+			msg := buildPingMessage(p.clock.ping(time.Now()))
+			err := client.Send(msg)
+			if err != nil {
+				return nil, err
+			}
 		case <-gossipNeighborsTicker.C:
 			if me.gossipNeighbors {
 				msg := buildGossipNeighborsMessage(me.neighbors.Slice())
+				if p.Capabilities().Supports(PeerMessageTypePeerRecords) {
+					msg = buildPeerRecordsMessage(me.peerRecords())
+				}
 				err := client.Send(msg)
 				if err != nil {
 					return nil, err
@@ -299,7 +468,7 @@ func (me *Peer) openPeerStream(p *Peer, resend *ChanMsg) (*ChanMsg, error) {
 			hd = true
 		} else {
 			msg := item.(*ChanMsg)
-			if !me.snapshotsCaches.contains(msg.key, time.Minute) {
+			if !me.snapshotsCaches.contains(msg.key, time.Minute) && p.accepts(msg.data) {
 				err := client.Send(msg.data)
 				if err != nil {
 					return msg, err
@@ -318,7 +487,26 @@ func (me *Peer) openPeerStream(p *Peer, resend *ChanMsg) (*ChanMsg, error) {
 			nd = true
 		} else {
 			msg := item.(*ChanMsg)
-			if !me.snapshotsCaches.contains(msg.key, time.Minute) {
+			if !me.snapshotsCaches.contains(msg.key, time.Minute) && p.accepts(msg.data) {
+				err := client.Send(msg.data)
+				if err != nil {
+					return msg, err
+				}
+				me.snapshotsCaches.store(msg.key, time.Now())
+			}
+		}
+		if !nd {
+			continue
+		}
+
+		item, err = p.lowRing.Poll(false)
+		if err != nil {
+			return nil, err
+		} else if item == nil {
+			ld = true
+		} else {
+			msg := item.(*ChanMsg)
+			if !me.snapshotsCaches.contains(msg.key, time.Minute) && p.accepts(msg.data) {
 				err := client.Send(msg.data)
 				if err != nil {
 					return msg, err
@@ -327,29 +515,45 @@ func (me *Peer) openPeerStream(p *Peer, resend *ChanMsg) (*ChanMsg, error) {
 			}
 		}
 
-		if gd && hd && nd {
-			time.Sleep(100 * time.Millisecond)
+		if gd && hd && nd && ld {
+			util.Sleep(p.ctx, 100*time.Millisecond)
 		}
 	}
 
 	return nil, fmt.Errorf("PEER DONE")
 }
 
-func (me *Peer) acceptNeighborConnection(client Client) error {
-	done := make(chan bool, 1)
+func (me *Peer) acceptNeighborConnection(client Client) (err error) {
+	ctx, cancel := context.WithCancel(me.ctx)
 	receive := make(chan *PeerMessage, 1024)
 
-	defer func() {
+	// the receive blocks until the read deadline, so close the client to
+	// stop the connection as soon as the peer torn down
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		<-ctx.Done()
 		client.Close()
-		done <- true
 	}()
+	defer wg.Wait()
+	defer cancel()
 
-	peer, err := me.authenticateNeighbor(client)
+	peer, err := me.authenticateNeighbor(ctx, client)
 	if err != nil {
 		return fmt.Errorf("peer authentication error %s", err.Error())
 	}
 
-	go me.handlePeerMessage(peer, receive, done)
+	defer peer.stats.connect(false)()
+	defer func() { peer.stats.updateError(err) }()
+	client = &meteredClient{Client: client, stats: peer.stats}
+	client = me.recordClient(peer.IdForNetwork, client)
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		me.handlePeerMessage(ctx, peer, receive)
+	}()
 
 	for {
 		data, err := client.Receive()
@@ -358,8 +562,15 @@ func (me *Peer) acceptNeighborConnection(client Client) error {
 		}
 		msg, err := parseNetworkMessage(data)
 		if err != nil {
+			me.PenalizeNeighbor(peer.IdForNetwork, PeerPenaltyMalformedMessage, err.Error())
 			return fmt.Errorf("parseNetworkMessage %s %s", peer.IdForNetwork, err.Error())
 		}
+		if me.scores.banned(peer.IdForNetwork, time.Now()) {
+			return fmt.Errorf("peer banned %s", peer.IdForNetwork)
+		}
+		if me.duplicatedMessage(peer.IdForNetwork, msg.Type, data) {
+			me.PenalizeNeighbor(peer.IdForNetwork, PeerPenaltyDuplicateMessage, "duplicated message")
+		}
 
 		select {
 		case receive <- msg:
@@ -369,9 +580,9 @@ func (me *Peer) acceptNeighborConnection(client Client) error {
 	}
 }
 
-func (me *Peer) authenticateNeighbor(client Client) (*Peer, error) {
+func (me *Peer) authenticateNeighbor(ctx context.Context, client Client) (*Peer, error) {
 	var peer *Peer
-	auth := make(chan error)
+	auth := make(chan error, 1)
 	go func() {
 		data, err := client.Receive()
 		if err != nil {
@@ -387,12 +598,34 @@ func (me *Peer) authenticateNeighbor(client Client) (*Peer, error) {
 			auth <- fmt.Errorf("peer authentication invalid message type %d", msg.Type)
 			return
 		}
+		binding, err := client.Binding()
+		if err != nil {
+			auth <- err
+			return
+		}
+		certified, err := client.SignerId()
+		if err != nil {
+			auth <- err
+			return
+		}
 
-		id, addr, err := me.handle.Authenticate(msg.Auth)
+		// the id is only returned with the errors if the message is signed
+		// with the binding of this connection, so a message replayed from
+		// another connection can't get its signer penalized
+		id, addr, err := me.handle.Authenticate(msg.Auth, binding, certified)
+		if err != nil && id.HasValue() {
+			me.PenalizeNeighbor(id, PeerPenaltyAuthentication, err.Error())
+		}
 		if err != nil {
 			auth <- err
 			return
 		}
+		// the certificate proves the connection is from its signer, so the
+		// authentication must be signed by the same node
+		if certified.HasValue() && certified != id {
+			auth <- fmt.Errorf("peer authentication certificate mismatch %s %s", id, certified)
+			return
+		}
 
 		peer, err = me.AddNeighbor(id, addr)
 		if err != nil {
@@ -402,13 +635,19 @@ func (me *Peer) authenticateNeighbor(client Client) (*Peer, error) {
 		}
 	}()
 
+	timer := time.NewTimer(3 * time.Second)
+	defer timer.Stop()
+
 	select {
 	case err := <-auth:
 		if err != nil {
 			client.Close()
 			return nil, fmt.Errorf("peer authentication failed %s", err.Error())
 		}
-	case <-time.After(3 * time.Second):
+	case <-ctx.Done():
+		client.Close()
+		return nil, ctx.Err()
+	case <-timer.C:
 		client.Close()
 		return nil, fmt.Errorf("peer authentication timeout")
 	}
@@ -493,6 +732,15 @@ func (m *neighborMap) Set(key crypto.Hash, v *Peer) {
 	m.m[key] = v
 }
 
+func (m *neighborMap) Delete(key crypto.Hash) *Peer {
+	m.Lock()
+	defer m.Unlock()
+
+	v := m.m[key]
+	delete(m.m, key)
+	return v
+}
+
 func (m *neighborMap) Slice() []*Peer {
 	m.Lock()
 	defer m.Unlock()
@@ -0,0 +1,69 @@
+package network
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/MixinNetwork/mixin/crypto"
+	"github.com/VictoriaMetrics/fastcache"
+	"github.com/stretchr/testify/assert"
+)
+
+type teardownSyncHandle struct {
+	*recordSyncHandle
+}
+
+func (h *teardownSyncHandle) BuildGraph() []*SyncPoint {
+	return nil
+}
+
+func TestPeerTeardown(t *testing.T) {
+	assert := assert.New(t)
+
+	a, b, c := "127.0.0.1:7201", "127.0.0.1:7202", "127.0.0.1:7203"
+	mn := NewMemoryNetwork(7)
+	handle := &teardownSyncHandle{&recordSyncHandle{
+		cache:   fastcache.New(16 * 1024 * 1024),
+		records: make(map[crypto.Hash][]byte),
+	}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	me := NewPeer(ctx, handle, crypto.NewHash([]byte("local")), a, false)
+	me.SetTransportFactory(mn.Endpoint(a))
+	listened := make(chan error)
+	go func() {
+		listened <- me.ListenNeighbors(nil)
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	trans, err := mn.Endpoint(c).NewClient(a)
+	assert.Nil(err)
+	client, err := trans.Dial(context.Background())
+	assert.Nil(err)
+	defer client.Close()
+	neighbor, err := me.AddNeighbor(crypto.NewHash([]byte("remote")), b)
+	assert.Nil(err)
+	assert.Nil(me.PingNeighbor(b))
+	time.Sleep(100 * time.Millisecond)
+
+	served := &PeerMessage{Type: PeerMessageTypeSnapshotRangeRequest, Range: &SnapshotRange{Count: 1}}
+	me.handleMessage(neighbor, served)
+
+	start := time.Now()
+	cancel()
+	assert.NotNil(neighbor.ctx.Err())
+	me.Teardown()
+	assert.True(time.Since(start) < time.Second)
+	select {
+	case err := <-listened:
+		assert.Nil(err)
+	case <-time.After(time.Second):
+		t.Fatal("ListenNeighbors not returned after teardown")
+	}
+	assert.False(me.loop(func() {}))
+	me.handleMessage(neighbor, served)
+	assert.Equal(int32(0), atomic.LoadInt32(&neighbor.serving))
+	assert.Nil(me.ListenNeighbors(nil))
+}
@@ -0,0 +1,392 @@
+package network
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/MixinNetwork/mixin/common"
+	"github.com/MixinNetwork/mixin/config"
+	"github.com/MixinNetwork/mixin/crypto"
+	"github.com/MixinNetwork/mixin/logger"
+)
+
+// The topological orders are local to each node, so the lagging node pulls
+// the rounds of each chain, which are the same on all nodes. The rounds are
+// requested from several neighbors in parallel, buffered and applied in
+// order, and only within a window ahead of the local final round, because
+// the chain final pool drops the rounds too far away. The chains near the
+// head are left to the push sync of the neighbors.
+const (
+	pullRangeRounds  = 10
+	pullWindowRounds = config.SnapshotSyncRoundThreshold
+	pullHeadDistance = config.SnapshotSyncRoundThreshold
+	pullRangeTimeout = 10 * time.Second
+	pullRangeBytes   = 4 * 1024 * 1024
+	pullServeLimit   = 2
+)
+
+type SnapshotRange struct {
+	NodeId       crypto.Hash
+	Start        uint64
+	Count        uint64
+	Snapshots    []*common.Snapshot
+	Transactions [][]byte
+}
+
+type pullRequest struct {
+	peerId crypto.Hash
+	nodeId crypto.Hash
+	start  uint64
+	count  uint64
+	at     time.Time
+}
+
+type pullChain struct {
+	next     uint64
+	applied  uint64
+	progress time.Time
+	requests map[uint64]*pullRequest
+	ranges   map[uint64]*SnapshotRange
+}
+
+type rangeSync struct {
+	sync.Mutex
+	apply  sync.Mutex
+	chains map[crypto.Hash]*pullChain
+}
+
+func newRangeSync() *rangeSync {
+	return &rangeSync{chains: make(map[crypto.Hash]*pullChain)}
+}
+
+// schedule returns the new requests of the chain, the next is the first
+// round not finalized locally, and the remotes are the final rounds of the
+// chain from the neighbors which support the range sync.
+func (rs *rangeSync) schedule(nodeId crypto.Hash, next uint64, remotes map[crypto.Hash]uint64, now time.Time) []*pullRequest {
+	rs.Lock()
+	defer rs.Unlock()
+
+	var head uint64
+	for _, r := range remotes {
+		if r > head {
+			head = r
+		}
+	}
+	if head < next+pullHeadDistance {
+		delete(rs.chains, nodeId)
+		return nil
+	}
+	pc := rs.chains[nodeId]
+	if pc == nil {
+		pc = &pullChain{
+			next:     next,
+			applied:  next,
+			progress: now,
+			requests: make(map[uint64]*pullRequest),
+			ranges:   make(map[uint64]*SnapshotRange),
+		}
+		rs.chains[nodeId] = pc
+	}
+	if next > pc.next {
+		pc.next, pc.progress = next, now
+	}
+	if pc.applied < next {
+		pc.applied = next
+	} else if pc.applied > next && now.Sub(pc.progress) > pullRangeTimeout*3 {
+		logger.Verbosef("rangeSync.schedule(%s) stalled %d %d\n", nodeId, next, pc.applied)
+		pc.applied, pc.progress = next, now
+		pc.ranges = make(map[uint64]*SnapshotRange)
+	}
+	for start, r := range pc.ranges {
+		if start+r.Count <= pc.applied {
+			delete(pc.ranges, start)
+		}
+	}
+	for start, req := range pc.requests {
+		if start+req.count <= pc.applied || now.Sub(req.at) > pullRangeTimeout {
+			delete(pc.requests, start)
+		}
+	}
+
+	var requests []*pullRequest
+	for cursor := pc.applied; cursor < next+pullWindowRounds && cursor <= head; {
+		if end := pc.covered(cursor); end > cursor {
+			cursor = end
+			continue
+		}
+		end := cursor - cursor%pullRangeRounds + pullRangeRounds
+		if end > next+pullWindowRounds {
+			end = next + pullWindowRounds
+		}
+		if end > head+1 {
+			end = head + 1
+		}
+		var candidates []crypto.Hash
+		for id, r := range remotes {
+			if r >= end-1 {
+				candidates = append(candidates, id)
+			}
+		}
+		if len(candidates) == 0 {
+			break
+		}
+		sort.Slice(candidates, func(i, j int) bool {
+			return candidates[i].String() < candidates[j].String()
+		})
+		req := &pullRequest{
+			peerId: candidates[int(cursor/pullRangeRounds)%len(candidates)],
+			nodeId: nodeId,
+			start:  cursor,
+			count:  end - cursor,
+			at:     now,
+		}
+		pc.requests[cursor] = req
+		requests = append(requests, req)
+		cursor = end
+	}
+	return requests
+}
+
+// covered returns the end of the request or range which covers the round,
+// or zero if not requested.
+func (pc *pullChain) covered(round uint64) uint64 {
+	for start, req := range pc.requests {
+		if start <= round && round < start+req.count {
+			return start + req.count
+		}
+	}
+	for start, r := range pc.ranges {
+		if start <= round && round < start+r.Count {
+			return start + r.Count
+		}
+	}
+	return 0
+}
+
+// receive buffers the range of a request, and returns the snapshots and
+// their transactions ready to apply in order.
+func (rs *rangeSync) receive(peerId crypto.Hash, r *SnapshotRange) ([]*common.Snapshot, [][]byte, error) {
+	rs.Lock()
+	defer rs.Unlock()
+
+	pc := rs.chains[r.NodeId]
+	if pc == nil {
+		return nil, nil, nil
+	}
+	req := pc.requests[r.Start]
+	if req == nil || req.peerId != peerId {
+		return nil, nil, nil
+	}
+	delete(pc.requests, r.Start)
+	if r.Count > req.count {
+		return nil, nil, fmt.Errorf("invalid range count %d %d", r.Count, req.count)
+	}
+	rounds := make(map[uint64]bool)
+	for _, s := range r.Snapshots {
+		if s.NodeId != r.NodeId || s.RoundNumber < r.Start || s.RoundNumber >= r.Start+r.Count {
+			return nil, nil, fmt.Errorf("invalid range snapshot %s:%d", s.NodeId, s.RoundNumber)
+		}
+		rounds[s.RoundNumber] = true
+	}
+	// each finalized round has snapshots, a counted round without any would
+	// move the applied round past the rounds never applied
+	if uint64(len(rounds)) != r.Count {
+		return nil, nil, fmt.Errorf("invalid range rounds %d %d", len(rounds), r.Count)
+	}
+	if r.Count == 0 {
+		return nil, nil, nil
+	}
+	pc.ranges[r.Start] = r
+
+	var snapshots []*common.Snapshot
+	var transactions [][]byte
+	for {
+		var next *SnapshotRange
+		for start, r := range pc.ranges {
+			if start <= pc.applied && pc.applied < start+r.Count {
+				next = r
+				break
+			}
+		}
+		if next == nil {
+			return snapshots, transactions, nil
+		}
+		for i, s := range next.Snapshots {
+			if s.RoundNumber >= pc.applied {
+				snapshots = append(snapshots, s)
+				transactions = append(transactions, next.Transactions[i])
+			}
+		}
+		delete(pc.ranges, next.Start)
+		pc.applied = next.Start + next.Count
+	}
+}
+
+func (me *Peer) pullSyncLoop() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		me.pullSync(time.Now())
+		select {
+		case <-me.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (me *Peer) pullSync(now time.Time) {
+	local := make(map[crypto.Hash]uint64)
+	for _, p := range me.handle.BuildGraph() {
+		local[p.NodeId] = p.Number + 1
+	}
+	remotes := make(map[crypto.Hash]map[crypto.Hash]uint64)
+	for _, p := range me.neighbors.Slice() {
+		if !p.Capabilities().Supports(PeerMessageTypeSnapshotRangeRequest) {
+			continue
+		}
+		for _, r := range p.stats.lastGraph() {
+			if remotes[r.NodeId] == nil {
+				remotes[r.NodeId] = make(map[crypto.Hash]uint64)
+			}
+			remotes[r.NodeId][p.IdForNetwork] = r.Number
+		}
+	}
+	for nodeId, rm := range remotes {
+		for _, req := range me.pulls.schedule(nodeId, local[nodeId], rm, now) {
+			logger.Verbosef("network.pull pullSync %s %s:%d:%d\n", req.peerId, nodeId, req.start, req.count)
+			me.sendSnapshotRangeRequest(req)
+		}
+	}
+}
+
+func (me *Peer) sendSnapshotRangeRequest(req *pullRequest) error {
+	data := buildSnapshotRangeRequestMessage(req.nodeId, req.start, req.count)
+	key := rangeMessageKey(req.peerId, data, req.at)
+	return me.sendSnapshotMessageToPeer(req.peerId, key, PeerMessageTypeSnapshotRangeRequest, data)
+}
+
+func (me *Peer) handleSnapshotRange(peer *Peer, r *SnapshotRange) {
+	me.pulls.apply.Lock()
+	defer me.pulls.apply.Unlock()
+
+	snapshots, transactions, err := me.pulls.receive(peer.IdForNetwork, r)
+	if err != nil {
+		me.PenalizeNeighbor(peer.IdForNetwork, PeerPenaltyMalformedMessage, err.Error())
+		return
+	}
+	logger.Verbosef("network.pull handleSnapshotRange %s %s:%d:%d %d\n", peer.IdForNetwork, r.NodeId, r.Start, r.Count, len(snapshots))
+	for i, s := range snapshots {
+		ver, err := common.UnmarshalVersionedTransaction(transactions[i])
+		if err != nil || ver.PayloadHash() != s.Transaction {
+			me.PenalizeNeighbor(peer.IdForNetwork, PeerPenaltyMalformedMessage, "invalid range transaction")
+			return
+		}
+		err = me.handle.CachePutTransaction(peer.IdForNetwork, ver)
+		if err != nil {
+			logger.Printf("network.pull handleSnapshotRange CachePutTransaction %s error %s\n", s.Transaction, err)
+			return
+		}
+		me.handle.VerifyAndQueueAppendSnapshotFinalization(peer.IdForNetwork, s)
+	}
+}
+
+// serveSnapshotRange reads the finalized rounds of the request until the
+// first round not finalized or the bytes limit, and sends them in the low
+// ring with the sync rate limits. It runs in the peer loops, so the teardown
+// waits it, and it stops when the neighbor is disconnected.
+func (me *Peer) serveSnapshotRange(peer *Peer, req *SnapshotRange) {
+	if atomic.AddInt32(&peer.serving, 1) > pullServeLimit {
+		atomic.AddInt32(&peer.serving, -1)
+		logger.Verbosef("network.pull serveSnapshotRange %s busy\n", peer.IdForNetwork)
+		return
+	}
+	defer atomic.AddInt32(&peer.serving, -1)
+
+	limit := int(peer.Capabilities().MaxMessageSize) / 2
+	if limit > pullRangeBytes {
+		limit = pullRangeBytes
+	}
+	r := &SnapshotRange{NodeId: req.NodeId, Start: req.Start}
+	size := 0
+	for i := req.Start; i < req.Start+req.Count && size < limit; i++ {
+		if peer.ctx.Err() != nil {
+			return
+		}
+		ss, err := me.cacheReadSnapshotsForNodeRound(req.NodeId, i)
+		if err != nil || len(ss) == 0 {
+			break
+		}
+		for _, s := range ss {
+			ver, err := me.handle.ReadTransaction(s.Transaction)
+			if err != nil || ver == nil {
+				logger.Printf("network.pull serveSnapshotRange ReadTransaction %s %v\n", s.Transaction, err)
+				return
+			}
+			tx := ver.Marshal()
+			size += len(tx)
+			r.Snapshots = append(r.Snapshots, &s.Snapshot)
+			r.Transactions = append(r.Transactions, tx)
+		}
+		r.Count += 1
+	}
+
+	data := buildSnapshotRangeResponseMessage(r)
+	if !me.waitSyncLimit(peer, len(data)) {
+		return
+	}
+	hash := rangeMessageKey(peer.IdForNetwork, data, time.Now())
+	key := append(peer.IdForNetwork[:], hash[:]...)
+	key = append(key, 'S', 'N', 'A', 'P', PeerMessageTypeSnapshotRangeResponse)
+	success, _ := peer.lowRing.Offer(&ChanMsg{key, data})
+	if !success {
+		logger.Verbosef("network.pull serveSnapshotRange %s low ring full\n", peer.IdForNetwork)
+	}
+}
+
+// pulling returns the chains on which the neighbor supports the range sync
+// and is too far behind, then it pulls them instead of the topology push.
+func (me *Peer) pulling(p *Peer, remote map[crypto.Hash]*SyncPoint) map[crypto.Hash]bool {
+	if !p.Capabilities().Supports(PeerMessageTypeSnapshotRangeRequest) {
+		return nil
+	}
+	chains := make(map[crypto.Hash]bool)
+	for _, l := range me.handle.BuildGraph() {
+		var number uint64
+		if r := remote[l.NodeId]; r != nil {
+			number = r.Number
+		}
+		if l.Number > number+pullHeadDistance {
+			chains[l.NodeId] = true
+		}
+	}
+	return chains
+}
+
+// rangeMessageKey is unique for each attempt, so a request timed out could
+// be sent again to the same neighbor.
+func rangeMessageKey(peerId crypto.Hash, data []byte, at time.Time) crypto.Hash {
+	ts := make([]byte, 8)
+	binary.BigEndian.PutUint64(ts, uint64(at.UnixNano()))
+	key := append(peerId[:], ts...)
+	return crypto.NewHash(append(key, data...))
+}
+
+func buildSnapshotRangeRequestMessage(nodeId crypto.Hash, start, count uint64) []byte {
+	buf := make([]byte, 16)
+	binary.BigEndian.PutUint64(buf, start)
+	binary.BigEndian.PutUint64(buf[8:], count)
+	data := []byte{PeerMessageTypeSnapshotRangeRequest}
+	data = append(data, nodeId[:]...)
+	return append(data, buf...)
+}
+
+func buildSnapshotRangeResponseMessage(r *SnapshotRange) []byte {
+	data := common.MsgpackMarshalPanic(r)
+	return append([]byte{PeerMessageTypeSnapshotRangeResponse}, data...)
+}
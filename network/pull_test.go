@@ -0,0 +1,151 @@
+package network
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/MixinNetwork/mixin/common"
+	"github.com/MixinNetwork/mixin/crypto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRangeSync(t *testing.T) {
+	assert := assert.New(t)
+
+	nodeId := crypto.NewHash([]byte("chain"))
+	p1, p2 := crypto.NewHash([]byte("p1")), crypto.NewHash([]byte("p2"))
+	rs := newRangeSync()
+	now := time.Now()
+
+	reqs := rs.schedule(nodeId, 5, map[crypto.Hash]uint64{p1: 50, p2: 80}, now)
+	assert.Len(reqs, 0)
+	assert.Len(rs.chains, 0)
+
+	remotes := map[crypto.Hash]uint64{p1: 300, p2: 150}
+	reqs = rs.schedule(nodeId, 5, remotes, now)
+	assert.Len(reqs, 11)
+	assert.Equal(uint64(5), reqs[0].start)
+	assert.Equal(uint64(5), reqs[0].count)
+	assert.Equal(uint64(100), reqs[10].start)
+	assert.Equal(uint64(5), reqs[10].count)
+	peers := make(map[crypto.Hash]int)
+	for i, req := range reqs {
+		peers[req.peerId] += 1
+		if i > 0 {
+			assert.Equal(reqs[i-1].start+reqs[i-1].count, req.start)
+		}
+	}
+	assert.Len(peers, 2)
+	assert.Len(rs.schedule(nodeId, 5, remotes, now), 0)
+
+	ss, txs, err := rs.receive(reqs[1].peerId, testSnapshotRange(nodeId, 10, 10))
+	assert.Nil(err)
+	assert.Len(ss, 0)
+	assert.Len(txs, 0)
+	ss, _, err = rs.receive(reqs[1].peerId, testSnapshotRange(nodeId, 10, 10))
+	assert.Nil(err)
+	assert.Len(ss, 0)
+
+	other := p1
+	if reqs[0].peerId == p1 {
+		other = p2
+	}
+	_, _, err = rs.receive(other, testSnapshotRange(nodeId, 5, 5))
+	assert.Nil(err)
+	_, _, err = rs.receive(reqs[0].peerId, testSnapshotRange(nodeId, 5, 6))
+	assert.NotNil(err)
+
+	reqs = rs.schedule(nodeId, 5, remotes, now.Add(pullRangeTimeout/2))
+	assert.Len(reqs, 1)
+	assert.Equal(uint64(5), reqs[0].start)
+	empty := &SnapshotRange{NodeId: nodeId, Start: 5, Count: 5}
+	_, _, err = rs.receive(reqs[0].peerId, empty)
+	assert.NotNil(err)
+	assert.Equal(uint64(5), rs.chains[nodeId].applied)
+
+	reqs = rs.schedule(nodeId, 5, remotes, now.Add(pullRangeTimeout+time.Second))
+	assert.Len(reqs, 10)
+	assert.Equal(uint64(5), reqs[0].start)
+	assert.Equal(uint64(20), reqs[1].start)
+	ss, txs, err = rs.receive(reqs[0].peerId, testSnapshotRange(nodeId, 5, 3))
+	assert.Nil(err)
+	assert.Len(ss, 3)
+	assert.Len(txs, 3)
+	for i, s := range ss {
+		assert.Equal(uint64(5+i), s.RoundNumber)
+	}
+	ss, _, err = rs.receive(reqs[1].peerId, testSnapshotRange(nodeId, 20, 10))
+	assert.Nil(err)
+	assert.Len(ss, 0)
+
+	reqs = rs.schedule(nodeId, 8, remotes, now.Add(pullRangeTimeout+2*time.Second))
+	assert.Len(reqs, 2)
+	assert.Equal(uint64(8), reqs[0].start)
+	assert.Equal(uint64(2), reqs[0].count)
+	assert.Equal(uint64(105), reqs[1].start)
+	assert.Equal(uint64(3), reqs[1].count)
+	ss, _, err = rs.receive(reqs[0].peerId, testSnapshotRange(nodeId, 8, 2))
+	assert.Nil(err)
+	assert.Len(ss, 22)
+	assert.Equal(uint64(8), ss[0].RoundNumber)
+	assert.Equal(uint64(29), ss[21].RoundNumber)
+	assert.Equal(uint64(30), rs.chains[nodeId].applied)
+
+	reqs = rs.schedule(nodeId, 8, remotes, now.Add(pullRangeTimeout*5))
+	assert.Equal(uint64(8), rs.chains[nodeId].applied)
+	assert.Equal(uint64(8), reqs[0].start)
+
+	data := buildSnapshotRangeRequestMessage(nodeId, 8, 2)
+	msg, err := parseNetworkMessage(data)
+	assert.Nil(err)
+	assert.Equal(nodeId, msg.Range.NodeId)
+	assert.Equal(uint64(8), msg.Range.Start)
+	assert.Equal(uint64(2), msg.Range.Count)
+	_, err = parseNetworkMessage(buildSnapshotRangeRequestMessage(nodeId, 8, pullRangeRounds+1))
+	assert.NotNil(err)
+	r := testSnapshotRange(nodeId, 8, 2)
+	msg, err = parseNetworkMessage(buildSnapshotRangeResponseMessage(r))
+	assert.Nil(err)
+	assert.Equal(r.Transactions, msg.Range.Transactions)
+	assert.Equal(r.Snapshots[1].RoundNumber, msg.Range.Snapshots[1].RoundNumber)
+	r.Transactions = r.Transactions[1:]
+	_, err = parseNetworkMessage(buildSnapshotRangeResponseMessage(r))
+	assert.NotNil(err)
+}
+
+func TestPulling(t *testing.T) {
+	assert := assert.New(t)
+
+	lagging, synced := crypto.NewHash([]byte("lagging")), crypto.NewHash([]byte("synced"))
+	handle := &testSyncHandle{graph: []*SyncPoint{
+		{NodeId: lagging, Number: pullHeadDistance + 20},
+		{NodeId: synced, Number: pullHeadDistance + 20},
+	}}
+	me := NewPeer(context.Background(), handle, crypto.NewHash([]byte("local")), "127.0.0.1:7011", false)
+	p := NewPeer(context.Background(), nil, crypto.NewHash([]byte("remote")), "127.0.0.1:7012", false)
+	remote := map[crypto.Hash]*SyncPoint{
+		lagging: {NodeId: lagging, Number: 10},
+		synced:  {NodeId: synced, Number: pullHeadDistance + 18},
+	}
+	assert.Len(me.pulling(p, remote), 0)
+
+	me.negotiateCapabilities(p, LocalCapabilities())
+	pulled := me.pulling(p, remote)
+	assert.Len(pulled, 1)
+	assert.True(pulled[lagging])
+	assert.False(pulled[synced])
+}
+
+func testSnapshotRange(nodeId crypto.Hash, start, count uint64) *SnapshotRange {
+	r := &SnapshotRange{NodeId: nodeId, Start: start, Count: count}
+	for i := start; i < start+count; i++ {
+		r.Snapshots = append(r.Snapshots, &common.Snapshot{
+			Version:     common.SnapshotVersion,
+			NodeId:      nodeId,
+			RoundNumber: i,
+		})
+		r.Transactions = append(r.Transactions, []byte{byte(i)})
+	}
+	return r
+}
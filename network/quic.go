@@ -14,6 +14,7 @@ import (
 	"time"
 
 	"github.com/MixinNetwork/mixin/common"
+	"github.com/MixinNetwork/mixin/crypto"
 	"github.com/klauspost/compress/zstd"
 	"github.com/lucas-clemente/quic-go"
 )
@@ -36,12 +37,16 @@ type QuicClient struct {
 	receive      quic.ReceiveStream
 	zstdZipper   *zstd.Encoder
 	zstdUnzipper *zstd.Decoder
+	compression  uint8
+	dictionary   uint32
+	networkId    crypto.Hash
 }
 
 type QuicTransport struct {
-	addr     string
-	tls      *tls.Config
-	listener quic.Listener
+	addr      string
+	tls       *tls.Config
+	listener  quic.Listener
+	networkId crypto.Hash
 }
 
 func NewQuicServer(addr string) (*QuicTransport, error) {
@@ -63,7 +68,7 @@ func NewQuicClient(addr string) (*QuicTransport, error) {
 }
 
 func (t *QuicTransport) Dial(ctx context.Context) (Client, error) {
-	sess, err := quic.DialAddr(t.addr, t.tls, &quic.Config{
+	sess, err := quic.DialAddrContext(ctx, t.addr, t.tls, &quic.Config{
 		MaxIncomingStreams:   MaxIncomingStreams,
 		HandshakeIdleTimeout: HandshakeTimeout,
 		MaxIdleTimeout:       IdleTimeout,
@@ -74,12 +79,16 @@ func (t *QuicTransport) Dial(ctx context.Context) (Client, error) {
 	}
 	stm, err := sess.OpenUniStreamSync(ctx)
 	if err != nil {
+		sess.CloseWithError(0, err.Error())
 		return nil, err
 	}
 	return &QuicClient{
-		session:    sess,
-		send:       stm,
-		zstdZipper: common.NewZstdEncoder(1),
+		session:     sess,
+		send:        stm,
+		zstdZipper:  common.NewZstdDictionaryEncoder(1, common.ZstdDictionaryVersionZero),
+		compression: TransportCompressionMethod,
+		dictionary:  common.ZstdDictionaryVersionZero,
+		networkId:   t.networkId,
 	}, nil
 }
 
@@ -106,14 +115,19 @@ func (t *QuicTransport) Accept(ctx context.Context) (Client, error) {
 	if err != nil {
 		return nil, err
 	}
+	// a session without the stream opened must not block the accept loop
+	ctx, cancel := context.WithTimeout(ctx, HandshakeTimeout)
+	defer cancel()
 	stm, err := sess.AcceptUniStream(ctx)
 	if err != nil {
+		sess.CloseWithError(0, err.Error())
 		return nil, err
 	}
 	return &QuicClient{
 		session:      sess,
 		receive:      stm,
 		zstdUnzipper: common.NewZstdDecoder(1),
+		networkId:    t.networkId,
 	}, nil
 }
 
@@ -121,6 +135,16 @@ func (c *QuicClient) RemoteAddr() net.Addr {
 	return c.session.RemoteAddr()
 }
 
+func (c *QuicClient) Binding() ([]byte, error) {
+	cs := c.session.ConnectionState()
+	return cs.TLS.ExportKeyingMaterial(clientBindingLabel, nil, clientBindingLength)
+}
+
+func (c *QuicClient) SignerId() (crypto.Hash, error) {
+	cs := c.session.ConnectionState()
+	return peerCertificateSigner(cs.TLS.ConnectionState, c.networkId)
+}
+
 func (c *QuicClient) Receive() ([]byte, error) {
 	err := c.receive.SetReadDeadline(time.Now().Add(ReadDeadline))
 	if err != nil {
@@ -140,7 +164,7 @@ func (c *QuicClient) Receive() ([]byte, error) {
 		return nil, fmt.Errorf("quic receive invalid message version %d", m.Version)
 	}
 	m.Compression = header[1]
-	if m.Compression != TransportCompressionZstd {
+	if m.Compression != TransportCompressionZstd && m.Compression != TransportCompressionGzip {
 		return nil, fmt.Errorf("quic receive invalid message compression %d", m.Compression)
 	}
 	m.Size = binary.BigEndian.Uint32(header[2:])
@@ -162,6 +186,8 @@ func (c *QuicClient) Receive() ([]byte, error) {
 	switch m.Compression {
 	case TransportCompressionZstd:
 		m.Data, err = c.zstdUnzipper.DecodeAll(m.Data, nil)
+	case TransportCompressionGzip:
+		m.Data, err = gunzipTransportMessage(m.Data)
 	}
 
 	return m.Data, err
@@ -172,16 +198,24 @@ func (c *QuicClient) Send(data []byte) error {
 		return fmt.Errorf("quic send invalid message size %d", l)
 	}
 
-	switch TransportCompressionMethod {
+	switch c.compression {
 	case TransportCompressionZstd:
 		data = c.zstdZipper.EncodeAll(data, nil)
+	case TransportCompressionGzip:
+		gz, err := gzipTransportMessage(data)
+		if err != nil {
+			return err
+		}
+		data = gz
+	default:
+		return fmt.Errorf("quic send invalid message compression %d", c.compression)
 	}
 
 	err := c.send.SetWriteDeadline(time.Now().Add(WriteDeadline))
 	if err != nil {
 		return err
 	}
-	header := []byte{TransportMessageVersion, TransportCompressionMethod, 0, 0, 0, 0}
+	header := []byte{TransportMessageVersion, c.compression, 0, 0, 0, 0}
 	binary.BigEndian.PutUint32(header[2:], uint32(len(data)))
 	_, err = c.send.Write(header)
 	if err != nil {
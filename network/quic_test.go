@@ -18,6 +18,7 @@ func TestQuic(t *testing.T) {
 	defer serverTrans.Close()
 	err = serverTrans.Listen()
 	assert.Nil(err)
+	bound := make(chan []byte, 1)
 	go func() {
 		server, err := serverTrans.Accept(context.Background())
 		assert.Nil(err)
@@ -25,6 +26,9 @@ func TestQuic(t *testing.T) {
 		msg, err := server.Receive()
 		assert.Nil(err)
 		assert.Equal("hello mixin", string(msg))
+		binding, err := server.Binding()
+		assert.Nil(err)
+		bound <- binding
 	}()
 
 	clientTrans, err := NewQuicClient(addr)
@@ -35,5 +39,9 @@ func TestQuic(t *testing.T) {
 	assert.NotNil(client)
 	err = client.Send([]byte("hello mixin"))
 	assert.Nil(err)
+	binding, err := client.Binding()
+	assert.Nil(err)
+	assert.Len(binding, clientBindingLength)
+	assert.Equal(binding, <-bound)
 	time.Sleep(1 * time.Second)
 }
@@ -0,0 +1,340 @@
+package network
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/MixinNetwork/mixin/common"
+	"github.com/MixinNetwork/mixin/crypto"
+	"github.com/MixinNetwork/mixin/logger"
+)
+
+// A peer record is the addresses of a node signed by its signer key, so the
+// addresses gossiped could not be forged by the neighbors. The records are
+// validated against the node list by the sync handle, then kept in the
+// cache store with the reachability of the node, so the node could dial the
+// healthy peers after restart, and the config peers are only the seeds.
+const (
+	PeerRecordMaxAddresses = 4
+
+	peerRecordLifetime    = 7 * 24 * time.Hour
+	peerRecordRefresh     = time.Hour
+	peerRecordFutureDrift = time.Minute
+	peerRecordsMaxCount   = 1024
+
+	peerBookDialLimit   = 64
+	peerBookMaxFailures = 8
+	peerBookRetryPeriod = time.Hour
+)
+
+type PeerRecord struct {
+	NodeId    crypto.Hash
+	Signer    crypto.Key
+	Addresses []string
+	Timestamp uint64
+	Signature crypto.Signature
+}
+
+// PeerReachability counts the dials to the node, the failures are reset by
+// any successful dial.
+type PeerReachability struct {
+	Successes   uint64
+	Failures    uint64
+	LastSuccess uint64
+	LastFailure uint64
+}
+
+type peerBookEntry struct {
+	Record       *PeerRecord
+	Reachability PeerReachability
+	dirty        bool
+}
+
+type peerBook struct {
+	sync.Mutex
+	entries map[crypto.Hash]*peerBookEntry
+}
+
+func newPeerBook() *peerBook {
+	return &peerBook{entries: make(map[crypto.Hash]*peerBookEntry)}
+}
+
+// Payload is the record without signature, which is signed by the node.
+func (r *PeerRecord) Payload() []byte {
+	p := *r
+	p.Signature = crypto.Signature{}
+	return common.MsgpackMarshalPanic(&p)
+}
+
+func (r *PeerRecord) validate(now time.Time) error {
+	if len(r.Addresses) == 0 || len(r.Addresses) > PeerRecordMaxAddresses {
+		return fmt.Errorf("invalid peer record addresses %d", len(r.Addresses))
+	}
+	ts := time.Unix(0, int64(r.Timestamp))
+	if ts.After(now.Add(peerRecordFutureDrift)) || ts.Add(peerRecordLifetime).Before(now) {
+		return fmt.Errorf("invalid peer record timestamp %d", r.Timestamp)
+	}
+	for _, a := range r.Addresses {
+		if _, _, err := ParseAddress(a); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// update returns true if the record is newer and changes the addresses, or
+// refreshes the old record, the reachability is kept.
+func (b *peerBook) update(r *PeerRecord, now time.Time) (*peerBookEntry, bool) {
+	b.Lock()
+	defer b.Unlock()
+
+	e := b.entries[r.NodeId]
+	if e == nil {
+		e = &peerBookEntry{}
+		b.entries[r.NodeId] = e
+	} else if e.Record.Timestamp >= r.Timestamp {
+		return nil, false
+	} else if equalAddresses(e.Record.Addresses, r.Addresses) &&
+		time.Unix(0, int64(e.Record.Timestamp)).Add(peerRecordRefresh).After(now) {
+		return nil, false
+	}
+	e.Record = r
+	e.dirty = false
+	return e.copy(), true
+}
+
+// reached updates the reachability of the node by the dial result, and
+// returns the entry to persist if the node becomes reachable or not. The
+// ping dials don't know the node, so the node is found by the address.
+func (b *peerBook) reached(id crypto.Hash, addr string, err error, now time.Time) *peerBookEntry {
+	b.Lock()
+	defer b.Unlock()
+
+	e := b.entries[id]
+	if e == nil {
+		for _, be := range b.entries {
+			if containsAddress(be.Record.Addresses, addr) {
+				e = be
+				break
+			}
+		}
+	}
+	if e == nil {
+		return nil
+	}
+
+	r := &e.Reachability
+	flipped := (err == nil) != (r.Failures == 0)
+	if err == nil {
+		r.Successes += 1
+		r.Failures = 0
+		r.LastSuccess = uint64(now.UnixNano())
+	} else {
+		r.Failures += 1
+		r.LastFailure = uint64(now.UnixNano())
+		flipped = flipped || r.Failures == peerBookMaxFailures
+	}
+	e.dirty = !flipped
+	if !flipped {
+		return nil
+	}
+	return e.copy()
+}
+
+// healthy returns the records to dial, the unreachable nodes are skipped
+// until the retry period passed, then the nodes with less failures and more
+// recent success first.
+func (b *peerBook) healthy(now time.Time, limit int) []*PeerRecord {
+	b.Lock()
+	defer b.Unlock()
+
+	var entries []*peerBookEntry
+	for _, e := range b.entries {
+		r := e.Reachability
+		if time.Unix(0, int64(e.Record.Timestamp)).Add(peerRecordLifetime).Before(now) {
+			continue
+		}
+		retry := time.Unix(0, int64(r.LastFailure)).Add(peerBookRetryPeriod)
+		if r.Failures >= peerBookMaxFailures && retry.After(now) {
+			continue
+		}
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i].Reachability, entries[j].Reachability
+		if a.Failures != b.Failures {
+			return a.Failures < b.Failures
+		}
+		if a.LastSuccess != b.LastSuccess {
+			return a.LastSuccess > b.LastSuccess
+		}
+		return entries[i].Record.Timestamp > entries[j].Record.Timestamp
+	})
+	if len(entries) > limit {
+		entries = entries[:limit]
+	}
+	records := make([]*PeerRecord, len(entries))
+	for i, e := range entries {
+		records[i] = e.Record
+	}
+	return records
+}
+
+// dirty returns the entries with reachability not persisted yet.
+func (b *peerBook) dirty() []*peerBookEntry {
+	b.Lock()
+	defer b.Unlock()
+
+	var entries []*peerBookEntry
+	for _, e := range b.entries {
+		if e.dirty {
+			entries = append(entries, e.copy())
+			e.dirty = false
+		}
+	}
+	return entries
+}
+
+func (b *peerBook) set(e *peerBookEntry) {
+	b.Lock()
+	defer b.Unlock()
+
+	b.entries[e.Record.NodeId] = e
+}
+
+func (e *peerBookEntry) copy() *peerBookEntry {
+	c := *e
+	return &c
+}
+
+// UpdatePeerRecord verifies the record by the sync handle, and keeps it in
+// the cache store if newer. It returns true if the record is updated.
+func (me *Peer) UpdatePeerRecord(r *PeerRecord) (bool, error) {
+	if r.NodeId == me.IdForNetwork {
+		return false, nil
+	}
+	now := time.Now()
+	err := r.validate(now)
+	if err != nil {
+		return false, err
+	}
+	err = me.handle.VerifyPeerRecord(r)
+	if err != nil {
+		return false, err
+	}
+	e, updated := me.book.update(r, now)
+	if updated {
+		me.writePeerRecord(e)
+	}
+	return updated, nil
+}
+
+// PingRecordedNeighbors pings the healthy nodes in the peer records, so
+// the node could connect the network without the seeds in config.
+func (me *Peer) PingRecordedNeighbors() {
+	for _, r := range me.book.healthy(time.Now(), peerBookDialLimit) {
+		err := me.PingNeighbor(routableAddress(r.Addresses))
+		if err != nil {
+			logger.Verbosef("PingRecordedNeighbors(%s) error %s\n", r.NodeId, err.Error())
+		}
+	}
+}
+
+// peerRecords are the healthy records and the record of this node to gossip.
+func (me *Peer) peerRecords() []*PeerRecord {
+	records := me.book.healthy(time.Now(), peerRecordsMaxCount-1)
+	if r := me.handle.BuildPeerRecord(); r != nil {
+		records = append(records, r)
+	}
+	return records
+}
+
+func (me *Peer) handlePeerRecords(peer *Peer, records []*PeerRecord) {
+	for _, r := range records {
+		updated, err := me.UpdatePeerRecord(r)
+		if err != nil {
+			logger.Verbosef("handlePeerRecords(%s) %s error %s\n", peer.IdForNetwork, r.NodeId, err.Error())
+			continue
+		}
+		if updated && me.neighbors.Get(r.NodeId) == nil {
+			me.PingNeighbor(routableAddress(r.Addresses))
+		}
+	}
+}
+
+func (me *Peer) reachPeer(id crypto.Hash, addr string, err error) {
+	e := me.book.reached(id, addr, err, time.Now())
+	if e != nil {
+		me.writePeerRecord(e)
+	}
+}
+
+func (me *Peer) writePeerRecord(e *peerBookEntry) {
+	until := time.Unix(0, int64(e.Record.Timestamp)).Add(peerRecordLifetime)
+	err := me.handle.WritePeerRecord(e.Record.NodeId, common.MsgpackMarshalPanic(e), until)
+	if err != nil {
+		logger.Printf("WritePeerRecord(%s) error %s\n", e.Record.NodeId, err.Error())
+	}
+}
+
+// flushPeerRecords persists the reachability not persisted yet, which is
+// only written when the node becomes reachable or not.
+func (me *Peer) flushPeerRecords() {
+	for _, e := range me.book.dirty() {
+		me.writePeerRecord(e)
+	}
+}
+
+func (me *Peer) loadPeerRecords() {
+	records, err := me.handle.ReadPeerRecords()
+	if err != nil {
+		logger.Printf("loadPeerRecords error %s\n", err.Error())
+		return
+	}
+	now := time.Now()
+	for id, data := range records {
+		var e peerBookEntry
+		err := common.MsgpackUnmarshal(data, &e)
+		if err != nil || e.Record == nil || e.Record.NodeId != id {
+			logger.Printf("loadPeerRecords(%s) invalid %v\n", id, err)
+			continue
+		}
+		err = e.Record.validate(now)
+		if err == nil {
+			err = me.handle.VerifyPeerRecord(e.Record)
+		}
+		if err != nil {
+			logger.Verbosef("loadPeerRecords(%s) error %s\n", id, err.Error())
+			continue
+		}
+		me.book.set(&e)
+	}
+}
+
+func buildPeerRecordsMessage(records []*PeerRecord) []byte {
+	data := common.MsgpackMarshalPanic(records)
+	return append([]byte{PeerMessageTypePeerRecords}, data...)
+}
+
+func equalAddresses(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func containsAddress(addresses []string, addr string) bool {
+	for _, a := range addresses {
+		if a == addr {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,161 @@
+package network
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/MixinNetwork/mixin/crypto"
+	"github.com/VictoriaMetrics/fastcache"
+	"github.com/stretchr/testify/assert"
+)
+
+type recordSyncHandle struct {
+	SyncHandle
+	cache   *fastcache.Cache
+	signers map[crypto.Hash]crypto.Key
+	records map[crypto.Hash][]byte
+}
+
+func (h *recordSyncHandle) GetCacheStore() *fastcache.Cache {
+	return h.cache
+}
+
+func (h *recordSyncHandle) ReadPeerBans() (map[crypto.Hash]time.Time, error) {
+	return nil, nil
+}
+
+func (h *recordSyncHandle) BuildPeerRecord() *PeerRecord {
+	return nil
+}
+
+func (h *recordSyncHandle) VerifyPeerRecord(r *PeerRecord) error {
+	signer, found := h.signers[r.NodeId]
+	if !found || signer != r.Signer {
+		return fmt.Errorf("unknown peer %s", r.NodeId)
+	}
+	if !signer.Verify(r.Payload(), r.Signature) {
+		return fmt.Errorf("invalid signature %s", r.NodeId)
+	}
+	return nil
+}
+
+func (h *recordSyncHandle) ReadPeerRecords() (map[crypto.Hash][]byte, error) {
+	return h.records, nil
+}
+
+func (h *recordSyncHandle) WritePeerRecord(peerId crypto.Hash, data []byte, until time.Time) error {
+	h.records[peerId] = data
+	return nil
+}
+
+func TestPeerRecords(t *testing.T) {
+	assert := assert.New(t)
+
+	handle := &recordSyncHandle{
+		cache:   fastcache.New(16 * 1024 * 1024),
+		signers: make(map[crypto.Hash]crypto.Key),
+		records: make(map[crypto.Hash][]byte),
+	}
+	var keys []crypto.Key
+	var ids []crypto.Hash
+	for i := 0; i < 3; i++ {
+		seed := make([]byte, 64)
+		rand.Read(seed)
+		key := crypto.NewKeyFromSeed(seed)
+		id := crypto.NewHash(seed)
+		handle.signers[id] = key.Public()
+		keys, ids = append(keys, key), append(ids, id)
+	}
+	record := func(i int, addr string, ts time.Time) *PeerRecord {
+		r := &PeerRecord{
+			NodeId:    ids[i],
+			Signer:    keys[i].Public(),
+			Addresses: []string{addr},
+			Timestamp: uint64(ts.UnixNano()),
+		}
+		r.Signature = keys[i].Sign(r.Payload())
+		return r
+	}
+
+	me := NewPeer(context.Background(), handle, crypto.NewHash([]byte("local")), "127.0.0.1:7013", true)
+	now := time.Now()
+	updated, err := me.UpdatePeerRecord(record(0, "127.0.0.1:7014", now))
+	assert.Nil(err)
+	assert.True(updated)
+	assert.Len(handle.records, 1)
+	updated, err = me.UpdatePeerRecord(record(0, "127.0.0.1:7014", now))
+	assert.Nil(err)
+	assert.False(updated)
+	updated, err = me.UpdatePeerRecord(record(0, "127.0.0.1:7014", now.Add(time.Second)))
+	assert.Nil(err)
+	assert.False(updated)
+	updated, err = me.UpdatePeerRecord(record(0, "127.0.0.1:7015", now.Add(time.Second)))
+	assert.Nil(err)
+	assert.True(updated)
+
+	r := record(1, "127.0.0.1:7016", now)
+	r.Addresses = []string{"127.0.0.1:7017"}
+	_, err = me.UpdatePeerRecord(r)
+	assert.NotNil(err)
+	_, err = me.UpdatePeerRecord(record(1, "127.0.0.1:7016", now.Add(time.Hour)))
+	assert.NotNil(err)
+	_, err = me.UpdatePeerRecord(record(1, "127.0.0.1:7016", now.Add(-peerRecordLifetime)))
+	assert.NotNil(err)
+	_, err = me.UpdatePeerRecord(record(1, "invalid", now))
+	assert.NotNil(err)
+	r = record(1, "127.0.0.1:7016", now)
+	r.NodeId = ids[2]
+	_, err = me.UpdatePeerRecord(r)
+	assert.NotNil(err)
+	updated, err = me.UpdatePeerRecord(record(1, "127.0.0.1:7016", now))
+	assert.Nil(err)
+	assert.True(updated)
+	updated, err = me.UpdatePeerRecord(record(2, "127.0.0.1:7018", now))
+	assert.Nil(err)
+	assert.True(updated)
+
+	records := me.book.healthy(now, peerBookDialLimit)
+	assert.Len(records, 3)
+	me.reachPeer(ids[2], "", nil)
+	me.reachPeer(crypto.Hash{}, "127.0.0.1:7016", fmt.Errorf("dial"))
+	records = me.book.healthy(now, peerBookDialLimit)
+	assert.Equal(ids[2], records[0].NodeId)
+	assert.Equal(ids[0], records[1].NodeId)
+	assert.Equal(ids[1], records[2].NodeId)
+	assert.Len(me.book.healthy(now, 1), 1)
+
+	written := string(handle.records[ids[1]])
+	for i := 1; i < peerBookMaxFailures; i++ {
+		me.reachPeer(ids[1], "", fmt.Errorf("dial"))
+	}
+	assert.NotEqual(written, string(handle.records[ids[1]]))
+	assert.Len(me.book.healthy(time.Now(), peerBookDialLimit), 2)
+	assert.Len(me.book.healthy(time.Now().Add(peerBookRetryPeriod), peerBookDialLimit), 3)
+	me.reachPeer(ids[1], "", nil)
+	assert.Len(me.book.healthy(time.Now(), peerBookDialLimit), 3)
+
+	written = string(handle.records[ids[2]])
+	me.reachPeer(ids[2], "", nil)
+	assert.Equal(written, string(handle.records[ids[2]]))
+	me.flushPeerRecords()
+	assert.NotEqual(written, string(handle.records[ids[2]]))
+
+	restarted := NewPeer(context.Background(), handle, crypto.NewHash([]byte("local")), "127.0.0.1:7013", true)
+	records = restarted.book.healthy(now, peerBookDialLimit)
+	assert.Len(records, 3)
+	assert.Equal(ids[2], records[0].NodeId)
+	assert.Equal("127.0.0.1:7015", restarted.book.entries[ids[0]].Record.Addresses[0])
+	delete(handle.signers, ids[0])
+	restarted = NewPeer(context.Background(), handle, crypto.NewHash([]byte("local")), "127.0.0.1:7013", true)
+	assert.Len(restarted.book.healthy(now, peerBookDialLimit), 2)
+
+	msg, err := parseNetworkMessage(buildPeerRecordsMessage(me.peerRecords()))
+	assert.Nil(err)
+	assert.Equal(uint8(PeerMessageTypePeerRecords), msg.Type)
+	assert.Len(msg.Records, 3)
+	_, err = parseNetworkMessage(buildPeerRecordsMessage([]*PeerRecord{nil}))
+	assert.NotNil(err)
+}
@@ -0,0 +1,298 @@
+package network
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/MixinNetwork/mixin/common"
+	"github.com/MixinNetwork/mixin/crypto"
+	"github.com/MixinNetwork/mixin/logger"
+	"github.com/MixinNetwork/mixin/util"
+)
+
+// The message recorder writes the inbound and outbound messages of all the
+// neighbors with the timestamps, so the message interleaving of an incident
+// could be replayed by a fresh node later. The messages are written to the
+// files in the directory, and a new file is created once the file is too
+// large, then the oldest files are removed.
+const (
+	recorderFileSize  = 256 * 1024 * 1024
+	recorderFileCount = 16
+	recorderFlush     = time.Second
+	recorderMaxRecord = TransportMessageMaxSize + 1024
+)
+
+type RecordedMessage struct {
+	Timestamp uint64
+	PeerId    crypto.Hash
+	Outbound  bool
+	Data      []byte
+}
+
+type MessageRecorder struct {
+	sync.Mutex
+	dir      string
+	maxSize  int64
+	maxFiles int
+	file     *os.File
+	w        *bufio.Writer
+	size     int64
+	closed   bool
+	done     chan struct{}
+}
+
+func NewMessageRecorder(dir string) (*MessageRecorder, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	r := &MessageRecorder{
+		dir:      dir,
+		maxSize:  recorderFileSize,
+		maxFiles: recorderFileCount,
+		done:     make(chan struct{}),
+	}
+	err = r.rotate()
+	if err != nil {
+		return nil, err
+	}
+	go r.flushLoop()
+	return r, nil
+}
+
+func (r *MessageRecorder) record(peerId crypto.Hash, outbound bool, data []byte) {
+	r.Lock()
+	defer r.Unlock()
+
+	if r.closed {
+		return
+	}
+	rm := common.MsgpackMarshalPanic(&RecordedMessage{
+		Timestamp: uint64(time.Now().UnixNano()),
+		PeerId:    peerId,
+		Outbound:  outbound,
+		Data:      data,
+	})
+	header := make([]byte, 4)
+	binary.BigEndian.PutUint32(header, uint32(len(rm)))
+	_, err := r.w.Write(header)
+	if err == nil {
+		_, err = r.w.Write(rm)
+	}
+	if err != nil {
+		logger.Printf("MessageRecorder.record error %s\n", err.Error())
+		return
+	}
+	r.size += int64(len(header) + len(rm))
+	if r.size < r.maxSize {
+		return
+	}
+	err = r.rotate()
+	if err != nil {
+		logger.Printf("MessageRecorder.rotate error %s\n", err.Error())
+	}
+}
+
+// rotate closes the current file and creates a new one, the files are named
+// by the creation time, so the oldest files are sorted first.
+func (r *MessageRecorder) rotate() error {
+	if r.file != nil {
+		err := r.w.Flush()
+		if err != nil {
+			return err
+		}
+		err = r.file.Close()
+		if err != nil {
+			return err
+		}
+	}
+	name := fmt.Sprintf("messages-%019d.dat", time.Now().UnixNano())
+	f, err := os.Create(filepath.Join(r.dir, name))
+	if err != nil {
+		return err
+	}
+	r.file, r.w, r.size = f, bufio.NewWriter(f), 0
+
+	files, err := RecordedFiles(r.dir)
+	if err != nil {
+		return err
+	}
+	for len(files) > r.maxFiles {
+		err = os.Remove(files[0])
+		if err != nil {
+			return err
+		}
+		files = files[1:]
+	}
+	return nil
+}
+
+func (r *MessageRecorder) flushLoop() {
+	ticker := time.NewTicker(recorderFlush)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.done:
+			return
+		case <-ticker.C:
+		}
+		r.Lock()
+		err := r.w.Flush()
+		r.Unlock()
+		if err != nil {
+			logger.Printf("MessageRecorder.flush error %s\n", err.Error())
+		}
+	}
+}
+
+func (r *MessageRecorder) Close() error {
+	r.Lock()
+	defer r.Unlock()
+
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	close(r.done)
+	err := r.w.Flush()
+	if err != nil {
+		return err
+	}
+	return r.file.Close()
+}
+
+// RecordedFiles lists the recorded files in the directory, the oldest first.
+func RecordedFiles(dir string) ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "messages-*.dat"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+type MessageReader struct {
+	r *bufio.Reader
+}
+
+func NewMessageReader(r io.Reader) *MessageReader {
+	return &MessageReader{r: bufio.NewReader(r)}
+}
+
+// Next returns the next recorded message, or io.EOF at the end. The last
+// message may be truncated if the node crashed, which is also io.EOF.
+func (mr *MessageReader) Next() (*RecordedMessage, error) {
+	header := make([]byte, 4)
+	_, err := io.ReadFull(mr.r, header)
+	if err == io.ErrUnexpectedEOF {
+		return nil, io.EOF
+	} else if err != nil {
+		return nil, err
+	}
+	size := binary.BigEndian.Uint32(header)
+	if size > recorderMaxRecord {
+		return nil, fmt.Errorf("recorded message too large %d", size)
+	}
+	data := make([]byte, size)
+	_, err = io.ReadFull(mr.r, data)
+	if err == io.ErrUnexpectedEOF || err == io.EOF {
+		return nil, io.EOF
+	} else if err != nil {
+		return nil, err
+	}
+	var rm RecordedMessage
+	err = common.MsgpackUnmarshal(data, &rm)
+	return &rm, err
+}
+
+// recordedClient records the messages after authentication, the inbound
+// messages are received from the peer, and outbound ones sent to it.
+type recordedClient struct {
+	Client
+	peerId   crypto.Hash
+	recorder *MessageRecorder
+}
+
+func (c *recordedClient) Send(data []byte) error {
+	err := c.Client.Send(data)
+	if err == nil {
+		c.recorder.record(c.peerId, true, data)
+	}
+	return err
+}
+
+func (c *recordedClient) Receive() ([]byte, error) {
+	data, err := c.Client.Receive()
+	if err == nil && len(data) > 0 {
+		c.recorder.record(c.peerId, false, data)
+	}
+	return data, err
+}
+
+// SetMessageRecorder records the messages of all neighbors, and must be
+// called before any neighbor added or listened. The recorder is not closed
+// by the peer, and should be closed after the peer teardown.
+func (me *Peer) SetMessageRecorder(r *MessageRecorder) {
+	me.recorder = r
+}
+
+func (me *Peer) recordClient(peerId crypto.Hash, c Client) Client {
+	if me.recorder == nil {
+		return c
+	}
+	return &recordedClient{Client: c, peerId: peerId, recorder: me.recorder}
+}
+
+// Replay feeds the inbound messages recorded to the sync handle as if they
+// were received from the neighbors, with the recorded intervals if realtime.
+// The authentication and gossip messages are skipped, so the node never
+// connects the neighbors, and the messages sent by the node are dropped.
+func (me *Peer) Replay(r *MessageReader, realtime bool) (int, error) {
+	peers := make(map[crypto.Hash]*Peer)
+	var count int
+	var last uint64
+	for {
+		rm, err := r.Next()
+		if err == io.EOF {
+			return count, nil
+		} else if err != nil {
+			return count, err
+		}
+		if rm.Outbound {
+			continue
+		}
+		if realtime && last > 0 && rm.Timestamp > last {
+			if !util.Sleep(me.ctx, time.Duration(rm.Timestamp-last)) {
+				return count, me.ctx.Err()
+			}
+		}
+		last = rm.Timestamp
+
+		msg, err := parseNetworkMessage(rm.Data)
+		if err != nil {
+			logger.Printf("Replay(%s, %d) error %s\n", rm.PeerId, rm.Timestamp, err.Error())
+			continue
+		}
+		switch msg.Type {
+		case PeerMessageTypeAuthentication,
+			PeerMessageTypeGossipNeighbors,
+			PeerMessageTypePeerRecords:
+			continue
+		}
+		peer := peers[rm.PeerId]
+		if peer == nil {
+			peer = NewPeer(me.ctx, nil, rm.PeerId, "", false)
+			peers[rm.PeerId] = peer
+		}
+		me.handleMessage(peer, msg)
+		count += 1
+	}
+}
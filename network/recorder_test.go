@@ -0,0 +1,83 @@
+package network
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/MixinNetwork/mixin/common"
+	"github.com/MixinNetwork/mixin/crypto"
+	"github.com/VictoriaMetrics/fastcache"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMessageRecorder(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := t.TempDir()
+	recorder, err := NewMessageRecorder(dir)
+	assert.Nil(err)
+	recorder.maxSize, recorder.maxFiles = 1024, 3
+
+	peerId := crypto.NewHash([]byte("remote"))
+	var txs []crypto.Hash
+	for i := 0; i < 64; i++ {
+		ver := common.NewTransaction(crypto.NewHash([]byte{byte(i)})).AsLatestVersion()
+		recorder.record(peerId, i%2 == 1, buildTransactionMessage(ver))
+		if i%2 == 0 {
+			txs = append(txs, ver.PayloadHash())
+		}
+	}
+	recorder.record(peerId, false, buildAuthenticationMessage([]byte("auth")))
+	assert.Nil(recorder.Close())
+	select {
+	case <-recorder.done:
+	default:
+		t.Fatal("recorder flush loop not stopped")
+	}
+	recorder.record(peerId, false, buildTransactionRequestMessage(crypto.Hash{}))
+	assert.Nil(recorder.Close())
+
+	files, err := RecordedFiles(dir)
+	assert.Nil(err)
+	assert.Len(files, 3)
+
+	handle := &testSyncHandle{
+		cache:        fastcache.New(16 * 1024 * 1024),
+		transactions: make(map[crypto.Hash]*common.VersionedTransaction),
+	}
+	me := NewPeer(context.Background(), handle, crypto.NewHash([]byte("local")), "127.0.0.1:7019", false)
+	var total int
+	for _, path := range files {
+		data, err := os.ReadFile(path)
+		assert.Nil(err)
+		count, err := me.Replay(NewMessageReader(bytes.NewReader(data)), false)
+		assert.Nil(err)
+		total += count
+	}
+	assert.Equal(len(handle.cached), total)
+	assert.True(total > 0 && total < len(txs))
+	assert.Equal(txs[len(txs)-total:], handle.cached)
+
+	dir = t.TempDir()
+	recorder, err = NewMessageRecorder(dir)
+	assert.Nil(err)
+	recorder.record(peerId, true, buildTransactionRequestMessage(txs[0]))
+	recorder.record(peerId, false, buildTransactionRequestMessage(txs[1]))
+	assert.Nil(recorder.Close())
+	files, err = RecordedFiles(dir)
+	assert.Nil(err)
+	assert.Len(files, 1)
+	data, err := os.ReadFile(files[0])
+	assert.Nil(err)
+	r := NewMessageReader(bytes.NewReader(data[:len(data)-1]))
+	rm, err := r.Next()
+	assert.Nil(err)
+	assert.Equal(peerId, rm.PeerId)
+	assert.True(rm.Outbound)
+	assert.Equal(buildTransactionRequestMessage(txs[0]), rm.Data)
+	_, err = r.Next()
+	assert.Equal(io.EOF, err)
+}
@@ -0,0 +1,198 @@
+package network
+
+import (
+	"sync"
+	"time"
+
+	"github.com/MixinNetwork/mixin/crypto"
+	"github.com/MixinNetwork/mixin/logger"
+)
+
+// A peer starts with the maximum score, every misbehavior lowers it by the
+// penalty, and it recovers one point each recovery period. The peer is
+// disconnected and banned once the score drops to zero.
+const (
+	peerScoreMaximum  = 100
+	peerScoreRecovery = 10 * time.Second
+	peerBanDuration   = time.Hour
+
+	peerTransactionRequestLimit = 100 // per second
+
+	PeerPenaltyMalformedMessage   = 20
+	PeerPenaltyAuthentication     = 10
+	PeerPenaltyInvalidSnapshot    = 20
+	PeerPenaltyDuplicateMessage   = 1
+	PeerPenaltyTransactionRequest = 1
+)
+
+type PeerBan struct {
+	IdForNetwork crypto.Hash
+	Until        time.Time
+}
+
+type peerScore struct {
+	value    int
+	at       time.Time
+	window   time.Time
+	requests int
+}
+
+type scoreBoard struct {
+	sync.Mutex
+	scores map[crypto.Hash]*peerScore
+	bans   map[crypto.Hash]time.Time
+}
+
+func newScoreBoard() *scoreBoard {
+	return &scoreBoard{
+		scores: make(map[crypto.Hash]*peerScore),
+		bans:   make(map[crypto.Hash]time.Time),
+	}
+}
+
+func (b *scoreBoard) get(id crypto.Hash, now time.Time) *peerScore {
+	ps := b.scores[id]
+	if ps == nil {
+		ps = &peerScore{value: peerScoreMaximum, at: now}
+		b.scores[id] = ps
+	}
+	if recovered := int(now.Sub(ps.at) / peerScoreRecovery); recovered > 0 {
+		ps.value += recovered
+		ps.at = ps.at.Add(time.Duration(recovered) * peerScoreRecovery)
+	}
+	if ps.value >= peerScoreMaximum {
+		ps.value, ps.at = peerScoreMaximum, now
+	}
+	return ps
+}
+
+func (b *scoreBoard) score(id crypto.Hash, now time.Time) int {
+	b.Lock()
+	defer b.Unlock()
+
+	return b.get(id, now).value
+}
+
+// penalize returns the score after the penalty, and true if the peer is
+// banned by this penalty.
+func (b *scoreBoard) penalize(id crypto.Hash, penalty int, now time.Time) (int, bool) {
+	b.Lock()
+	defer b.Unlock()
+
+	ps := b.get(id, now)
+	ps.value -= penalty
+	if ps.value > 0 || b.bans[id].After(now) {
+		return ps.value, false
+	}
+	delete(b.scores, id)
+	b.bans[id] = now.Add(peerBanDuration)
+	return 0, true
+}
+
+// request counts the transaction requests in the current second, and
+// returns false if the peer requests too many.
+func (b *scoreBoard) request(id crypto.Hash, now time.Time) bool {
+	b.Lock()
+	defer b.Unlock()
+
+	ps := b.get(id, now)
+	if now.Sub(ps.window) >= time.Second {
+		ps.window, ps.requests = now, 0
+	}
+	ps.requests += 1
+	return ps.requests <= peerTransactionRequestLimit
+}
+
+func (b *scoreBoard) banned(id crypto.Hash, now time.Time) bool {
+	b.Lock()
+	defer b.Unlock()
+
+	until, found := b.bans[id]
+	if found && !until.After(now) {
+		delete(b.bans, id)
+		return false
+	}
+	return found
+}
+
+func (b *scoreBoard) ban(id crypto.Hash, until time.Time) {
+	b.Lock()
+	defer b.Unlock()
+
+	b.bans[id] = until
+}
+
+func (b *scoreBoard) list(now time.Time) []*PeerBan {
+	b.Lock()
+	defer b.Unlock()
+
+	var bans []*PeerBan
+	for id, until := range b.bans {
+		if !until.After(now) {
+			delete(b.bans, id)
+			continue
+		}
+		bans = append(bans, &PeerBan{IdForNetwork: id, Until: until})
+	}
+	return bans
+}
+
+func (me *Peer) loadBans() {
+	bans, err := me.handle.ReadPeerBans()
+	if err != nil {
+		logger.Printf("loadBans error %s\n", err.Error())
+		return
+	}
+	for id, until := range bans {
+		me.scores.ban(id, until)
+	}
+}
+
+// duplicatedMessage checks the messages which the neighbor should never
+// send twice in a minute, the graph and ping are sent periodically.
+func (me *Peer) duplicatedMessage(id crypto.Hash, typ uint8, data []byte) bool {
+	switch typ {
+	case PeerMessageTypePing,
+		PeerMessageTypeAuthentication,
+		PeerMessageTypeCapabilities,
+		PeerMessageTypeGraph,
+		PeerMessageTypeSnapshotRangeRequest,
+		PeerMessageTypeSnapshotRangeResponse,
+		PeerMessageTypeGossipNeighbors,
+		PeerMessageTypePeerRecords:
+		return false
+	}
+	hash := crypto.NewHash(data)
+	key := append(id[:], hash[:]...)
+	key = append(key, 'D', 'U', 'P')
+	if me.snapshotsCaches.contains(key, time.Minute) {
+		return true
+	}
+	me.snapshotsCaches.store(key, time.Now())
+	return false
+}
+
+// PenalizeNeighbor lowers the score of the peer, then disconnects and bans
+// it if the score drops to zero.
+func (me *Peer) PenalizeNeighbor(idForNetwork crypto.Hash, penalty int, reason string) {
+	now := time.Now()
+	score, banned := me.scores.penalize(idForNetwork, penalty, now)
+	logger.Verbosef("PenalizeNeighbor(%s, %d, %s) score %d\n", idForNetwork, penalty, reason, score)
+	if !banned {
+		return
+	}
+
+	until := now.Add(peerBanDuration)
+	logger.Printf("PenalizeNeighbor(%s, %d, %s) banned until %s\n", idForNetwork, penalty, reason, until)
+	err := me.handle.WritePeerBan(idForNetwork, until)
+	if err != nil {
+		logger.Printf("PenalizeNeighbor(%s) WritePeerBan error %s\n", idForNetwork, err.Error())
+	}
+	go me.RemoveNeighbor(idForNetwork)
+}
+
+// Bans lists the peers banned now, including those loaded from the cache
+// store after restart.
+func (me *Peer) Bans() []*PeerBan {
+	return me.scores.list(time.Now())
+}
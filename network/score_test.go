@@ -0,0 +1,54 @@
+package network
+
+import (
+	"testing"
+	"time"
+
+	"github.com/MixinNetwork/mixin/crypto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScoreBoard(t *testing.T) {
+	assert := assert.New(t)
+
+	board := newScoreBoard()
+	id := crypto.NewHash([]byte("peer"))
+	now := time.Now()
+	assert.Equal(peerScoreMaximum, board.score(id, now))
+
+	score, banned := board.penalize(id, PeerPenaltyMalformedMessage, now)
+	assert.Equal(peerScoreMaximum-PeerPenaltyMalformedMessage, score)
+	assert.False(banned)
+	now = now.Add(peerScoreRecovery * 3)
+	assert.Equal(peerScoreMaximum-PeerPenaltyMalformedMessage+3, board.score(id, now))
+	now = now.Add(peerScoreRecovery * 100)
+	assert.Equal(peerScoreMaximum, board.score(id, now))
+
+	for i := 0; i < peerScoreMaximum/PeerPenaltyMalformedMessage-1; i++ {
+		_, banned = board.penalize(id, PeerPenaltyMalformedMessage, now)
+		assert.False(banned)
+	}
+	assert.False(board.banned(id, now))
+	score, banned = board.penalize(id, PeerPenaltyMalformedMessage, now)
+	assert.Equal(0, score)
+	assert.True(banned)
+	assert.True(board.banned(id, now))
+	_, banned = board.penalize(id, peerScoreMaximum, now)
+	assert.False(banned)
+
+	bans := board.list(now)
+	assert.Len(bans, 1)
+	assert.Equal(id, bans[0].IdForNetwork)
+	assert.Equal(now.Add(peerBanDuration), bans[0].Until)
+
+	now = now.Add(peerBanDuration)
+	assert.False(board.banned(id, now))
+	assert.Len(board.list(now), 0)
+	assert.Equal(peerScoreMaximum, board.score(id, now))
+
+	for i := 0; i < peerTransactionRequestLimit; i++ {
+		assert.True(board.request(id, now))
+	}
+	assert.False(board.request(id, now))
+	assert.True(board.request(id, now.Add(time.Second)))
+}
@@ -0,0 +1,196 @@
+package network
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/MixinNetwork/mixin/crypto"
+)
+
+const (
+	PeerDirectionNone     = "none"
+	PeerDirectionInbound  = "inbound"
+	PeerDirectionOutbound = "outbound"
+	PeerDirectionBoth     = "both"
+)
+
+type PeerInfo struct {
+	IdForNetwork crypto.Hash
+	Address      string
+	Direction    string
+	Uptime       time.Duration
+	Graph        []*SyncPoint
+	GraphAt      time.Time
+	HighRing     uint64
+	NormalRing   uint64
+	LowRing      uint64
+	SyncRing     uint64
+	BytesSent    uint64
+	BytesRecv    uint64
+	Error        string
+	ErrorAt      time.Time
+	Score        int
+	Capabilities *Capabilities
+	Messages     map[uint8]*MessageStats
+	ClockOffset  time.Duration
+	ClockDelay   time.Duration
+	ClockAt      time.Time
+}
+
+// MessageStats counts the messages and bytes before compression of a
+// message type, the transport headers are not counted.
+type MessageStats struct {
+	Sent      uint64
+	Received  uint64
+	BytesSent uint64
+	BytesRecv uint64
+}
+
+type messageCounter struct {
+	messages uint64
+	bytes    uint64
+}
+
+func (c *messageCounter) add(size int) {
+	atomic.AddUint64(&c.messages, 1)
+	atomic.AddUint64(&c.bytes, uint64(size))
+}
+
+type peerStats struct {
+	sync.Mutex
+	sent       uint64
+	received   uint64
+	sentTypes  [256]messageCounter
+	recvTypes  [256]messageCounter
+	inbound    int
+	inboundAt  time.Time
+	outbound   int
+	outboundAt time.Time
+	graph      []*SyncPoint
+	graphAt    time.Time
+	err        string
+	errAt      time.Time
+}
+
+// meteredClient counts the message bytes before compression.
+type meteredClient struct {
+	Client
+	stats *peerStats
+}
+
+func (c *meteredClient) Send(data []byte) error {
+	err := c.Client.Send(data)
+	if err == nil {
+		atomic.AddUint64(&c.stats.sent, uint64(len(data)))
+		c.stats.sentTypes[data[0]].add(len(data))
+	}
+	return err
+}
+
+func (c *meteredClient) Receive() ([]byte, error) {
+	data, err := c.Client.Receive()
+	if err == nil && len(data) > 0 {
+		atomic.AddUint64(&c.stats.received, uint64(len(data)))
+		c.stats.recvTypes[data[0]].add(len(data))
+	}
+	return data, err
+}
+
+// connect marks a new connection in the direction and returns the function
+// to mark it closed.
+func (s *peerStats) connect(outbound bool) func() {
+	s.Lock()
+	defer s.Unlock()
+
+	count, at := &s.inbound, &s.inboundAt
+	if outbound {
+		count, at = &s.outbound, &s.outboundAt
+	}
+	if *count == 0 {
+		*at = time.Now()
+	}
+	*count += 1
+	return func() {
+		s.Lock()
+		defer s.Unlock()
+		*count -= 1
+	}
+}
+
+func (s *peerStats) updateGraph(graph []*SyncPoint) {
+	s.Lock()
+	defer s.Unlock()
+	s.graph = graph
+	s.graphAt = time.Now()
+}
+
+func (s *peerStats) lastGraph() []*SyncPoint {
+	s.Lock()
+	defer s.Unlock()
+	return s.graph
+}
+
+func (s *peerStats) updateError(err error) {
+	s.Lock()
+	defer s.Unlock()
+	s.err = err.Error()
+	s.errAt = time.Now()
+}
+
+func (p *Peer) Info() *PeerInfo {
+	s := p.stats
+	s.Lock()
+	defer s.Unlock()
+
+	info := &PeerInfo{
+		IdForNetwork: p.IdForNetwork,
+		Address:      p.Address,
+		Direction:    PeerDirectionNone,
+		Graph:        s.graph,
+		GraphAt:      s.graphAt,
+		HighRing:     p.highRing.Len(),
+		NormalRing:   p.normalRing.Len(),
+		LowRing:      p.lowRing.Len(),
+		SyncRing:     p.syncRing.Len(),
+		BytesSent:    atomic.LoadUint64(&s.sent),
+		BytesRecv:    atomic.LoadUint64(&s.received),
+		Error:        s.err,
+		ErrorAt:      s.errAt,
+		Score:        p.scores.score(p.IdForNetwork, time.Now()),
+		Capabilities: p.capabilities.get(),
+		Messages:     make(map[uint8]*MessageStats),
+	}
+	if cs := p.clock.estimate(time.Now()); cs != nil {
+		info.ClockOffset, info.ClockDelay, info.ClockAt = cs.offset, cs.delay, cs.at
+	}
+	for i := range s.sentTypes {
+		sent, recv := &s.sentTypes[i], &s.recvTypes[i]
+		ms := &MessageStats{
+			Sent:      atomic.LoadUint64(&sent.messages),
+			Received:  atomic.LoadUint64(&recv.messages),
+			BytesSent: atomic.LoadUint64(&sent.bytes),
+			BytesRecv: atomic.LoadUint64(&recv.bytes),
+		}
+		if ms.Sent > 0 || ms.Received > 0 {
+			info.Messages[uint8(i)] = ms
+		}
+	}
+	var since time.Time
+	switch {
+	case s.inbound > 0 && s.outbound > 0:
+		info.Direction = PeerDirectionBoth
+		since = s.inboundAt
+		if s.outboundAt.Before(since) {
+			since = s.outboundAt
+		}
+	case s.inbound > 0:
+		info.Direction, since = PeerDirectionInbound, s.inboundAt
+	case s.outbound > 0:
+		info.Direction, since = PeerDirectionOutbound, s.outboundAt
+	}
+	if !since.IsZero() {
+		info.Uptime = time.Since(since)
+	}
+	return info
+}
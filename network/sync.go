@@ -8,6 +8,7 @@ import (
 	"github.com/MixinNetwork/mixin/config"
 	"github.com/MixinNetwork/mixin/crypto"
 	"github.com/MixinNetwork/mixin/logger"
+	"github.com/MixinNetwork/mixin/util"
 )
 
 func (me *Peer) cacheReadSnapshotsForNodeRound(nodeId crypto.Hash, number uint64) ([]*common.SnapshotWithTopologicalOrder, error) {
@@ -18,7 +19,7 @@ func (me *Peer) cacheReadSnapshotsSinceTopology(offset, limit uint64) ([]*common
 	return me.handle.ReadSnapshotsSinceTopology(offset, limit)
 }
 
-func (me *Peer) compareRoundGraphAndGetTopologicalOffset(p *Peer, local, remote []*SyncPoint) (uint64, error) {
+func (me *Peer) compareRoundGraphAndGetTopologicalOffset(p *Peer, local, remote []*SyncPoint, pulled map[crypto.Hash]bool) (uint64, error) {
 	remoteFilter := make(map[crypto.Hash]*SyncPoint)
 	for _, p := range remote {
 		remoteFilter[p.NodeId] = p
@@ -28,7 +29,7 @@ func (me *Peer) compareRoundGraphAndGetTopologicalOffset(p *Peer, local, remote
 
 	for _, l := range local {
 		r := remoteFilter[l.NodeId]
-		if r == nil || r.Number > l.Number {
+		if r == nil || r.Number > l.Number || pulled[l.NodeId] {
 			continue
 		}
 		number := r.Number + 2 // because the node may be stale or removed, and with cache
@@ -50,32 +51,38 @@ func (me *Peer) compareRoundGraphAndGetTopologicalOffset(p *Peer, local, remote
 	return offset, nil
 }
 
-func (me *Peer) syncToNeighborSince(graph map[crypto.Hash]*SyncPoint, p *Peer, offset uint64) (uint64, error) {
+func (me *Peer) syncToNeighborSince(graph map[crypto.Hash]*SyncPoint, pulled map[crypto.Hash]bool, p *Peer, offset uint64) (uint64, error) {
 	logger.Verbosef("network.sync syncToNeighborSince %s %d\n", p.IdForNetwork, offset)
 	limit := 200
 	snapshots, err := me.cacheReadSnapshotsSinceTopology(offset, uint64(limit))
 	if err != nil {
 		return offset, err
 	}
+	batch := me.newSyncBatch(p)
 	for _, s := range snapshots {
 		var remoteRound uint64
 		if r := graph[s.NodeId]; r != nil {
 			remoteRound = r.Number
 		}
-		if s.RoundNumber < remoteRound {
+		if s.RoundNumber < remoteRound || pulled[s.NodeId] {
 			offset = s.TopologicalOrder
 			continue
 		}
 		if s.RoundNumber >= remoteRound+config.SnapshotReferenceThreshold*2 {
+			batch.flush()
 			return offset, fmt.Errorf("FUTURE %s %d %d", s.NodeId, s.RoundNumber, remoteRound)
 		}
-		err := me.SendSnapshotFinalizationMessage(p.IdForNetwork, &s.Snapshot)
+		err := batch.add(&s.Snapshot, s.RoundNumber > remoteRound)
 		if err != nil {
 			return offset, err
 		}
 		offset = s.TopologicalOrder
 	}
-	time.Sleep(100 * time.Millisecond)
+	err = batch.flush()
+	if err != nil {
+		return offset, err
+	}
+	util.Sleep(p.ctx, 100*time.Millisecond)
 	if len(snapshots) < limit {
 		return offset, fmt.Errorf("EOF")
 	}
@@ -94,27 +101,65 @@ func (me *Peer) syncHeadRoundToRemote(local, remote map[crypto.Hash]*SyncPoint,
 		return
 	}
 	logger.Verbosef("network.sync syncHeadRoundToRemote %s %s:%d\n", p.IdForNetwork, nodeId, remoteFinal)
+	batch := me.newSyncBatch(p)
 	for i := remoteFinal; i <= remoteFinal+config.SnapshotReferenceThreshold+2; i++ {
 		ss, _ := me.cacheReadSnapshotsForNodeRound(nodeId, i)
 		for _, s := range ss {
-			me.SendSnapshotFinalizationMessage(p.IdForNetwork, &s.Snapshot)
+			batch.add(&s.Snapshot, i > remoteFinal)
 		}
 	}
+	batch.flush()
+}
+
+// sendSyncSnapshotToPeer queues the finalized snapshot to the low ring of
+// the neighbor, which is sent after all the consensus messages, and waits
+// for the sync rate limits.
+func (me *Peer) sendSyncSnapshotToPeer(p *Peer, s *common.Snapshot) error {
+	key := me.syncSnapshotKey(p, s)
+	if key == nil {
+		return nil
+	}
+
+	data := buildSnapshotFinalizationMessage(s)
+	if !me.waitSyncLimit(p, len(data)) {
+		return fmt.Errorf("peer send low closing")
+	}
+	success, _ := p.lowRing.Offer(&ChanMsg{key, data})
+	if !success {
+		return fmt.Errorf("peer send low timeout")
+	}
+	return nil
+}
+
+// syncSnapshotKey returns the key of the snapshot sent to the neighbor, or
+// nil if confirmed or sent recently.
+func (me *Peer) syncSnapshotKey(p *Peer, s *common.Snapshot) []byte {
+	key := append(p.IdForNetwork[:], s.Hash[:]...)
+	key = append(key, 'S', 'C', 'O')
+	if me.snapshotsCaches.contains(key, time.Hour) {
+		return nil
+	}
+	key = append(p.IdForNetwork[:], s.Hash[:]...)
+	key = append(key, 'S', 'N', 'A', 'P', PeerMessageTypeSnapshotFinalization)
+	if me.snapshotsCaches.contains(key, time.Minute) {
+		return nil
+	}
+	return key
 }
 
 func (me *Peer) syncToNeighborLoop(p *Peer) {
 	defer close(p.stn)
 
-	for !me.closing && !p.closing {
-		graph, offset := me.getSyncPointOffset(p)
-		logger.Verbosef("network.sync syncToNeighborLoop getSyncPointOffset %s %d %v\n", p.IdForNetwork, offset, graph != nil)
+	for p.ctx.Err() == nil {
+		graph, pulled, offset := me.getSyncPointOffset(p)
+		logger.Verbosef("network.sync syncToNeighborLoop getSyncPointOffset %s %d %v %d\n", p.IdForNetwork, offset, graph != nil, len(pulled))
 
 		if me.gossipRound.Get(p.IdForNetwork) == nil {
 			continue
 		}
 
-		for !me.closing && !p.closing && offset > 0 {
-			off, err := me.syncToNeighborSince(graph, p, offset)
+		for p.ctx.Err() == nil && offset > 0 {
+			off, err := me.syncToNeighborSince(graph, pulled, p, offset)
 			if err != nil {
 				logger.Verbosef("network.sync syncToNeighborLoop syncToNeighborSince %s %d DONE with %s", p.IdForNetwork, offset, err)
 				break
@@ -136,17 +181,20 @@ func (me *Peer) syncToNeighborLoop(p *Peer) {
 	}
 }
 
-func (me *Peer) getSyncPointOffset(p *Peer) (map[crypto.Hash]*SyncPoint, uint64) {
+// getSyncPointOffset returns the latest graph of the neighbor, the chains
+// pulled by it, and the topological offset to push the other chains since.
+func (me *Peer) getSyncPointOffset(p *Peer) (map[crypto.Hash]*SyncPoint, map[crypto.Hash]bool, uint64) {
 	var offset uint64
 	var graph map[crypto.Hash]*SyncPoint
+	var pulled map[crypto.Hash]bool
 
 	startAt := time.Now()
-	for !me.closing && !p.closing {
+	for p.ctx.Err() == nil {
 		item, err := p.syncRing.Poll(false)
 		if err != nil {
 			break
 		} else if item == nil {
-			time.Sleep(100 * time.Millisecond)
+			util.Sleep(p.ctx, 100*time.Millisecond)
 			continue
 		}
 
@@ -155,7 +203,8 @@ func (me *Peer) getSyncPointOffset(p *Peer) (map[crypto.Hash]*SyncPoint, uint64)
 		for _, r := range g {
 			graph[r.NodeId] = r
 		}
-		off, err := me.compareRoundGraphAndGetTopologicalOffset(p, me.handle.BuildGraph(), g)
+		pulled = me.pulling(p, graph)
+		off, err := me.compareRoundGraphAndGetTopologicalOffset(p, me.handle.BuildGraph(), g, pulled)
 		if err != nil {
 			logger.Printf("network.sync compareRoundGraphAndGetTopologicalOffset %s error %s\n", p.IdForNetwork, err.Error())
 		}
@@ -163,9 +212,9 @@ func (me *Peer) getSyncPointOffset(p *Peer) (map[crypto.Hash]*SyncPoint, uint64)
 			offset = off
 		}
 		if startAt.Add(time.Second).Before(time.Now()) {
-			return graph, offset
+			return graph, pulled, offset
 		}
 	}
 
-	return nil, 0
+	return nil, nil, 0
 }
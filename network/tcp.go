@@ -0,0 +1,200 @@
+package network
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/MixinNetwork/mixin/common"
+	"github.com/MixinNetwork/mixin/crypto"
+	"github.com/klauspost/compress/zstd"
+)
+
+type TCPClient struct {
+	conn         net.Conn
+	zstdZipper   *zstd.Encoder
+	zstdUnzipper *zstd.Decoder
+	compression  uint8
+	dictionary   uint32
+	networkId    crypto.Hash
+}
+
+type TCPTransport struct {
+	addr      string
+	tls       *tls.Config
+	listener  net.Listener
+	networkId crypto.Hash
+}
+
+func NewTCPServer(addr string) (*TCPTransport, error) {
+	tlsConf := generateTLSConfig()
+	tlsConf.NextProtos = []string{"mixin-tcp-peer"}
+	tlsConf.MinVersion = tls.VersionTLS13
+	return &TCPTransport{
+		addr: addr,
+		tls:  tlsConf,
+	}, nil
+}
+
+func NewTCPClient(addr string) (*TCPTransport, error) {
+	return &TCPTransport{
+		addr: addr,
+		tls: &tls.Config{
+			InsecureSkipVerify: true,
+			NextProtos:         []string{"mixin-tcp-peer"},
+			MinVersion:         tls.VersionTLS13,
+		},
+	}, nil
+}
+
+func (t *TCPTransport) Dial(ctx context.Context) (Client, error) {
+	dialer := &tls.Dialer{
+		NetDialer: &net.Dialer{Timeout: HandshakeTimeout, KeepAlive: IdleTimeout / 2},
+		Config:    t.tls,
+	}
+	conn, err := dialer.DialContext(ctx, "tcp", t.addr)
+	if err != nil {
+		return nil, err
+	}
+	return &TCPClient{
+		conn:        conn,
+		zstdZipper:  common.NewZstdDictionaryEncoder(1, common.ZstdDictionaryVersionZero),
+		compression: TransportCompressionMethod,
+		dictionary:  common.ZstdDictionaryVersionZero,
+		networkId:   t.networkId,
+	}, nil
+}
+
+func (t *TCPTransport) Listen() error {
+	l, err := tls.Listen("tcp", t.addr, t.tls)
+	if err != nil {
+		return err
+	}
+	t.listener = l
+	return nil
+}
+
+func (t *TCPTransport) Close() error {
+	return t.listener.Close()
+}
+
+// Accept doesn't do the TLS handshake, which is done by the first Receive
+// and limited by the read deadline, so a slow client won't block others.
+func (t *TCPTransport) Accept(ctx context.Context) (Client, error) {
+	conn, err := t.listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &TCPClient{
+		conn:         conn,
+		zstdUnzipper: common.NewZstdDecoder(1),
+		networkId:    t.networkId,
+	}, nil
+}
+
+func (c *TCPClient) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// Binding must be called after the first Receive on the accepted client,
+// which completes the TLS handshake.
+func (c *TCPClient) Binding() ([]byte, error) {
+	conn, ok := c.conn.(*tls.Conn)
+	if !ok {
+		return nil, fmt.Errorf("tcp binding without tls %T", c.conn)
+	}
+	cs := conn.ConnectionState()
+	if !cs.HandshakeComplete {
+		return nil, fmt.Errorf("tcp binding before tls handshake")
+	}
+	return cs.ExportKeyingMaterial(clientBindingLabel, nil, clientBindingLength)
+}
+
+// SignerId must be called after the Binding, the same as the Binding.
+func (c *TCPClient) SignerId() (crypto.Hash, error) {
+	conn, ok := c.conn.(*tls.Conn)
+	if !ok {
+		return crypto.Hash{}, nil
+	}
+	return peerCertificateSigner(conn.ConnectionState(), c.networkId)
+}
+
+func (c *TCPClient) Receive() ([]byte, error) {
+	err := c.conn.SetReadDeadline(time.Now().Add(ReadDeadline))
+	if err != nil {
+		return nil, err
+	}
+	var m TransportMessage
+	header := make([]byte, TransportMessageHeaderSize)
+	_, err = io.ReadFull(c.conn, header)
+	if err != nil {
+		return nil, err
+	}
+	m.Version = header[0]
+	if m.Version != TransportMessageVersion {
+		return nil, fmt.Errorf("tcp receive invalid message version %d", m.Version)
+	}
+	m.Compression = header[1]
+	if m.Compression != TransportCompressionZstd && m.Compression != TransportCompressionGzip {
+		return nil, fmt.Errorf("tcp receive invalid message compression %d", m.Compression)
+	}
+	m.Size = binary.BigEndian.Uint32(header[2:])
+	if m.Size > TransportMessageMaxSize {
+		return nil, fmt.Errorf("tcp receive invalid message size %d", m.Size)
+	}
+	m.Data = make([]byte, m.Size)
+	_, err = io.ReadFull(c.conn, m.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	switch m.Compression {
+	case TransportCompressionZstd:
+		m.Data, err = c.zstdUnzipper.DecodeAll(m.Data, nil)
+	case TransportCompressionGzip:
+		m.Data, err = gunzipTransportMessage(m.Data)
+	}
+
+	return m.Data, err
+}
+
+func (c *TCPClient) Send(data []byte) error {
+	if l := len(data); l < 1 || l > TransportMessageMaxSize {
+		return fmt.Errorf("tcp send invalid message size %d", l)
+	}
+
+	switch c.compression {
+	case TransportCompressionZstd:
+		data = c.zstdZipper.EncodeAll(data, nil)
+	case TransportCompressionGzip:
+		gz, err := gzipTransportMessage(data)
+		if err != nil {
+			return err
+		}
+		data = gz
+	default:
+		return fmt.Errorf("tcp send invalid message compression %d", c.compression)
+	}
+
+	err := c.conn.SetWriteDeadline(time.Now().Add(WriteDeadline))
+	if err != nil {
+		return err
+	}
+	header := []byte{TransportMessageVersion, c.compression, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(header[2:], uint32(len(data)))
+	_, err = c.conn.Write(append(header, data...))
+	return err
+}
+
+func (c *TCPClient) Close() error {
+	if c.zstdZipper != nil {
+		c.zstdZipper.Close()
+	} else {
+		c.zstdUnzipper.Close()
+	}
+	return c.conn.Close()
+}
@@ -0,0 +1,89 @@
+package network
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTCP(t *testing.T) {
+	assert := assert.New(t)
+
+	addr := "127.0.0.1:7001"
+	serverTrans, err := NewServerTransport("tcp://" + addr)
+	assert.Nil(err)
+	assert.NotNil(serverTrans)
+	defer serverTrans.Close()
+	err = serverTrans.Listen()
+	assert.Nil(err)
+	received, bound := make(chan []byte, 2), make(chan []byte, 1)
+	go func() {
+		server, err := serverTrans.Accept(context.Background())
+		assert.Nil(err)
+		assert.NotNil(server)
+		defer server.Close()
+		for i := 0; i < 2; i++ {
+			msg, err := server.Receive()
+			assert.Nil(err)
+			received <- msg
+			if i == 0 {
+				binding, err := server.Binding()
+				assert.Nil(err)
+				bound <- binding
+			}
+		}
+	}()
+
+	clientTrans, err := NewClientTransport("tcp://" + addr)
+	assert.Nil(err)
+	assert.IsType(&TCPTransport{}, clientTrans)
+	client, err := clientTrans.Dial(context.Background())
+	assert.Nil(err)
+	assert.NotNil(client)
+	defer client.Close()
+	err = client.Send([]byte("hello mixin"))
+	assert.Nil(err)
+	assert.Equal("hello mixin", string(<-received))
+	binding, err := client.Binding()
+	assert.Nil(err)
+	assert.Len(binding, clientBindingLength)
+	assert.Equal(binding, <-bound)
+
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	w.Write([]byte("hello gzip"))
+	w.Close()
+	header := []byte{TransportMessageVersion, TransportCompressionGzip, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(header[2:], uint32(buf.Len()))
+	_, err = client.(*TCPClient).conn.Write(append(header, buf.Bytes()...))
+	assert.Nil(err)
+	assert.Equal("hello gzip", string(<-received))
+}
+
+func TestParseAddress(t *testing.T) {
+	assert := assert.New(t)
+
+	scheme, host, err := ParseAddress("127.0.0.1:7239")
+	assert.Nil(err)
+	assert.Equal(AddressSchemeQuic, scheme)
+	assert.Equal("127.0.0.1:7239", host)
+	scheme, host, err = ParseAddress("tcp://127.0.0.1:7239")
+	assert.Nil(err)
+	assert.Equal(AddressSchemeTCP, scheme)
+	assert.Equal("127.0.0.1:7239", host)
+	_, _, err = ParseAddress("udp://127.0.0.1:7239")
+	assert.NotNil(err)
+	_, _, err = ParseAddress("tcp://127.0.0.1:70")
+	assert.NotNil(err)
+	scheme, host, err = ParseAddress("tcp://[::1]:7239")
+	assert.Nil(err)
+	assert.Equal(AddressSchemeTCP, scheme)
+	assert.Equal("[::1]:7239", host)
+
+	assert.Equal("127.0.0.1:7239", routableAddress([]string{"invalid", "tcp://127.0.0.1:70", "127.0.0.1:7239"}))
+	assert.Equal("invalid", routableAddress([]string{"invalid"}))
+}
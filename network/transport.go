@@ -1,8 +1,15 @@
 package network
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
+	"fmt"
+	"io"
 	"net"
+
+	"github.com/MixinNetwork/mixin/common"
+	"github.com/MixinNetwork/mixin/crypto"
 )
 
 const (
@@ -22,8 +29,20 @@ type TransportMessage struct {
 	Data        []byte
 }
 
+// The binding of a client is the keying material exported from the TLS
+// session, which is the same on both sides of the connection and unique to
+// it, so the peer authentication signs it to be bound to the connection.
+const (
+	clientBindingLabel  = "EXPORTER-mixin-peer-authentication"
+	clientBindingLength = 32
+)
+
+// The signer id of a client is the peer id proven by the TLS signer
+// certificate of the remote, or an empty hash if it's not signed.
 type Client interface {
 	RemoteAddr() net.Addr
+	Binding() ([]byte, error)
+	SignerId() (crypto.Hash, error)
 	Receive() ([]byte, error)
 	Send([]byte) error
 	Close() error
@@ -35,3 +54,74 @@ type Transport interface {
 	Accept(ctx context.Context) (Client, error)
 	Close() error
 }
+
+// TransportFactory creates the listening transports from bind addresses,
+// and the dialing transports from peer addresses.
+type TransportFactory interface {
+	NewServer(addr string) (Transport, error)
+	NewClient(addr string) (Transport, error)
+}
+
+type socketTransportFactory struct{}
+
+func (socketTransportFactory) NewServer(addr string) (Transport, error) {
+	return NewServerTransport(addr)
+}
+
+func (socketTransportFactory) NewClient(addr string) (Transport, error) {
+	return NewClientTransport(addr)
+}
+
+// setClientCompression changes the compression method and zstd dictionary
+// of the dialed client, the clients without compression are not changed. The
+// received messages are decoded with any embedded dictionary by the frame id,
+// so only the sender needs the dictionary negotiated. The dialed clients use
+// the dictionary version zero until changed, because all nodes have it.
+func setClientCompression(c Client, method uint8, dictionary uint32) {
+	switch c := c.(type) {
+	case *QuicClient:
+		c.compression = method
+		if c.dictionary != dictionary {
+			c.zstdZipper.Close()
+			c.zstdZipper = common.NewZstdDictionaryEncoder(1, dictionary)
+			c.dictionary = dictionary
+		}
+	case *TCPClient:
+		c.compression = method
+		if c.dictionary != dictionary {
+			c.zstdZipper.Close()
+			c.zstdZipper = common.NewZstdDictionaryEncoder(1, dictionary)
+			c.dictionary = dictionary
+		}
+	}
+}
+
+func gzipTransportMessage(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	_, err := w.Write(data)
+	if err != nil {
+		return nil, err
+	}
+	err = w.Close()
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func gunzipTransportMessage(data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	data, err = io.ReadAll(io.LimitReader(r, TransportMessageMaxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > TransportMessageMaxSize {
+		return nil, fmt.Errorf("gzip invalid message size %d", len(data))
+	}
+	return data, nil
+}
@@ -0,0 +1,232 @@
+package proof
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sort"
+
+	"github.com/MixinNetwork/mixin/common"
+	"github.com/MixinNetwork/mixin/config"
+	"github.com/MixinNetwork/mixin/crypto"
+)
+
+// Node is the latest state of a consensus node known at some timestamp,
+// the signer is the public spend key of the node signer address.
+type Node struct {
+	Signer    crypto.Key `json:"signer"`
+	State     string     `json:"state"`
+	Timestamp uint64     `json:"timestamp"`
+}
+
+// Checkpoint is the consensus nodes list trusted by the verifier, usually
+// the genesis, or a checkpoint previously verified by the same verifier.
+type Checkpoint struct {
+	NetworkId crypto.Hash  `json:"network"`
+	Timestamp uint64       `json:"timestamp"`
+	Genesis   []crypto.Key `json:"genesis"`
+	Nodes     []*Node      `json:"nodes"`
+}
+
+// Change is a finalized node operation snapshot with its transaction,
+// both hex encoded, the snapshot in msgpack with the cosi signature.
+type Change struct {
+	Snapshot    string `json:"snapshot"`
+	Transaction string `json:"transaction"`
+}
+
+type SnapshotProof struct {
+	Snapshot   string       `json:"snapshot"`
+	Keys       []crypto.Key `json:"keys"`
+	Threshold  int          `json:"threshold"`
+	Checkpoint *Checkpoint  `json:"checkpoint"`
+	Changes    []*Change    `json:"changes"`
+}
+
+func EncodeSnapshot(s *common.Snapshot) string {
+	return hex.EncodeToString(common.MsgpackMarshalPanic(s))
+}
+
+func DecodeSnapshot(data string) (*common.Snapshot, error) {
+	b, err := hex.DecodeString(data)
+	if err != nil {
+		return nil, err
+	}
+	var s common.Snapshot
+	err = common.MsgpackUnmarshal(b, &s)
+	if err != nil {
+		return nil, err
+	}
+	if s.Version != common.SnapshotVersion || s.Signature == nil {
+		return nil, fmt.Errorf("invalid snapshot version %d", s.Version)
+	}
+	s.Hash = s.PayloadHash()
+	return &s, nil
+}
+
+// Verify the snapshot finalization only with the trusted checkpoint, the
+// checkpoint embedded in the proof is informational and never used here.
+// All node operations since the checkpoint are verified and applied in order
+// to rebuild the exact consensus keys when the snapshot is signed.
+func (p *SnapshotProof) Verify(trusted *Checkpoint) (*common.Snapshot, error) {
+	s, err := DecodeSnapshot(p.Snapshot)
+	if err != nil {
+		return nil, err
+	}
+	if s.Timestamp <= trusted.Timestamp {
+		return nil, fmt.Errorf("snapshot %s before checkpoint %d %d", s.Hash, s.Timestamp, trusted.Timestamp)
+	}
+
+	state := newNodesState(trusted)
+	for i, c := range p.Changes {
+		err := state.apply(c, s.Timestamp)
+		if err != nil {
+			return nil, fmt.Errorf("change %d %s", i, err.Error())
+		}
+	}
+
+	publics, threshold := state.consensusKeys(s)
+	if len(publics) != len(p.Keys) || threshold != p.Threshold {
+		return nil, fmt.Errorf("consensus keys not match %d %d %d %d", len(publics), len(p.Keys), threshold, p.Threshold)
+	}
+	for i, k := range publics {
+		if *k != p.Keys[i] {
+			return nil, fmt.Errorf("consensus key %d not match %s %s", i, k, p.Keys[i])
+		}
+	}
+	err = s.Signature.FullVerify(publics, threshold, s.Hash[:])
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+type nodesState struct {
+	networkId crypto.Hash
+	timestamp uint64
+	genesis   map[crypto.Key]bool
+	nodes     map[crypto.Key]*Node
+}
+
+func newNodesState(cp *Checkpoint) *nodesState {
+	state := &nodesState{
+		networkId: cp.NetworkId,
+		timestamp: cp.Timestamp,
+		genesis:   make(map[crypto.Key]bool),
+		nodes:     make(map[crypto.Key]*Node),
+	}
+	for _, k := range cp.Genesis {
+		state.genesis[k] = true
+	}
+	for _, n := range cp.Nodes {
+		state.nodes[n.Signer] = &Node{Signer: n.Signer, State: n.State, Timestamp: n.Timestamp}
+	}
+	return state
+}
+
+func (state *nodesState) apply(c *Change, until uint64) error {
+	s, err := DecodeSnapshot(c.Snapshot)
+	if err != nil {
+		return err
+	}
+	if s.Timestamp <= state.timestamp || s.Timestamp >= until {
+		return fmt.Errorf("invalid snapshot timestamp %d %d %d", s.Timestamp, state.timestamp, until)
+	}
+	raw, err := hex.DecodeString(c.Transaction)
+	if err != nil {
+		return err
+	}
+	tx, err := common.UnmarshalVersionedTransaction(raw)
+	if err != nil {
+		return err
+	}
+	if tx.PayloadHash() != s.Transaction {
+		return fmt.Errorf("invalid snapshot transaction %s %s", s.Transaction, tx.PayloadHash())
+	}
+
+	publics, threshold := state.consensusKeys(s)
+	err = s.Signature.FullVerify(publics, threshold, s.Hash[:])
+	if err != nil {
+		return err
+	}
+
+	var signer crypto.Key
+	if len(tx.Extra) < len(signer) {
+		return fmt.Errorf("invalid node transaction extra %x", tx.Extra)
+	}
+	copy(signer[:], tx.Extra)
+	var ns string
+	switch tx.TransactionType() {
+	case common.TransactionTypeNodePledge:
+		ns = common.NodeStatePledging
+	case common.TransactionTypeNodeCancel:
+		ns = common.NodeStateCancelled
+	case common.TransactionTypeNodeAccept:
+		ns = common.NodeStateAccepted
+	case common.TransactionTypeNodeRemove:
+		ns = common.NodeStateRemoved
+	default:
+		return fmt.Errorf("invalid node transaction type %d", tx.TransactionType())
+	}
+	state.nodes[signer] = &Node{Signer: signer, State: ns, Timestamp: s.Timestamp}
+	state.timestamp = s.Timestamp
+	return nil
+}
+
+// consensusKeys follows exactly the kernel Chain.ConsensusKeys and
+// Node.ConsensusThreshold, the keys order matters to the cosi mask.
+func (state *nodesState) consensusKeys(s *common.Snapshot) ([]*crypto.Key, int) {
+	type cnode struct {
+		*Node
+		id crypto.Hash
+	}
+	nodes := make([]*cnode, 0)
+	for _, n := range state.nodes {
+		if n.Timestamp >= s.Timestamp {
+			continue
+		}
+		nodes = append(nodes, &cnode{Node: n, id: state.idForNetwork(n.Signer)})
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		if nodes[i].Timestamp != nodes[j].Timestamp {
+			return nodes[i].Timestamp < nodes[j].Timestamp
+		}
+		return nodes[i].id.String() < nodes[j].id.String()
+	})
+
+	var publics []*crypto.Key
+	var pledging *cnode
+	base := 0
+	accept := uint64(config.KernelNodeAcceptPeriodMinimum)
+	gap := uint64(config.SnapshotReferenceThreshold * config.SnapshotRoundGap)
+	for _, n := range nodes {
+		switch n.State {
+		case common.NodeStatePledging:
+			pledging = n
+			if n.Timestamp+accept-gap*3 < s.Timestamp {
+				base++
+			}
+		case common.NodeStateAccepted:
+			genesis := state.genesis[n.Signer]
+			if genesis || n.Timestamp+accept < s.Timestamp {
+				publics = append(publics, &n.Node.Signer)
+			}
+			if genesis || n.Timestamp+gap < s.Timestamp {
+				base++
+			}
+		}
+	}
+	if pledging != nil && s.RoundNumber == 0 && pledging.id == s.NodeId {
+		publics = append(publics, &pledging.Node.Signer)
+	}
+	if base < config.KernelMinimumNodesCount {
+		return publics, 1000
+	}
+	return publics, base*2/3 + 1
+}
+
+func (state *nodesState) idForNetwork(signer crypto.Key) crypto.Hash {
+	var addr common.Address
+	addr.PublicSpendKey = signer
+	addr.PublicViewKey = signer.DeterministicHashDerive().Public()
+	return addr.Hash().ForNetwork(state.networkId)
+}
@@ -0,0 +1,144 @@
+package proof
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/MixinNetwork/mixin/common"
+	"github.com/MixinNetwork/mixin/crypto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSnapshotProof(t *testing.T) {
+	assert := assert.New(t)
+
+	networkId := crypto.NewHash([]byte("proof-test-network"))
+	epoch := uint64(time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC).UnixNano())
+	hour := uint64(time.Hour)
+
+	privs := make(map[crypto.Key]*crypto.Key)
+	cp := &Checkpoint{NetworkId: networkId, Timestamp: epoch}
+	for i := 0; i < 7; i++ {
+		pub := testPrivateKey(privs, i)
+		cp.Genesis = append(cp.Genesis, pub)
+		cp.Nodes = append(cp.Nodes, &Node{Signer: pub, State: common.NodeStateAccepted, Timestamp: epoch})
+	}
+	signer := testPrivateKey(privs, 7)
+	payee := testPrivateKey(privs, 8)
+	state := newNodesState(cp)
+	extra := append(signer[:], payee[:]...)
+
+	pledge := common.NewTransaction(common.XINAssetId)
+	pledge.AddOutputWithType(common.OutputTypeNodePledge, nil, common.Script{}, common.NewIntegerFromString("10000"), nil)
+	pledge.Extra = extra
+	ps := &common.Snapshot{
+		Version:     common.SnapshotVersion,
+		NodeId:      state.idForNetwork(cp.Genesis[0]),
+		Transaction: pledge.AsLatestVersion().PayloadHash(),
+		RoundNumber: 100,
+		Timestamp:   epoch + hour,
+	}
+	testSignSnapshot(assert, state, privs, ps, 5)
+	err := state.apply(&Change{EncodeSnapshot(ps), hex.EncodeToString(pledge.AsLatestVersion().Marshal())}, epoch+hour*30)
+	assert.Nil(err)
+
+	accept := common.NewTransaction(common.XINAssetId)
+	accept.AddOutputWithType(common.OutputTypeNodeAccept, nil, common.Script{}, common.NewIntegerFromString("10000"), nil)
+	accept.Extra = extra
+	as := &common.Snapshot{
+		Version:     common.SnapshotVersion,
+		NodeId:      state.idForNetwork(signer),
+		Transaction: accept.AsLatestVersion().PayloadHash(),
+		Timestamp:   epoch + hour*14,
+	}
+	publics, threshold := state.consensusKeys(as)
+	assert.Len(publics, 8)
+	assert.Equal(6, threshold)
+	testSignSnapshot(assert, state, privs, as, 6)
+
+	target := &common.Snapshot{
+		Version:     common.SnapshotVersion,
+		NodeId:      state.idForNetwork(cp.Genesis[3]),
+		Transaction: crypto.NewHash([]byte("target")),
+		RoundNumber: 1000,
+		Timestamp:   epoch + hour*30,
+	}
+	state = newNodesState(cp)
+	state.nodes[signer] = &Node{Signer: signer, State: common.NodeStateAccepted, Timestamp: as.Timestamp}
+	publics, threshold = state.consensusKeys(target)
+	assert.Len(publics, 8)
+	assert.Equal(6, threshold)
+	testSignSnapshot(assert, state, privs, target, 6)
+
+	keys := make([]crypto.Key, len(publics))
+	for i, k := range publics {
+		keys[i] = *k
+	}
+	p := &SnapshotProof{
+		Snapshot:  EncodeSnapshot(target),
+		Keys:      keys,
+		Threshold: threshold,
+		Changes: []*Change{
+			{EncodeSnapshot(ps), hex.EncodeToString(pledge.AsLatestVersion().Marshal())},
+			{EncodeSnapshot(as), hex.EncodeToString(accept.AsLatestVersion().Marshal())},
+		},
+	}
+	s, err := p.Verify(cp)
+	assert.Nil(err)
+	assert.Equal(target.Hash, s.Hash)
+
+	changes := p.Changes
+	p.Changes = changes[:1]
+	_, err = p.Verify(cp)
+	assert.NotNil(err)
+	p.Changes = []*Change{changes[1], changes[0]}
+	_, err = p.Verify(cp)
+	assert.NotNil(err)
+	p.Changes = changes
+
+	p.Threshold = 5
+	_, err = p.Verify(cp)
+	assert.NotNil(err)
+	p.Threshold = threshold
+
+	target.Signature = nil
+	testSignSnapshot(assert, state, privs, target, 5)
+	p.Snapshot = EncodeSnapshot(target)
+	_, err = p.Verify(cp)
+	assert.NotNil(err)
+}
+
+func testPrivateKey(privs map[crypto.Key]*crypto.Key, i int) crypto.Key {
+	seed := crypto.NewHash([]byte(fmt.Sprintf("proof-test-node-%d", i)))
+	priv := crypto.NewKeyFromSeed(append(seed[:], seed[:]...))
+	pub := priv.Public()
+	privs[pub] = &priv
+	return pub
+}
+
+func testSignSnapshot(assert *assert.Assertions, state *nodesState, privs map[crypto.Key]*crypto.Key, s *common.Snapshot, count int) {
+	s.Hash = s.PayloadHash()
+	publics, _ := state.consensusKeys(s)
+	randoms := make(map[int]*crypto.Key)
+	commitments := make(map[int]*crypto.Key)
+	for i := 0; i < count; i++ {
+		r := crypto.CosiCommit(rand.Reader)
+		R := r.Public()
+		randoms[i] = r
+		commitments[i] = &R
+	}
+	cosi, err := crypto.CosiAggregateCommitment(commitments)
+	assert.Nil(err)
+	responses := make(map[int]*[32]byte)
+	for i := 0; i < count; i++ {
+		res, err := cosi.Response(privs[*publics[i]], randoms[i], publics, s.Hash[:])
+		assert.Nil(err)
+		responses[i] = res
+	}
+	err = cosi.AggregateResponse(publics, responses, s.Hash[:], true)
+	assert.Nil(err)
+	s.Signature = cosi
+}
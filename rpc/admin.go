@@ -0,0 +1,185 @@
+package rpc
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"runtime"
+	"runtime/pprof"
+	"strconv"
+
+	"github.com/MixinNetwork/mixin/common"
+	"github.com/MixinNetwork/mixin/crypto"
+	"github.com/MixinNetwork/mixin/kernel"
+	"github.com/MixinNetwork/mixin/logger"
+	"github.com/MixinNetwork/mixin/storage"
+)
+
+func addNeighbor(node *kernel.Node, params []interface{}) (map[string]interface{}, error) {
+	if len(params) != 2 {
+		return nil, errors.New("invalid params count")
+	}
+	id, err := crypto.HashFromString(fmt.Sprint(params[0]))
+	if err != nil {
+		return nil, err
+	}
+	if node.Peer == nil {
+		return nil, errors.New("peer not ready")
+	}
+	if id == node.IdForNetwork {
+		return nil, errors.New("invalid neighbor self")
+	}
+	p, err := node.Peer.AddNeighbor(id, fmt.Sprint(params[1]))
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"id": p.IdForNetwork, "address": p.Address}, nil
+}
+
+func removeNeighbor(node *kernel.Node, params []interface{}) (map[string]interface{}, error) {
+	if len(params) != 1 {
+		return nil, errors.New("invalid params count")
+	}
+	id, err := crypto.HashFromString(fmt.Sprint(params[0]))
+	if err != nil {
+		return nil, err
+	}
+	if node.Peer == nil {
+		return nil, errors.New("peer not ready")
+	}
+	removed := node.Peer.RemoveNeighbor(id)
+	return map[string]interface{}{"id": id, "removed": removed}, nil
+}
+
+func setLogLevel(params []interface{}) (map[string]interface{}, error) {
+	if len(params) != 1 {
+		return nil, errors.New("invalid params count")
+	}
+	level, err := strconv.ParseUint(fmt.Sprint(params[0]), 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	if level > logger.DEBUG {
+		return nil, fmt.Errorf("invalid log level %d", level)
+	}
+	logger.SetLevel(int(level))
+	return map[string]interface{}{"level": level}, nil
+}
+
+func setLogLimiter(params []interface{}) (map[string]interface{}, error) {
+	if len(params) != 1 {
+		return nil, errors.New("invalid params count")
+	}
+	limiter, err := strconv.ParseUint(fmt.Sprint(params[0]), 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	logger.SetLimiter(int(limiter))
+	return map[string]interface{}{"limiter": limiter}, nil
+}
+
+func setLogFilter(params []interface{}) (map[string]interface{}, error) {
+	if len(params) != 1 {
+		return nil, errors.New("invalid params count")
+	}
+	pattern := fmt.Sprint(params[0])
+	err := logger.SetFilter(pattern)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"filter": pattern}, nil
+}
+
+func runValueLogGC(store storage.Store, params []interface{}) (map[string]interface{}, error) {
+	if len(params) != 1 {
+		return nil, errors.New("invalid params count")
+	}
+	ratio, err := strconv.ParseFloat(fmt.Sprint(params[0]), 64)
+	if err != nil {
+		return nil, err
+	}
+	if ratio <= 0 || ratio >= 1 {
+		return nil, fmt.Errorf("invalid discard ratio %f", ratio)
+	}
+	count, err := store.RunValueLogGC(ratio)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"rewritten": count}, nil
+}
+
+func dumpGoroutines() (map[string]interface{}, error) {
+	var buf bytes.Buffer
+	err := pprof.Lookup("goroutine").WriteTo(&buf, 1)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"count":  runtime.NumGoroutine(),
+		"stacks": buf.String(),
+	}, nil
+}
+
+func dumpQueue(node *kernel.Node, store storage.Store) (map[string]interface{}, error) {
+	count, bytes, _, err := store.CacheTransactionsStats()
+	if err != nil {
+		return nil, err
+	}
+	caches, finals, state := node.QueueState()
+	return map[string]interface{}{
+		"caches": caches,
+		"finals": finals,
+		"state":  state,
+		"pending": map[string]interface{}{
+			"count": count,
+			"bytes": bytes,
+		},
+	}, nil
+}
+
+func exportCheckpoint(node *kernel.Node, params []interface{}) (map[string]interface{}, error) {
+	if len(params) != 0 {
+		return nil, errors.New("invalid params count")
+	}
+	cs, err := node.ExportCheckpoint()
+	if err != nil {
+		return nil, err
+	}
+	return checkpointStateToMap(cs), nil
+}
+
+func signCheckpoint(node *kernel.Node, params []interface{}) (map[string]interface{}, error) {
+	if len(params) != 1 {
+		return nil, errors.New("invalid params count")
+	}
+	data, err := hex.DecodeString(fmt.Sprint(params[0]))
+	if err != nil {
+		return nil, err
+	}
+	var header common.CheckpointHeader
+	err = common.MsgpackUnmarshal(data, &header)
+	if err != nil {
+		return nil, err
+	}
+	cs, err := node.SignCheckpoint(&header)
+	if err != nil {
+		return nil, err
+	}
+	return checkpointStateToMap(cs), nil
+}
+
+func checkpointStateToMap(cs *kernel.CheckpointState) map[string]interface{} {
+	state := map[string]interface{}{
+		"header": hex.EncodeToString(common.MsgpackMarshalPanic(cs.Header)),
+		"node":   cs.NodeId,
+		"done":   cs.Done,
+	}
+	if cs.Err != nil {
+		state["error"] = cs.Err.Error()
+	} else if cs.Done {
+		state["hash"] = cs.Hash
+		state["signature"] = cs.Signature
+	}
+	return state
+}
@@ -0,0 +1,31 @@
+package rpc
+
+import (
+	"net/http"
+	"os"
+
+	"github.com/unrolled/render"
+)
+
+// checkpoint serves the checkpoint file exported by the node, with the range
+// requests supported, so a large file could be downloaded in many requests
+// within the write timeout.
+func (impl *R) checkpoint(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+	f, err := os.Open(impl.Node.CheckpointFile())
+	if os.IsNotExist(err) {
+		render.New().JSON(w, http.StatusNotFound, map[string]interface{}{"error": "not found"})
+		return
+	} else if err != nil {
+		render.New().JSON(w, http.StatusInternalServerError, map[string]interface{}{"error": "server error"})
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		render.New().JSON(w, http.StatusInternalServerError, map[string]interface{}{"error": "server error"})
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	http.ServeContent(w, r, "checkpoint.dat", info.ModTime(), f)
+}
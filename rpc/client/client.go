@@ -0,0 +1,158 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	DefaultTimeout = 60 * time.Second
+)
+
+// Error is returned by the node when the call reached it but failed, it's
+// never retried on other endpoints.
+type Error struct {
+	Method  string
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("ERROR %s", e.Message)
+}
+
+type Response struct {
+	Runtime string
+	Data    json.RawMessage
+}
+
+type Client struct {
+	// Endpoints are tried in order until one responds, the last responded
+	// endpoint is preferred by the following calls.
+	Endpoints []string
+	// AdminToken is sent as the bearer authorization for admin methods.
+	AdminToken string
+	// Timeout applies to each attempt if the context has no deadline.
+	Timeout time.Duration
+	// Retries is the rounds to try all endpoints before giving up.
+	Retries int
+	// OnRuntime is called with the node runtime of each responded call.
+	OnRuntime func(method, runtime string)
+
+	httpClient *http.Client
+	preferred  uint32
+}
+
+func NewClient(endpoints ...string) *Client {
+	return &Client{
+		Endpoints:  endpoints,
+		Timeout:    DefaultTimeout,
+		Retries:    1,
+		httpClient: &http.Client{},
+	}
+}
+
+// Call the method and decode the result data into result, a nil data
+// leaves result untouched and the caller should check it.
+func (c *Client) Call(ctx context.Context, method string, params []interface{}, result interface{}) error {
+	resp, err := c.CallRaw(ctx, method, params)
+	if err != nil || result == nil {
+		return err
+	}
+	return json.Unmarshal(resp.Data, result)
+}
+
+func (c *Client) CallRaw(ctx context.Context, method string, params []interface{}) (*Response, error) {
+	if len(c.Endpoints) == 0 {
+		return nil, errors.New("no endpoints")
+	}
+	if params == nil {
+		params = []interface{}{}
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"method": method,
+		"params": params,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	retries := c.Retries
+	if retries < 1 {
+		retries = 1
+	}
+	start := int(atomic.LoadUint32(&c.preferred))
+	for i := 0; i < retries*len(c.Endpoints); i++ {
+		var resp *rawResponse
+		index := (start + i) % len(c.Endpoints)
+		resp, err = c.post(ctx, c.Endpoints[index], body)
+		if err == nil {
+			atomic.StoreUint32(&c.preferred, uint32(index))
+			if c.OnRuntime != nil && resp.Runtime != "" {
+				c.OnRuntime(method, resp.Runtime)
+			}
+			return resp.decode(method)
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+	}
+	return nil, err
+}
+
+func (c *Client) post(ctx context.Context, node string, body []byte) (*rawResponse, error) {
+	if _, ok := ctx.Deadline(); !ok && c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+
+	endpoint := "http://" + node
+	if strings.HasPrefix(node, "http") {
+		endpoint = node
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Close = true
+	req.Header.Set("Content-Type", "application/json")
+	if c.AdminToken != "" {
+		req.Header.Set("Authorization", "Bearer "+c.AdminToken)
+	}
+	hc := c.httpClient
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result rawResponse
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	if err != nil {
+		return nil, fmt.Errorf("invalid response from %s %d %s", node, resp.StatusCode, err)
+	}
+	return &result, nil
+}
+
+type rawResponse struct {
+	Runtime string          `json:"runtime"`
+	Data    json.RawMessage `json:"data"`
+	Error   interface{}     `json:"error"`
+}
+
+func (r *rawResponse) decode(method string) (*Response, error) {
+	if r.Error != nil {
+		return nil, &Error{Method: method, Message: fmt.Sprint(r.Error)}
+	}
+	return &Response{Runtime: r.Runtime, Data: r.Data}, nil
+}
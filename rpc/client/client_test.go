@@ -0,0 +1,111 @@
+package client
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/MixinNetwork/mixin/common"
+	"github.com/MixinNetwork/mixin/crypto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClient(t *testing.T) {
+	assert := assert.New(t)
+
+	tx := common.NewTransaction(common.XINAssetId)
+	tx.AddInput(crypto.NewHash([]byte("input")), 1)
+	tx.Extra = []byte("client-test")
+	ver := tx.AsLatestVersion()
+	snap := crypto.NewHash([]byte("snapshot"))
+
+	var calls int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		var call struct {
+			Method string        `json:"method"`
+			Params []interface{} `json:"params"`
+		}
+		json.NewDecoder(r.Body).Decode(&call)
+		var body map[string]interface{}
+		switch call.Method {
+		case "gettransaction":
+			body = map[string]interface{}{"data": map[string]interface{}{
+				"hash":     ver.PayloadHash(),
+				"hex":      hex.EncodeToString(ver.Marshal()),
+				"snapshot": snap,
+			}}
+		case "getroundlink":
+			body = map[string]interface{}{"data": map[string]interface{}{"link": 123}, "runtime": "1ms"}
+		case "removependingtransaction":
+			if r.Header.Get("Authorization") != "Bearer token" {
+				body = map[string]interface{}{"error": "unauthorized"}
+			} else if call.Params[0] != snap.String() {
+				body = map[string]interface{}{"error": "transaction not found"}
+			} else {
+				body = map[string]interface{}{"data": map[string]interface{}{"hash": snap}}
+			}
+		case "signcheckpoint":
+			body = map[string]interface{}{"data": map[string]interface{}{
+				"header": call.Params[0],
+				"node":   snap,
+				"done":   true,
+				"hash":   snap,
+			}}
+		default:
+			body = map[string]interface{}{"error": "invalid method " + call.Method}
+		}
+		json.NewEncoder(w).Encode(body)
+	}))
+	defer server.Close()
+
+	dead := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	dead.Close()
+
+	rc := NewClient(dead.URL, server.URL)
+	rc.Timeout = time.Second
+	ctx := context.Background()
+
+	res, hash, err := rc.GetTransaction(ctx, ver.PayloadHash())
+	assert.Nil(err)
+	assert.Equal(snap, hash)
+	assert.Equal(ver.PayloadHash(), res.PayloadHash())
+	assert.Equal(1, calls)
+
+	var runtime string
+	rc.OnRuntime = func(method, rt string) {
+		runtime = method + " " + rt
+	}
+	link, err := rc.GetRoundLink(ctx, snap, snap)
+	assert.Nil(err)
+	assert.Equal(uint64(123), link)
+	assert.Equal(2, calls)
+	assert.Equal("getroundlink 1ms", runtime)
+
+	err = rc.RemovePendingTransaction(ctx, hash)
+	assert.NotNil(err)
+	assert.IsType(&Error{}, err)
+	assert.Equal(3, calls)
+	rc.AdminToken = "token"
+	err = rc.RemovePendingTransaction(ctx, hash)
+	assert.Nil(err)
+	err = rc.RemovePendingTransaction(ctx, ver.PayloadHash())
+	assert.NotNil(err)
+	assert.Equal("ERROR transaction not found", err.Error())
+
+	state, err := rc.SignCheckpoint(ctx, []byte("header"))
+	assert.Nil(err)
+	assert.Equal([]byte("header"), state.Header)
+	assert.True(state.Done)
+	assert.Equal(snap, state.Hash)
+	assert.Equal(6, calls)
+
+	rc = NewClient(dead.URL)
+	_, err = rc.GetInfo(ctx)
+	assert.NotNil(err)
+	assert.Equal(6, calls)
+}
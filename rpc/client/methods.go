@@ -0,0 +1,330 @@
+package client
+
+import (
+	"context"
+	"encoding/hex"
+
+	"github.com/MixinNetwork/mixin/common"
+	"github.com/MixinNetwork/mixin/crypto"
+	"github.com/MixinNetwork/mixin/proof"
+)
+
+func (c *Client) GetInfo(ctx context.Context) (*Info, error) {
+	var info Info
+	err := c.Call(ctx, "getinfo", nil, &info)
+	if err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+func (c *Client) DumpGraphHead(ctx context.Context) ([]*GraphHead, error) {
+	var heads []*GraphHead
+	err := c.Call(ctx, "dumpgraphhead", nil, &heads)
+	if err != nil {
+		return nil, err
+	}
+	return heads, nil
+}
+
+func (c *Client) SendRawTransaction(ctx context.Context, raw string) (crypto.Hash, error) {
+	var result struct {
+		Hash crypto.Hash `json:"hash"`
+	}
+	err := c.Call(ctx, "sendrawtransaction", []interface{}{raw}, &result)
+	return result.Hash, err
+}
+
+func (c *Client) SendTransaction(ctx context.Context, ver *common.VersionedTransaction) (crypto.Hash, error) {
+	return c.SendRawTransaction(ctx, hex.EncodeToString(ver.Marshal()))
+}
+
+func (c *Client) ValidateRawTransaction(ctx context.Context, raw string) (*TransactionValidation, error) {
+	var result TransactionValidation
+	err := c.Call(ctx, "validaterawtransaction", []interface{}{raw}, &result)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// GetTransaction returns the finalized transaction and its snapshot hash,
+// or nil if not found.
+func (c *Client) GetTransaction(ctx context.Context, hash crypto.Hash) (*common.VersionedTransaction, crypto.Hash, error) {
+	var result *struct {
+		transactionJSON
+		Snapshot crypto.Hash `json:"snapshot"`
+	}
+	err := c.Call(ctx, "gettransaction", []interface{}{hash.String()}, &result)
+	if err != nil || result == nil {
+		return nil, crypto.Hash{}, err
+	}
+	ver, err := result.decode()
+	return ver, result.Snapshot, err
+}
+
+func (c *Client) GetCacheTransaction(ctx context.Context, hash crypto.Hash) (*common.VersionedTransaction, error) {
+	var result *transactionJSON
+	err := c.Call(ctx, "getcachetransaction", []interface{}{hash.String()}, &result)
+	if err != nil || result == nil {
+		return nil, err
+	}
+	return result.decode()
+}
+
+func (c *Client) GetTransactionStatus(ctx context.Context, hash crypto.Hash) (*TransactionStatus, error) {
+	var result TransactionStatus
+	err := c.Call(ctx, "gettransactionstatus", []interface{}{hash.String()}, &result)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// ListPendingTransactions returns the cached transactions and the offset of
+// the next page, which is empty at the end.
+func (c *Client) ListPendingTransactions(ctx context.Context, offset crypto.Hash, limit int, asset crypto.Hash) ([]*common.VersionedTransaction, crypto.Hash, error) {
+	params := []interface{}{"", limit, ""}
+	if offset.HasValue() {
+		params[0] = offset.String()
+	}
+	if asset.HasValue() {
+		params[2] = asset.String()
+	}
+	var result struct {
+		Transactions []*transactionJSON `json:"transactions"`
+		Next         crypto.Hash        `json:"next"`
+	}
+	err := c.Call(ctx, "listpendingtransactions", params, &result)
+	if err != nil {
+		return nil, crypto.Hash{}, err
+	}
+	txs := make([]*common.VersionedTransaction, len(result.Transactions))
+	for i, t := range result.Transactions {
+		ver, err := t.decode()
+		if err != nil {
+			return nil, crypto.Hash{}, err
+		}
+		txs[i] = ver
+	}
+	return txs, result.Next, nil
+}
+
+func (c *Client) GetPendingTransactionsStats(ctx context.Context) (*PendingTransactionsStats, error) {
+	var result PendingTransactionsStats
+	err := c.Call(ctx, "getpendingtransactionsstats", nil, &result)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// RemovePendingTransaction requires the client AdminToken, and returns
+// the node error if the transaction is not in cache.
+func (c *Client) RemovePendingTransaction(ctx context.Context, hash crypto.Hash) error {
+	return c.Call(ctx, "removependingtransaction", []interface{}{hash.String()}, nil)
+}
+
+func (c *Client) GetUTXO(ctx context.Context, hash crypto.Hash, index int) (*common.UTXOWithLock, error) {
+	var result *struct {
+		Type   uint8          `json:"type"`
+		Hash   crypto.Hash    `json:"hash"`
+		Index  int            `json:"index"`
+		Amount common.Integer `json:"amount"`
+		Keys   []*crypto.Key  `json:"keys"`
+		Script common.Script  `json:"script"`
+		Mask   crypto.Key     `json:"mask"`
+		Lock   crypto.Hash    `json:"lock"`
+	}
+	err := c.Call(ctx, "getutxo", []interface{}{hash.String(), index}, &result)
+	if err != nil || result == nil {
+		return nil, err
+	}
+	utxo := &common.UTXOWithLock{LockHash: result.Lock}
+	utxo.Hash, utxo.Index = result.Hash, result.Index
+	utxo.Type, utxo.Amount = result.Type, result.Amount
+	utxo.Keys, utxo.Script, utxo.Mask = result.Keys, result.Script, result.Mask
+	return utxo, nil
+}
+
+func (c *Client) GetSnapshot(ctx context.Context, hash crypto.Hash) (*common.SnapshotWithTopologicalOrder, error) {
+	var result *snapshotJSON
+	err := c.Call(ctx, "getsnapshot", []interface{}{hash.String()}, &result)
+	if err != nil || result == nil {
+		return nil, err
+	}
+	return result.decode()
+}
+
+func (c *Client) GetSnapshotProof(ctx context.Context, hash crypto.Hash, checkpoint uint64) (*proof.SnapshotProof, error) {
+	var result *proof.SnapshotProof
+	err := c.Call(ctx, "getsnapshotproof", []interface{}{hash.String(), checkpoint}, &result)
+	return result, err
+}
+
+// ListSnapshots returns the snapshots without their transactions, use
+// GetTransaction to read them.
+func (c *Client) ListSnapshots(ctx context.Context, offset, count uint64, sig bool) ([]*common.SnapshotWithTopologicalOrder, error) {
+	var result []*snapshotJSON
+	err := c.Call(ctx, "listsnapshots", []interface{}{offset, count, sig, false}, &result)
+	if err != nil {
+		return nil, err
+	}
+	return decodeSnapshots(result)
+}
+
+func (c *Client) ListMintWorks(ctx context.Context, offset uint64) (map[crypto.Hash][2]uint64, error) {
+	var result map[string][2]uint64
+	err := c.Call(ctx, "listmintworks", []interface{}{offset}, &result)
+	if err != nil {
+		return nil, err
+	}
+	works := make(map[crypto.Hash][2]uint64)
+	for id, w := range result {
+		hash, err := crypto.HashFromString(id)
+		if err != nil {
+			return nil, err
+		}
+		works[hash] = w
+	}
+	return works, nil
+}
+
+func (c *Client) ListMintDistributions(ctx context.Context, offset, count uint64) ([]*common.MintDistribution, error) {
+	var result []*struct {
+		Group       string         `json:"group"`
+		Batch       uint64         `json:"batch"`
+		Amount      common.Integer `json:"amount"`
+		Transaction crypto.Hash    `json:"transaction"`
+	}
+	err := c.Call(ctx, "listmintdistributions", []interface{}{offset, count, false}, &result)
+	if err != nil {
+		return nil, err
+	}
+	mints := make([]*common.MintDistribution, len(result))
+	for i, m := range result {
+		mints[i] = &common.MintDistribution{Transaction: m.Transaction}
+		mints[i].Group, mints[i].Batch, mints[i].Amount = m.Group, m.Batch, m.Amount
+	}
+	return mints, nil
+}
+
+func (c *Client) ListAllNodes(ctx context.Context, threshold uint64, withState bool) ([]*Node, error) {
+	var nodes []*Node
+	err := c.Call(ctx, "listallnodes", []interface{}{threshold, withState}, &nodes)
+	if err != nil {
+		return nil, err
+	}
+	return nodes, nil
+}
+
+func (c *Client) GetRoundByNumber(ctx context.Context, node crypto.Hash, number uint64) (*Round, error) {
+	var round Round
+	err := c.Call(ctx, "getroundbynumber", []interface{}{node.String(), number}, &round)
+	if err != nil {
+		return nil, err
+	}
+	return &round, nil
+}
+
+func (c *Client) GetRoundByHash(ctx context.Context, hash crypto.Hash) (*Round, error) {
+	var round Round
+	err := c.Call(ctx, "getroundbyhash", []interface{}{hash.String()}, &round)
+	if err != nil {
+		return nil, err
+	}
+	return &round, nil
+}
+
+func (c *Client) GetRoundLink(ctx context.Context, from, to crypto.Hash) (uint64, error) {
+	var result struct {
+		Link uint64 `json:"link"`
+	}
+	err := c.Call(ctx, "getroundlink", []interface{}{from.String(), to.String()}, &result)
+	return result.Link, err
+}
+
+func (c *Client) ListPeers(ctx context.Context) ([]*Peer, error) {
+	var peers []*Peer
+	err := c.Call(ctx, "listpeers", nil, &peers)
+	if err != nil {
+		return nil, err
+	}
+	return peers, nil
+}
+
+// The following admin methods require the client AdminToken.
+
+func (c *Client) AddNeighbor(ctx context.Context, id crypto.Hash, address string) error {
+	return c.Call(ctx, "addneighbor", []interface{}{id.String(), address}, nil)
+}
+
+// RemoveNeighbor returns false if the node is not a neighbor.
+func (c *Client) RemoveNeighbor(ctx context.Context, id crypto.Hash) (bool, error) {
+	var result struct {
+		Removed bool `json:"removed"`
+	}
+	err := c.Call(ctx, "removeneighbor", []interface{}{id.String()}, &result)
+	return result.Removed, err
+}
+
+func (c *Client) SetLogLevel(ctx context.Context, level int) error {
+	return c.Call(ctx, "setloglevel", []interface{}{level}, nil)
+}
+
+func (c *Client) SetLogLimiter(ctx context.Context, limiter int) error {
+	return c.Call(ctx, "setloglimiter", []interface{}{limiter}, nil)
+}
+
+func (c *Client) SetLogFilter(ctx context.Context, pattern string) error {
+	return c.Call(ctx, "setlogfilter", []interface{}{pattern}, nil)
+}
+
+// RunValueLogGC returns the count of rewritten value log files.
+func (c *Client) RunValueLogGC(ctx context.Context, ratio float64) (int, error) {
+	var result struct {
+		Rewritten int `json:"rewritten"`
+	}
+	err := c.Call(ctx, "runvalueloggc", []interface{}{ratio}, &result)
+	return result.Rewritten, err
+}
+
+func (c *Client) DumpGoroutines(ctx context.Context) (int, string, error) {
+	var result struct {
+		Count  int    `json:"count"`
+		Stacks string `json:"stacks"`
+	}
+	err := c.Call(ctx, "dumpgoroutines", nil, &result)
+	return result.Count, result.Stacks, err
+}
+
+func (c *Client) DumpQueue(ctx context.Context) (*QueueState, error) {
+	var result QueueState
+	err := c.Call(ctx, "dumpqueue", nil, &result)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// ExportCheckpoint starts to write the checkpoint of the node state, poll
+// SignCheckpoint with the returned header until the state is done.
+func (c *Client) ExportCheckpoint(ctx context.Context) (*CheckpointState, error) {
+	var result CheckpointState
+	err := c.Call(ctx, "exportcheckpoint", nil, &result)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// SignCheckpoint returns the node signature of the checkpoint hash with
+// the msgpack encoded header, which is only available when the state is done.
+func (c *Client) SignCheckpoint(ctx context.Context, header []byte) (*CheckpointState, error) {
+	var result CheckpointState
+	err := c.Call(ctx, "signcheckpoint", []interface{}{hex.EncodeToString(header)}, &result)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
@@ -0,0 +1,355 @@
+package client
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"github.com/MixinNetwork/mixin/common"
+	"github.com/MixinNetwork/mixin/crypto"
+)
+
+type Info struct {
+	Network   crypto.Hash `json:"network"`
+	Node      crypto.Hash `json:"node"`
+	Version   string      `json:"version"`
+	Uptime    string      `json:"uptime"`
+	Epoch     time.Time   `json:"epoch"`
+	Timestamp time.Time   `json:"timestamp"`
+	Mint      struct {
+		Pool   common.Integer `json:"pool"`
+		Batch  uint64         `json:"batch"`
+		Pledge common.Integer `json:"pledge"`
+	} `json:"mint"`
+	Clock struct {
+		Skew  int64 `json:"skew"`
+		Nodes int   `json:"nodes"`
+	} `json:"clock"`
+	Graph struct {
+		Consensus []*ConsensusNode       `json:"consensus"`
+		Cache     map[string]*CacheRound `json:"cache"`
+		Final     map[string]*FinalRound `json:"final"`
+		Topology  uint64                 `json:"topology"`
+		SPS       float64                `json:"sps"`
+	} `json:"graph"`
+	Queue struct {
+		Finals uint64               `json:"finals"`
+		Caches uint64               `json:"caches"`
+		State  map[string][2]uint64 `json:"state"`
+	} `json:"queue"`
+}
+
+type ConsensusNode struct {
+	Node        crypto.Hash    `json:"node"`
+	Signer      common.Address `json:"signer"`
+	Payee       common.Address `json:"payee"`
+	State       string         `json:"state"`
+	Timestamp   uint64         `json:"timestamp"`
+	Transaction crypto.Hash    `json:"transaction"`
+	Aggregator  uint64         `json:"aggregator"`
+	Works       [2]uint64      `json:"works"`
+}
+
+type CacheRound struct {
+	Node       crypto.Hash
+	Round      uint64
+	Timestamp  uint64
+	References *common.RoundLink
+	Snapshots  []*common.Snapshot
+}
+
+func (r *CacheRound) UnmarshalJSON(b []byte) error {
+	var rj struct {
+		Node       crypto.Hash       `json:"node"`
+		Round      uint64            `json:"round"`
+		Timestamp  uint64            `json:"timestamp"`
+		References *common.RoundLink `json:"references"`
+		Snapshots  []*snapshotJSON   `json:"snapshots"`
+	}
+	err := json.Unmarshal(b, &rj)
+	if err != nil {
+		return err
+	}
+	snapshots, err := decodeSnapshots(rj.Snapshots)
+	if err != nil {
+		return err
+	}
+	r.Node, r.Round, r.Timestamp, r.References = rj.Node, rj.Round, rj.Timestamp, rj.References
+	for _, s := range snapshots {
+		r.Snapshots = append(r.Snapshots, &s.Snapshot)
+	}
+	return nil
+}
+
+type FinalRound struct {
+	Node  crypto.Hash `json:"node"`
+	Round uint64      `json:"round"`
+	Start uint64      `json:"start"`
+	End   uint64      `json:"end"`
+	Hash  crypto.Hash `json:"hash"`
+}
+
+type GraphHead struct {
+	Node  crypto.Hash    `json:"node"`
+	Round uint64         `json:"round"`
+	Hash  crypto.Hash    `json:"hash"`
+	Pool  map[string]int `json:"pool"`
+}
+
+type TransactionValidation struct {
+	Hash     crypto.Hash `json:"hash"`
+	Version  uint8       `json:"version"`
+	Type     uint8       `json:"type"`
+	Valid    bool        `json:"valid"`
+	Error    string      `json:"error"`
+	Snapshot crypto.Hash `json:"snapshot"`
+	Inputs   []struct {
+		Hash      crypto.Hash         `json:"hash"`
+		Index     int                 `json:"index"`
+		Genesis   string              `json:"genesis"`
+		Deposit   *common.DepositData `json:"deposit"`
+		Mint      *common.MintData    `json:"mint"`
+		Type      uint8               `json:"type"`
+		Amount    common.Integer      `json:"amount"`
+		Status    string              `json:"status"`
+		Lock      crypto.Hash         `json:"lock"`
+		Signature string              `json:"signature"`
+		Error     string              `json:"error"`
+	} `json:"inputs"`
+	Outputs []struct {
+		Index     int           `json:"index"`
+		Type      uint8         `json:"type"`
+		Conflicts []*crypto.Key `json:"conflicts"`
+	} `json:"outputs"`
+}
+
+type TransactionStatus struct {
+	Hash      crypto.Hash `json:"hash"`
+	Stage     string      `json:"stage"`
+	Error     string      `json:"error"`
+	Snapshots []struct {
+		Hash        crypto.Hash `json:"hash"`
+		Commitments int         `json:"commitments"`
+		Responses   int         `json:"responses"`
+	} `json:"snapshots"`
+}
+
+type PendingTransactionsStats struct {
+	Count int    `json:"count"`
+	Bytes int64  `json:"bytes"`
+	Age   uint64 `json:"age"`
+}
+
+type Peer struct {
+	Id        crypto.Hash `json:"id"`
+	Address   string      `json:"address"`
+	Direction string      `json:"direction"`
+	Uptime    string      `json:"uptime"`
+	Graph     struct {
+		Points    []*GraphHead `json:"points"`
+		Timestamp uint64       `json:"timestamp"`
+	} `json:"graph"`
+	Rings struct {
+		High   uint64 `json:"high"`
+		Normal uint64 `json:"normal"`
+		Low    uint64 `json:"low"`
+		Sync   uint64 `json:"sync"`
+	} `json:"rings"`
+	Bytes struct {
+		Sent     uint64 `json:"sent"`
+		Received uint64 `json:"received"`
+	} `json:"bytes"`
+	Messages map[string]struct {
+		Sent     uint64 `json:"sent"`
+		Received uint64 `json:"received"`
+		Bytes    struct {
+			Sent     uint64 `json:"sent"`
+			Received uint64 `json:"received"`
+		} `json:"bytes"`
+	} `json:"messages"`
+	Error *struct {
+		Message   string `json:"message"`
+		Timestamp uint64 `json:"timestamp"`
+	} `json:"error"`
+	Score int `json:"score"`
+	Ban   *struct {
+		Until uint64 `json:"until"`
+	} `json:"ban"`
+	Capabilities *struct {
+		Version        uint8    `json:"version"`
+		Software       string   `json:"software"`
+		Messages       []int    `json:"messages"`
+		Compressions   []int    `json:"compressions"`
+		Size           uint32   `json:"size"`
+		Dictionaries   []uint32 `json:"dictionaries"`
+		Authentication uint8    `json:"authentication"`
+	} `json:"capabilities"`
+	Clock *struct {
+		Offset    int64  `json:"offset"`
+		Delay     int64  `json:"delay"`
+		Timestamp uint64 `json:"timestamp"`
+	} `json:"clock"`
+}
+
+type QueueState struct {
+	Caches  uint64               `json:"caches"`
+	Finals  uint64               `json:"finals"`
+	State   map[string][2]uint64 `json:"state"`
+	Pending struct {
+		Count int   `json:"count"`
+		Bytes int64 `json:"bytes"`
+	} `json:"pending"`
+}
+
+// CheckpointState is not Done until the node has computed the checkpoint
+// hash, and Error is set if the computation failed.
+type CheckpointState struct {
+	// Header is the msgpack encoded common.CheckpointHeader.
+	Header    []byte
+	Node      crypto.Hash
+	Done      bool
+	Hash      crypto.Hash
+	Signature crypto.Signature
+	Error     string
+}
+
+func (cs *CheckpointState) UnmarshalJSON(b []byte) error {
+	var sj struct {
+		Header    string           `json:"header"`
+		Node      crypto.Hash      `json:"node"`
+		Done      bool             `json:"done"`
+		Hash      crypto.Hash      `json:"hash"`
+		Signature crypto.Signature `json:"signature"`
+		Error     string           `json:"error"`
+	}
+	err := json.Unmarshal(b, &sj)
+	if err != nil {
+		return err
+	}
+	header, err := hex.DecodeString(sj.Header)
+	if err != nil {
+		return err
+	}
+	cs.Header, cs.Node, cs.Done = header, sj.Node, sj.Done
+	cs.Hash, cs.Signature, cs.Error = sj.Hash, sj.Signature, sj.Error
+	return nil
+}
+
+type Node struct {
+	Id          crypto.Hash    `json:"id"`
+	Signer      common.Address `json:"signer"`
+	Payee       common.Address `json:"payee"`
+	Transaction crypto.Hash    `json:"transaction"`
+	Timestamp   uint64         `json:"timestamp"`
+	State       string         `json:"state"`
+}
+
+type Round struct {
+	Node       crypto.Hash
+	Hash       crypto.Hash
+	Start      uint64
+	End        uint64
+	Number     uint64
+	References *common.RoundLink
+	Snapshots  []*common.SnapshotWithTopologicalOrder
+}
+
+func (r *Round) UnmarshalJSON(b []byte) error {
+	var rj struct {
+		Node       crypto.Hash       `json:"node"`
+		Hash       crypto.Hash       `json:"hash"`
+		Start      uint64            `json:"start"`
+		End        uint64            `json:"end"`
+		Number     uint64            `json:"number"`
+		References *common.RoundLink `json:"references"`
+		Snapshots  []*snapshotJSON   `json:"snapshots"`
+	}
+	err := json.Unmarshal(b, &rj)
+	if err != nil {
+		return err
+	}
+	snapshots, err := decodeSnapshots(rj.Snapshots)
+	if err != nil {
+		return err
+	}
+	r.Node, r.Hash, r.Start, r.End, r.Number = rj.Node, rj.Hash, rj.Start, rj.End, rj.Number
+	r.References, r.Snapshots = rj.References, snapshots
+	return nil
+}
+
+type transactionJSON struct {
+	Hash crypto.Hash `json:"hash"`
+	Hex  string      `json:"hex"`
+}
+
+func (t *transactionJSON) decode() (*common.VersionedTransaction, error) {
+	raw, err := hex.DecodeString(t.Hex)
+	if err != nil {
+		return nil, err
+	}
+	return common.UnmarshalVersionedTransaction(raw)
+}
+
+// decodeTransactionField handles the transaction field which is either
+// the transaction hash or the transaction object.
+func decodeTransactionField(b json.RawMessage) (crypto.Hash, error) {
+	var hash crypto.Hash
+	if len(b) == 0 || bytes.Equal(b, []byte("null")) {
+		return hash, nil
+	}
+	if b[0] == '"' {
+		err := json.Unmarshal(b, &hash)
+		return hash, err
+	}
+	var t transactionJSON
+	err := json.Unmarshal(b, &t)
+	return t.Hash, err
+}
+
+type snapshotJSON struct {
+	Version     uint8                 `json:"version"`
+	Node        crypto.Hash           `json:"node"`
+	References  *common.RoundLink     `json:"references"`
+	Round       uint64                `json:"round"`
+	Timestamp   uint64                `json:"timestamp"`
+	Hash        crypto.Hash           `json:"hash"`
+	Topology    uint64                `json:"topology"`
+	Transaction json.RawMessage       `json:"transaction"`
+	Signatures  []*crypto.Signature   `json:"signatures"`
+	Signature   *crypto.CosiSignature `json:"signature"`
+}
+
+func (sj *snapshotJSON) decode() (*common.SnapshotWithTopologicalOrder, error) {
+	hash, err := decodeTransactionField(sj.Transaction)
+	if err != nil {
+		return nil, err
+	}
+	s := &common.SnapshotWithTopologicalOrder{
+		Snapshot: common.Snapshot{
+			Version:     sj.Version,
+			NodeId:      sj.Node,
+			Transaction: hash,
+			References:  sj.References,
+			RoundNumber: sj.Round,
+			Timestamp:   sj.Timestamp,
+			Signatures:  sj.Signatures,
+			Signature:   sj.Signature,
+			Hash:        sj.Hash,
+		},
+		TopologicalOrder: sj.Topology,
+	}
+	return s, nil
+}
+
+func decodeSnapshots(list []*snapshotJSON) ([]*common.SnapshotWithTopologicalOrder, error) {
+	snapshots := make([]*common.SnapshotWithTopologicalOrder, len(list))
+	for i, sj := range list {
+		s, err := sj.decode()
+		if err != nil {
+			return nil, err
+		}
+		snapshots[i] = s
+	}
+	return snapshots, nil
+}
@@ -0,0 +1,344 @@
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/MixinNetwork/mixin/common"
+	"github.com/MixinNetwork/mixin/config"
+	"github.com/MixinNetwork/mixin/crypto"
+	"github.com/MixinNetwork/mixin/kernel"
+	"github.com/MixinNetwork/mixin/rpc/client"
+	"github.com/MixinNetwork/mixin/storage"
+	"github.com/VictoriaMetrics/fastcache"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPendingTransactionHandlers(t *testing.T) {
+	assert := assert.New(t)
+
+	root, err := os.MkdirTemp("", "mixin-rpc-test")
+	assert.Nil(err)
+	defer os.RemoveAll(root)
+
+	router, store, _ := setupTestHandler(assert, root)
+
+	var hashes []crypto.Hash
+	for i := 0; i < 3; i++ {
+		tx := common.NewTransaction(common.XINAssetId)
+		tx.AddInput(crypto.NewHash([]byte(fmt.Sprintf("pending-%d", i))), 0)
+		ver := tx.AsLatestVersion()
+		assert.Nil(store.CachePutTransaction(ver))
+		hashes = append(hashes, ver.PayloadHash())
+	}
+
+	var list struct {
+		Transactions []map[string]interface{} `json:"transactions"`
+		Next         crypto.Hash              `json:"next"`
+	}
+	data, msg := testCallHandler(router, "listpendingtransactions", "", 2, "")
+	assert.Equal("", msg)
+	assert.Nil(json.Unmarshal(data, &list))
+	assert.Len(list.Transactions, 2)
+	assert.True(list.Next.HasValue())
+	assert.NotNil(list.Transactions[0]["hex"])
+	data, msg = testCallHandler(router, "listpendingtransactions", list.Next.String(), 2, "")
+	assert.Equal("", msg)
+	list.Next = crypto.Hash{}
+	assert.Nil(json.Unmarshal(data, &list))
+	assert.Len(list.Transactions, 1)
+	assert.False(list.Next.HasValue())
+	_, msg = testCallHandler(router, "listpendingtransactions", "", 0, "")
+	assert.Equal("invalid limit", msg)
+	_, msg = testCallHandler(router, "listpendingtransactions", "", 2)
+	assert.Equal("invalid params count", msg)
+
+	server := httptest.NewServer(router)
+	defer server.Close()
+	rc := client.NewClient(server.URL)
+	txs, next, err := rc.ListPendingTransactions(context.Background(), crypto.Hash{}, 2, common.XINAssetId)
+	assert.Nil(err)
+	assert.Len(txs, 2)
+	assert.True(next.HasValue())
+	for _, ver := range txs {
+		assert.Contains(hashes, ver.PayloadHash())
+		assert.Equal(common.XINAssetId, ver.Asset)
+	}
+	txs, next, err = rc.ListPendingTransactions(context.Background(), next, 2, common.XINAssetId)
+	assert.Nil(err)
+	assert.Len(txs, 1)
+	assert.False(next.HasValue())
+
+	var stats struct {
+		Count int `json:"count"`
+	}
+	data, msg = testCallHandler(router, "getpendingtransactionsstats")
+	assert.Equal("", msg)
+	assert.Nil(json.Unmarshal(data, &stats))
+	assert.Equal(3, stats.Count)
+
+	var removed struct {
+		Hash crypto.Hash `json:"hash"`
+	}
+	_, msg = testCallHandlerWithToken(router, "", "removependingtransaction", hashes[0].String())
+	assert.Equal("unauthorized", msg)
+	_, msg = testCallHandlerWithToken(router, "invalid", "removependingtransaction", hashes[0].String())
+	assert.Equal("unauthorized", msg)
+	data, msg = testCallHandler(router, "getpendingtransactionsstats")
+	assert.Equal("", msg)
+	assert.Nil(json.Unmarshal(data, &stats))
+	assert.Equal(3, stats.Count)
+	data, msg = testCallHandler(router, "removependingtransaction", hashes[0].String())
+	assert.Equal("", msg)
+	assert.Nil(json.Unmarshal(data, &removed))
+	assert.Equal(hashes[0], removed.Hash)
+	_, msg = testCallHandler(router, "removependingtransaction", hashes[0].String())
+	assert.Equal("transaction not found", msg)
+	_, msg = testCallHandler(router, "removependingtransaction", "invalid")
+	assert.NotEqual("", msg)
+	data, msg = testCallHandler(router, "getpendingtransactionsstats")
+	assert.Equal("", msg)
+	assert.Nil(json.Unmarshal(data, &stats))
+	assert.Equal(2, stats.Count)
+}
+
+type testBrokenStore struct {
+	storage.Store
+}
+
+func (s *testBrokenStore) ReadSnapshotsSinceTopology(offset, count uint64) ([]*common.SnapshotWithTopologicalOrder, error) {
+	return nil, errors.New("broken store")
+}
+
+func setupTestHandler(assert *assert.Assertions, root string) (http.Handler, storage.Store, *kernel.Node) {
+	setupTestNet(root)
+	dir := root + "/mixin-17001"
+
+	custom, err := config.Initialize(dir + "/config.toml")
+	assert.Nil(err)
+	custom.RPC.AdminToken = "token"
+	cache := fastcache.New(16 * 1024 * 1024)
+	store, err := storage.NewBadgerStore(custom, dir)
+	assert.Nil(err)
+	assert.NotNil(store)
+	node, err := kernel.SetupNode(custom, store, cache, ":17001", dir)
+	assert.Nil(err)
+	assert.NotNil(node)
+	return NewRouter(custom, store, node), store, node
+}
+
+// testCallHandler returns the result data, or the error message if the
+// call failed.
+func testCallHandler(router http.Handler, method string, params ...interface{}) (json.RawMessage, string) {
+	return testCallHandlerWithToken(router, "token", method, params...)
+}
+
+func testCallHandlerWithToken(router http.Handler, token, method string, params ...interface{}) (json.RawMessage, string) {
+	if params == nil {
+		params = []interface{}{}
+	}
+	body, _ := json.Marshal(map[string]interface{}{
+		"method": method,
+		"params": params,
+	})
+	req := httptest.NewRequest("POST", "/", bytes.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	var resp struct {
+		Data  json.RawMessage `json:"data"`
+		Error string          `json:"error"`
+	}
+	err := json.Unmarshal(w.Body.Bytes(), &resp)
+	if err != nil {
+		panic(err)
+	}
+	return resp.Data, resp.Error
+}
+
+func TestValidateTransactionHandler(t *testing.T) {
+	assert := assert.New(t)
+
+	root, err := os.MkdirTemp("", "mixin-rpc-test")
+	assert.Nil(err)
+	defer os.RemoveAll(root)
+
+	router, store, _ := setupTestHandler(assert, root)
+
+	snapshots, err := store.ReadSnapshotsSinceTopology(0, 1)
+	assert.Nil(err)
+	assert.Len(snapshots, 1)
+	genesis, finalized, err := store.ReadTransaction(snapshots[0].Transaction)
+	assert.Nil(err)
+	assert.Equal(snapshots[0].Hash.String(), finalized)
+
+	var result struct {
+		Hash     crypto.Hash              `json:"hash"`
+		Valid    bool                     `json:"valid"`
+		Error    string                   `json:"error"`
+		Snapshot string                   `json:"snapshot"`
+		Inputs   []map[string]interface{} `json:"inputs"`
+		Outputs  []map[string]interface{} `json:"outputs"`
+	}
+	data, msg := testCallHandler(router, "validaterawtransaction", hex.EncodeToString(genesis.Marshal()))
+	assert.Equal("", msg)
+	assert.Nil(json.Unmarshal(data, &result))
+	assert.Equal(genesis.PayloadHash(), result.Hash)
+	assert.Equal(finalized, result.Snapshot)
+	assert.Len(result.Outputs, len(genesis.Outputs))
+
+	tx := common.NewTransaction(common.XINAssetId)
+	tx.AddInput(genesis.PayloadHash(), 0)
+	tx.AddInput(crypto.NewHash([]byte("missing")), 0)
+	tx.Outputs = append(tx.Outputs, &common.Output{
+		Type:   common.OutputTypeScript,
+		Amount: common.NewInteger(1),
+		Keys:   genesis.Outputs[0].Keys,
+		Script: common.NewThresholdScript(1),
+		Mask:   genesis.Outputs[0].Mask,
+	})
+	ver := tx.AsLatestVersion()
+	data, msg = testCallHandler(router, "validaterawtransaction", hex.EncodeToString(ver.Marshal()))
+	assert.Equal("", msg)
+	result.Snapshot = ""
+	assert.Nil(json.Unmarshal(data, &result))
+	assert.Equal(ver.PayloadHash(), result.Hash)
+	assert.False(result.Valid)
+	assert.NotEqual("", result.Error)
+	assert.Equal("", result.Snapshot)
+	assert.Len(result.Inputs, 2)
+	assert.Equal("unspent", result.Inputs[0]["status"])
+	assert.Equal("missing", result.Inputs[0]["signature"])
+	assert.Equal("missing", result.Inputs[1]["status"])
+	assert.Len(result.Outputs, 1)
+	assert.Len(result.Outputs[0]["conflicts"], len(genesis.Outputs[0].Keys))
+
+	_, msg = testCallHandler(router, "validaterawtransaction", "invalid")
+	assert.NotEqual("", msg)
+	_, msg = testCallHandler(router, "validaterawtransaction", hex.EncodeToString([]byte("invalid")))
+	assert.NotEqual("", msg)
+	_, msg = testCallHandler(router, "validaterawtransaction")
+	assert.Equal("invalid params count", msg)
+}
+
+func TestTransactionStatusHandler(t *testing.T) {
+	assert := assert.New(t)
+
+	root, err := os.MkdirTemp("", "mixin-rpc-test")
+	assert.Nil(err)
+	defer os.RemoveAll(root)
+
+	router, store, _ := setupTestHandler(assert, root)
+
+	var status struct {
+		Hash      crypto.Hash `json:"hash"`
+		Stage     string      `json:"stage"`
+		Snapshots []struct {
+			Hash crypto.Hash `json:"hash"`
+		} `json:"snapshots"`
+		Error string `json:"error"`
+	}
+	unknown := crypto.NewHash([]byte("unknown"))
+	data, msg := testCallHandler(router, "gettransactionstatus", unknown.String())
+	assert.Equal("", msg)
+	assert.Nil(json.Unmarshal(data, &status))
+	assert.Equal(unknown, status.Hash)
+	assert.Equal(kernel.TransactionStageUnknown, status.Stage)
+	assert.Len(status.Snapshots, 0)
+
+	tx := common.NewTransaction(common.XINAssetId)
+	tx.AddInput(crypto.NewHash([]byte("cache")), 0)
+	ver := tx.AsLatestVersion()
+	assert.Nil(store.CachePutTransaction(ver))
+	data, msg = testCallHandler(router, "gettransactionstatus", ver.PayloadHash().String())
+	assert.Equal("", msg)
+	assert.Nil(json.Unmarshal(data, &status))
+	assert.Equal(ver.PayloadHash(), status.Hash)
+	assert.Equal(kernel.TransactionStageCache, status.Stage)
+
+	snapshots, err := store.ReadSnapshotsSinceTopology(0, 1)
+	assert.Nil(err)
+	assert.Len(snapshots, 1)
+	data, msg = testCallHandler(router, "gettransactionstatus", snapshots[0].Transaction.String())
+	assert.Equal("", msg)
+	assert.Nil(json.Unmarshal(data, &status))
+	assert.Equal(kernel.TransactionStageFinalized, status.Stage)
+	assert.Len(status.Snapshots, 1)
+	assert.Equal(snapshots[0].Hash, status.Snapshots[0].Hash)
+
+	_, msg = testCallHandler(router, "gettransactionstatus", "invalid")
+	assert.NotEqual("", msg)
+	_, msg = testCallHandler(router, "gettransactionstatus")
+	assert.Equal("invalid params count", msg)
+}
+
+func TestHealthHandlers(t *testing.T) {
+	assert := assert.New(t)
+
+	root, err := os.MkdirTemp("", "mixin-rpc-test")
+	assert.Nil(err)
+	defer os.RemoveAll(root)
+
+	router, store, node := setupTestHandler(assert, root)
+	defer kernel.TestMockReset()
+
+	var health struct {
+		Alive    bool   `json:"alive"`
+		Topology uint64 `json:"topology"`
+		Error    string `json:"error"`
+	}
+	code := testGetHandler(router, "/healthz", &health)
+	assert.Equal(http.StatusOK, code)
+	assert.True(health.Alive)
+	assert.Equal(store.TopologySequence(), health.Topology)
+
+	var ready struct {
+		Ready  bool                       `json:"ready"`
+		Checks map[string]*readinessCheck `json:"checks"`
+	}
+	code = testGetHandler(router, "/readyz", &ready)
+	assert.Equal(http.StatusServiceUnavailable, code)
+	assert.False(ready.Ready)
+	assert.False(ready.Checks["neighbors"].Ok)
+	assert.Equal("neighbors 0 < 1", ready.Checks["neighbors"].Reason)
+	assert.True(ready.Checks["topology"].Ok)
+
+	checks := readinessChecks(node, 0, 600)
+	assert.True(checks["neighbors"].Ok)
+	assert.True(checks["topology"].Ok)
+	kernel.TestMockDiff(601 * time.Second)
+	checks = readinessChecks(node, 0, 600)
+	assert.True(checks["neighbors"].Ok)
+	assert.False(checks["topology"].Ok)
+	assert.Equal(int64(601), checks["topology"].Value)
+	assert.Equal("no new snapshots in 10m1s", checks["topology"].Reason)
+
+	router = NewRouter(nil, &testBrokenStore{store}, node)
+	code = testGetHandler(router, "/healthz", &health)
+	assert.Equal(http.StatusServiceUnavailable, code)
+	assert.False(health.Alive)
+	assert.Equal("broken store", health.Error)
+}
+
+func testGetHandler(router http.Handler, path string, result interface{}) int {
+	req := httptest.NewRequest("GET", path, nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	err := json.Unmarshal(w.Body.Bytes(), result)
+	if err != nil {
+		panic(err)
+	}
+	return w.Code
+}
@@ -0,0 +1,89 @@
+package rpc
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/MixinNetwork/mixin/kernel"
+	"github.com/unrolled/render"
+)
+
+type readinessCheck struct {
+	Ok        bool   `json:"ok"`
+	Value     int64  `json:"value"`
+	Threshold int64  `json:"threshold"`
+	Reason    string `json:"reason,omitempty"`
+}
+
+func (impl *R) healthz(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+	topology := impl.Store.TopologySequence()
+	_, err := impl.Store.ReadSnapshotsSinceTopology(topology, 1)
+	if err != nil {
+		render.New().JSON(w, http.StatusServiceUnavailable, map[string]interface{}{
+			"alive": false,
+			"error": err.Error(),
+		})
+		return
+	}
+	render.New().JSON(w, http.StatusOK, map[string]interface{}{
+		"alive":    true,
+		"uptime":   impl.Node.Uptime().String(),
+		"topology": topology,
+	})
+}
+
+func (impl *R) readyz(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+	checks := readinessChecks(impl.Node, impl.custom.RPC.ReadyNeighbors, impl.custom.RPC.ReadyTopologyStall)
+	ready := true
+	for _, c := range checks {
+		ready = ready && c.Ok
+	}
+	status := http.StatusOK
+	if !ready {
+		status = http.StatusServiceUnavailable
+	}
+	render.New().JSON(w, status, map[string]interface{}{
+		"ready":  ready,
+		"checks": checks,
+	})
+}
+
+// readinessChecks skips the catch up and broadcast checks for nodes not in
+// the consensus, because they have no chain state of their own.
+func readinessChecks(node *kernel.Node, neighbors, stall int) map[string]*readinessCheck {
+	checks := make(map[string]*readinessCheck)
+
+	nc := &readinessCheck{Threshold: int64(neighbors)}
+	if node.Peer != nil {
+		nc.Value = int64(len(node.Peer.Neighbors()))
+	}
+	nc.Ok = nc.Value >= nc.Threshold
+	if !nc.Ok {
+		nc.Reason = fmt.Sprintf("neighbors %d < %d", nc.Value, nc.Threshold)
+	}
+	checks["neighbors"] = nc
+
+	since := node.TopologyAge()
+	tc := &readinessCheck{Value: int64(since.Seconds()), Threshold: int64(stall)}
+	tc.Ok = since <= time.Duration(stall)*time.Second
+	if !tc.Ok {
+		tc.Reason = fmt.Sprintf("no new snapshots in %s", since.Round(time.Second))
+	}
+	checks["topology"] = tc
+
+	if node.GetAcceptedOrPledgingNode(node.IdForNetwork) == nil {
+		return checks
+	}
+	cc := &readinessCheck{Ok: node.CheckCatchUpWithPeers()}
+	if !cc.Ok {
+		cc.Reason = "not caught up with the consensus peers"
+	}
+	checks["catchup"] = cc
+	bc := &readinessCheck{Ok: node.CheckBroadcastedToPeers()}
+	if !bc.Ok {
+		bc.Reason = "final round not broadcasted to the consensus peers"
+	}
+	checks["broadcasted"] = bc
+	return checks
+}
@@ -1,9 +1,12 @@
 package rpc
 
 import (
+	"crypto/subtle"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/MixinNetwork/mixin/config"
@@ -30,6 +33,9 @@ func NewRouter(custom *config.Custom, store storage.Store, node *kernel.Node) *h
 	router := httptreemux.New()
 	impl := &R{Store: store, Node: node, custom: custom}
 	router.POST("/", impl.handle)
+	router.GET("/healthz", impl.healthz)
+	router.GET("/readyz", impl.readyz)
+	router.GET("/checkpoint", impl.checkpoint)
 	registerHandlers(router)
 	return router
 }
@@ -109,6 +115,13 @@ func (impl *R) handle(w http.ResponseWriter, r *http.Request, _ map[string]strin
 		} else {
 			renderer.RenderData(map[string]string{"hash": id})
 		}
+	case "validaterawtransaction":
+		result, err := validateTransaction(impl.Store, call.Params)
+		if err != nil {
+			renderer.RenderError(err)
+		} else {
+			renderer.RenderData(result)
+		}
 	case "gettransaction":
 		tx, err := getTransaction(impl.Store, call.Params)
 		if err != nil {
@@ -116,6 +129,13 @@ func (impl *R) handle(w http.ResponseWriter, r *http.Request, _ map[string]strin
 		} else {
 			renderer.RenderData(tx)
 		}
+	case "gettransactionstatus":
+		status, err := getTransactionStatus(impl.Node, call.Params)
+		if err != nil {
+			renderer.RenderError(err)
+		} else {
+			renderer.RenderData(status)
+		}
 	case "getcachetransaction":
 		tx, err := getCacheTransaction(impl.Store, call.Params)
 		if err != nil {
@@ -123,6 +143,20 @@ func (impl *R) handle(w http.ResponseWriter, r *http.Request, _ map[string]strin
 		} else {
 			renderer.RenderData(tx)
 		}
+	case "listpendingtransactions":
+		txs, err := listPendingTransactions(impl.Store, call.Params)
+		if err != nil {
+			renderer.RenderError(err)
+		} else {
+			renderer.RenderData(txs)
+		}
+	case "getpendingtransactionsstats":
+		stats, err := getPendingTransactionsStats(impl.Store)
+		if err != nil {
+			renderer.RenderError(err)
+		} else {
+			renderer.RenderData(stats)
+		}
 	case "getutxo":
 		utxo, err := getUTXO(impl.Store, call.Params)
 		if err != nil {
@@ -137,6 +171,13 @@ func (impl *R) handle(w http.ResponseWriter, r *http.Request, _ map[string]strin
 		} else {
 			renderer.RenderData(snap)
 		}
+	case "getsnapshotproof":
+		proof, err := getSnapshotProof(impl.Node, call.Params)
+		if err != nil {
+			renderer.RenderError(err)
+		} else {
+			renderer.RenderData(proof)
+		}
 	case "listsnapshots":
 		snapshots, err := listSnapshots(impl.Node, impl.Store, call.Params)
 		if err != nil {
@@ -186,11 +227,69 @@ func (impl *R) handle(w http.ResponseWriter, r *http.Request, _ map[string]strin
 		} else {
 			renderer.RenderData(map[string]interface{}{"link": link})
 		}
+	case "listpeers":
+		peers, err := listPeers(impl.Node)
+		if err != nil {
+			renderer.RenderError(err)
+		} else {
+			renderer.RenderData(peers)
+		}
+	case "addneighbor", "removeneighbor", "setloglevel", "setloglimiter", "setlogfilter", "runvalueloggc", "dumpgoroutines", "dumpqueue", "exportcheckpoint", "signcheckpoint", "removependingtransaction":
+		if err := impl.authorizeAdmin(r); err != nil {
+			renderer.RenderError(err)
+			return
+		}
+		data, err := impl.handleAdmin(call.Method, call.Params)
+		if err != nil {
+			renderer.RenderError(err)
+		} else {
+			renderer.RenderData(data)
+		}
 	default:
 		renderer.RenderError(fmt.Errorf("invalid method %s", call.Method))
 	}
 }
 
+func (impl *R) handleAdmin(method string, params []interface{}) (map[string]interface{}, error) {
+	switch method {
+	case "addneighbor":
+		return addNeighbor(impl.Node, params)
+	case "removeneighbor":
+		return removeNeighbor(impl.Node, params)
+	case "setloglevel":
+		return setLogLevel(params)
+	case "setloglimiter":
+		return setLogLimiter(params)
+	case "setlogfilter":
+		return setLogFilter(params)
+	case "runvalueloggc":
+		return runValueLogGC(impl.Store, params)
+	case "dumpgoroutines":
+		return dumpGoroutines()
+	case "dumpqueue":
+		return dumpQueue(impl.Node, impl.Store)
+	case "exportcheckpoint":
+		return exportCheckpoint(impl.Node, params)
+	case "signcheckpoint":
+		return signCheckpoint(impl.Node, params)
+	case "removependingtransaction":
+		return removePendingTransaction(impl.Store, params)
+	}
+	return nil, fmt.Errorf("invalid method %s", method)
+}
+
+func (impl *R) authorizeAdmin(r *http.Request) error {
+	token := impl.custom.RPC.AdminToken
+	if token == "" {
+		return errors.New("admin methods disabled")
+	}
+	auth := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(auth), []byte(token)) != 1 {
+		return errors.New("unauthorized")
+	}
+	return nil
+}
+
 func handleCORS(handler http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		origin := r.Header.Get("Origin")
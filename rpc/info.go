@@ -111,6 +111,11 @@ func getInfo(store storage.Store, node *kernel.Node) (map[string]interface{}, er
 		"topology":  node.TopologicalOrder(),
 		"sps":       node.SPS(),
 	}
+	skew, estimated := node.ClockSkew()
+	info["clock"] = map[string]interface{}{
+		"skew":  int64(skew),
+		"nodes": estimated,
+	}
 	caches, finals, state := node.QueueState()
 	info["queue"] = map[string]interface{}{
 		"finals": finals,
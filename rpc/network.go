@@ -0,0 +1,143 @@
+package rpc
+
+import (
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/MixinNetwork/mixin/kernel"
+	"github.com/MixinNetwork/mixin/network"
+)
+
+func listPeers(node *kernel.Node) ([]map[string]interface{}, error) {
+	if node.Peer == nil {
+		return []map[string]interface{}{}, nil
+	}
+	neighbors := node.Peer.Neighbors()
+	sort.Slice(neighbors, func(i, j int) bool {
+		return neighbors[i].Address < neighbors[j].Address
+	})
+	peers := make([]map[string]interface{}, len(neighbors))
+	for i, p := range neighbors {
+		info := p.Info()
+		peer := map[string]interface{}{
+			"id":        info.IdForNetwork,
+			"address":   info.Address,
+			"direction": info.Direction,
+			"uptime":    info.Uptime.String(),
+			"graph": map[string]interface{}{
+				"points":    info.Graph,
+				"timestamp": unixNanoOrZero(info.GraphAt),
+			},
+			"rings": map[string]interface{}{
+				"high":   info.HighRing,
+				"normal": info.NormalRing,
+				"low":    info.LowRing,
+				"sync":   info.SyncRing,
+			},
+			"bytes": map[string]interface{}{
+				"sent":     info.BytesSent,
+				"received": info.BytesRecv,
+			},
+			"messages":     messageStatsToMap(info.Messages),
+			"error":        nil,
+			"score":        info.Score,
+			"ban":          nil,
+			"capabilities": nil,
+			"clock":        nil,
+		}
+		if info.Error != "" {
+			peer["error"] = map[string]interface{}{
+				"message":   info.Error,
+				"timestamp": unixNanoOrZero(info.ErrorAt),
+			}
+		}
+		if c := info.Capabilities; c != nil {
+			peer["capabilities"] = map[string]interface{}{
+				"version":        c.Version,
+				"software":       c.Software,
+				"messages":       bytesToInts(c.MessageTypes),
+				"compressions":   bytesToInts(c.Compressions),
+				"size":           c.MaxMessageSize,
+				"dictionaries":   c.Dictionaries,
+				"authentication": c.Authentication,
+			}
+		}
+		if !info.ClockAt.IsZero() {
+			peer["clock"] = map[string]interface{}{
+				"offset":    int64(info.ClockOffset),
+				"delay":     int64(info.ClockDelay),
+				"timestamp": unixNanoOrZero(info.ClockAt),
+			}
+		}
+		peers[i] = peer
+	}
+
+	bans := node.Peer.Bans()
+	sort.Slice(bans, func(i, j int) bool {
+		return bans[i].IdForNetwork.String() < bans[j].IdForNetwork.String()
+	})
+	for _, b := range bans {
+		peers = append(peers, map[string]interface{}{
+			"id":        b.IdForNetwork,
+			"address":   "",
+			"direction": network.PeerDirectionNone,
+			"uptime":    time.Duration(0).String(),
+			"graph": map[string]interface{}{
+				"points":    []*network.SyncPoint{},
+				"timestamp": 0,
+			},
+			"rings": map[string]interface{}{
+				"high":   0,
+				"normal": 0,
+				"low":    0,
+				"sync":   0,
+			},
+			"bytes": map[string]interface{}{
+				"sent":     0,
+				"received": 0,
+			},
+			"messages": map[string]interface{}{},
+			"error":    nil,
+			"score":    0,
+			"ban": map[string]interface{}{
+				"until": unixNanoOrZero(b.Until),
+			},
+			"capabilities": nil,
+			"clock":        nil,
+		})
+	}
+	return peers, nil
+}
+
+func unixNanoOrZero(t time.Time) uint64 {
+	if t.IsZero() {
+		return 0
+	}
+	return uint64(t.UnixNano())
+}
+
+// messageStatsToMap keys the stats by the peer message type number.
+func messageStatsToMap(stats map[uint8]*network.MessageStats) map[string]interface{} {
+	messages := make(map[string]interface{})
+	for typ, ms := range stats {
+		messages[strconv.Itoa(int(typ))] = map[string]interface{}{
+			"sent":     ms.Sent,
+			"received": ms.Received,
+			"bytes": map[string]interface{}{
+				"sent":     ms.BytesSent,
+				"received": ms.BytesRecv,
+			},
+		}
+	}
+	return messages
+}
+
+// bytesToInts avoids the base64 JSON encoding of the byte slices.
+func bytesToInts(b []uint8) []int {
+	ints := make([]int, len(b))
+	for i, v := range b {
+		ints[i] = int(v)
+	}
+	return ints
+}
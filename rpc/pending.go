@@ -0,0 +1,117 @@
+package rpc
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/MixinNetwork/mixin/common"
+	"github.com/MixinNetwork/mixin/crypto"
+	"github.com/MixinNetwork/mixin/storage"
+)
+
+func listPendingTransactions(store storage.Store, params []interface{}) (map[string]interface{}, error) {
+	if len(params) != 3 {
+		return nil, errors.New("invalid params count")
+	}
+	var offset, asset crypto.Hash
+	if s := fmt.Sprint(params[0]); s != "" {
+		hash, err := crypto.HashFromString(s)
+		if err != nil {
+			return nil, err
+		}
+		offset = hash
+	}
+	limit, err := strconv.ParseUint(fmt.Sprint(params[1]), 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	if limit == 0 || limit > 500 {
+		return nil, errors.New("invalid limit")
+	}
+	if s := fmt.Sprint(params[2]); s != "" {
+		hash, err := crypto.HashFromString(s)
+		if err != nil {
+			return nil, err
+		}
+		asset = hash
+	}
+
+	var next, skip crypto.Hash
+	var txs []*common.VersionedTransaction
+	for batch := int(limit) + 1; ; {
+		list, err := store.CacheListTransactions(offset, batch)
+		if err != nil {
+			return nil, err
+		}
+		for _, tx := range list {
+			hash := tx.PayloadHash()
+			if hash == skip {
+				continue
+			}
+			if len(txs) == int(limit) {
+				next = hash
+				break
+			}
+			if !asset.HasValue() || tx.Asset == asset {
+				txs = append(txs, tx)
+			}
+		}
+		if next.HasValue() || len(list) < batch {
+			break
+		}
+		offset = list[len(list)-1].PayloadHash()
+		skip = offset
+	}
+
+	data := make([]map[string]interface{}, len(txs))
+	for i, tx := range txs {
+		data[i] = transactionToMap(tx)
+		data[i]["hex"] = hex.EncodeToString(tx.Marshal())
+	}
+	result := map[string]interface{}{"transactions": data}
+	if next.HasValue() {
+		result["next"] = next
+	}
+	return result, nil
+}
+
+func getPendingTransactionsStats(store storage.Store) (map[string]interface{}, error) {
+	count, size, oldest, err := store.CacheTransactionsStats()
+	if err != nil {
+		return nil, err
+	}
+	data := map[string]interface{}{
+		"count": count,
+		"bytes": size,
+		"age":   uint64(0),
+	}
+	if now := uint64(time.Now().Unix()); count > 0 && oldest > 0 && now > oldest {
+		data["age"] = now - oldest
+	}
+	return data, nil
+}
+
+func removePendingTransaction(store storage.Store, params []interface{}) (map[string]interface{}, error) {
+	if len(params) != 1 {
+		return nil, errors.New("invalid params count")
+	}
+	hash, err := crypto.HashFromString(fmt.Sprint(params[0]))
+	if err != nil {
+		return nil, err
+	}
+	tx, err := store.CacheGetTransaction(hash)
+	if err != nil {
+		return nil, err
+	}
+	if tx == nil {
+		return nil, errors.New("transaction not found")
+	}
+	err = store.CacheRemoveTransactions([]crypto.Hash{hash})
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"hash": hash}, nil
+}
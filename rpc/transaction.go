@@ -250,3 +250,187 @@ func transactionToMap(tx *common.VersionedTransaction) map[string]interface{} {
 		"hash":    tx.PayloadHash(),
 	}
 }
+
+func validateTransaction(store storage.Store, params []interface{}) (map[string]interface{}, error) {
+	if len(params) != 1 {
+		return nil, errors.New("invalid params count")
+	}
+	raw, err := hex.DecodeString(fmt.Sprint(params[0]))
+	if err != nil {
+		return nil, err
+	}
+	ver, err := common.UnmarshalVersionedTransaction(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	hash, msg := ver.PayloadHash(), ver.PayloadMarshal()
+	_, finalized, err := store.ReadTransaction(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	var inputs []map[string]interface{}
+	for i, in := range ver.Inputs {
+		item, err := validateTransactionInput(store, ver, hash, msg, i, in)
+		if err != nil {
+			return nil, err
+		}
+		inputs = append(inputs, item)
+	}
+
+	var outputs []map[string]interface{}
+	for i, out := range ver.Outputs {
+		var conflicts []*crypto.Key
+		for _, k := range out.Keys {
+			exist, err := store.CheckGhost(*k)
+			if err != nil {
+				return nil, err
+			}
+			if exist {
+				conflicts = append(conflicts, k)
+			}
+		}
+		outputs = append(outputs, map[string]interface{}{
+			"index":     i,
+			"type":      out.Type,
+			"conflicts": conflicts,
+		})
+	}
+
+	result := map[string]interface{}{
+		"hash":    hash,
+		"version": ver.Version,
+		"type":    ver.TransactionType(),
+		"inputs":  inputs,
+		"outputs": outputs,
+		"valid":   true,
+	}
+	if len(finalized) > 0 {
+		result["snapshot"] = finalized
+	}
+	if err := ver.Validate(store); err != nil {
+		result["valid"] = false
+		result["error"] = err.Error()
+	}
+	return result, nil
+}
+
+func validateTransactionInput(store storage.Store, ver *common.VersionedTransaction, hash crypto.Hash, msg []byte, index int, in *common.Input) (map[string]interface{}, error) {
+	item := map[string]interface{}{}
+	switch {
+	case in.Mint != nil:
+		item["mint"] = in.Mint
+		return item, nil
+	case in.Deposit != nil:
+		item["deposit"] = in.Deposit
+		if err := store.CheckDepositInput(in.Deposit, hash); err != nil {
+			item["status"] = "locked"
+			item["error"] = err.Error()
+		} else {
+			item["status"] = "unspent"
+		}
+		return item, nil
+	case len(in.Genesis) > 0:
+		item["genesis"] = hex.EncodeToString(in.Genesis)
+		return item, nil
+	}
+
+	item["hash"] = in.Hash
+	item["index"] = in.Index
+	utxo, err := store.ReadUTXOLock(in.Hash, in.Index)
+	if err != nil {
+		return nil, err
+	}
+	if utxo == nil {
+		item["status"] = "missing"
+		return item, nil
+	}
+	item["type"] = utxo.Type
+	item["amount"] = utxo.Amount
+	item["status"] = "unspent"
+	if utxo.LockHash.HasValue() {
+		item["lock"] = utxo.LockHash
+		item["status"] = "locked"
+		if utxo.LockHash == hash {
+			item["status"] = "self"
+		}
+	}
+
+	if ver.AggregatedSignature != nil {
+		item["signature"] = "aggregated"
+		return item, nil
+	}
+	if index >= len(ver.SignaturesMap) || len(ver.SignaturesMap[index]) == 0 {
+		item["signature"] = "missing"
+		return item, nil
+	}
+	sigs := ver.SignaturesMap[index]
+	item["signature"] = "valid"
+	for i, sig := range sigs {
+		if int(i) >= len(utxo.Keys) {
+			item["signature"] = fmt.Sprintf("invalid signature map index %d %d", i, len(utxo.Keys))
+			return item, nil
+		}
+		if !utxo.Keys[i].Verify(msg, *sig) {
+			item["signature"] = fmt.Sprintf("invalid signature for key %d", i)
+			return item, nil
+		}
+	}
+	if utxo.Type == common.OutputTypeScript {
+		if err := utxo.Script.Validate(len(sigs)); err != nil {
+			item["signature"] = err.Error()
+		}
+	}
+	return item, nil
+}
+
+func getTransactionStatus(node *kernel.Node, params []interface{}) (map[string]interface{}, error) {
+	if len(params) != 1 {
+		return nil, errors.New("invalid params count")
+	}
+	hash, err := crypto.HashFromString(fmt.Sprint(params[0]))
+	if err != nil {
+		return nil, err
+	}
+	status, err := node.TransactionStatus(hash)
+	if err != nil {
+		return nil, err
+	}
+	snapshots := make([]map[string]interface{}, len(status.Snapshots))
+	for i, s := range status.Snapshots {
+		snapshots[i] = map[string]interface{}{
+			"hash":        s.Hash,
+			"commitments": s.Commitments,
+			"responses":   s.Responses,
+		}
+	}
+	data := map[string]interface{}{
+		"hash":      status.Hash,
+		"stage":     status.Stage,
+		"snapshots": snapshots,
+	}
+	if status.Error != "" {
+		data["error"] = status.Error
+	}
+	return data, nil
+}
+
+func getSnapshotProof(node *kernel.Node, params []interface{}) (interface{}, error) {
+	if len(params) != 2 {
+		return nil, errors.New("invalid params count")
+	}
+	hash, err := crypto.HashFromString(fmt.Sprint(params[0]))
+	if err != nil {
+		return nil, err
+	}
+	checkpoint, err := strconv.ParseUint(fmt.Sprint(params[1]), 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	p, err := node.BuildSnapshotProof(hash, checkpoint)
+	if err != nil || p == nil {
+		return nil, err
+	}
+	return p, nil
+}
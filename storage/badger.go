@@ -33,6 +33,25 @@ func NewBadgerStore(custom *config.Custom, dir string) (*BadgerStore, error) {
 	}, nil
 }
 
+// NewBadgerMemoryStore keeps everything in memory and loses it on Close,
+// it's only for tests and simulations.
+func NewBadgerMemoryStore(custom *config.Custom) (*BadgerStore, error) {
+	snapshotsDB, err := openMemoryDB()
+	if err != nil {
+		return nil, err
+	}
+	cacheDB, err := openMemoryDB()
+	if err != nil {
+		return nil, err
+	}
+	return &BadgerStore{
+		custom:      custom,
+		snapshotsDB: snapshotsDB,
+		cacheDB:     cacheDB,
+		closing:     false,
+	}, nil
+}
+
 func (store *BadgerStore) Close() error {
 	store.closing = true
 	err := store.snapshotsDB.Close()
@@ -42,6 +61,24 @@ func (store *BadgerStore) Close() error {
 	return store.cacheDB.Close()
 }
 
+// RunValueLogGC rewrites the value log files of both databases until
+// nothing to rewrite, and returns the count of rewritten files.
+func (store *BadgerStore) RunValueLogGC(discardRatio float64) (int, error) {
+	var count int
+	for _, db := range []*badger.DB{store.snapshotsDB, store.cacheDB} {
+		for !store.closing {
+			err := db.RunValueLogGC(discardRatio)
+			if err == badger.ErrNoRewrite {
+				break
+			} else if err != nil {
+				return count, err
+			}
+			count += 1
+		}
+	}
+	return count, nil
+}
+
 func openDB(dir string, sync bool, custom *config.Custom) (*badger.DB, error) {
 	opts := badger.DefaultOptions(dir)
 	opts = opts.WithSyncWrites(sync)
@@ -76,3 +113,13 @@ func openDB(dir string, sync bool, custom *config.Custom) (*badger.DB, error) {
 
 	return db, nil
 }
+
+func openMemoryDB() (*badger.DB, error) {
+	opts := badger.DefaultOptions("").WithInMemory(true)
+	opts = opts.WithCompression(options.None)
+	opts = opts.WithBlockCacheSize(0)
+	opts = opts.WithIndexCacheSize(0)
+	opts = opts.WithMaxTableSize(8 << 20)
+	opts = opts.WithLogger(nil)
+	return badger.Open(opts)
+}
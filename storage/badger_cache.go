@@ -1,6 +1,7 @@
 package storage
 
 import (
+	"encoding/binary"
 	"time"
 
 	"github.com/MixinNetwork/mixin/common"
@@ -12,6 +13,8 @@ const (
 	cachePrefixTransactionCache  = "TRANSACTIONCACHE"
 	cachePrefixSnapshotNodeQueue = "SNAPSHOTNODEQUEUE"
 	cachePrefixSnapshotNodeMeta  = "SNAPSHOTNODEMETA"
+	cachePrefixPeerBan           = "PEERBAN"
+	cachePrefixPeerRecord        = "PEERRECORD"
 )
 
 func (s *BadgerStore) CacheListTransactions(offset crypto.Hash, limit int) ([]*common.VersionedTransaction, error) {
@@ -43,6 +46,37 @@ func (s *BadgerStore) CacheListTransactions(offset crypto.Hash, limit int) ([]*c
 	return txs, nil
 }
 
+// CacheTransactionsStats returns the oldest cache time computed from the
+// expiration by the current cache TTL, which is wrong for the transactions
+// cached before a restart with a different cache TTL.
+func (s *BadgerStore) CacheTransactionsStats() (int, int64, uint64, error) {
+	txn := s.cacheDB.NewTransaction(false)
+	defer txn.Discard()
+
+	opts := badger.DefaultIteratorOptions
+	opts.PrefetchValues = false
+	opts.Prefix = []byte(cachePrefixTransactionCache)
+	it := txn.NewIterator(opts)
+	defer it.Close()
+
+	var count int
+	var size int64
+	var oldest uint64
+	ttl := uint64(s.custom.Node.CacheTTL) * 8
+	for it.Rewind(); it.Valid(); it.Next() {
+		item := it.Item()
+		count, size = count+1, size+item.ValueSize()
+		expire := item.ExpiresAt()
+		if expire < ttl {
+			continue
+		}
+		if created := expire - ttl; oldest == 0 || created < oldest {
+			oldest = created
+		}
+	}
+	return count, size, oldest, nil
+}
+
 func (s *BadgerStore) CacheRemoveTransactions(hashes []crypto.Hash) error {
 	batch := 100
 	for {
@@ -98,6 +132,97 @@ func (s *BadgerStore) CacheGetTransaction(hash crypto.Hash) (*common.VersionedTr
 	return common.DecompressUnmarshalVersionedTransaction(val)
 }
 
+// CacheWritePeerBan keeps the ban until the time, then badger expires it.
+func (s *BadgerStore) CacheWritePeerBan(peerId crypto.Hash, until time.Time) error {
+	ttl := time.Until(until)
+	if ttl <= 0 {
+		return nil
+	}
+	txn := s.cacheDB.NewTransaction(true)
+	defer txn.Discard()
+
+	val := make([]byte, 8)
+	binary.BigEndian.PutUint64(val, uint64(until.UnixNano()))
+	etr := badger.NewEntry(cachePeerBanKey(peerId), val).WithTTL(ttl)
+	err := txn.SetEntry(etr)
+	if err != nil {
+		return err
+	}
+	return txn.Commit()
+}
+
+func (s *BadgerStore) CacheListPeerBans() (map[crypto.Hash]time.Time, error) {
+	txn := s.cacheDB.NewTransaction(false)
+	defer txn.Discard()
+
+	opts := badger.DefaultIteratorOptions
+	opts.Prefix = []byte(cachePrefixPeerBan)
+	it := txn.NewIterator(opts)
+	defer it.Close()
+
+	bans := make(map[crypto.Hash]time.Time)
+	for it.Rewind(); it.Valid(); it.Next() {
+		item := it.Item()
+		var id crypto.Hash
+		copy(id[:], item.Key()[len(cachePrefixPeerBan):])
+		val, err := item.ValueCopy(nil)
+		if err != nil {
+			return nil, err
+		}
+		bans[id] = time.Unix(0, int64(binary.BigEndian.Uint64(val)))
+	}
+	return bans, nil
+}
+
+// CacheWritePeerRecord keeps the peer record until the time, then badger
+// expires it, the record is opaque to the store.
+func (s *BadgerStore) CacheWritePeerRecord(peerId crypto.Hash, data []byte, until time.Time) error {
+	ttl := time.Until(until)
+	if ttl <= 0 {
+		return nil
+	}
+	txn := s.cacheDB.NewTransaction(true)
+	defer txn.Discard()
+
+	etr := badger.NewEntry(cachePeerRecordKey(peerId), data).WithTTL(ttl)
+	err := txn.SetEntry(etr)
+	if err != nil {
+		return err
+	}
+	return txn.Commit()
+}
+
+func (s *BadgerStore) CacheListPeerRecords() (map[crypto.Hash][]byte, error) {
+	txn := s.cacheDB.NewTransaction(false)
+	defer txn.Discard()
+
+	opts := badger.DefaultIteratorOptions
+	opts.Prefix = []byte(cachePrefixPeerRecord)
+	it := txn.NewIterator(opts)
+	defer it.Close()
+
+	records := make(map[crypto.Hash][]byte)
+	for it.Rewind(); it.Valid(); it.Next() {
+		item := it.Item()
+		var id crypto.Hash
+		copy(id[:], item.Key()[len(cachePrefixPeerRecord):])
+		val, err := item.ValueCopy(nil)
+		if err != nil {
+			return nil, err
+		}
+		records[id] = val
+	}
+	return records, nil
+}
+
+func cachePeerRecordKey(id crypto.Hash) []byte {
+	return append([]byte(cachePrefixPeerRecord), id[:]...)
+}
+
+func cachePeerBanKey(id crypto.Hash) []byte {
+	return append([]byte(cachePrefixPeerBan), id[:]...)
+}
+
 func cacheTransactionCacheKey(hash crypto.Hash) []byte {
 	return append([]byte(cachePrefixTransactionCache), hash[:]...)
 }
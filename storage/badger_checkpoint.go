@@ -0,0 +1,665 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/MixinNetwork/mixin/common"
+	"github.com/MixinNetwork/mixin/config"
+	"github.com/MixinNetwork/mixin/crypto"
+	"github.com/dgraph-io/badger/v2"
+)
+
+const checkpointImportBatch = 1000
+
+// ExportCheckpoint writes the state finalized in the rounds up to the heads,
+// and the recent rounds of the heads. All nodes finalized the heads produce
+// the same records, so the checkpoint hash could be signed by them. The lock
+// of an output is dropped if the spending transaction is not in the cut, and
+// the node timestamp is the earliest snapshot of the transaction in the cut,
+// because each node uses the snapshot finalized first.
+func (s *BadgerStore) ExportCheckpoint(heads []*common.CheckpointHead, w *common.CheckpointWriter) error {
+	txn := s.snapshotsDB.NewTransaction(false)
+	defer txn.Discard()
+
+	for _, h := range heads {
+		cache, err := readRound(txn, h.NodeId)
+		if err != nil {
+			return err
+		}
+		if cache == nil || cache.Number < h.Number {
+			return fmt.Errorf("checkpoint head %s:%d not finalized", h.NodeId, h.Number)
+		}
+		snapshots, err := readSnapshotsForNodeRound(txn, h.NodeId, h.Number)
+		if err != nil {
+			return err
+		}
+		if len(snapshots) == 0 {
+			return fmt.Errorf("checkpoint head %s:%d empty", h.NodeId, h.Number)
+		}
+		_, _, hash := computeRoundHash(h.NodeId, h.Number, snapshots)
+		if hash != h.Hash {
+			return fmt.Errorf("checkpoint head %s:%d unmatch %s %s", h.NodeId, h.Number, hash, h.Hash)
+		}
+	}
+
+	nodes, err := readCheckpointNodes(txn)
+	if err != nil {
+		return err
+	}
+	domains, err := readCheckpointDomains(txn)
+	if err != nil {
+		return err
+	}
+	timestamps := make(map[crypto.Hash]uint64)
+	for _, n := range nodes {
+		timestamps[n.Transaction] = 0
+	}
+	for _, d := range domains {
+		timestamps[d.Transaction] = 0
+	}
+	final, err := readCheckpointFinalization(txn, heads, timestamps)
+	if err != nil {
+		return err
+	}
+
+	var extra []crypto.Hash
+	cn := make([]*common.CheckpointNode, 0)
+	for _, n := range nodes {
+		if !final[n.Transaction] {
+			continue
+		}
+		n.Timestamp = timestamps[n.Transaction]
+		cn = append(cn, n)
+		extra = append(extra, n.Transaction)
+		ver, err := readTransaction(txn, n.Transaction)
+		if err != nil {
+			return err
+		}
+		if ver == nil {
+			return fmt.Errorf("checkpoint node transaction %s not found", n.Transaction)
+		}
+		if len(ver.Inputs) > 0 && final[ver.Inputs[0].Hash] {
+			extra = append(extra, ver.Inputs[0].Hash)
+		}
+	}
+	sort.Slice(cn, func(i, j int) bool {
+		if cn[i].Timestamp != cn[j].Timestamp {
+			return cn[i].Timestamp < cn[j].Timestamp
+		}
+		return bytes.Compare(cn[i].Signer[:], cn[j].Signer[:]) < 0
+	})
+	for _, n := range cn {
+		err := w.Write(common.CheckpointRecordNode, n)
+		if err != nil {
+			return err
+		}
+	}
+	for _, d := range domains {
+		if !final[d.Transaction] {
+			continue
+		}
+		d.Timestamp = timestamps[d.Transaction]
+		err := w.Write(common.CheckpointRecordDomain, d)
+		if err != nil {
+			return err
+		}
+	}
+
+	mints, err := exportCheckpointMints(txn, w, final)
+	if err != nil {
+		return err
+	}
+	extra = append(extra, mints...)
+	err = exportCheckpointDeposits(txn, w, final)
+	if err != nil {
+		return err
+	}
+	submits, err := exportCheckpointUTXOs(txn, w, final)
+	if err != nil {
+		return err
+	}
+	extra = append(extra, submits...)
+
+	written := make(map[crypto.Hash]bool)
+	for _, h := range extra {
+		err := exportCheckpointTransaction(txn, w, h, written)
+		if err != nil {
+			return err
+		}
+	}
+	for _, h := range heads {
+		for n := checkpointRoundStart(h.Number); n <= h.Number; n++ {
+			snapshots, err := readSnapshotsForNodeRound(txn, h.NodeId, n)
+			if err != nil {
+				return err
+			}
+			if len(snapshots) == 0 {
+				return fmt.Errorf("checkpoint round %s:%d empty", h.NodeId, n)
+			}
+			sort.Slice(snapshots, func(i, j int) bool {
+				if snapshots[i].Timestamp != snapshots[j].Timestamp {
+					return snapshots[i].Timestamp < snapshots[j].Timestamp
+				}
+				a, b := snapshots[i].Hash, snapshots[j].Hash
+				return bytes.Compare(a[:], b[:]) < 0
+			})
+			for _, s := range snapshots {
+				err := exportCheckpointTransaction(txn, w, s.Transaction, written)
+				if err != nil {
+					return err
+				}
+				err = w.Write(common.CheckpointRecordSnapshot, &s.Snapshot)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// ImportCheckpoint writes the records after the header to a fresh store with
+// the genesis loaded, the head of each chain becomes the cache round, and the
+// rounds before it are final. Only the transactions of the imported rounds
+// have the finalization snapshot.
+func (s *BadgerStore) ImportCheckpoint(header *common.CheckpointHeader, r *common.CheckpointReader) error {
+	heads := header.Heads
+	cut := make(map[crypto.Hash]uint64)
+	for _, h := range heads {
+		cut[h.NodeId] = h.Number
+	}
+	rounds := make(map[crypto.Hash]map[uint64][]*common.SnapshotWithTopologicalOrder)
+	sequence := s.TopologySequence()
+
+	txn := s.snapshotsDB.NewTransaction(true)
+	defer func() { txn.Discard() }()
+
+	for count := 1; ; count++ {
+		kind, data, err := r.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		switch kind {
+		case common.CheckpointRecordNode:
+			var n common.CheckpointNode
+			err = common.MsgpackUnmarshal(data, &n)
+			if err == nil {
+				key := nodeStateQueueKey(n.Signer, n.Timestamp)
+				err = txn.Set(key, nodeEntryValue(n.Payee, n.Transaction, n.State))
+			}
+		case common.CheckpointRecordDomain:
+			var d common.CheckpointDomain
+			err = common.MsgpackUnmarshal(data, &d)
+			if err == nil {
+				err = writeDomainAccept(txn, d.Signer, d.Transaction, d.Timestamp)
+			}
+		case common.CheckpointRecordMint:
+			var dist common.MintDistribution
+			err = common.MsgpackUnmarshal(data, &dist)
+			if err == nil {
+				err = txn.Set(graphMintKey(dist.Group, dist.Batch), common.MsgpackMarshalPanic(&dist))
+			}
+		case common.CheckpointRecordDeposit:
+			var d common.CheckpointDeposit
+			err = common.MsgpackUnmarshal(data, &d)
+			if err == nil {
+				key := append([]byte(graphPrefixDeposit), d.Key[:]...)
+				err = txn.Set(key, d.Transaction[:])
+			}
+		case common.CheckpointRecordUTXO:
+			err = importCheckpointUTXO(txn, data)
+		case common.CheckpointRecordTransaction:
+			err = importCheckpointTransaction(txn, data)
+		case common.CheckpointRecordSnapshot:
+			var snap *common.SnapshotWithTopologicalOrder
+			snap, err = importCheckpointSnapshot(txn, data, cut, sequence+1)
+			if snap != nil {
+				if snap.TopologicalOrder > sequence {
+					sequence = snap.TopologicalOrder
+				}
+				if rounds[snap.NodeId] == nil {
+					rounds[snap.NodeId] = make(map[uint64][]*common.SnapshotWithTopologicalOrder)
+				}
+				rounds[snap.NodeId][snap.RoundNumber] = append(rounds[snap.NodeId][snap.RoundNumber], snap)
+			}
+		case common.CheckpointRecordSignature:
+		default:
+			err = fmt.Errorf("invalid checkpoint record %d", kind)
+		}
+		if err != nil {
+			return err
+		}
+
+		if count%checkpointImportBatch == 0 {
+			err = txn.Commit()
+			if err != nil {
+				return err
+			}
+			txn = s.snapshotsDB.NewTransaction(true)
+		}
+	}
+
+	for _, h := range heads {
+		err := importCheckpointRounds(txn, h, rounds[h.NodeId])
+		if err != nil {
+			return err
+		}
+	}
+	for _, h := range heads {
+		err := importCheckpointLinks(txn, h, rounds[h.NodeId])
+		if err != nil {
+			return err
+		}
+	}
+	err := txn.Set([]byte(graphPrefixCheckpoint), common.MsgpackMarshalPanic(header))
+	if err != nil {
+		return err
+	}
+	return txn.Commit()
+}
+
+// ReadCheckpoint returns the header of the checkpoint imported, or nil if
+// the store is not initialized by a checkpoint.
+func (s *BadgerStore) ReadCheckpoint() (*common.CheckpointHeader, error) {
+	txn := s.snapshotsDB.NewTransaction(false)
+	defer txn.Discard()
+
+	item, err := txn.Get([]byte(graphPrefixCheckpoint))
+	if err == badger.ErrKeyNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	val, err := item.ValueCopy(nil)
+	if err != nil {
+		return nil, err
+	}
+	var header common.CheckpointHeader
+	err = common.MsgpackUnmarshal(val, &header)
+	return &header, err
+}
+
+func checkpointRoundStart(head uint64) uint64 {
+	if head+1 < config.SnapshotSyncRoundThreshold {
+		return 0
+	}
+	return head + 1 - config.SnapshotSyncRoundThreshold
+}
+
+func readCheckpointNodes(txn *badger.Txn) ([]*common.CheckpointNode, error) {
+	prefix := []byte(graphPrefixNodeStateQueue)
+	opts := badger.DefaultIteratorOptions
+	opts.Prefix = prefix
+	it := txn.NewIterator(opts)
+	defer it.Close()
+
+	nodes := make([]*common.CheckpointNode, 0)
+	for it.Seek(prefix); it.Valid(); it.Next() {
+		item := it.Item()
+		signer, ts := nodeSignerFromStateKey(item.KeyCopy(nil))
+		ival, err := item.ValueCopy(nil)
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, &common.CheckpointNode{
+			Signer:      signer.PublicSpendKey,
+			Payee:       nodePayee(ival).PublicSpendKey,
+			State:       nodeState(ival),
+			Transaction: nodeTransaction(ival),
+			Timestamp:   ts,
+		})
+	}
+	return nodes, nil
+}
+
+func readCheckpointDomains(txn *badger.Txn) ([]*common.CheckpointDomain, error) {
+	prefix := []byte(graphPrefixDomainAccept)
+	opts := badger.DefaultIteratorOptions
+	opts.Prefix = prefix
+	it := txn.NewIterator(opts)
+	defer it.Close()
+
+	domains := make([]*common.CheckpointDomain, 0)
+	for it.Seek(prefix); it.Valid(); it.Next() {
+		item := it.Item()
+		ival, err := item.ValueCopy(nil)
+		if err != nil {
+			return nil, err
+		}
+		d := &common.CheckpointDomain{
+			Signer:    domainAccountForState(item.KeyCopy(nil), graphPrefixDomainAccept).PublicSpendKey,
+			Timestamp: binary.BigEndian.Uint64(ival[len(crypto.Hash{}):]),
+		}
+		copy(d.Transaction[:], ival)
+		domains = append(domains, d)
+	}
+	return domains, nil
+}
+
+// readCheckpointFinalization returns all transactions of the snapshots in the
+// rounds up to the heads, and updates the timestamps of the transactions in
+// the filter to their earliest snapshots.
+func readCheckpointFinalization(txn *badger.Txn, heads []*common.CheckpointHead, timestamps map[crypto.Hash]uint64) (map[crypto.Hash]bool, error) {
+	final := make(map[crypto.Hash]bool)
+	for _, h := range heads {
+		err := readCheckpointFinalizationForNode(txn, h, final, timestamps)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return final, nil
+}
+
+func readCheckpointFinalizationForNode(txn *badger.Txn, h *common.CheckpointHead, final map[crypto.Hash]bool, timestamps map[crypto.Hash]uint64) error {
+	prefix := append([]byte(graphPrefixSnapshot), h.NodeId[:]...)
+	opts := badger.DefaultIteratorOptions
+	opts.PrefetchValues = false
+	opts.Prefix = prefix
+	it := txn.NewIterator(opts)
+	defer it.Close()
+
+	for it.Seek(prefix); it.Valid(); it.Next() {
+		item := it.Item()
+		key := item.KeyCopy(nil)
+		round := binary.BigEndian.Uint64(key[len(prefix):])
+		if round > h.Number {
+			break
+		}
+		var tx crypto.Hash
+		copy(tx[:], key[len(prefix)+8:])
+		final[tx] = true
+
+		ts, found := timestamps[tx]
+		if !found {
+			continue
+		}
+		v, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+		var snap common.SnapshotWithTopologicalOrder
+		err = common.DecompressMsgpackUnmarshal(v, &snap)
+		if err != nil {
+			return err
+		}
+		if ts == 0 || snap.Timestamp < ts {
+			timestamps[tx] = snap.Timestamp
+		}
+	}
+	return nil
+}
+
+func exportCheckpointMints(txn *badger.Txn, w *common.CheckpointWriter, final map[crypto.Hash]bool) ([]crypto.Hash, error) {
+	prefix := []byte(graphPrefixMint)
+	opts := badger.DefaultIteratorOptions
+	opts.Prefix = prefix
+	it := txn.NewIterator(opts)
+	defer it.Close()
+
+	var txs []crypto.Hash
+	for it.Seek(prefix); it.Valid(); it.Next() {
+		ival, err := it.Item().ValueCopy(nil)
+		if err != nil {
+			return nil, err
+		}
+		var dist common.MintDistribution
+		err = common.MsgpackUnmarshal(ival, &dist)
+		if err != nil {
+			return nil, err
+		}
+		if !final[dist.Transaction] {
+			continue
+		}
+		err = w.Write(common.CheckpointRecordMint, &dist)
+		if err != nil {
+			return nil, err
+		}
+		txs = append(txs, dist.Transaction)
+	}
+	return txs, nil
+}
+
+func exportCheckpointDeposits(txn *badger.Txn, w *common.CheckpointWriter, final map[crypto.Hash]bool) error {
+	prefix := []byte(graphPrefixDeposit)
+	opts := badger.DefaultIteratorOptions
+	opts.Prefix = prefix
+	it := txn.NewIterator(opts)
+	defer it.Close()
+
+	for it.Seek(prefix); it.Valid(); it.Next() {
+		item := it.Item()
+		ival, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+		var d common.CheckpointDeposit
+		copy(d.Key[:], item.KeyCopy(nil)[len(prefix):])
+		copy(d.Transaction[:], ival)
+		if !final[d.Transaction] {
+			continue
+		}
+		err = w.Write(common.CheckpointRecordDeposit, &d)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// exportCheckpointUTXOs writes all outputs of the transactions in the cut,
+// the spent outputs are kept with the lock to reject the double spends, and
+// returns the withdrawal submit transactions, which are read by the claims.
+func exportCheckpointUTXOs(txn *badger.Txn, w *common.CheckpointWriter, final map[crypto.Hash]bool) ([]crypto.Hash, error) {
+	prefix := []byte(graphPrefixUTXO)
+	opts := badger.DefaultIteratorOptions
+	opts.Prefix = prefix
+	it := txn.NewIterator(opts)
+	defer it.Close()
+
+	var submits []crypto.Hash
+	for it.Seek(prefix); it.Valid(); it.Next() {
+		ival, err := it.Item().ValueCopy(nil)
+		if err != nil {
+			return nil, err
+		}
+		var utxo common.UTXOWithLock
+		err = common.DecompressMsgpackUnmarshal(ival, &utxo)
+		if err != nil {
+			return nil, err
+		}
+		if !final[utxo.Hash] {
+			continue
+		}
+		if !final[utxo.LockHash] {
+			utxo.LockHash = crypto.Hash{}
+		}
+		err = w.Write(common.CheckpointRecordUTXO, &utxo)
+		if err != nil {
+			return nil, err
+		}
+		if utxo.Type == common.OutputTypeWithdrawalSubmit {
+			submits = append(submits, utxo.Hash)
+		}
+	}
+	return submits, nil
+}
+
+func exportCheckpointTransaction(txn *badger.Txn, w *common.CheckpointWriter, hash crypto.Hash, written map[crypto.Hash]bool) error {
+	if written[hash] {
+		return nil
+	}
+	ver, err := readTransaction(txn, hash)
+	if err != nil {
+		return err
+	}
+	if ver == nil {
+		return fmt.Errorf("checkpoint transaction %s not found", hash)
+	}
+	written[hash] = true
+	return w.Write(common.CheckpointRecordTransaction, &common.CheckpointTransaction{Data: ver.Marshal()})
+}
+
+func importCheckpointUTXO(txn *badger.Txn, data []byte) error {
+	var utxo common.UTXOWithLock
+	err := common.MsgpackUnmarshal(data, &utxo)
+	if err != nil {
+		return err
+	}
+	for _, k := range utxo.Keys {
+		err := txn.Set(graphGhostKey(*k), []byte{0})
+		if err != nil {
+			return err
+		}
+	}
+	key := graphUtxoKey(utxo.Hash, utxo.Index)
+	return txn.Set(key, common.CompressMsgpackMarshalPanic(&utxo))
+}
+
+func importCheckpointTransaction(txn *badger.Txn, data []byte) error {
+	var ct common.CheckpointTransaction
+	err := common.MsgpackUnmarshal(data, &ct)
+	if err != nil {
+		return err
+	}
+	ver, err := common.UnmarshalVersionedTransaction(ct.Data)
+	if err != nil {
+		return err
+	}
+	err = writeTransaction(txn, ver)
+	if err != nil {
+		return err
+	}
+	key := graphFinalizationKey(ver.PayloadHash())
+	_, err = txn.Get(key)
+	if err == badger.ErrKeyNotFound {
+		return txn.Set(key, []byte{})
+	}
+	return err
+}
+
+// importCheckpointSnapshot writes the snapshot with the next topological
+// order, unless the snapshot exists, i.e. in the genesis.
+func importCheckpointSnapshot(txn *badger.Txn, data []byte, cut map[crypto.Hash]uint64, order uint64) (*common.SnapshotWithTopologicalOrder, error) {
+	var s common.Snapshot
+	err := common.MsgpackUnmarshal(data, &s)
+	if err != nil {
+		return nil, err
+	}
+	s.Hash = s.PayloadHash()
+	head, found := cut[s.NodeId]
+	if !found || s.RoundNumber > head {
+		return nil, fmt.Errorf("checkpoint snapshot %s out of the heads", s.Hash)
+	}
+	ver, err := readTransaction(txn, s.Transaction)
+	if err != nil {
+		return nil, err
+	}
+	if ver == nil {
+		return nil, fmt.Errorf("checkpoint snapshot %s transaction %s not found", s.Hash, s.Transaction)
+	}
+
+	old, err := readSnapshotWithTopo(txn, s.Hash)
+	if err != nil || old != nil {
+		return old, err
+	}
+	snap := &common.SnapshotWithTopologicalOrder{Snapshot: s, TopologicalOrder: order}
+	err = writeSnapshotEntries(txn, snap)
+	if err != nil {
+		return nil, err
+	}
+
+	key := graphFinalizationKey(s.Transaction)
+	item, err := txn.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	if item.ValueSize() == 0 {
+		err = txn.Set(key, s.Hash[:])
+	}
+	return snap, err
+}
+
+// importCheckpointRounds writes the final rounds before the head, and the
+// head as the cache round with the work offset. The head of a genesis chain
+// could be the round 0, which is loaded already.
+func importCheckpointRounds(txn *badger.Txn, h *common.CheckpointHead, rounds map[uint64][]*common.SnapshotWithTopologicalOrder) error {
+	if h.Number == 0 {
+		cache, err := readRound(txn, h.NodeId)
+		if err != nil || cache != nil {
+			return err
+		}
+		return fmt.Errorf("checkpoint head %s:%d not in genesis", h.NodeId, h.Number)
+	}
+
+	for n := checkpointRoundStart(h.Number); n <= h.Number; n++ {
+		snapshots := rounds[n]
+		if len(snapshots) == 0 {
+			return fmt.Errorf("checkpoint round %s:%d missing", h.NodeId, n)
+		}
+		if n == h.Number {
+			break
+		}
+		start, _, hash := computeRoundHash(h.NodeId, n, snapshots)
+		err := writeRound(txn, hash, &common.Round{
+			NodeId:     h.NodeId,
+			Number:     n,
+			Timestamp:  start,
+			References: snapshots[0].References,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	snapshots := rounds[h.Number]
+	_, _, hash := computeRoundHash(h.NodeId, h.Number, snapshots)
+	if hash != h.Hash {
+		return fmt.Errorf("checkpoint head %s:%d unmatch %s %s", h.NodeId, h.Number, hash, h.Hash)
+	}
+	err := writeRound(txn, h.NodeId, &common.Round{
+		NodeId:     h.NodeId,
+		Number:     h.Number,
+		References: snapshots[0].References,
+	})
+	if err != nil {
+		return err
+	}
+	works := make([]*common.SnapshotWork, len(snapshots))
+	for i, s := range snapshots {
+		err := writeSnapshotWork(txn, s, nil)
+		if err != nil {
+			return err
+		}
+		works[i] = &common.SnapshotWork{Hash: s.Hash}
+	}
+	return graphWriteWorkOffset(txn, graphWorkOffsetKey(h.NodeId), h.Number, works)
+}
+
+func importCheckpointLinks(txn *badger.Txn, h *common.CheckpointHead, rounds map[uint64][]*common.SnapshotWithTopologicalOrder) error {
+	links := make(map[crypto.Hash]uint64)
+	for _, snapshots := range rounds {
+		if snapshots[0].References == nil {
+			continue
+		}
+		external, err := readRound(txn, snapshots[0].References.External)
+		if err != nil {
+			return err
+		}
+		if external != nil && external.Number >= links[external.NodeId] {
+			links[external.NodeId] = external.Number
+		}
+	}
+	for id, link := range links {
+		err := writeLink(txn, h.NodeId, id, link)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -28,6 +28,7 @@ const (
 	graphPrefixWorkSign     = "WORKVOTE"
 	graphPrefixWorkOffset   = "WORKCHECKPOINT"
 	graphPrefixWorkSnapshot = "WORKSNAPSHOT"
+	graphPrefixCheckpoint   = "CHECKPOINT" // header of the checkpoint imported
 )
 
 func (s *BadgerStore) RemoveGraphEntries(prefix string) (int, error) {
@@ -152,10 +153,13 @@ func writeSnapshot(txn *badger.Txn, snap *common.SnapshotWithTopologicalOrder, v
 	if err != nil {
 		return err
 	}
+	return writeSnapshotEntries(txn, snap)
+}
 
+func writeSnapshotEntries(txn *badger.Txn, snap *common.SnapshotWithTopologicalOrder) error {
 	key := graphSnapshotKey(snap.NodeId, snap.RoundNumber, snap.Transaction)
 	val := common.CompressMsgpackMarshalPanic(snap)
-	err = txn.Set(key, val)
+	err := txn.Set(key, val)
 	if err != nil {
 		return err
 	}
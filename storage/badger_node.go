@@ -101,10 +101,12 @@ func (s *BadgerStore) AddNodeOperation(tx *common.VersionedTransaction, timestam
 		return err
 	}
 
+	// the same operation is validated again by the node which snapshots the
+	// finalized transaction, and should never extend the lock
+	if lastOp == op && lastTx == hash {
+		return nil
+	}
 	if lastTs+threshold >= timestamp {
-		if lastOp == op && lastTx == hash {
-			return nil
-		}
 		if hash.String() == "12e3d4dbc8fe04888d080c6223f17e64886a7d8eb458704c74efb13cc6ce340f" {
 			logger.Printf("FORK invalid operation lock %s %s %d\n", lastTx, lastOp, lastTs)
 		} else {
@@ -3,8 +3,11 @@ package storage
 import (
 	"os"
 	"testing"
+	"time"
 
+	"github.com/MixinNetwork/mixin/common"
 	"github.com/MixinNetwork/mixin/config"
+	"github.com/MixinNetwork/mixin/crypto"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -26,4 +29,96 @@ func TestBadger(t *testing.T) {
 
 	err = store.Close()
 	assert.Nil(err)
+
+	store, err = NewBadgerMemoryStore(custom)
+	assert.Nil(err)
+	assert.NotNil(store)
+
+	seq = store.TopologySequence()
+	assert.Equal(uint64(0), seq)
+
+	err = store.Close()
+	assert.Nil(err)
+}
+
+func TestBadgerPeerBans(t *testing.T) {
+	assert := assert.New(t)
+	custom, err := config.Initialize("../config/config.example.toml")
+	assert.Nil(err)
+
+	store, err := NewBadgerMemoryStore(custom)
+	assert.Nil(err)
+	defer store.Close()
+
+	bans, err := store.CacheListPeerBans()
+	assert.Nil(err)
+	assert.Len(bans, 0)
+
+	id := crypto.NewHash([]byte("peer"))
+	until := time.Now().Add(time.Hour)
+	err = store.CacheWritePeerBan(id, until)
+	assert.Nil(err)
+	err = store.CacheWritePeerBan(crypto.NewHash([]byte("expired")), time.Now().Add(-time.Second))
+	assert.Nil(err)
+
+	bans, err = store.CacheListPeerBans()
+	assert.Nil(err)
+	assert.Len(bans, 1)
+	assert.Equal(until.UnixNano(), bans[id].UnixNano())
+}
+
+func TestBadgerPeerRecords(t *testing.T) {
+	assert := assert.New(t)
+	custom, err := config.Initialize("../config/config.example.toml")
+	assert.Nil(err)
+
+	store, err := NewBadgerMemoryStore(custom)
+	assert.Nil(err)
+	defer store.Close()
+
+	records, err := store.CacheListPeerRecords()
+	assert.Nil(err)
+	assert.Len(records, 0)
+
+	id := crypto.NewHash([]byte("peer"))
+	err = store.CacheWritePeerRecord(id, []byte("record"), time.Now().Add(time.Hour))
+	assert.Nil(err)
+	err = store.CacheWritePeerRecord(crypto.NewHash([]byte("expired")), []byte("record"), time.Now().Add(-time.Second))
+	assert.Nil(err)
+	err = store.CacheWritePeerBan(id, time.Now().Add(time.Hour))
+	assert.Nil(err)
+
+	records, err = store.CacheListPeerRecords()
+	assert.Nil(err)
+	assert.Len(records, 1)
+	assert.Equal([]byte("record"), records[id])
+}
+
+func TestBadgerNodeOperation(t *testing.T) {
+	assert := assert.New(t)
+	custom, err := config.Initialize("../config/config.example.toml")
+	assert.Nil(err)
+
+	store, err := NewBadgerMemoryStore(custom)
+	assert.Nil(err)
+	defer store.Close()
+
+	pledge := common.NewTransaction(common.XINAssetId)
+	pledge.AddOutputWithType(common.OutputTypeNodePledge, nil, common.Script{}, common.NewIntegerFromString("13439"), make([]byte, 64))
+	cancel := common.NewTransaction(common.XINAssetId)
+	cancel.AddOutputWithType(common.OutputTypeNodeCancel, nil, common.Script{}, common.NewIntegerFromString("134.39"), make([]byte, 64))
+
+	threshold := uint64(time.Hour)
+	err = store.AddNodeOperation(pledge.AsLatestVersion(), threshold*2, threshold)
+	assert.Nil(err)
+	err = store.AddNodeOperation(cancel.AsLatestVersion(), threshold*3, threshold)
+	assert.NotNil(err)
+
+	// the pledge validated again after the lock must not lock it again
+	err = store.AddNodeOperation(pledge.AsLatestVersion(), threshold*4, threshold)
+	assert.Nil(err)
+	err = store.AddNodeOperation(cancel.AsLatestVersion(), threshold*4, threshold)
+	assert.Nil(err)
+	err = store.AddNodeOperation(pledge.AsLatestVersion(), threshold*5, threshold)
+	assert.NotNil(err)
 }
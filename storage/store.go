@@ -1,6 +1,8 @@
 package storage
 
 import (
+	"time"
+
 	"github.com/MixinNetwork/mixin/common"
 	"github.com/MixinNetwork/mixin/crypto"
 )
@@ -37,6 +39,11 @@ type Store interface {
 	CacheGetTransaction(hash crypto.Hash) (*common.VersionedTransaction, error)
 	CacheListTransactions(offset crypto.Hash, limit int) ([]*common.VersionedTransaction, error)
 	CacheRemoveTransactions([]crypto.Hash) error
+	CacheTransactionsStats() (int, int64, uint64, error)
+	CacheWritePeerBan(peerId crypto.Hash, until time.Time) error
+	CacheListPeerBans() (map[crypto.Hash]time.Time, error)
+	CacheWritePeerRecord(peerId crypto.Hash, data []byte, until time.Time) error
+	CacheListPeerRecords() (map[crypto.Hash][]byte, error)
 
 	ReadLastMintDistribution(group string) (*common.MintDistribution, error)
 	LockMintInput(mint *common.MintData, tx crypto.Hash, fork bool) error
@@ -47,6 +54,11 @@ type Store interface {
 	ReadWorkOffset(nodeId crypto.Hash) (uint64, error)
 	WriteRoundWork(nodeId crypto.Hash, round uint64, snapshots []*common.SnapshotWork) error
 
+	ExportCheckpoint(heads []*common.CheckpointHead, w *common.CheckpointWriter) error
+	ImportCheckpoint(header *common.CheckpointHeader, r *common.CheckpointReader) error
+	ReadCheckpoint() (*common.CheckpointHeader, error)
+
+	RunValueLogGC(discardRatio float64) (int, error)
 	RemoveGraphEntries(prefix string) (int, error)
 	ValidateGraphEntries(networkId crypto.Hash, depth uint64) (int, int, error)
 }
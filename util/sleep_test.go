@@ -0,0 +1,25 @@
+package util
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSleep(t *testing.T) {
+	assert := assert.New(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	assert.True(Sleep(ctx, 10*time.Millisecond))
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	start := time.Now()
+	assert.False(Sleep(ctx, time.Hour))
+	assert.True(time.Since(start) < time.Second)
+	assert.False(Sleep(ctx, 0))
+}